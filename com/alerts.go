@@ -0,0 +1,784 @@
+package com
+
+import (
+	"OnlySats/com/logging"
+	"OnlySats/com/metrics"
+	"OnlySats/com/shared"
+	"OnlySats/config"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var alertLog = logging.For("alerts")
+
+// ---------- Types ----------
+
+// Rule kinds understood by the alert engine.
+//
+//	disk_free_pct     free space on the volume holding Target (default live_output) below Threshold %
+//	disk_days_to_full projected days until that volume is full below Threshold
+//	cpu_temp          CPU temperature above Threshold °C
+//	satdump_offline   SatDump instance Target (empty = any) unreachable
+//	no_pass           no pass from satellite Target within the last Threshold hours (default 24)
+//
+// DurationSec is how long a condition must hold before the alert is raised.
+const (
+	AlertDiskFreePct    = "disk_free_pct"
+	AlertDiskDaysToFull = "disk_days_to_full"
+	AlertCPUTemp        = "cpu_temp"
+	AlertSatdumpOffline = "satdump_offline"
+	AlertNoPass         = "no_pass"
+)
+
+var alertKinds = map[string]bool{
+	AlertDiskFreePct:    true,
+	AlertDiskDaysToFull: true,
+	AlertCPUTemp:        true,
+	AlertSatdumpOffline: true,
+	AlertNoPass:         true,
+}
+
+// severities share the message types so alerts can be posted as-is
+var alertSeverities = map[string]bool{"info": true, "warn": true, "alert": true}
+
+type AlertRule struct {
+	ID          int64     `json:"id"`
+	Name        string    `json:"name"`
+	Kind        string    `json:"kind"`
+	Target      string    `json:"target,omitempty"`
+	Threshold   float64   `json:"threshold"`
+	DurationSec int       `json:"duration_sec"`
+	Severity    string    `json:"severity"`
+	Enabled     bool      `json:"enabled"`
+	PostMessage bool      `json:"post_message"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type Alert struct {
+	ID         int64      `json:"id"`
+	RuleID     int64      `json:"rule_id"`
+	Key        string     `json:"key"`
+	Severity   string     `json:"severity"`
+	Title      string     `json:"title"`
+	Message    string     `json:"message"`
+	Value      float64    `json:"value"`
+	State      string     `json:"state"` // active | resolved
+	Acked      bool       `json:"acked"`
+	FirstSeen  time.Time  `json:"first_seen"`
+	LastSeen   time.Time  `json:"last_seen"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+	MessageID  *int64     `json:"message_id,omitempty"`
+}
+
+// ---------- Rules CRUD ----------
+
+func normalizeAlertRule(r *AlertRule) error {
+	r.Kind = strings.TrimSpace(r.Kind)
+	r.Target = strings.TrimSpace(r.Target)
+	r.Name = strings.TrimSpace(r.Name)
+	r.Severity = strings.TrimSpace(r.Severity)
+	if !alertKinds[r.Kind] {
+		return fmt.Errorf("unknown rule kind %q", r.Kind)
+	}
+	if r.Name == "" {
+		r.Name = r.Kind
+	}
+	if r.Severity == "" {
+		r.Severity = "warn"
+	}
+	if !alertSeverities[r.Severity] {
+		return fmt.Errorf("severity must be info, warn or alert")
+	}
+	if r.DurationSec < 0 {
+		return errors.New("duration_sec must be >= 0")
+	}
+	switch r.Kind {
+	case AlertDiskFreePct:
+		if r.Threshold <= 0 || r.Threshold >= 100 {
+			return errors.New("threshold must be a percentage between 0 and 100")
+		}
+	case AlertDiskDaysToFull, AlertCPUTemp:
+		if r.Threshold <= 0 {
+			return errors.New("threshold must be > 0")
+		}
+	case AlertNoPass:
+		if r.Target == "" {
+			return errors.New("target satellite required")
+		}
+		if r.Threshold <= 0 {
+			r.Threshold = 24
+		}
+	}
+	return nil
+}
+
+func scanAlertRule(sc interface{ Scan(...any) error }) (AlertRule, error) {
+	var (
+		r       AlertRule
+		target  sql.NullString
+		enabled int
+		post    int
+		created int64
+	)
+	if err := sc.Scan(&r.ID, &r.Name, &r.Kind, &target, &r.Threshold, &r.DurationSec,
+		&r.Severity, &enabled, &post, &created); err != nil {
+		return r, err
+	}
+	r.Target = target.String
+	r.Enabled = enabled != 0
+	r.PostMessage = post != 0
+	r.CreatedAt = time.Unix(created, 0).UTC()
+	return r, nil
+}
+
+const alertRuleCols = `id, name, kind, target, threshold, duration_sec, severity, enabled, post_message, created_ts`
+
+func (s *LocalDataStore) CreateAlertRule(ctx context.Context, r AlertRule) (int64, error) {
+	if err := normalizeAlertRule(&r); err != nil {
+		return 0, err
+	}
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO alert_rules (name, kind, target, threshold, duration_sec, severity, enabled, post_message)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.Name, r.Kind, r.Target, r.Threshold, r.DurationSec, r.Severity, boolToInt(r.Enabled), boolToInt(r.PostMessage))
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (s *LocalDataStore) UpdateAlertRule(ctx context.Context, r AlertRule) error {
+	if r.ID <= 0 {
+		return errors.New("invalid id")
+	}
+	if err := normalizeAlertRule(&r); err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE alert_rules
+		SET name=?, kind=?, target=?, threshold=?, duration_sec=?, severity=?, enabled=?, post_message=?
+		WHERE id=?`,
+		r.Name, r.Kind, r.Target, r.Threshold, r.DurationSec, r.Severity, boolToInt(r.Enabled), boolToInt(r.PostMessage), r.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (s *LocalDataStore) GetAlertRule(ctx context.Context, id int64) (*AlertRule, error) {
+	r, err := scanAlertRule(s.db.QueryRowContext(ctx,
+		`SELECT `+alertRuleCols+` FROM alert_rules WHERE id=?`, id))
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (s *LocalDataStore) ListAlertRules(ctx context.Context) ([]AlertRule, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+alertRuleCols+` FROM alert_rules ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []AlertRule{}
+	for rows.Next() {
+		r, err := scanAlertRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// deleting a rule drops its alert history with it
+func (s *LocalDataStore) DeleteAlertRule(ctx context.Context, id int64) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM alert_rules WHERE id=?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ---------- Alerts ----------
+
+const alertCols = `id, rule_id, key, severity, title, message, value, state, acked, first_ts, last_ts, resolved_ts, message_id`
+
+func scanAlert(sc interface{ Scan(...any) error }) (Alert, error) {
+	var (
+		a           Alert
+		acked       int
+		first, last int64
+		resolved    sql.NullInt64
+		msgID       sql.NullInt64
+	)
+	if err := sc.Scan(&a.ID, &a.RuleID, &a.Key, &a.Severity, &a.Title, &a.Message, &a.Value,
+		&a.State, &acked, &first, &last, &resolved, &msgID); err != nil {
+		return a, err
+	}
+	a.Acked = acked != 0
+	a.FirstSeen = time.Unix(first, 0).UTC()
+	a.LastSeen = time.Unix(last, 0).UTC()
+	if resolved.Valid {
+		t := time.Unix(resolved.Int64, 0).UTC()
+		a.ResolvedAt = &t
+	}
+	if msgID.Valid {
+		id := msgID.Int64
+		a.MessageID = &id
+	}
+	return a, nil
+}
+
+// ListAlerts returns alerts newest first. state may be "", "active" or "resolved".
+func (s *LocalDataStore) ListAlerts(ctx context.Context, state string, limit int) ([]Alert, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = 200
+	}
+	q := `SELECT ` + alertCols + ` FROM alerts`
+	args := []any{}
+	if state != "" {
+		q += ` WHERE state = ?`
+		args = append(args, state)
+	}
+	q += ` ORDER BY last_ts DESC, id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := s.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []Alert{}
+	for rows.Next() {
+		a, err := scanAlert(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+func (s *LocalDataStore) GetAlert(ctx context.Context, id int64) (*Alert, error) {
+	a, err := scanAlert(s.db.QueryRowContext(ctx, `SELECT `+alertCols+` FROM alerts WHERE id=?`, id))
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func (s *LocalDataStore) AckAlert(ctx context.Context, id int64) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE alerts SET acked=1 WHERE id=?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (s *LocalDataStore) activeAlerts(ctx context.Context, ruleID int64) (map[string]Alert, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+alertCols+` FROM alerts WHERE rule_id=? AND state='active'`, ruleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := map[string]Alert{}
+	for rows.Next() {
+		a, err := scanAlert(rows)
+		if err != nil {
+			return nil, err
+		}
+		out[a.Key] = a
+	}
+	return out, rows.Err()
+}
+
+func (s *LocalDataStore) insertAlert(ctx context.Context, a *Alert) error {
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO alerts (rule_id, key, severity, title, message, value, state, first_ts, last_ts)
+		VALUES (?, ?, ?, ?, ?, ?, 'active', ?, ?)`,
+		a.RuleID, a.Key, a.Severity, a.Title, a.Message, a.Value, a.FirstSeen.Unix(), a.LastSeen.Unix())
+	if err != nil {
+		return err
+	}
+	a.ID, err = res.LastInsertId()
+	a.State = "active"
+	return err
+}
+
+func (s *LocalDataStore) touchAlert(ctx context.Context, id int64, value float64, message string, ts time.Time) error {
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE alerts SET value=?, message=?, last_ts=? WHERE id=?`, value, message, ts.Unix(), id)
+	return err
+}
+
+func (s *LocalDataStore) setAlertMessage(ctx context.Context, id, messageID int64) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE alerts SET message_id=? WHERE id=?`, messageID, id)
+	return err
+}
+
+func (s *LocalDataStore) resolveAlert(ctx context.Context, id int64, ts time.Time) error {
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE alerts SET state='resolved', resolved_ts=? WHERE id=? AND state='active'`, ts.Unix(), id)
+	return err
+}
+
+// PruneAlerts removes resolved alerts older than before.
+func (s *LocalDataStore) PruneAlerts(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx,
+		`DELETE FROM alerts WHERE state='resolved' AND resolved_ts < ?`, before.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// ---------- Engine ----------
+
+// finding is one firing condition produced by a rule evaluation.
+type finding struct {
+	key     string
+	value   float64
+	title   string
+	message string
+	since   time.Time // when the condition started, if the source knows
+}
+
+// AlertEngine evaluates the alert rules on a fixed interval, raising new
+// alerts, refreshing ones that keep firing and resolving the rest.
+type AlertEngine struct {
+	Store    *LocalDataStore
+	Cfg      *config.AppConfig
+	ImageDB  *sql.DB
+	Interval time.Duration
+
+	mu       sync.Mutex
+	pending  map[string]time.Time // rule:key -> first time seen firing
+	offline  map[string]offlineState
+	runway   map[string]runwayCache
+	lastEval time.Time
+}
+
+type offlineState struct {
+	endpoint string
+	since    time.Time
+	err      string
+}
+
+type runwayCache struct {
+	at  time.Time
+	est shared.DiskRunway
+}
+
+// walking live_output is expensive, so runway estimates are reused for a while
+const runwayCacheTTL = time.Hour
+
+func NewAlertEngine(store *LocalDataStore, cfg *config.AppConfig, imageDB *sql.DB) *AlertEngine {
+	return &AlertEngine{
+		Store:    store,
+		Cfg:      cfg,
+		ImageDB:  imageDB,
+		Interval: time.Minute,
+		pending:  map[string]time.Time{},
+		offline:  map[string]offlineState{},
+		runway:   map[string]runwayCache{},
+	}
+}
+
+// Start subscribes to SatDump state changes and begins evaluating rules
+// until ctx is cancelled.
+func (e *AlertEngine) Start(ctx context.Context) {
+	SubscribeEvents(e.onEvent)
+	go e.loop(ctx)
+}
+
+func (e *AlertEngine) onEvent(ev Event) {
+	inst, _ := ev.Data["instance"].(string)
+	if inst == "" {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	switch ev.Type {
+	case EventSatdumpOffline:
+		st := offlineState{since: ev.Time}
+		st.endpoint, _ = ev.Data["endpoint"].(string)
+		st.err, _ = ev.Data["error"].(string)
+		if unix, ok := ev.Data["since"].(int64); ok {
+			st.since = time.Unix(unix, 0)
+		}
+		e.offline[inst] = st
+	case EventSatdumpOnline:
+		delete(e.offline, inst)
+	}
+}
+
+func (e *AlertEngine) loop(ctx context.Context) {
+	every := e.Interval
+	if every <= 0 {
+		every = time.Minute
+	}
+	t := time.NewTicker(every)
+	defer t.Stop()
+	prune := time.NewTicker(24 * time.Hour)
+	defer prune.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := e.Evaluate(ctx); err != nil && ctx.Err() == nil {
+				alertLog.Error("evaluate", "err", err)
+			}
+		case <-prune.C:
+			if n, err := e.Store.PruneAlerts(ctx, time.Now().Add(-90*24*time.Hour)); err != nil {
+				alertLog.Warn("prune", "err", err)
+			} else if n > 0 {
+				alertLog.Info("pruned resolved alerts", "count", n)
+			}
+		}
+	}
+}
+
+// Evaluate runs every rule once.
+func (e *AlertEngine) Evaluate(ctx context.Context) error {
+	rules, err := e.Store.ListAlertRules(ctx)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+
+	// hardware is only sampled when a rule needs it
+	var snap *metrics.Snapshot
+	for _, r := range rules {
+		if r.Enabled && r.Kind == AlertCPUTemp {
+			s, err := metrics.CollectNative(ctx, e.Cfg.Paths.LiveOutputDir)
+			if err != nil {
+				alertLog.Warn("hardware snapshot", "err", err)
+			} else {
+				snap = &s
+			}
+			break
+		}
+	}
+
+	for _, r := range rules {
+		var found []finding
+		if r.Enabled {
+			found, err = e.evalRule(ctx, r, snap)
+			if err != nil {
+				// leave existing alerts untouched when the check itself fails
+				alertLog.Warn("evaluate rule", "rule", r.ID, "name", r.Name, "err", err)
+				continue
+			}
+		}
+		if err := e.reconcile(ctx, r, found, now); err != nil {
+			return fmt.Errorf("rule %d: %w", r.ID, err)
+		}
+	}
+
+	e.mu.Lock()
+	e.lastEval = now
+	e.mu.Unlock()
+	return nil
+}
+
+func (e *AlertEngine) evalRule(ctx context.Context, r AlertRule, snap *metrics.Snapshot) ([]finding, error) {
+	switch r.Kind {
+	case AlertDiskFreePct, AlertDiskDaysToFull:
+		path := r.Target
+		if path == "" {
+			path = e.Cfg.Paths.LiveOutputDir
+		}
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		if r.Kind == AlertDiskFreePct {
+			total, free, err := shared.DiskTotalsForPath(abs)
+			if err != nil {
+				return nil, err
+			}
+			if total == 0 {
+				return nil, nil
+			}
+			pct := float64(free) / float64(total) * 100
+			if pct >= r.Threshold {
+				return nil, nil
+			}
+			return []finding{{
+				key:     abs,
+				value:   pct,
+				title:   fmt.Sprintf("Low disk space on %s", abs),
+				message: fmt.Sprintf("%.1f%% free (%s of %s), below the %.0f%% threshold.", pct, humanBytes(free), humanBytes(total), r.Threshold),
+			}}, nil
+		}
+		est, err := e.diskRunway(abs)
+		if err != nil {
+			return nil, err
+		}
+		days := float64(est.TimeToFullDays)
+		if days >= r.Threshold {
+			return nil, nil
+		}
+		return []finding{{
+			key:     abs,
+			value:   days,
+			title:   fmt.Sprintf("Disk holding %s fills in ~%d days", abs, est.TimeToFullDays),
+			message: fmt.Sprintf("%s free, %s written in the last 14 days.", humanBytes(est.Free), humanBytes(est.RecentSize)),
+		}}, nil
+
+	case AlertCPUTemp:
+		if snap == nil || snap.CPU.TemperatureC == nil {
+			return nil, nil
+		}
+		temp := *snap.CPU.TemperatureC
+		if temp <= r.Threshold {
+			return nil, nil
+		}
+		return []finding{{
+			key:     "cpu",
+			value:   temp,
+			title:   "CPU temperature high",
+			message: fmt.Sprintf("CPU at %.1f °C, above the %.0f °C threshold.", temp, r.Threshold),
+		}}, nil
+
+	case AlertSatdumpOffline:
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		var out []finding
+		for inst, st := range e.offline {
+			if r.Target != "" && !strings.EqualFold(r.Target, inst) {
+				continue
+			}
+			mins := time.Since(st.since).Minutes()
+			msg := fmt.Sprintf("%s has been unreachable since %s.", st.endpoint, st.since.Local().Format("2006-01-02 15:04"))
+			if st.err != "" {
+				msg += " Last error: " + st.err
+			}
+			out = append(out, finding{
+				key:     inst,
+				value:   mins,
+				title:   fmt.Sprintf("SatDump %s offline", inst),
+				message: msg,
+				since:   st.since,
+			})
+		}
+		return out, nil
+
+	case AlertNoPass:
+		if e.ImageDB == nil {
+			return nil, nil
+		}
+		var last sql.NullInt64
+		if err := e.ImageDB.QueryRowContext(ctx,
+			`SELECT MAX(timestamp) FROM passes WHERE satellite = ? COLLATE NOCASE`, r.Target).Scan(&last); err != nil {
+			return nil, err
+		}
+		window := time.Duration(r.Threshold * float64(time.Hour))
+		if last.Valid && time.Since(time.Unix(last.Int64, 0)) < window {
+			return nil, nil
+		}
+		f := finding{
+			key:   r.Target,
+			value: -1,
+			title: fmt.Sprintf("No passes from %s", r.Target),
+		}
+		if last.Valid {
+			lt := time.Unix(last.Int64, 0)
+			f.value = time.Since(lt).Hours()
+			f.message = fmt.Sprintf("Last pass was %.0f hours ago (%s).", f.value, lt.Local().Format("2006-01-02 15:04"))
+			f.since = lt.Add(window)
+		} else {
+			f.message = "No passes from this satellite have been recorded."
+		}
+		return []finding{f}, nil
+	}
+	return nil, fmt.Errorf("unknown rule kind %q", r.Kind)
+}
+
+func (e *AlertEngine) diskRunway(path string) (shared.DiskRunway, error) {
+	e.mu.Lock()
+	c, ok := e.runway[path]
+	e.mu.Unlock()
+	if ok && time.Since(c.at) < runwayCacheTTL {
+		return c.est, nil
+	}
+	est, err := shared.EstimateDiskRunway(path)
+	if err != nil {
+		return est, err
+	}
+	e.mu.Lock()
+	e.runway[path] = runwayCache{at: time.Now(), est: est}
+	e.mu.Unlock()
+	return est, nil
+}
+
+// reconcile applies one rule's findings to the stored alerts.
+func (e *AlertEngine) reconcile(ctx context.Context, r AlertRule, found []finding, now time.Time) error {
+	active, err := e.Store.activeAlerts(ctx, r.ID)
+	if err != nil {
+		return err
+	}
+
+	firing := map[string]bool{}
+	for _, f := range found {
+		pk := fmt.Sprintf("%d:%s", r.ID, f.key)
+		e.mu.Lock()
+		start, ok := e.pending[pk]
+		if !ok {
+			start = now
+			if !f.since.IsZero() && f.since.Before(now) {
+				start = f.since
+			}
+			e.pending[pk] = start
+		}
+		e.mu.Unlock()
+
+		if a, ok := active[f.key]; ok {
+			firing[f.key] = true
+			if err := e.Store.touchAlert(ctx, a.ID, f.value, f.message, now); err != nil {
+				return err
+			}
+			continue
+		}
+		if now.Sub(start) < time.Duration(r.DurationSec)*time.Second {
+			continue // not held long enough yet
+		}
+		firing[f.key] = true
+		if err := e.raise(ctx, r, f, start, now); err != nil {
+			return err
+		}
+	}
+
+	// clear pending state for conditions that stopped
+	e.mu.Lock()
+	prefix := fmt.Sprintf("%d:", r.ID)
+	seen := map[string]bool{}
+	for _, f := range found {
+		seen[prefix+f.key] = true
+	}
+	for pk := range e.pending {
+		if strings.HasPrefix(pk, prefix) && !seen[pk] {
+			delete(e.pending, pk)
+		}
+	}
+	e.mu.Unlock()
+
+	for key, a := range active {
+		if firing[key] {
+			continue
+		}
+		if err := e.resolve(ctx, r, a, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *AlertEngine) raise(ctx context.Context, r AlertRule, f finding, start, now time.Time) error {
+	a := Alert{
+		RuleID:    r.ID,
+		Key:       f.key,
+		Severity:  r.Severity,
+		Title:     f.title,
+		Message:   f.message,
+		Value:     f.value,
+		FirstSeen: start,
+		LastSeen:  now,
+	}
+	if err := e.Store.insertAlert(ctx, &a); err != nil {
+		return err
+	}
+	alertLog.Warn("alert raised", "title", a.Title, "rule", r.Name)
+
+	if r.PostMessage {
+		msgID, err := e.Store.AddMessage(ctx, a.Title, a.Message, r.Severity, nil, now)
+		if err != nil {
+			alertLog.Warn("post message for alert", "id", a.ID, "err", err)
+		} else if err := e.Store.setAlertMessage(ctx, a.ID, msgID); err != nil {
+			alertLog.Warn("link message for alert", "id", a.ID, "err", err)
+		}
+	}
+
+	PublishEvent(EventAlertRaised, map[string]any{
+		"id":       a.ID,
+		"rule":     r.Name,
+		"kind":     r.Kind,
+		"key":      a.Key,
+		"severity": a.Severity,
+		"title":    a.Title,
+		"message":  a.Message,
+		"value":    a.Value,
+		"since":    a.FirstSeen.Unix(),
+	})
+	return nil
+}
+
+func (e *AlertEngine) resolve(ctx context.Context, r AlertRule, a Alert, now time.Time) error {
+	if err := e.Store.resolveAlert(ctx, a.ID, now); err != nil {
+		return err
+	}
+	alertLog.Info("alert resolved", "title", a.Title, "rule", r.Name)
+
+	if a.MessageID != nil {
+		title := "Resolved: " + a.Title
+		typ := "info"
+		if err := e.Store.UpdateMessage(ctx, *a.MessageID, &title, nil, &typ, nil, nil); err != nil &&
+			!errors.Is(err, sql.ErrNoRows) {
+			alertLog.Warn("update message for alert", "id", a.ID, "err", err)
+		}
+	}
+
+	PublishEvent(EventAlertResolved, map[string]any{
+		"id":       a.ID,
+		"rule":     r.Name,
+		"kind":     r.Kind,
+		"key":      a.Key,
+		"severity": a.Severity,
+		"title":    a.Title,
+		"since":    a.FirstSeen.Unix(),
+		"duration": now.Sub(a.FirstSeen).Round(time.Second).String(),
+	})
+	return nil
+}
+
+// LastEvaluated reports when the rules last ran (zero before the first pass).
+func (e *AlertEngine) LastEvaluated() time.Time {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.lastEval
+}
+
+func humanBytes(b uint64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := uint64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
@@ -98,6 +98,14 @@ func queueSatdump(ctx context.Context, out chan<- satdumpLogEntry, instance stri
 	if !ok {
 		return nil
 	}
+	if snr, ber, hasSNR, hasBER := satdumpSignal(filtered); hasSNR || hasBER {
+		if hasSNR {
+			satdumpSNR.Set(snr, instance)
+		}
+		if hasBER {
+			satdumpBER.Set(ber, instance)
+		}
+	}
 
 	rounded := trimJSON(filtered, 2)
 
@@ -123,8 +131,11 @@ func queueSatdump(ctx context.Context, out chan<- satdumpLogEntry, instance stri
 func fetchAndEnqueueSatdump(ctx context.Context, out chan<- satdumpLogEntry, instance, endpoint string) error {
 	raw, err := httpGetJSON(ctx, endpoint)
 	if err != nil {
+		satdumpOnline.Set(0, instance)
 		return err
 	}
+	satdumpOnline.Set(1, instance)
+	satdumpLastSeen.Set(float64(time.Now().Unix()), instance)
 	return queueSatdump(ctx, out, instance, raw)
 }
 
@@ -0,0 +1,702 @@
+package com
+
+import (
+	"OnlySats/com/imaging"
+	"OnlySats/com/logging"
+	"OnlySats/config"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+var animLog = logging.For("animations")
+
+var (
+	// ErrBadAnimation wraps problems with a request, as opposed to rendering.
+	ErrBadAnimation = errors.New("invalid animation request")
+	// ErrAnimationBusy is returned when deleting an animation being rendered.
+	ErrAnimationBusy = errors.New("animation is being rendered")
+)
+
+// Animation is a timelapse job and, once done, its rendered file.
+type Animation struct {
+	ID          int64      `json:"id"`
+	Satellite   string     `json:"satellite"`
+	Composite   string     `json:"composite"`
+	Start       time.Time  `json:"start"`
+	End         time.Time  `json:"end"`
+	StepMinutes int        `json:"stepMinutes"`
+	Format      string     `json:"format"` // gif | webp
+	Width       int        `json:"width"`
+	DelayMs     int        `json:"delayMs"`
+	Status      string     `json:"status"` // queued | running | done | failed
+	Frames      int        `json:"frames"`
+	Rendered    int        `json:"rendered"`
+	Progress    float64    `json:"progress"` // 0..1
+	Size        int64      `json:"size,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	URL         string     `json:"url,omitempty"` // set once done
+	CreatedAt   time.Time  `json:"createdAt"`
+	FinishedAt  *time.Time `json:"finishedAt,omitempty"`
+
+	imageIDs []int64
+}
+
+// AnimationRequest selects the images for a new animation.
+type AnimationRequest struct {
+	Satellite   string    `json:"satellite"`
+	Composite   string    `json:"composite"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	StepMinutes int       `json:"stepMinutes"` // minimum spacing between frames; 0 uses every pass
+	Format      string    `json:"format"`      // gif (default) | webp
+	Width       int       `json:"width"`
+	DelayMs     int       `json:"delayMs"`
+}
+
+func (a *Animation) Ext() string {
+	return "." + a.Format
+}
+
+func (a *Animation) ContentType() string {
+	if a.Format == "webp" {
+		return "image/webp"
+	}
+	return "image/gif"
+}
+
+// ---------- Store ----------
+
+const animationCols = `id, satellite, composite, start_ts, end_ts, step_sec, format, width, delay_ms, image_ids,
+	status, frames, rendered, size_bytes, COALESCE(error, ''), created_ts, finished_ts`
+
+func scanAnimation(row rowScanner) (*Animation, error) {
+	var (
+		a                   Animation
+		start, end, created int64
+		step                int
+		ids                 string
+		finished            sql.NullInt64
+	)
+	if err := row.Scan(&a.ID, &a.Satellite, &a.Composite, &start, &end, &step, &a.Format, &a.Width, &a.DelayMs, &ids,
+		&a.Status, &a.Frames, &a.Rendered, &a.Size, &a.Error, &created, &finished); err != nil {
+		return nil, err
+	}
+	a.Start = time.Unix(start, 0).UTC()
+	a.End = time.Unix(end, 0).UTC()
+	a.StepMinutes = step / 60
+	a.CreatedAt = time.Unix(created, 0).UTC()
+	if finished.Valid {
+		t := time.Unix(finished.Int64, 0).UTC()
+		a.FinishedAt = &t
+	}
+	for _, s := range strings.Split(ids, ",") {
+		if id, err := strconv.ParseInt(s, 10, 64); err == nil {
+			a.imageIDs = append(a.imageIDs, id)
+		}
+	}
+	if a.Frames > 0 {
+		a.Progress = float64(a.Rendered) / float64(a.Frames)
+	}
+	if a.Status == "done" {
+		a.Progress = 1 // Rendered leaves out skipped frames
+		a.URL = fmt.Sprintf("/api/animations/%d/file", a.ID)
+	}
+	return &a, nil
+}
+
+func (s *LocalDataStore) GetAnimation(ctx context.Context, id int64) (*Animation, error) {
+	return scanAnimation(s.db.QueryRowContext(ctx, `SELECT `+animationCols+` FROM animations WHERE id = ?`, id))
+}
+
+// ListAnimations returns the newest animations first.
+func (s *LocalDataStore) ListAnimations(ctx context.Context, limit int) ([]Animation, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT `+animationCols+` FROM animations ORDER BY id DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Animation{}
+	for rows.Next() {
+		a, err := scanAnimation(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *a)
+	}
+	return out, rows.Err()
+}
+
+func (s *LocalDataStore) insertAnimation(ctx context.Context, a *Animation, key string) (int64, error) {
+	ids := make([]string, len(a.imageIDs))
+	for i, id := range a.imageIDs {
+		ids[i] = strconv.FormatInt(id, 10)
+	}
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO animations (key, satellite, composite, start_ts, end_ts, step_sec, format, width, delay_ms, image_ids, frames)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		key, a.Satellite, a.Composite, a.Start.Unix(), a.End.Unix(), a.StepMinutes*60, a.Format, a.Width, a.DelayMs,
+		strings.Join(ids, ","), a.Frames)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// animationByKey finds an earlier animation of the same frames and settings
+// that is done or on its way.
+func (s *LocalDataStore) animationByKey(ctx context.Context, key string) (*Animation, error) {
+	return scanAnimation(s.db.QueryRowContext(ctx, `
+		SELECT `+animationCols+` FROM animations
+		WHERE key = ? AND status != 'failed' ORDER BY id DESC LIMIT 1`, key))
+}
+
+func (s *LocalDataStore) nextQueuedAnimation(ctx context.Context) (*Animation, error) {
+	a, err := scanAnimation(s.db.QueryRowContext(ctx, `
+		SELECT `+animationCols+` FROM animations WHERE status = 'queued' ORDER BY id LIMIT 1`))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return a, err
+}
+
+func (s *LocalDataStore) setAnimationState(ctx context.Context, id int64, status string, rendered int, size int64, msg string) error {
+	var finished any
+	if status == "done" || status == "failed" {
+		finished = time.Now().Unix()
+	}
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE animations SET status = ?, rendered = ?, size_bytes = ?, error = ?, finished_ts = ? WHERE id = ?`,
+		status, rendered, size, nullIfEmpty(msg), finished, id)
+	return err
+}
+
+func (s *LocalDataStore) requeueAnimations(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE animations SET status = 'queued', rendered = 0 WHERE status = 'running'`)
+	return err
+}
+
+func (s *LocalDataStore) animationProgress(ctx context.Context, id int64, rendered int) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE animations SET rendered = ? WHERE id = ?`, rendered, id)
+	return err
+}
+
+func (s *LocalDataStore) deleteAnimation(ctx context.Context, id int64) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM animations WHERE id = ?`, id)
+	return err
+}
+
+// ---------- Renderer ----------
+
+// Animator renders timelapses, each from the images of one satellite and
+// composite, one job at a time. Jobs live in the animations table, so queued ones survive a
+// restart; files are kept in Dir as <id>.gif or <id>.webp.
+type Animator struct {
+	Store         *LocalDataStore
+	DB            *sql.DB // image metadata
+	LiveOutputDir string
+	Dir           string
+	Backend       imaging.Backend
+	Width         int
+	MaxWidth      int
+	MaxFrames     int
+	DelayMs       int
+	Quality       int
+
+	img2webp string // empty when WebP output is unavailable
+	kick     chan struct{}
+}
+
+// NewAnimator returns nil when [animations] is disabled. WebP output needs
+// img2webp (from libwebp) on PATH; GIF is always available.
+func NewAnimator(cfg *config.AppConfig, store *LocalDataStore, imageDB *sql.DB, backend imaging.Backend) *Animator {
+	ac := cfg.Animations
+	if !ac.Enabled {
+		return nil
+	}
+	a := &Animator{
+		Store:         store,
+		DB:            imageDB,
+		LiveOutputDir: cfg.Paths.LiveOutputDir,
+		Dir:           ac.Dir,
+		Backend:       backend,
+		Width:         ac.Width,
+		MaxWidth:      ac.MaxWidth,
+		MaxFrames:     ac.MaxFrames,
+		DelayMs:       ac.DelayMs,
+		Quality:       ac.Quality,
+		kick:          make(chan struct{}, 1),
+	}
+	if strings.TrimSpace(a.Dir) == "" {
+		a.Dir = filepath.Join(cfg.Paths.DataDir, "animations")
+	}
+	if a.MaxWidth <= 0 {
+		a.MaxWidth = 1280
+	}
+	if a.Width <= 0 || a.Width > a.MaxWidth {
+		a.Width = min(800, a.MaxWidth)
+	}
+	if a.MaxFrames <= 0 {
+		a.MaxFrames = 240
+	}
+	if a.DelayMs <= 0 {
+		a.DelayMs = 200
+	}
+	if a.Quality <= 0 || a.Quality > 100 {
+		a.Quality = 75
+	}
+	if p, err := exec.LookPath("img2webp"); err == nil {
+		a.img2webp = p
+	}
+	return a
+}
+
+// Formats lists the output formats this station can render.
+func (a *Animator) Formats() []string {
+	if a.img2webp != "" {
+		return []string{"gif", "webp"}
+	}
+	return []string{"gif"}
+}
+
+// Path is where a finished animation's file is kept.
+func (a *Animator) Path(an *Animation) string {
+	return filepath.Join(a.Dir, strconv.FormatInt(an.ID, 10)+an.Ext())
+}
+
+// Start renders queued jobs until ctx is done. Jobs interrupted by a
+// shutdown are rendered again from the start.
+func (a *Animator) Start(ctx context.Context) {
+	if err := os.MkdirAll(a.Dir, 0o755); err != nil {
+		animLog.Error("create animation dir", "dir", a.Dir, "err", err)
+		return
+	}
+	if err := a.Store.requeueAnimations(ctx); err != nil {
+		animLog.Warn("requeue interrupted animations", "err", err)
+	}
+	animLog.Info("starting animation renderer", "dir", a.Dir, "formats", a.Formats())
+	go a.loop(ctx)
+}
+
+// Create queues an animation, or returns an earlier one of the same frames
+// and settings; created reports which.
+func (a *Animator) Create(ctx context.Context, req AnimationRequest) (an *Animation, created bool, err error) {
+	an = &Animation{
+		Satellite:   strings.TrimSpace(req.Satellite),
+		Composite:   strings.TrimSpace(req.Composite),
+		Start:       req.Start.UTC().Truncate(time.Second),
+		End:         req.End.UTC().Truncate(time.Second),
+		StepMinutes: req.StepMinutes,
+		Format:      strings.ToLower(strings.TrimSpace(req.Format)),
+		Width:       req.Width,
+		DelayMs:     req.DelayMs,
+		Status:      "queued",
+	}
+	switch {
+	case an.Satellite == "" || an.Composite == "":
+		return nil, false, fmt.Errorf("%w: satellite and composite required", ErrBadAnimation)
+	case an.Start.IsZero() || an.End.IsZero() || !an.End.After(an.Start):
+		return nil, false, fmt.Errorf("%w: start and end required, end after start", ErrBadAnimation)
+	case an.StepMinutes < 0:
+		return nil, false, fmt.Errorf("%w: stepMinutes must not be negative", ErrBadAnimation)
+	}
+	switch an.Format {
+	case "", "gif":
+		an.Format = "gif"
+	case "webp":
+		if a.img2webp == "" {
+			return nil, false, fmt.Errorf("%w: webp animations need img2webp (libwebp) installed", ErrBadAnimation)
+		}
+	default:
+		return nil, false, fmt.Errorf("%w: format must be gif or webp", ErrBadAnimation)
+	}
+	if an.Width <= 0 {
+		an.Width = a.Width
+	}
+	an.Width = clampInt(an.Width, 64, a.MaxWidth)
+	if an.DelayMs <= 0 {
+		an.DelayMs = a.DelayMs
+	}
+	// GIF delays are in hundredths of a second
+	an.DelayMs = clampInt(an.DelayMs, 20, 10000) / 10 * 10
+
+	an.imageIDs, err = a.match(ctx, an)
+	if err != nil {
+		return nil, false, err
+	}
+	an.Frames = len(an.imageIDs)
+	if an.Frames == 0 {
+		return nil, false, fmt.Errorf("%w: no %s images of %s in that time range", ErrBadAnimation, an.Composite, an.Satellite)
+	}
+	if an.Frames > a.MaxFrames {
+		return nil, false, fmt.Errorf("%w: %d frames match, the limit is %d; use a larger step or a shorter range",
+			ErrBadAnimation, an.Frames, a.MaxFrames)
+	}
+
+	key := animationKey(an)
+	prev, err := a.Store.animationByKey(ctx, key)
+	switch {
+	case err == nil:
+		if prev.Status != "done" {
+			return prev, false, nil
+		}
+		if _, err := os.Stat(a.Path(prev)); err == nil {
+			return prev, false, nil
+		}
+		// file removed by hand; render a new one
+	case !errors.Is(err, sql.ErrNoRows):
+		return nil, false, err
+	}
+
+	an.ID, err = a.Store.insertAnimation(ctx, an, key)
+	if err != nil {
+		return nil, false, err
+	}
+	an.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	select {
+	case a.kick <- struct{}{}:
+	default:
+	}
+	return an, true, nil
+}
+
+// Delete removes an animation and its file.
+func (a *Animator) Delete(ctx context.Context, id int64) error {
+	an, err := a.Store.GetAnimation(ctx, id)
+	if err != nil {
+		return err
+	}
+	if an.Status == "running" {
+		return ErrAnimationBusy
+	}
+	if err := os.Remove(a.Path(an)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return a.Store.deleteAnimation(ctx, id)
+}
+
+// match picks one image per pass, plain variants (no map overlay, not
+// corrected or filled) first, at least StepMinutes apart.
+func (a *Animator) match(ctx context.Context, an *Animation) ([]int64, error) {
+	rows, err := a.DB.QueryContext(ctx, `
+		SELECT i.id, i.passId, p.timestamp, p.satellite, i.composite
+		FROM images i JOIN passes p ON p.id = i.passId
+		WHERE p.satellite = ? COLLATE NOCASE AND i.composite = ? COLLATE NOCASE
+		  AND p.timestamp BETWEEN ? AND ?
+		ORDER BY p.timestamp, i.passId, COALESCE(i.mapOverlay, 0), COALESCE(i.corrected, 0), COALESCE(i.filled, 0), i.id`,
+		an.Satellite, an.Composite, an.Start.Unix(), an.End.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	step := int64(an.StepMinutes) * 60
+	var (
+		ids      []int64
+		lastPass int64 = -1
+		lastTS   int64
+	)
+	for rows.Next() {
+		var (
+			id, pass, ts   int64
+			sat, composite string
+		)
+		if err := rows.Scan(&id, &pass, &ts, &sat, &composite); err != nil {
+			return nil, err
+		}
+		// names as the station spells them, for the overlay and cache key
+		an.Satellite, an.Composite = sat, composite
+		if pass == lastPass || (len(ids) > 0 && ts-lastTS < step) {
+			continue
+		}
+		ids = append(ids, id)
+		lastPass, lastTS = pass, ts
+	}
+	return ids, rows.Err()
+}
+
+func animationKey(an *Animation) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s|%s|%d|%d|%d|%s|%d|%d",
+		strings.ToLower(an.Satellite), strings.ToLower(an.Composite), an.Start.Unix(), an.End.Unix(),
+		an.StepMinutes, an.Format, an.Width, an.DelayMs)
+	for _, id := range an.imageIDs {
+		fmt.Fprintf(h, "|%d", id)
+	}
+	return hex.EncodeToString(h.Sum(nil)[:12])
+}
+
+func clampInt(v, lo, hi int) int {
+	return max(lo, min(v, hi))
+}
+
+func (a *Animator) loop(ctx context.Context) {
+	for {
+		an, err := a.Store.nextQueuedAnimation(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			animLog.Error("animation queue", "err", err)
+		}
+		if an != nil {
+			a.run(ctx, an)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-a.kick:
+		}
+	}
+}
+
+func (a *Animator) run(ctx context.Context, an *Animation) {
+	if err := a.Store.setAnimationState(ctx, an.ID, "running", 0, 0, ""); err != nil {
+		animLog.Warn("start animation", "id", an.ID, "err", err)
+		return
+	}
+	start := time.Now()
+	animLog.Info("rendering animation", "id", an.ID, "satellite", an.Satellite, "composite", an.Composite,
+		"frames", an.Frames, "format", an.Format)
+
+	rendered, size, err := a.render(ctx, an)
+	if ctx.Err() != nil {
+		return // requeued on the next start
+	}
+	if err != nil {
+		animationsRendered.Inc("failed")
+		animLog.Warn("animation failed", "id", an.ID, "err", err)
+		err = a.Store.setAnimationState(ctx, an.ID, "failed", rendered, 0, err.Error())
+	} else {
+		animationsRendered.Inc("done")
+		animLog.Info("animation done", "id", an.ID, "frames", rendered, "bytes", size,
+			"elapsed", time.Since(start).Truncate(time.Millisecond))
+		err = a.Store.setAnimationState(ctx, an.ID, "done", rendered, size, "")
+	}
+	if err != nil {
+		animLog.Warn("update animation", "id", an.ID, "err", err)
+	}
+}
+
+type animFrame struct {
+	path string
+	ts   int64
+}
+
+func (a *Animator) frames(ctx context.Context, ids []int64) ([]animFrame, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	rows, err := a.DB.QueryContext(ctx, `
+		SELECT i.path, p.timestamp FROM images i JOIN passes p ON p.id = i.passId
+		WHERE i.id IN (?`+strings.Repeat(",?", len(ids)-1)+`) ORDER BY p.timestamp, i.id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []animFrame
+	for rows.Next() {
+		var f animFrame
+		if err := rows.Scan(&f.path, &f.ts); err != nil {
+			return nil, err
+		}
+		out = append(out, f)
+	}
+	return out, rows.Err()
+}
+
+// render writes the animation and returns how many frames made it in.
+// Images that have disappeared or fail to decode are skipped.
+func (a *Animator) render(ctx context.Context, an *Animation) (int, int64, error) {
+	frames, err := a.frames(ctx, an.imageIDs)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var (
+		canvas   image.Rectangle
+		anim     = &gif.GIF{}
+		pngs     []string // webp frames, handed to img2webp at the end
+		pngDir   string
+		rendered int
+	)
+	if an.Format == "webp" {
+		if pngDir, err = os.MkdirTemp(a.Dir, ".frames-"); err != nil {
+			return 0, 0, err
+		}
+		defer os.RemoveAll(pngDir)
+	}
+	for i, f := range frames {
+		if ctx.Err() != nil {
+			return rendered, 0, ctx.Err()
+		}
+		data, err := os.ReadFile(filepath.Join(a.LiveOutputDir, filepath.FromSlash(f.path)))
+		if err == nil && canvas.Empty() {
+			canvas, err = a.canvas(data, an.Width)
+		}
+		var img *image.RGBA
+		if err == nil {
+			img, err = a.frame(data, canvas)
+		}
+		if err != nil {
+			animLog.Debug("animation frame skipped", "id", an.ID, "path", f.path, "err", err)
+		} else {
+			stamp(img, time.Unix(f.ts, 0).UTC().Format("2006-01-02 15:04 UTC")+"  "+an.Satellite)
+			if an.Format == "gif" {
+				pal := image.NewPaletted(canvas, palette.Plan9)
+				draw.FloydSteinberg.Draw(pal, canvas, img, image.Point{})
+				anim.Image = append(anim.Image, pal)
+				anim.Delay = append(anim.Delay, an.DelayMs/10)
+			} else {
+				p := filepath.Join(pngDir, fmt.Sprintf("%05d.png", i))
+				data, err := imaging.Encode(a.Backend, img, "png", 0)
+				if err == nil {
+					err = os.WriteFile(p, data, 0o644)
+				}
+				if err != nil {
+					return rendered, 0, err
+				}
+				pngs = append(pngs, p)
+			}
+			rendered++
+		}
+		if err := a.Store.animationProgress(ctx, an.ID, i+1); err != nil && ctx.Err() == nil {
+			animLog.Warn("animation progress", "id", an.ID, "err", err)
+		}
+	}
+	if rendered == 0 {
+		return 0, 0, errors.New("none of the images could be read")
+	}
+
+	dst := a.Path(an)
+	tmp := filepath.Join(a.Dir, ".anim-"+strconv.FormatInt(an.ID, 10)+an.Ext())
+	defer os.Remove(tmp)
+	if an.Format == "gif" {
+		err = writeGIF(tmp, anim)
+	} else {
+		err = a.writeWebP(ctx, tmp, pngs, an.DelayMs)
+	}
+	if err != nil {
+		return rendered, 0, err
+	}
+	info, err := os.Stat(tmp)
+	if err != nil {
+		return rendered, 0, err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		return rendered, 0, err
+	}
+	return rendered, info.Size(), nil
+}
+
+// canvas sizes the animation from its first frame: width px wide (never
+// upscaled) with that frame's aspect ratio. Later frames are letterboxed.
+func (a *Animator) canvas(data []byte, width int) (image.Rectangle, error) {
+	w, h, err := a.Backend.Size(data)
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+	if w <= 0 || h <= 0 {
+		return image.Rectangle{}, fmt.Errorf("invalid image size %dx%d", w, h)
+	}
+	width = min(width, w)
+	return image.Rect(0, 0, width, max(1, width*h/w)), nil
+}
+
+func (a *Animator) frame(data []byte, canvas image.Rectangle) (*image.RGBA, error) {
+	w, h, err := a.Backend.Size(data)
+	if err != nil {
+		return nil, err
+	}
+	if w <= 0 || h <= 0 {
+		return nil, fmt.Errorf("invalid image size %dx%d", w, h)
+	}
+	cw, ch := canvas.Dx(), canvas.Dy()
+	fw, fh := cw, max(1, cw*h/w)
+	if fh > ch {
+		fw, fh = max(1, ch*w/h), ch
+	}
+	scaled, err := a.Backend.Resize(data, fw, fh, "png", 0)
+	if err != nil {
+		return nil, err
+	}
+	src, err := imaging.Decode(scaled)
+	if err != nil {
+		return nil, err
+	}
+	out := image.NewRGBA(canvas)
+	draw.Draw(out, canvas, image.Black, image.Point{}, draw.Src)
+	off := image.Pt((cw-fw)/2, (ch-fh)/2)
+	draw.Draw(out, src.Bounds().Add(off), src, image.Point{}, draw.Src)
+	return out, nil
+}
+
+// stamp writes text in the bottom-left corner on a translucent box, scaled
+// up with the frame so it stays legible.
+func stamp(img *image.RGBA, text string) {
+	face := basicfont.Face7x13
+	lw := font.MeasureString(face, text).Ceil() + 8
+	lh := face.Height + 6
+	label := image.NewRGBA(image.Rect(0, 0, lw, lh))
+	draw.Draw(label, label.Bounds(), image.NewUniform(color.RGBA{A: 160}), image.Point{}, draw.Src)
+	d := font.Drawer{Dst: label, Src: image.White, Face: face, Dot: fixed.P(4, 3+face.Ascent)}
+	d.DrawString(text)
+
+	b := img.Bounds()
+	scale := max(1, b.Dx()/480)
+	for scale > 1 && lw*scale > b.Dx()-8 {
+		scale--
+	}
+	m := 4 * scale
+	r := image.Rect(b.Min.X+m, b.Max.Y-m-lh*scale, b.Min.X+m+lw*scale, b.Max.Y-m)
+	draw.NearestNeighbor.Scale(img, r, label, label.Bounds(), draw.Over, nil)
+}
+
+func writeGIF(dst string, anim *gif.GIF) error {
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if err := gif.EncodeAll(f, anim); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeWebP assembles PNG frames with img2webp.
+func (a *Animator) writeWebP(ctx context.Context, dst string, frames []string, delayMs int) error {
+	args := []string{"-loop", "0", "-lossy", "-q", strconv.Itoa(a.Quality), "-d", strconv.Itoa(delayMs)}
+	args = append(args, frames...)
+	args = append(args, "-o", dst)
+	if out, err := exec.CommandContext(ctx, a.img2webp, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("img2webp: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
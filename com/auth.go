@@ -144,7 +144,7 @@ func NewEphemeralAdminIfNoAdmins(ctx context.Context, s *LocalDataStore) (*Ephem
 	}
 	return &EphemeralAdmin{
 		Username: "admin",
-		Password: shared.GenerateRandomPassword(12),
+		Password: shared.GenerateRandomPassword(24),
 		enabled:  true,
 	}, nil
 }
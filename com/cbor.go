@@ -0,0 +1,286 @@
+package com
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// decodeCBOR reads one CBOR (RFC 8949) item, enough of the format for the
+// product.cbor files SatDump writes: maps become map[string]any, arrays
+// []any, numbers int64/uint64/float64. Tags are dropped.
+func decodeCBOR(data []byte) (any, error) {
+	d := cborDecoder{data: data}
+	v, err := d.item(0)
+	if err != nil {
+		return nil, fmt.Errorf("cbor: %w", err)
+	}
+	return v, nil
+}
+
+var errCBORShort = errors.New("unexpected end of data")
+
+type cborDecoder struct {
+	data []byte
+	off  int
+}
+
+const cborMaxDepth = 64
+
+func (d *cborDecoder) item(depth int) (any, error) {
+	if depth > cborMaxDepth {
+		return nil, errors.New("nested too deeply")
+	}
+	if d.off >= len(d.data) {
+		return nil, errCBORShort
+	}
+	ib := d.data[d.off]
+	d.off++
+	major, info := ib>>5, ib&0x1f
+
+	if major == 7 {
+		return d.simple(info)
+	}
+	if info == 31 {
+		return d.indefinite(major, depth)
+	}
+	n, err := d.arg(info)
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case 0:
+		if n > math.MaxInt64 {
+			return n, nil
+		}
+		return int64(n), nil
+	case 1:
+		if n > math.MaxInt64 {
+			return nil, errors.New("negative integer out of range")
+		}
+		return -1 - int64(n), nil
+	case 2, 3:
+		b, err := d.bytes(n)
+		if err != nil {
+			return nil, err
+		}
+		if major == 3 {
+			return string(b), nil
+		}
+		return append([]byte(nil), b...), nil
+	case 4:
+		if n > uint64(len(d.data)-d.off) { // every item is at least one byte
+			return nil, errCBORShort
+		}
+		out := make([]any, 0, n)
+		for i := uint64(0); i < n; i++ {
+			v, err := d.item(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+		}
+		return out, nil
+	case 5:
+		if n > uint64(len(d.data)-d.off)/2 {
+			return nil, errCBORShort
+		}
+		out := make(map[string]any, n)
+		for i := uint64(0); i < n; i++ {
+			if err := d.pair(out, depth); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	default: // 6: tag
+		return d.item(depth + 1)
+	}
+}
+
+// arg reads the argument that follows the initial byte.
+func (d *cborDecoder) arg(info byte) (uint64, error) {
+	var size int
+	switch {
+	case info < 24:
+		return uint64(info), nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	default:
+		return 0, fmt.Errorf("reserved additional info %d", info)
+	}
+	b, err := d.bytes(uint64(size))
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+func (d *cborDecoder) bytes(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.off) {
+		return nil, errCBORShort
+	}
+	b := d.data[d.off : d.off+int(n)]
+	d.off += int(n)
+	return b, nil
+}
+
+func (d *cborDecoder) pair(m map[string]any, depth int) error {
+	k, err := d.item(depth + 1)
+	if err != nil {
+		return err
+	}
+	v, err := d.item(depth + 1)
+	if err != nil {
+		return err
+	}
+	if s, ok := k.(string); ok {
+		m[s] = v
+	} else {
+		m[fmt.Sprint(k)] = v
+	}
+	return nil
+}
+
+func (d *cborDecoder) simple(info byte) (any, error) {
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 25:
+		b, err := d.bytes(2)
+		if err != nil {
+			return nil, err
+		}
+		return halfFloat(binary.BigEndian.Uint16(b)), nil
+	case 26:
+		b, err := d.bytes(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case 27:
+		b, err := d.bytes(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case 24:
+		_, err := d.bytes(1)
+		return nil, err
+	}
+	if info < 20 {
+		return nil, nil // unassigned simple value
+	}
+	return nil, fmt.Errorf("unexpected break or reserved simple value %d", info)
+}
+
+// indefinite reads a streamed string, array or map up to its break byte.
+func (d *cborDecoder) indefinite(major byte, depth int) (any, error) {
+	atBreak := func() (bool, error) {
+		if d.off >= len(d.data) {
+			return false, errCBORShort
+		}
+		if d.data[d.off] == 0xff {
+			d.off++
+			return true, nil
+		}
+		return false, nil
+	}
+	switch major {
+	case 2, 3:
+		var buf []byte
+		for {
+			done, err := atBreak()
+			if err != nil {
+				return nil, err
+			}
+			if done {
+				break
+			}
+			chunk, err := d.item(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch c := chunk.(type) {
+			case string:
+				buf = append(buf, c...)
+			case []byte:
+				buf = append(buf, c...)
+			default:
+				return nil, errors.New("bad chunk in indefinite-length string")
+			}
+		}
+		if major == 3 {
+			return string(buf), nil
+		}
+		return buf, nil
+	case 4:
+		out := []any{}
+		for {
+			done, err := atBreak()
+			if err != nil {
+				return nil, err
+			}
+			if done {
+				return out, nil
+			}
+			v, err := d.item(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+		}
+	case 5:
+		out := map[string]any{}
+		for {
+			done, err := atBreak()
+			if err != nil {
+				return nil, err
+			}
+			if done {
+				return out, nil
+			}
+			if err := d.pair(out, depth); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return nil, fmt.Errorf("indefinite length not allowed for major type %d", major)
+}
+
+func halfFloat(h uint16) float64 {
+	sign := 1.0
+	if h&0x8000 != 0 {
+		sign = -1
+	}
+	exp := int(h>>10) & 0x1f
+	frac := float64(h & 0x3ff)
+	switch exp {
+	case 0:
+		return sign * math.Ldexp(frac, -24)
+	case 31:
+		if frac == 0 {
+			return sign * math.Inf(1)
+		}
+		return math.NaN()
+	}
+	return sign * math.Ldexp(frac+1024, exp-25)
+}
@@ -0,0 +1,120 @@
+package com
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeCBOR(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		in   []byte
+		want any
+	}{
+		{"small uint", []byte{0x17}, int64(23)},
+		{"one-byte uint", []byte{0x18, 0x64}, int64(100)},
+		{"two-byte uint", []byte{0x19, 0x03, 0xe8}, int64(1000)},
+		{"four-byte uint", []byte{0x1a, 0x00, 0x0f, 0x42, 0x40}, int64(1000000)},
+		{"uint above int64", []byte{0x1b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, uint64(math.MaxUint64)},
+		{"negative int", []byte{0x20}, int64(-1)},
+		{"one-byte negative int", []byte{0x38, 0x63}, int64(-100)},
+		{"byte string", []byte{0x43, 1, 2, 3}, []byte{1, 2, 3}},
+		{"text string", []byte{0x63, 'a', 'b', 'c'}, "abc"},
+		{"array", []byte{0x82, 0x01, 0x62, 'h', 'i'}, []any{int64(1), "hi"}},
+		{"map", []byte{0xa2, 0x61, 'a', 0x01, 0x61, 'b', 0x80}, map[string]any{"a": int64(1), "b": []any{}}},
+		{"map with a number key", []byte{0xa1, 0x01, 0x02}, map[string]any{"1": int64(2)}},
+		{"tag is dropped", []byte{0xc1, 0x1a, 0x5f, 0x5e, 0x10, 0x00}, int64(1600000000)},
+		{"false", []byte{0xf4}, false},
+		{"true", []byte{0xf5}, true},
+		{"null", []byte{0xf6}, nil},
+		{"undefined", []byte{0xf7}, nil},
+		{"half float", []byte{0xf9, 0x3e, 0x00}, 1.5},
+		{"half float subnormal", []byte{0xf9, 0x00, 0x01}, math.Ldexp(1, -24)},
+		{"half float infinity", []byte{0xf9, 0xfc, 0x00}, math.Inf(-1)},
+		{"single float", []byte{0xfa, 0x3f, 0xc0, 0x00, 0x00}, 1.5},
+		{"double float", []byte{0xfb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}, 1.5},
+		{"indefinite byte string", []byte{0x5f, 0x42, 1, 2, 0x41, 3, 0xff}, []byte{1, 2, 3}},
+		{"indefinite text string", []byte{0x7f, 0x62, 'a', 'b', 0x61, 'c', 0xff}, "abc"},
+		{"empty indefinite text string", []byte{0x7f, 0xff}, ""},
+		{"indefinite array", []byte{0x9f, 0x01, 0x9f, 0xff, 0xff}, []any{int64(1), []any{}}},
+		{"indefinite map", []byte{0xbf, 0x61, 'a', 0xf5, 0xff}, map[string]any{"a": true}},
+	} {
+		got, err := decodeCBOR(tc.in)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %#v, want %#v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestDecodeCBORErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		in   []byte
+		want string // part of the error; "" for errCBORShort
+	}{
+		{"empty", nil, ""},
+		{"missing argument", []byte{0x19, 0x01}, ""},
+		{"short text string", []byte{0x63, 'a', 'b'}, ""},
+		{"short array", []byte{0x83, 0x01, 0x02}, ""},
+		{"array longer than the data", []byte{0x9b, 0, 0, 0, 1, 0, 0, 0, 0}, ""},
+		{"map without a value", []byte{0xa1, 0x61, 'a'}, ""},
+		{"map longer than the data", []byte{0xba, 0, 1, 0, 0, 0x01}, ""},
+		{"short float", []byte{0xfb, 0x3f, 0xf8}, ""},
+		{"tag without an item", []byte{0xc1}, ""},
+		{"indefinite array without a break", []byte{0x9f, 0x01, 0x02}, ""},
+		{"indefinite map without a break", []byte{0xbf, 0x61, 'a', 0x01}, ""},
+		{"reserved additional info", []byte{0x1c}, "reserved additional info 28"},
+		{"stray break", []byte{0xff}, "unexpected break"},
+		{"indefinite integer", []byte{0x1f}, "not allowed for major type 0"},
+		{"indefinite tag", []byte{0xdf, 0x01, 0xff}, "not allowed for major type 6"},
+		{"number chunk in a string", []byte{0x7f, 0x01, 0xff}, "bad chunk"},
+		{"negative int out of range", []byte{0x3b, 0x80, 0, 0, 0, 0, 0, 0, 0}, "out of range"},
+	} {
+		_, err := decodeCBOR(tc.in)
+		switch {
+		case err == nil:
+			t.Errorf("%s: decoded without an error", tc.name)
+		case tc.want == "" && !errors.Is(err, errCBORShort):
+			t.Errorf("%s: err = %v, want %v", tc.name, err, errCBORShort)
+		case tc.want != "" && !strings.Contains(err.Error(), tc.want):
+			t.Errorf("%s: err = %v, want %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestDecodeCBORDepth(t *testing.T) {
+	nested := func(open byte, n int, close bool) []byte {
+		b := append(bytes.Repeat([]byte{open}, n), 0x01)
+		if close {
+			b = append(b, bytes.Repeat([]byte{0xff}, n)...)
+		}
+		return b
+	}
+	for _, tc := range []struct {
+		name string
+		in   []byte
+		ok   bool
+	}{
+		{"arrays at the limit", nested(0x81, cborMaxDepth, false), true},
+		{"arrays past the limit", nested(0x81, cborMaxDepth+1, false), false},
+		{"indefinite arrays at the limit", nested(0x9f, cborMaxDepth, true), true},
+		{"indefinite arrays past the limit", nested(0x9f, cborMaxDepth+1, true), false},
+		{"tags past the limit", nested(0xc1, cborMaxDepth+1, false), false},
+	} {
+		_, err := decodeCBOR(tc.in)
+		if tc.ok && err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+		if !tc.ok && (err == nil || !strings.Contains(err.Error(), "nested too deeply")) {
+			t.Errorf("%s: err = %v, want nested too deeply", tc.name, err)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package com
 
 import (
+	"OnlySats/com/logging"
 	"OnlySats/config"
 	"context"
 	"database/sql"
@@ -22,6 +23,8 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
+var ingestLog = logging.For("ingest")
+
 type Pass struct {
 	ID          int    `json:"id"`
 	Name        string `json:"name"`
@@ -55,6 +58,7 @@ type updCtx struct {
 	passCfg       *config.PassConfig
 	db            *sql.DB
 	liveOutputDir string
+	notify        bool // publish pass.ingested for newly inserted passes
 }
 
 type existingPassData struct {
@@ -343,6 +347,8 @@ func (c *updCtx) initializeDatabase() error {
 			vPixels INTEGER,
 			passId INTEGER,
 			needsThumb INTEGER DEFAULT 1,
+			thumbParams TEXT,
+			tiles INTEGER DEFAULT 0,
 			FOREIGN KEY (passId) REFERENCES passes(id)
 		);
 	`)
@@ -356,6 +362,18 @@ func (c *updCtx) initializeDatabase() error {
 	if err := c.ensureColumnExists("images", "needsThumb", "INTEGER DEFAULT 1"); err != nil {
 		return err
 	}
+	// fingerprints of the renditions on disk (see ThumbParams)
+	if err := c.ensureColumnExists("images", "thumbParams", "TEXT"); err != nil {
+		return err
+	}
+	// 1 when a deep-zoom pyramid exists under /tiles/{id}
+	if err := c.ensureColumnExists("images", "tiles", "INTEGER DEFAULT 0"); err != nil {
+		return err
+	}
+	// on-demand thumbnails look images up by path
+	if _, err := c.db.Exec(`CREATE INDEX IF NOT EXISTS idx_images_path ON images(path)`); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -636,7 +654,23 @@ func (c *updCtx) processPassOptimized(passFolder string, images []Image, dataset
 		}
 	}
 
-	return tx.Commit()
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if existingPassID == 0 && c.notify {
+		data := map[string]any{
+			"id":        passID,
+			"name":      passFolder,
+			"satellite": satellite,
+			"downlink":  dl,
+			"images":    len(newImages),
+		}
+		if timestamp != nil {
+			data["timestamp"] = *timestamp
+		}
+		PublishEvent(EventPassIngested, data)
+	}
+	return nil
 }
 
 func (c *updCtx) processPasses(mode int8) error {
@@ -658,6 +692,10 @@ func (c *updCtx) processPasses(mode int8) error {
 	if err != nil {
 		return fmt.Errorf("load existing passes: %w", err)
 	}
+	// a fresh database is a bulk import, not a stream of new passes
+	if len(existingPasses) == 0 {
+		c.notify = false
+	}
 
 	// support two modes:
 	//  1- Simple pattern (no '/' and no '*'): case-insensitive substring match on top-level folders
@@ -717,6 +755,7 @@ func (c *updCtx) processPasses(mode int8) error {
 
 	added := 0
 	skipped := 0
+	start := time.Now()
 
 	// Process each candidate pass folder once
 	for _, cnd := range candidates {
@@ -727,15 +766,17 @@ func (c *updCtx) processPasses(mode int8) error {
 		}
 
 		if existing, found := existingPasses[passRel]; found && existing.needsRescan == 0 {
-			fmt.Println("Skipping possible pass: ", passRel)
+			ingestLog.Debug("skipping pass, already ingested", "pass", passRel)
 			skipped++
+			ingestPasses.Inc("skipped")
 			continue
 		}
 
 		passType := c.passCfg.PassTypes[matchedTypeName]
 		images, dataset, _, downlink, rawDataRelPath, err := c.processPassType(passRel, passType)
 		if err != nil {
-			fmt.Printf("Error processing %s: %v\n", passRel, err)
+			ingestLog.Error("processing pass failed", "pass", passRel, "err", err)
+			ingestPasses.Inc("failed")
 			continue
 		}
 
@@ -746,16 +787,22 @@ func (c *updCtx) processPasses(mode int8) error {
 		}
 
 		if err := c.processPassOptimized(passRel, images, dataset, downlink, rawDataRelPath, passID, matchedTypeName); err != nil {
-			fmt.Printf("Error inserting pass %s: %v\n", passRel, err)
+			ingestLog.Error("inserting pass failed", "pass", passRel, "err", err)
+			ingestPasses.Inc("failed")
 			continue
 		}
 		added++
+		ingestPasses.Inc("added")
 	}
 
+	ingestRuns.Inc()
+	ingestDuration.Set(time.Since(start).Seconds())
+	ingestLastRun.Set(float64(time.Now().Unix()))
+
 	if mode == 0 {
-		fmt.Printf("Database population complete. Passes processed: %d\n", added)
+		ingestLog.Info("database population complete", "added", added, "elapsed", time.Since(start).Truncate(time.Millisecond))
 	} else {
-		fmt.Printf("Database updated. Processed %d passes (skipped %d)\n", added, skipped)
+		ingestLog.Info("database updated", "added", added, "skipped", skipped, "elapsed", time.Since(start).Truncate(time.Millisecond))
 	}
 	return nil
 }
@@ -776,9 +823,9 @@ func RunDBUpdate(cfg *config.AppConfig, passCfg *config.PassConfig, repopulate b
 	prefsDBPath := filepath.Join(strings.TrimSpace(cfg.Paths.DataDir), "local_data.db")
 	if loaded, err := loadPassConfigFromPrefs(ctx, prefsDBPath); err == nil {
 		passCfg = loaded
-		fmt.Println("PassConfig loaded")
+		ingestLog.Debug("pass config loaded from preferences")
 	} else {
-		fmt.Println("PassConfig could not be loaded: ", err)
+		ingestLog.Warn("pass config could not be loaded from preferences", "err", err)
 	}
 	if passCfg == nil {
 		return fmt.Errorf("RunDBUpdate: no pass config available")
@@ -795,6 +842,7 @@ func RunDBUpdate(cfg *config.AppConfig, passCfg *config.PassConfig, repopulate b
 		passCfg:       passCfg,
 		db:            db,
 		liveOutputDir: cfg.Paths.LiveOutputDir,
+		notify:        !repopulate,
 	}
 
 	if err := uctx.initializeDatabase(); err != nil {
@@ -0,0 +1,276 @@
+package com
+
+import (
+	"OnlySats/com/imaging"
+	"OnlySats/com/logging"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var derivLog = logging.For("derivatives")
+
+// DerivativeSpec is one requested variant of an original image.
+type DerivativeSpec struct {
+	Width     int
+	Format    string // jpeg | webp | png | avif
+	Quality   int
+	Watermark *Watermark // drawn after resizing; nil for none
+}
+
+func (s DerivativeSpec) Ext() string {
+	switch s.Format {
+	case "webp":
+		return ".webp"
+	case "png":
+		return ".png"
+	case "avif":
+		return ".avif"
+	}
+	return ".jpg"
+}
+
+func (s DerivativeSpec) ContentType() string {
+	switch s.Format {
+	case "webp":
+		return "image/webp"
+	case "png":
+		return "image/png"
+	case "avif":
+		return "image/avif"
+	}
+	return "image/jpeg"
+}
+
+// DerivativeCache keeps resized copies of originals on disk, evicting the
+// least recently used once the total passes MaxBytes. Files are keyed by
+// the source's path, size and mtime plus the spec, so a changed original
+// never serves a stale copy.
+type DerivativeCache struct {
+	Dir      string
+	MaxBytes int64
+	Backend  imaging.Backend
+
+	sem      chan struct{}
+	mu       sync.Mutex
+	lru      *list.List // front = most recently used
+	entries  map[string]*list.Element
+	total    int64
+	inflight map[string]*derivCall
+}
+
+type derivEntry struct {
+	key  string
+	path string
+	size int64
+}
+
+type derivCall struct {
+	done chan struct{}
+	err  error
+}
+
+// NewDerivativeCache indexes the files already in dir by modification time
+// and trims the cache to maxBytes.
+func NewDerivativeCache(dir string, maxBytes int64, backend imaging.Backend, workers int) (*DerivativeCache, error) {
+	if workers <= 0 {
+		workers = 2
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create derivative cache: %w", err)
+	}
+	c := &DerivativeCache{
+		Dir:      dir,
+		MaxBytes: maxBytes,
+		Backend:  backend,
+		sem:      make(chan struct{}, workers),
+		lru:      list.New(),
+		entries:  map[string]*list.Element{},
+		inflight: map[string]*derivCall{},
+	}
+
+	type found struct {
+		derivEntry
+		mod time.Time
+	}
+	var files []found
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".thumb-") {
+			_ = os.Remove(p) // interrupted write
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		key := strings.TrimSuffix(d.Name(), filepath.Ext(d.Name()))
+		files = append(files, found{derivEntry{key: key, path: p, size: info.Size()}, info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scan derivative cache: %w", err)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].mod.After(files[j].mod) })
+	for _, f := range files {
+		e := f.derivEntry
+		c.entries[e.key] = c.lru.PushBack(&e)
+		c.total += e.size
+	}
+	c.evict()
+	derivCacheBytes.Set(float64(c.total))
+	return c, nil
+}
+
+// DerivativeKey identifies spec of the original at rel with the given stat info.
+// It doubles as the ETag.
+func DerivativeKey(rel string, info os.FileInfo, spec DerivativeSpec) string {
+	id := fmt.Sprintf("%s|%d|%d|%d|%s|%d",
+		rel, info.Size(), info.ModTime().UnixNano(), spec.Width, spec.Format, spec.Quality)
+	if spec.Watermark != nil {
+		id += "|" + spec.Watermark.Key()
+	}
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:12])
+}
+
+// Open returns the cached derivative, encoding it first if needed. src is
+// the original's filesystem path and key its DerivativeKey.
+func (c *DerivativeCache) Open(ctx context.Context, src, key string, spec DerivativeSpec) (*os.File, error) {
+	for attempt := 0; ; attempt++ {
+		c.mu.Lock()
+		if el, ok := c.entries[key]; ok {
+			c.lru.MoveToFront(el)
+			// opened under the lock so eviction can't remove it first
+			f, err := os.Open(el.Value.(*derivEntry).path)
+			if err == nil {
+				c.mu.Unlock()
+				if attempt == 0 {
+					derivRequests.Inc("hit")
+				}
+				return f, nil
+			}
+			c.removeLocked(el) // deleted behind our back
+		}
+		if attempt > 0 {
+			c.mu.Unlock()
+			return nil, errors.New("derivative vanished after encoding")
+		}
+		call, ok := c.inflight[key]
+		if !ok {
+			call = &derivCall{done: make(chan struct{})}
+			c.inflight[key] = call
+			// finishes even if this client leaves, so the next one gets it
+			go c.generate(key, src, spec, call)
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if call.err != nil {
+			return nil, call.err
+		}
+	}
+}
+
+func (c *DerivativeCache) generate(key, src string, spec DerivativeSpec, call *derivCall) {
+	defer func() {
+		c.mu.Lock()
+		delete(c.inflight, key)
+		c.mu.Unlock()
+		close(call.done)
+	}()
+
+	c.sem <- struct{}{}
+	out, err := c.encode(src, spec)
+	<-c.sem
+	if err != nil {
+		derivRequests.Inc("failed")
+		derivLog.Warn("derivative failed", "src", src, "width", spec.Width, "format", spec.Format, "err", err)
+		call.err = err
+		return
+	}
+
+	dst := filepath.Join(c.Dir, key[:2], key+spec.Ext())
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		call.err = err
+		return
+	}
+	if err := writeThumb(dst, out); err != nil {
+		call.err = err
+		return
+	}
+	derivRequests.Inc("miss")
+	derivLog.Debug("derivative created", "src", src, "width", spec.Width, "format", spec.Format, "bytes", len(out))
+
+	c.mu.Lock()
+	c.entries[key] = c.lru.PushFront(&derivEntry{key: key, path: dst, size: int64(len(out))})
+	c.total += int64(len(out))
+	c.evict()
+	c.mu.Unlock()
+}
+
+func (c *DerivativeCache) encode(src string, spec DerivativeSpec) ([]byte, error) {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return nil, err
+	}
+	srcW, srcH, err := c.Backend.Size(data)
+	if err != nil {
+		return nil, err
+	}
+	if srcW <= 0 || srcH <= 0 {
+		return nil, fmt.Errorf("invalid image size %dx%d", srcW, srcH)
+	}
+	width := spec.Width
+	if width > srcW {
+		width = srcW // never upscale
+	}
+	height := int(float64(width) * float64(srcH) / float64(srcW))
+	if height <= 0 {
+		height = 1
+	}
+	if spec.Watermark != nil {
+		return watermarked(c.Backend, data, width, height, srcW, srcH, spec.Watermark, spec.Format, spec.Quality)
+	}
+	return c.Backend.Resize(data, width, height, spec.Format, spec.Quality)
+}
+
+// evict drops least recently used files until the cache fits. c.mu held.
+func (c *DerivativeCache) evict() {
+	for c.MaxBytes > 0 && c.total > c.MaxBytes {
+		el := c.lru.Back()
+		if el == nil {
+			break
+		}
+		e := el.Value.(*derivEntry)
+		if err := os.Remove(e.path); err != nil && !os.IsNotExist(err) {
+			derivLog.Warn("evict derivative", "path", e.path, "err", err)
+		}
+		c.removeLocked(el)
+		derivEvictions.Inc()
+	}
+	derivCacheBytes.Set(float64(c.total))
+}
+
+func (c *DerivativeCache) removeLocked(el *list.Element) {
+	e := el.Value.(*derivEntry)
+	c.lru.Remove(el)
+	delete(c.entries, e.key)
+	c.total -= e.size
+}
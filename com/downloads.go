@@ -0,0 +1,234 @@
+package com
+
+import (
+	"OnlySats/com/logging"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var dlLog = logging.For("downloads")
+
+// DownloadSettingKey is the app_settings key the raw download limits are
+// kept under.
+const DownloadSettingKey = "downloads"
+
+// ErrBadDownloadSettings wraps validation errors for DownloadSettings.
+var ErrBadDownloadSettings = errors.New("invalid download settings")
+
+// DownloadSettings limit raw file downloads (/api/export). Zero means
+// unlimited.
+type DownloadSettings struct {
+	PerConnectionKBps int `json:"perConnectionKBps"` // each download
+	TotalKBps         int `json:"totalKBps"`         // all downloads together
+	PerIP             int `json:"perIP"`             // concurrent downloads from one client
+	RetryAfter        int `json:"retryAfter"`        // seconds, sent with 429
+}
+
+// DefaultDownloadSettings leaves bandwidth alone and allows two downloads
+// per client.
+func DefaultDownloadSettings() DownloadSettings {
+	return DownloadSettings{PerIP: 2, RetryAfter: 30}
+}
+
+// Validate reports the first problem with s.
+func (s *DownloadSettings) Validate() error {
+	switch {
+	case s.PerConnectionKBps < 0 || s.TotalKBps < 0:
+		return fmt.Errorf("%w: bandwidth caps can't be negative", ErrBadDownloadSettings)
+	case s.PerConnectionKBps > 10_000_000 || s.TotalKBps > 10_000_000:
+		return fmt.Errorf("%w: bandwidth caps are limited to 10000000 KB/s", ErrBadDownloadSettings)
+	case s.PerIP < 0 || s.PerIP > 100:
+		return fmt.Errorf("%w: perIP must be 0 to 100", ErrBadDownloadSettings)
+	case s.RetryAfter < 1 || s.RetryAfter > 3600:
+		return fmt.Errorf("%w: retryAfter must be 1 to 3600 seconds", ErrBadDownloadSettings)
+	}
+	return nil
+}
+
+// DownloadLimiter counts raw downloads per client and paces their bytes.
+type DownloadLimiter struct {
+	Store *LocalDataStore
+
+	mu       sync.Mutex
+	settings savedSetting[DownloadSettings]
+	active   map[string]int
+	total    *tokenBucket
+}
+
+// NewDownloadLimiter returns a limiter reading its settings from store.
+func NewDownloadLimiter(store *LocalDataStore) *DownloadLimiter {
+	return &DownloadLimiter{
+		Store: store,
+		settings: savedSetting[DownloadSettings]{
+			key:      DownloadSettingKey,
+			defaults: DefaultDownloadSettings,
+			check:    (*DownloadSettings).Validate,
+			log:      dlLog,
+		},
+		active: map[string]int{},
+		total:  newTokenBucket(0),
+	}
+}
+
+// Settings returns the saved settings.
+func (d *DownloadLimiter) Settings(ctx context.Context) DownloadSettings {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.settingsLocked(ctx)
+}
+
+func (d *DownloadLimiter) settingsLocked(ctx context.Context) DownloadSettings {
+	s := d.settings.get(ctx, d.Store)
+	d.total.setRate(s.TotalKBps * 1024)
+	return s
+}
+
+// SaveSettings validates and stores s; running downloads pick up the new
+// caps straight away.
+func (d *DownloadLimiter) SaveSettings(ctx context.Context, s DownloadSettings) (DownloadSettings, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s, err := d.settings.save(ctx, d.Store, s)
+	if err != nil {
+		return s, err
+	}
+	d.total.setRate(s.TotalKBps * 1024)
+	return s, nil
+}
+
+// Active returns the number of running downloads per client.
+func (d *DownloadLimiter) Active() map[string]int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := make(map[string]int, len(d.active))
+	for ip, n := range d.active {
+		out[ip] = n
+	}
+	return out
+}
+
+// Acquire claims a download slot for ip. When the client already has
+// PerIP downloads running it returns ok=false and the seconds to wait.
+func (d *DownloadLimiter) Acquire(ctx context.Context, ip string) (release func(), retryAfter int, ok bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s := d.settingsLocked(ctx)
+	if s.PerIP > 0 && d.active[ip] >= s.PerIP {
+		return nil, s.RetryAfter, false
+	}
+	d.active[ip]++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			d.mu.Lock()
+			if d.active[ip]--; d.active[ip] <= 0 {
+				delete(d.active, ip)
+			}
+			d.mu.Unlock()
+		})
+	}, 0, true
+}
+
+// Writer paces writes to w by the per-connection and total caps. Both are
+// re-read as settings change, so an admin can slow a download in progress.
+func (d *DownloadLimiter) Writer(ctx context.Context, w http.ResponseWriter) http.ResponseWriter {
+	return &throttledWriter{ResponseWriter: w, ctx: ctx, d: d, conn: newTokenBucket(0)}
+}
+
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx  context.Context
+	d    *DownloadLimiter
+	conn *tokenBucket
+}
+
+// Unwrap lets http.ResponseController reach the connection, e.g. to lift
+// the write deadline for a long download.
+func (t *throttledWriter) Unwrap() http.ResponseWriter { return t.ResponseWriter }
+
+// throttleChunk bounds how far a single write can run ahead of its budget.
+const throttleChunk = 16 << 10
+
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), throttleChunk)
+		s := t.d.Settings(t.ctx)
+		t.conn.setRate(s.PerConnectionKBps * 1024)
+		if err := t.conn.wait(t.ctx, n); err != nil {
+			return written, err
+		}
+		if err := t.d.total.wait(t.ctx, n); err != nil {
+			return written, err
+		}
+		m, err := t.ResponseWriter.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// tokenBucket allows rate bytes per second with a one second burst. A zero
+// rate never waits. Callers may overdraw; the debt is slept off before the
+// next caller gets through.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int) *tokenBucket {
+	return &tokenBucket{rate: float64(rate), tokens: float64(rate), last: time.Now()}
+}
+
+func (b *tokenBucket) setRate(rate int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if float64(rate) == b.rate {
+		return
+	}
+	b.refillLocked(time.Now())
+	b.rate = float64(rate)
+	b.tokens = min(b.tokens, b.rate)
+}
+
+func (b *tokenBucket) refillLocked(now time.Time) {
+	if b.rate > 0 {
+		b.tokens = min(b.rate, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+}
+
+func (b *tokenBucket) wait(ctx context.Context, n int) error {
+	b.mu.Lock()
+	if b.rate <= 0 {
+		b.mu.Unlock()
+		return nil
+	}
+	b.refillLocked(time.Now())
+	b.tokens -= float64(n)
+	var delay time.Duration
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,66 @@
+package com
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketRefill(t *testing.T) {
+	start := time.Unix(1_000_000, 0)
+	for _, tc := range []struct {
+		name   string
+		rate   float64
+		tokens float64
+		after  time.Duration
+		want   float64
+	}{
+		{"full stays full", 1000, 1000, time.Second, 1000},
+		{"half a second", 1000, 0, 500 * time.Millisecond, 500},
+		{"capped at one second", 1000, 0, time.Minute, 1000},
+		{"debt is paid back first", 1000, -1500, time.Second, -500},
+		{"zero rate never refills", 0, 0, time.Minute, 0},
+	} {
+		b := &tokenBucket{rate: tc.rate, tokens: tc.tokens, last: start}
+		b.refillLocked(start.Add(tc.after))
+		if b.tokens != tc.want || !b.last.Equal(start.Add(tc.after)) {
+			t.Errorf("%s: %v tokens, want %v", tc.name, b.tokens, tc.want)
+		}
+	}
+}
+
+func TestTokenBucketOverdraw(t *testing.T) {
+	ctx := context.Background()
+	b := newTokenBucket(1 << 20)
+	// A whole burst goes through without waiting.
+	if err := b.wait(ctx, 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	b.mu.Lock()
+	b.tokens = -float64(1 << 20) // a second of debt
+	b.mu.Unlock()
+
+	short, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	if err := b.wait(short, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("wait with a second of debt: err = %v, want deadline exceeded", err)
+	}
+	if d := time.Since(begin); d > 500*time.Millisecond {
+		t.Errorf("cancelled wait took %s", d)
+	}
+
+	// Lowering the rate caps the saved tokens at the new burst.
+	b = newTokenBucket(1 << 20)
+	b.setRate(1024)
+	if b.tokens > 1024 {
+		t.Errorf("tokens %v after lowering the rate to 1024", b.tokens)
+	}
+	// A zero rate never waits, whatever the debt.
+	b.setRate(0)
+	b.tokens = -1e9
+	if err := b.wait(short, 1<<30); err != nil {
+		t.Errorf("zero rate: %v", err)
+	}
+}
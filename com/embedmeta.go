@@ -0,0 +1,601 @@
+package com
+
+import (
+	"OnlySats/com/logging"
+	"OnlySats/config"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var embedLog = logging.For("imagemeta")
+
+// CaptureMeta is what gets written into a downloaded image.
+type CaptureMeta struct {
+	Satellite   string
+	Composite   string
+	Sensor      string
+	Time        time.Time // zero when the file isn't in the image database
+	Station     string
+	Country     string
+	Lat, Lon    float64
+	HasLocation bool
+	License     string
+	Attribution string
+}
+
+func (m CaptureMeta) title() string {
+	return strings.TrimSpace(m.Satellite + " " + m.Composite)
+}
+
+func (m CaptureMeta) description() string {
+	var b strings.Builder
+	b.WriteString(m.title())
+	if m.Sensor != "" {
+		fmt.Fprintf(&b, " (%s)", m.Sensor)
+	}
+	if !m.Time.IsZero() {
+		fmt.Fprintf(&b, ", captured %s", m.Time.Format("2006-01-02 15:04:05 UTC"))
+	}
+	if m.Station != "" {
+		fmt.Fprintf(&b, ", received by %s", m.Station)
+	}
+	return strings.TrimPrefix(b.String(), ", ")
+}
+
+// MetadataEmbedder splices capture details into JPEG (EXIF + XMP) and PNG
+// (tEXt/iTXt + XMP) downloads. Files on disk are never modified: the new
+// header is served in front of the untouched remainder of the original.
+type MetadataEmbedder struct {
+	DB          *sql.DB
+	Store       *LocalDataStore
+	Default     bool   // embed when the request doesn't say
+	Station     string // fixed name; empty reads about_meta "name"
+	Fallback    string // station name when about_meta has none
+	License     string
+	Attribution string
+
+	mu      sync.Mutex
+	about   map[string]string
+	aboutAt time.Time
+}
+
+// NewMetadataEmbedder returns nil when [image_metadata] is disabled.
+func NewMetadataEmbedder(cfg *config.AppConfig, store *LocalDataStore, imageDB *sql.DB) *MetadataEmbedder {
+	mc := cfg.EmbedMeta
+	if !mc.Enabled {
+		return nil
+	}
+	return &MetadataEmbedder{
+		DB:          imageDB,
+		Store:       store,
+		Default:     mc.Default,
+		Station:     strings.TrimSpace(mc.Station),
+		Fallback:    strings.TrimSpace(cfg.StationProxy.StationId),
+		License:     strings.TrimSpace(mc.License),
+		Attribution: strings.TrimSpace(mc.Attribution),
+	}
+}
+
+// Want interprets a ?meta= query value.
+func (e *MetadataEmbedder) Want(v string) bool {
+	switch strings.ToLower(v) {
+	case "1", "true", "yes", "on":
+		return true
+	case "0", "false", "no", "off":
+		return false
+	}
+	return e.Default
+}
+
+// CanEmbed reports whether Open handles files named name.
+func CanEmbed(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".png", ".jpg", ".jpeg":
+		return true
+	}
+	return false
+}
+
+// aboutMeta caches the about page's key/values for a minute; ZIP exports
+// look them up once per file.
+func (e *MetadataEmbedder) aboutMeta(ctx context.Context) map[string]string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.about != nil && time.Since(e.aboutAt) < time.Minute {
+		return e.about
+	}
+	m, err := e.Store.GetAllAboutMeta(ctx)
+	if err != nil {
+		embedLog.Debug("read about_meta", "err", err)
+		if e.about != nil {
+			return e.about
+		}
+		return map[string]string{}
+	}
+	e.about, e.aboutAt = m, time.Now()
+	return m
+}
+
+// Lookup gathers the metadata for rel, an images.path. Files that aren't
+// in the database still get the station details.
+func (e *MetadataEmbedder) Lookup(ctx context.Context, rel string) (CaptureMeta, error) {
+	about := e.aboutMeta(ctx)
+	m := CaptureMeta{
+		Station:     e.Station,
+		Country:     strings.TrimSpace(about["country"]),
+		License:     e.License,
+		Attribution: e.Attribution,
+	}
+	if m.Station == "" {
+		m.Station = strings.TrimSpace(about["name"])
+	}
+	if m.Station == "" {
+		m.Station = e.Fallback
+	}
+	lat, err1 := strconv.ParseFloat(strings.TrimSpace(about["lat"]), 64)
+	lon, err2 := strconv.ParseFloat(strings.TrimSpace(about["lon"]), 64)
+	if err1 == nil && err2 == nil && math.Abs(lat) <= 90 && math.Abs(lon) <= 180 {
+		m.Lat, m.Lon, m.HasLocation = lat, lon, true
+	}
+
+	err := lookupCapture(ctx, e.DB, rel, &m)
+	return m, err
+}
+
+// lookupCapture fills the pass and image columns of m for rel, an
+// images.path. A file that isn't in the database is not an error.
+func lookupCapture(ctx context.Context, db *sql.DB, rel string, m *CaptureMeta) error {
+	var ts sql.NullInt64
+	err := db.QueryRowContext(ctx, `
+		SELECT COALESCE(p.satellite, ''), p.timestamp, COALESCE(i.composite, ''), COALESCE(i.sensor, '')
+		FROM images i LEFT JOIN passes p ON p.id = i.passId
+		WHERE i.path = ? LIMIT 1`, rel).Scan(&m.Satellite, &ts, &m.Composite, &m.Sensor)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if ts.Valid && ts.Int64 > 0 {
+		m.Time = time.Unix(ts.Int64, 0).UTC()
+	}
+	return nil
+}
+
+// Open returns src (size bytes, stored at rel) with metadata spliced into
+// its header. ok is false for files CanEmbed rejects and for headers it
+// can't parse; serve those unchanged.
+func (e *MetadataEmbedder) Open(ctx context.Context, src io.ReaderAt, size int64, rel string) (r *io.SectionReader, ok bool, err error) {
+	if !CanEmbed(rel) {
+		return nil, false, nil
+	}
+	m, err := e.Lookup(ctx, rel)
+	if err != nil {
+		return nil, false, err
+	}
+	var parts []*io.SectionReader
+	if strings.EqualFold(path.Ext(rel), ".png") {
+		parts, err = splicePNG(src, size, m)
+	} else {
+		parts, err = spliceJPEG(src, size, m)
+	}
+	if err != nil {
+		embedLog.Debug("metadata not embedded", "path", rel, "err", err)
+		return nil, false, nil
+	}
+	return newSpliced(parts), true, nil
+}
+
+// ---------- splicing ----------
+
+// spliced reads several sections back to back.
+type spliced struct {
+	parts []*io.SectionReader
+}
+
+func newSpliced(parts []*io.SectionReader) *io.SectionReader {
+	var total int64
+	for _, p := range parts {
+		total += p.Size()
+	}
+	return io.NewSectionReader(&spliced{parts}, 0, total)
+}
+
+func (s *spliced) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for _, part := range s.parts {
+		if len(p) == 0 {
+			break
+		}
+		if off >= part.Size() {
+			off -= part.Size()
+			continue
+		}
+		k, err := part.ReadAt(p, off)
+		n += k
+		p = p[k:]
+		off = 0
+		if err != nil && err != io.EOF {
+			return n, err
+		}
+	}
+	if len(p) > 0 {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func bytesPart(b []byte) *io.SectionReader {
+	return io.NewSectionReader(bytes.NewReader(b), 0, int64(len(b)))
+}
+
+func filePart(src io.ReaderAt, off, n int64) *io.SectionReader {
+	return io.NewSectionReader(src, off, n)
+}
+
+// splicePNG inserts text chunks right after IHDR.
+func splicePNG(src io.ReaderAt, size int64, m CaptureMeta) ([]*io.SectionReader, error) {
+	const ihdrEnd = 8 + 8 + 13 + 4
+	hdr := make([]byte, ihdrEnd)
+	if size < ihdrEnd {
+		return nil, errors.New("png too short")
+	}
+	if _, err := src.ReadAt(hdr, 0); err != nil {
+		return nil, err
+	}
+	if string(hdr[:8]) != "\x89PNG\r\n\x1a\n" || string(hdr[12:16]) != "IHDR" || binary.BigEndian.Uint32(hdr[8:12]) != 13 {
+		return nil, errors.New("not a png")
+	}
+	return []*io.SectionReader{
+		filePart(src, 0, ihdrEnd),
+		bytesPart(pngTextChunks(m)),
+		filePart(src, ihdrEnd, size-ihdrEnd),
+	}, nil
+}
+
+func pngTextChunks(m CaptureMeta) []byte {
+	var b bytes.Buffer
+	chunk := func(typ string, data []byte) {
+		_ = binary.Write(&b, binary.BigEndian, uint32(len(data)))
+		b.WriteString(typ)
+		b.Write(data)
+		crc := crc32.NewIEEE()
+		crc.Write([]byte(typ))
+		crc.Write(data)
+		_ = binary.Write(&b, binary.BigEndian, crc.Sum32())
+	}
+	// tEXt is Latin-1; anything else goes in an uncompressed iTXt
+	text := func(key, val string) {
+		if val == "" {
+			return
+		}
+		if isASCII(val) {
+			chunk("tEXt", []byte(key+"\x00"+val))
+		} else {
+			chunk("iTXt", []byte(key+"\x00\x00\x00\x00\x00"+val))
+		}
+	}
+	text("Title", m.title())
+	text("Description", m.description())
+	text("Author", m.Station)
+	text("Copyright", m.License)
+	text("Comment", m.Attribution)
+	if !m.Time.IsZero() {
+		text("Creation Time", m.Time.Format(time.RFC1123))
+	}
+	text("Source", strings.TrimSpace(m.Satellite+" "+m.Sensor))
+	text("Satellite", m.Satellite)
+	text("Composite", m.Composite)
+	text("Sensor", m.Sensor)
+	text("Station", m.Station)
+	if m.HasLocation {
+		text("Location", fmt.Sprintf("%.5f, %.5f", m.Lat, m.Lon))
+	}
+	chunk("iTXt", []byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"+xmpPacket(m)))
+	return b.Bytes()
+}
+
+const (
+	exifPrefix = "Exif\x00\x00"
+	xmpPrefix  = "http://ns.adobe.com/xap/1.0/\x00"
+)
+
+// spliceJPEG puts EXIF and XMP APP1 segments after SOI (and JFIF APP0, which
+// must stay first) and drops any EXIF/XMP segments already there.
+func spliceJPEG(src io.ReaderAt, size int64, m CaptureMeta) ([]*io.SectionReader, error) {
+	buf := make([]byte, 4+len(xmpPrefix))
+	if _, err := src.ReadAt(buf[:2], 0); err != nil || buf[0] != 0xFF || buf[1] != 0xD8 {
+		return nil, errors.New("not a jpeg")
+	}
+
+	type seg struct{ off, n int64 }
+	var keep []seg
+	off := int64(2)
+	insertAt := int64(2)
+	for {
+		n, _ := src.ReadAt(buf, off)
+		if n < 4 || buf[0] != 0xFF {
+			return nil, errors.New("malformed jpeg header")
+		}
+		marker := buf[1]
+		if !(marker >= 0xE0 && marker <= 0xEF) && marker != 0xFE {
+			break // leading APPn/COM segments end here
+		}
+		segLen := 2 + int64(binary.BigEndian.Uint16(buf[2:4]))
+		if off+segLen > size {
+			return nil, errors.New("truncated jpeg segment")
+		}
+		payload := string(buf[4:n])
+		switch {
+		case marker == 0xE0 && off == 2 && strings.HasPrefix(payload, "JFIF\x00"):
+			insertAt = off + segLen
+		case marker == 0xE1 && (strings.HasPrefix(payload, exifPrefix) || strings.HasPrefix(payload, xmpPrefix)):
+			// replaced by ours
+		default:
+			keep = append(keep, seg{off, segLen})
+		}
+		off += segLen
+	}
+
+	parts := []*io.SectionReader{filePart(src, 0, insertAt)}
+	var b bytes.Buffer
+	app1 := func(prefix string, data []byte) {
+		n := 2 + len(prefix) + len(data)
+		if n > 0xFFFF {
+			return
+		}
+		b.Write([]byte{0xFF, 0xE1, byte(n >> 8), byte(n)})
+		b.WriteString(prefix)
+		b.Write(data)
+	}
+	app1(exifPrefix, exifTIFF(m))
+	app1(xmpPrefix, []byte(xmpPacket(m)))
+	parts = append(parts, bytesPart(b.Bytes()))
+	for _, s := range keep {
+		parts = append(parts, filePart(src, s.off, s.n))
+	}
+	return append(parts, filePart(src, off, size-off)), nil
+}
+
+// ---------- EXIF ----------
+
+type ifdEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	data  []byte
+}
+
+const (
+	tiffByte      = 1
+	tiffASCII     = 2
+	tiffLong      = 4
+	tiffRational  = 5
+	tiffUndefined = 7
+)
+
+func asciiEntry(tag uint16, s string) ifdEntry {
+	b := []byte(toASCII(s) + "\x00")
+	return ifdEntry{tag, tiffASCII, uint32(len(b)), b}
+}
+
+func rationalEntry(tag uint16, vals ...[2]uint32) ifdEntry {
+	b := make([]byte, 0, 8*len(vals))
+	for _, v := range vals {
+		b = binary.BigEndian.AppendUint32(b, v[0])
+		b = binary.BigEndian.AppendUint32(b, v[1])
+	}
+	return ifdEntry{tag, tiffRational, uint32(len(vals)), b}
+}
+
+func ifdSize(entries []ifdEntry) uint32 {
+	n := uint32(2 + 12*len(entries) + 4)
+	for _, e := range entries {
+		if len(e.data) > 4 {
+			n += uint32(len(e.data)+1) &^ 1
+		}
+	}
+	return n
+}
+
+// writeIFD appends an IFD that starts at TIFF offset start, values that
+// don't fit in an entry following it.
+func writeIFD(b []byte, entries []ifdEntry, start uint32) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(entries)))
+	data := start + uint32(2+12*len(entries)+4)
+	var extra []byte
+	for _, e := range entries {
+		b = binary.BigEndian.AppendUint16(b, e.tag)
+		b = binary.BigEndian.AppendUint16(b, e.typ)
+		b = binary.BigEndian.AppendUint32(b, e.count)
+		if len(e.data) <= 4 {
+			v := make([]byte, 4)
+			copy(v, e.data)
+			b = append(b, v...)
+			continue
+		}
+		b = binary.BigEndian.AppendUint32(b, data+uint32(len(extra)))
+		extra = append(extra, e.data...)
+		if len(extra)%2 == 1 {
+			extra = append(extra, 0)
+		}
+	}
+	b = binary.BigEndian.AppendUint32(b, 0) // no next IFD
+	return append(b, extra...)
+}
+
+func dms(v float64) [][2]uint32 {
+	v = math.Abs(v)
+	d := math.Floor(v)
+	mins := math.Floor((v - d) * 60)
+	secs := (v - d - mins/60) * 3600
+	return [][2]uint32{{uint32(d), 1}, {uint32(mins), 1}, {uint32(math.Round(secs * 1000)), 1000}}
+}
+
+// exifTIFF builds a big-endian TIFF header with IFD0, an Exif IFD and,
+// when the station location is known, a GPS IFD.
+func exifTIFF(m CaptureMeta) []byte {
+	var ifd0, exif, gps []ifdEntry
+	if d := m.description(); d != "" {
+		ifd0 = append(ifd0, asciiEntry(0x010E, d)) // ImageDescription
+	}
+	ifd0 = append(ifd0, asciiEntry(0x0131, "OnlySats")) // Software
+	if !m.Time.IsZero() {
+		ifd0 = append(ifd0, asciiEntry(0x0132, m.Time.Format("2006:01:02 15:04:05"))) // DateTime
+	}
+	if m.Station != "" {
+		ifd0 = append(ifd0, asciiEntry(0x013B, m.Station)) // Artist
+	}
+	if c := strings.Trim(m.Attribution+", "+m.License, ", "); c != "" {
+		ifd0 = append(ifd0, asciiEntry(0x8298, c)) // Copyright
+	}
+
+	exif = append(exif, ifdEntry{0x9000, tiffUndefined, 4, []byte("0232")}) // ExifVersion
+	if !m.Time.IsZero() {
+		exif = append(exif,
+			asciiEntry(0x9003, m.Time.Format("2006:01:02 15:04:05")), // DateTimeOriginal
+			asciiEntry(0x9011, "+00:00"))                             // OffsetTimeOriginal
+	}
+
+	if m.HasLocation {
+		latRef, lonRef := "N", "E"
+		if m.Lat < 0 {
+			latRef = "S"
+		}
+		if m.Lon < 0 {
+			lonRef = "W"
+		}
+		gps = []ifdEntry{
+			{0x0000, tiffByte, 4, []byte{2, 3, 0, 0}}, // GPSVersionID
+			asciiEntry(0x0001, latRef),
+			rationalEntry(0x0002, dms(m.Lat)...),
+			asciiEntry(0x0003, lonRef),
+			rationalEntry(0x0004, dms(m.Lon)...),
+		}
+	}
+
+	// pointer entries are fixed size, so offsets can be worked out first
+	ptr := func(tag uint16) ifdEntry { return ifdEntry{tag, tiffLong, 1, make([]byte, 4)} }
+	ifd0 = append(ifd0, ptr(0x8769)) // ExifIFDPointer
+	if gps != nil {
+		ifd0 = append(ifd0, ptr(0x8825)) // GPSInfoIFDPointer
+	}
+	exifOff := 8 + ifdSize(ifd0)
+	gpsOff := exifOff + ifdSize(exif)
+	for i := range ifd0 {
+		switch ifd0[i].tag {
+		case 0x8769:
+			binary.BigEndian.PutUint32(ifd0[i].data, exifOff)
+		case 0x8825:
+			binary.BigEndian.PutUint32(ifd0[i].data, gpsOff)
+		}
+	}
+
+	b := []byte{'M', 'M', 0, 42, 0, 0, 0, 8}
+	b = writeIFD(b, ifd0, 8)
+	b = writeIFD(b, exif, exifOff)
+	if gps != nil {
+		b = writeIFD(b, gps, gpsOff)
+	}
+	return b
+}
+
+// ---------- XMP ----------
+
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func xmpPacket(m CaptureMeta) string {
+	var b strings.Builder
+	prop := func(name, val string) {
+		if val != "" {
+			fmt.Fprintf(&b, "   <%s>%s</%s>\n", name, xmlEscape(val), name)
+		}
+	}
+	alt := func(name, val string) {
+		if val != "" {
+			fmt.Fprintf(&b, "   <%s><rdf:Alt><rdf:li xml:lang=\"x-default\">%s</rdf:li></rdf:Alt></%s>\n", name, xmlEscape(val), name)
+		}
+	}
+	b.WriteString("<?xpacket begin=\"\ufeff\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	b.WriteString(`<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:dc="http://purl.org/dc/elements/1.1/"
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    xmlns:photoshop="http://ns.adobe.com/photoshop/1.0/"
+    xmlns:exif="http://ns.adobe.com/exif/1.0/"
+    xmlns:onlysats="https://onlysatellites.com/xmp/1.0/">
+`)
+	alt("dc:title", m.title())
+	alt("dc:description", m.description())
+	if m.Station != "" {
+		fmt.Fprintf(&b, "   <dc:creator><rdf:Seq><rdf:li>%s</rdf:li></rdf:Seq></dc:creator>\n", xmlEscape(m.Station))
+	}
+	alt("dc:rights", m.License)
+	prop("photoshop:Credit", m.Attribution)
+	if !m.Time.IsZero() {
+		prop("photoshop:DateCreated", m.Time.Format(time.RFC3339))
+		prop("xmp:CreateDate", m.Time.Format(time.RFC3339))
+	}
+	prop("xmp:CreatorTool", "OnlySats")
+	if m.HasLocation {
+		prop("exif:GPSLatitude", xmpCoord(m.Lat, "N", "S"))
+		prop("exif:GPSLongitude", xmpCoord(m.Lon, "E", "W"))
+	}
+	prop("onlysats:Satellite", m.Satellite)
+	prop("onlysats:Composite", m.Composite)
+	prop("onlysats:Sensor", m.Sensor)
+	prop("onlysats:Station", m.Station)
+	prop("onlysats:Country", m.Country)
+	b.WriteString("  </rdf:Description>\n </rdf:RDF>\n</x:xmpmeta>\n<?xpacket end=\"r\"?>")
+	return b.String()
+}
+
+// xmpCoord formats "DDD,MM.mmmmk" as the XMP EXIF schema wants.
+func xmpCoord(v float64, pos, neg string) string {
+	ref := pos
+	if v < 0 {
+		ref = neg
+	}
+	v = math.Abs(v)
+	d := math.Floor(v)
+	return fmt.Sprintf("%d,%.4f%s", int(d), (v-d)*60, ref)
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 || s[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func toASCII(s string) string {
+	if isASCII(s) {
+		return s
+	}
+	var b strings.Builder
+	for _, r := range s {
+		if r >= 0x20 && r <= 0x7e {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,207 @@
+package com
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testCapture = CaptureMeta{
+	Satellite:   "NOAA 19",
+	Composite:   "MCIR",
+	Sensor:      "AVHRR",
+	Time:        time.Date(2026, 3, 1, 12, 34, 56, 0, time.UTC),
+	Station:     "Ørsted Ground",
+	Lat:         55.6761,
+	Lon:         -12.5683,
+	HasLocation: true,
+	License:     "CC BY 4.0",
+	Attribution: "OnlySats",
+}
+
+func testImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 8))
+	for x := 0; x < 16; x++ {
+		for y := 0; y < 8; y++ {
+			img.Set(x, y, color.RGBA{uint8(x * 16), uint8(y * 32), 128, 255})
+		}
+	}
+	return img
+}
+
+// spliceAll runs splice over src and returns the served file.
+func spliceAll(t *testing.T, src []byte, splice func(io.ReaderAt, int64, CaptureMeta) ([]*io.SectionReader, error)) []byte {
+	t.Helper()
+	parts, err := splice(bytes.NewReader(src), int64(len(src)), testCapture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := io.ReadAll(newSpliced(parts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := image.Decode(bytes.NewReader(out)); err != nil {
+		t.Fatalf("spliced file doesn't decode: %v", err)
+	}
+	return out
+}
+
+func TestSplicePNG(t *testing.T) {
+	var src bytes.Buffer
+	if err := png.Encode(&src, testImage()); err != nil {
+		t.Fatal(err)
+	}
+	out := spliceAll(t, src.Bytes(), splicePNG)
+
+	// walk the chunks; image.Decode has already checked their CRCs
+	text := map[string]string{}
+	var order []string
+	for b := out[8:]; len(b) >= 12; {
+		n := binary.BigEndian.Uint32(b)
+		typ, data := string(b[4:8]), b[8:8+n]
+		order = append(order, typ)
+		switch typ {
+		case "tEXt":
+			k, v, _ := strings.Cut(string(data), "\x00")
+			text[k] = v
+		case "iTXt":
+			k, v, _ := strings.Cut(string(data), "\x00")
+			text[k] = v[4:] // compression flag and method, empty language and keyword
+		}
+		b = b[12+n:]
+	}
+	if order[0] != "IHDR" || order[1] != "tEXt" || order[len(order)-1] != "IEND" {
+		t.Errorf("chunk order %v", order)
+	}
+	for k, want := range map[string]string{
+		"Title":         "NOAA 19 MCIR",
+		"Description":   "NOAA 19 MCIR (AVHRR), captured 2026-03-01 12:34:56 UTC, received by Ørsted Ground",
+		"Author":        "Ørsted Ground",
+		"Copyright":     "CC BY 4.0",
+		"Comment":       "OnlySats",
+		"Creation Time": "Sun, 01 Mar 2026 12:34:56 UTC",
+		"Source":        "NOAA 19 AVHRR",
+		"Sensor":        "AVHRR",
+		"Location":      "55.67610, -12.56830",
+	} {
+		if text[k] != want {
+			t.Errorf("%s = %q, want %q", k, text[k], want)
+		}
+	}
+	if !strings.Contains(text["XML:com.adobe.xmp"], "<onlysats:Satellite>NOAA 19</onlysats:Satellite>") {
+		t.Errorf("xmp packet missing or incomplete: %q", text["XML:com.adobe.xmp"])
+	}
+
+	if _, err := splicePNG(bytes.NewReader(out[:20]), 20, testCapture); err == nil {
+		t.Error("spliced a truncated png")
+	}
+}
+
+func TestSpliceJPEG(t *testing.T) {
+	var enc bytes.Buffer
+	if err := jpeg.Encode(&enc, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	// SOI, JFIF, an old EXIF segment to be replaced, a comment to be kept,
+	// then the encoder's tables and scan
+	segment := func(marker byte, payload string) []byte {
+		n := 2 + len(payload)
+		return append([]byte{0xFF, marker, byte(n >> 8), byte(n)}, payload...)
+	}
+	src := []byte{0xFF, 0xD8}
+	src = append(src, segment(0xE0, "JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00")...)
+	src = append(src, segment(0xE1, exifPrefix+"stale")...)
+	src = append(src, segment(0xFE, "kept")...)
+	src = append(src, enc.Bytes()[2:]...)
+	out := spliceAll(t, src, spliceJPEG)
+
+	type seg struct {
+		marker  byte
+		payload string
+	}
+	var segs []seg
+	for off := 2; out[off+1] != 0xDB; { // up to the first DQT
+		n := int(binary.BigEndian.Uint16(out[off+2:]))
+		segs = append(segs, seg{out[off+1], string(out[off+4 : off+2+n])})
+		off += 2 + n
+	}
+	if len(segs) != 4 || segs[0].marker != 0xE0 || segs[3].payload != "kept" ||
+		!strings.HasPrefix(segs[1].payload, exifPrefix) || !strings.HasPrefix(segs[2].payload, xmpPrefix) {
+		t.Fatalf("segments before the image data: %q", segs)
+	}
+	if !strings.Contains(segs[2].payload, "<photoshop:Credit>OnlySats</photoshop:Credit>") {
+		t.Errorf("xmp packet incomplete: %q", segs[2].payload)
+	}
+
+	tiff := []byte(segs[1].payload[len(exifPrefix):])
+	ifd0 := readTestIFD(t, tiff, 8)
+	exif := readTestIFD(t, tiff, binary.BigEndian.Uint32(ifd0[0x8769]))
+	gps := readTestIFD(t, tiff, binary.BigEndian.Uint32(ifd0[0x8825]))
+	for _, tc := range []struct {
+		ifd  map[uint16][]byte
+		tag  uint16
+		want string
+	}{
+		{ifd0, 0x010E, "NOAA 19 MCIR (AVHRR), captured 2026-03-01 12:34:56 UTC, received by ?rsted Ground"},
+		{ifd0, 0x0131, "OnlySats"},
+		{ifd0, 0x0132, "2026:03:01 12:34:56"},
+		{ifd0, 0x013B, "?rsted Ground"},
+		{ifd0, 0x8298, "OnlySats, CC BY 4.0"},
+		{exif, 0x9000, "0232"},
+		{exif, 0x9003, "2026:03:01 12:34:56"},
+		{exif, 0x9011, "+00:00"},
+		{gps, 0x0001, "N"},
+		{gps, 0x0003, "W"},
+	} {
+		if got := strings.TrimSuffix(string(tc.ifd[tc.tag]), "\x00"); got != tc.want {
+			t.Errorf("tag %#04x = %q, want %q", tc.tag, got, tc.want)
+		}
+	}
+	deg := func(b []byte) float64 {
+		var v float64
+		for i, unit := range []float64{1, 60, 3600} {
+			r := b[8*i:]
+			v += float64(binary.BigEndian.Uint32(r)) / float64(binary.BigEndian.Uint32(r[4:])) / unit
+		}
+		return v
+	}
+	if lat, lon := deg(gps[0x0002]), deg(gps[0x0004]); math.Abs(lat-55.6761) > 1e-6 || math.Abs(lon-12.5683) > 1e-6 {
+		t.Errorf("gps position %v, %v", lat, lon)
+	}
+
+	if _, err := spliceJPEG(bytes.NewReader(src[:30]), 30, testCapture); err == nil {
+		t.Error("spliced a truncated jpeg")
+	}
+}
+
+// readTestIFD returns the raw value of each entry of the big-endian IFD at
+// off in tiff.
+func readTestIFD(t *testing.T, tiff []byte, off uint32) map[uint16][]byte {
+	t.Helper()
+	if string(tiff[:8]) != "MM\x00\x2a\x00\x00\x00\x08" {
+		t.Fatalf("tiff header %q", tiff[:8])
+	}
+	size := map[uint16]uint32{tiffByte: 1, tiffASCII: 1, tiffLong: 4, tiffRational: 8, tiffUndefined: 1}
+	out := map[uint16][]byte{}
+	n := binary.BigEndian.Uint16(tiff[off:])
+	for i := uint32(0); i < uint32(n); i++ {
+		e := tiff[off+2+12*i:]
+		tag, typ, count := binary.BigEndian.Uint16(e), binary.BigEndian.Uint16(e[2:]), binary.BigEndian.Uint32(e[4:])
+		l := size[typ] * count
+		v := e[8 : 8+l]
+		if l > 4 {
+			p := binary.BigEndian.Uint32(e[8:])
+			v = tiff[p : p+l]
+		}
+		out[tag] = v
+	}
+	return out
+}
@@ -0,0 +1,68 @@
+package com
+
+import (
+	"OnlySats/com/logging"
+	"sync"
+	"time"
+)
+
+var eventLog = logging.For("events")
+
+// Event types published on the in-process bus
+const (
+	EventPassIngested   = "pass.ingested"
+	EventMessagePosted  = "message.posted"
+	EventSatdumpOffline = "satdump.offline"
+	EventSatdumpOnline  = "satdump.online"
+	EventAlertRaised    = "alert.raised"
+	EventAlertResolved  = "alert.resolved"
+	EventLoginLockout   = "login.lockout"
+	EventTest           = "test"
+)
+
+type Event struct {
+	Type string         `json:"type"`
+	Time time.Time      `json:"time"`
+	Data map[string]any `json:"data"`
+}
+
+type EventHandler func(Event)
+
+var (
+	eventMu   sync.RWMutex
+	eventSubs []EventHandler
+)
+
+// registers fn to receive every published event. fn must not block.
+func SubscribeEvents(fn EventHandler) {
+	if fn == nil {
+		return
+	}
+	eventMu.Lock()
+	eventSubs = append(eventSubs, fn)
+	eventMu.Unlock()
+}
+
+// fans an event out to all subscribers. Safe to call with no subscribers.
+func PublishEvent(typ string, data map[string]any) {
+	ev := Event{Type: typ, Time: time.Now().UTC(), Data: data}
+	if ev.Data == nil {
+		ev.Data = map[string]any{}
+	}
+
+	eventMu.RLock()
+	subs := make([]EventHandler, len(eventSubs))
+	copy(subs, eventSubs)
+	eventMu.RUnlock()
+
+	for _, fn := range subs {
+		func() {
+			defer func() {
+				if rec := recover(); rec != nil {
+					eventLog.Error("subscriber panic", "event", typ, "panic", rec)
+				}
+			}()
+			fn(ev)
+		}()
+	}
+}
@@ -0,0 +1,616 @@
+package com
+
+import (
+	"OnlySats/com/logging"
+	"OnlySats/config"
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var exportLog = logging.For("exports")
+
+var (
+	// ErrBadExport wraps problems with a request, as opposed to building.
+	ErrBadExport = errors.New("invalid export request")
+	// ErrExportTooLarge means the selection is over the file or size limit.
+	ErrExportTooLarge = errors.New("export too large")
+	// ErrExportBusy means the queue or the download slots are full; retry later.
+	ErrExportBusy = errors.New("export queue is full")
+)
+
+// ExportFile is one file in a bulk export, relative to the live output dir.
+type ExportFile struct {
+	Path      string `json:"path"`
+	Kind      string `json:"kind"` // image | raw
+	Satellite string `json:"satellite,omitempty"`
+	Composite string `json:"composite,omitempty"`
+	Sensor    string `json:"sensor,omitempty"`
+	Pass      string `json:"pass,omitempty"`
+	Timestamp int64  `json:"timestamp,omitempty"`
+	Size      int64  `json:"size"`
+}
+
+// Export is a background ZIP job and, once done, its archive.
+type Export struct {
+	ID         int64      `json:"id"`
+	Filters    string     `json:"filters"` // the /api/images query it was made from
+	Raw        bool       `json:"raw"`
+	Manifest   string     `json:"manifest"` // csv | json | none
+	Status     string     `json:"status"`   // queued | running | done | failed | expired
+	Files      int        `json:"files"`
+	Bytes      int64      `json:"bytes"` // source files together
+	Written    int        `json:"written"`
+	Progress   float64    `json:"progress"` // 0..1
+	Size       int64      `json:"size,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	URL        string     `json:"url,omitempty"` // set once done
+	CreatedAt  time.Time  `json:"createdAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+
+	files []ExportFile
+}
+
+// ---------- Store ----------
+
+const exportCols = `id, filters, raw, manifest, files_json, file_count, total_bytes, status, written, size_bytes,
+	COALESCE(error, ''), created_ts, finished_ts`
+
+func scanExport(row rowScanner, keep time.Duration) (*Export, error) {
+	var (
+		e        Export
+		files    string
+		created  int64
+		finished sql.NullInt64
+	)
+	if err := row.Scan(&e.ID, &e.Filters, &e.Raw, &e.Manifest, &files, &e.Files, &e.Bytes, &e.Status, &e.Written,
+		&e.Size, &e.Error, &created, &finished); err != nil {
+		return nil, err
+	}
+	e.CreatedAt = time.Unix(created, 0).UTC()
+	if finished.Valid {
+		t := time.Unix(finished.Int64, 0).UTC()
+		e.FinishedAt = &t
+		if e.Status == "done" && keep > 0 {
+			exp := t.Add(keep)
+			e.ExpiresAt = &exp
+		}
+	}
+	if err := json.Unmarshal([]byte(files), &e.files); err != nil {
+		return nil, fmt.Errorf("export %d file list: %w", e.ID, err)
+	}
+	if e.Files > 0 {
+		e.Progress = float64(e.Written) / float64(e.Files)
+	}
+	if e.Status == "done" {
+		e.Progress = 1
+		e.URL = fmt.Sprintf("/api/exports/%d/file", e.ID)
+	}
+	return &e, nil
+}
+
+func (s *LocalDataStore) insertExport(ctx context.Context, e *Export, key, client string) (int64, error) {
+	files, err := json.Marshal(e.files)
+	if err != nil {
+		return 0, err
+	}
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO exports (key, filters, raw, manifest, files_json, file_count, total_bytes, client_ip)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		key, e.Filters, e.Raw, e.Manifest, string(files), e.Files, e.Bytes, client)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (s *LocalDataStore) exportByKey(ctx context.Context, key string, keep time.Duration) (*Export, error) {
+	return scanExport(s.db.QueryRowContext(ctx, `
+		SELECT `+exportCols+` FROM exports
+		WHERE key = ? AND status IN ('queued', 'running', 'done') ORDER BY id DESC LIMIT 1`, key), keep)
+}
+
+// pendingExports counts unfinished jobs, all of them and those from client.
+func (s *LocalDataStore) pendingExports(ctx context.Context, client string) (total, mine int, err error) {
+	err = s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*), COALESCE(SUM(client_ip = ?), 0) FROM exports WHERE status IN ('queued', 'running')`,
+		client).Scan(&total, &mine)
+	return total, mine, err
+}
+
+func (s *LocalDataStore) nextQueuedExport(ctx context.Context) (*Export, error) {
+	e, err := scanExport(s.db.QueryRowContext(ctx, `
+		SELECT `+exportCols+` FROM exports WHERE status = 'queued' ORDER BY id LIMIT 1`), 0)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return e, err
+}
+
+func (s *LocalDataStore) setExportState(ctx context.Context, id int64, status string, written int, size int64, msg string) error {
+	var finished any
+	if status == "done" || status == "failed" {
+		finished = time.Now().Unix()
+	}
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE exports SET status = ?, written = ?, size_bytes = ?, error = ?, finished_ts = COALESCE(?, finished_ts) WHERE id = ?`,
+		status, written, size, nullIfEmpty(msg), finished, id)
+	return err
+}
+
+func (s *LocalDataStore) exportProgress(ctx context.Context, id int64, written int) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE exports SET written = ? WHERE id = ?`, written, id)
+	return err
+}
+
+func (s *LocalDataStore) requeueExports(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE exports SET status = 'queued', written = 0 WHERE status = 'running'`)
+	return err
+}
+
+// expiredExports lists finished archives older than cutoff.
+func (s *LocalDataStore) expiredExports(ctx context.Context, cutoff time.Time) ([]int64, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id FROM exports WHERE status = 'done' AND finished_ts < ?`, cutoff.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (s *LocalDataStore) deleteExport(ctx context.Context, id int64) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM exports WHERE id = ?`, id)
+	return err
+}
+
+// ---------- Builder ----------
+
+// Exporter packs gallery search results into ZIP archives. Small selections
+// can be streamed straight to the client; larger ones are built one at a
+// time into Dir as <id>.zip and kept for Keep.
+type Exporter struct {
+	Store         *LocalDataStore
+	LiveOutputDir string
+	Dir           string
+	MaxFiles      int
+	MaxBytes      int64
+	SyncMaxBytes  int64
+	MaxQueued     int
+	PerClient     int
+	Keep          time.Duration
+
+	downloads chan struct{}
+	kick      chan struct{}
+}
+
+// NewExporter returns nil when [exports] is disabled.
+func NewExporter(cfg *config.AppConfig, store *LocalDataStore) *Exporter {
+	ec := cfg.Exports
+	if !ec.Enabled {
+		return nil
+	}
+	x := &Exporter{
+		Store:         store,
+		LiveOutputDir: cfg.Paths.LiveOutputDir,
+		Dir:           ec.Dir,
+		MaxFiles:      ec.MaxFiles,
+		MaxBytes:      int64(ec.MaxSize) << 20,
+		SyncMaxBytes:  int64(ec.SyncMaxSize) << 20,
+		MaxQueued:     ec.MaxQueued,
+		PerClient:     ec.PerClient,
+		Keep:          time.Duration(ec.KeepHours) * time.Hour,
+		kick:          make(chan struct{}, 1),
+	}
+	if strings.TrimSpace(x.Dir) == "" {
+		x.Dir = filepath.Join(cfg.Paths.DataDir, "exports")
+	}
+	if x.MaxFiles <= 0 {
+		x.MaxFiles = 5000
+	}
+	if x.MaxBytes <= 0 {
+		x.MaxBytes = 4096 << 20
+	}
+	if x.MaxQueued <= 0 {
+		x.MaxQueued = 20
+	}
+	if x.PerClient <= 0 {
+		x.PerClient = 2
+	}
+	if x.Keep <= 0 {
+		x.Keep = 24 * time.Hour
+	}
+	x.downloads = make(chan struct{}, max(1, ec.MaxDownloads))
+	return x
+}
+
+// Path is where a finished export's archive is kept.
+func (x *Exporter) Path(e *Export) string {
+	return filepath.Join(x.Dir, strconv.FormatInt(e.ID, 10)+".zip")
+}
+
+// Get returns an export with its expiry filled in.
+func (x *Exporter) Get(ctx context.Context, id int64) (*Export, error) {
+	return scanExport(x.Store.db.QueryRowContext(ctx, `SELECT `+exportCols+` FROM exports WHERE id = ?`, id), x.Keep)
+}
+
+// List returns the newest exports first.
+func (x *Exporter) List(ctx context.Context, limit int) ([]Export, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+	rows, err := x.Store.db.QueryContext(ctx, `SELECT `+exportCols+` FROM exports ORDER BY id DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Export{}
+	for rows.Next() {
+		e, err := scanExport(rows, x.Keep)
+		if err != nil {
+			return nil, err
+		}
+		e.files = nil
+		out = append(out, *e)
+	}
+	return out, rows.Err()
+}
+
+// Acquire takes one of the download slots shared by streamed exports and
+// archive downloads; ok is false when all are in use.
+func (x *Exporter) Acquire() (release func(), ok bool) {
+	select {
+	case x.downloads <- struct{}{}:
+		return func() { <-x.downloads }, true
+	default:
+		return nil, false
+	}
+}
+
+// Plan sizes files, dropping those missing from disk, and enforces the
+// per-export limits.
+func (x *Exporter) Plan(files []ExportFile) ([]ExportFile, int64, error) {
+	root, err := filepath.Abs(x.LiveOutputDir)
+	if err != nil {
+		return nil, 0, err
+	}
+	out := make([]ExportFile, 0, len(files))
+	seen := make(map[string]bool, len(files))
+	var total int64
+	for _, f := range files {
+		if seen[f.Path] {
+			continue
+		}
+		seen[f.Path] = true
+		full, ok := exportPath(root, f.Path)
+		if !ok {
+			continue
+		}
+		info, err := os.Stat(full)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		f.Size = info.Size()
+		total += f.Size
+		out = append(out, f)
+	}
+	switch {
+	case len(out) == 0:
+		return nil, 0, fmt.Errorf("%w: no files match", ErrBadExport)
+	case len(out) > x.MaxFiles:
+		return nil, 0, fmt.Errorf("%w: %d files match, the limit is %d; narrow the filters", ErrExportTooLarge, len(out), x.MaxFiles)
+	case total > x.MaxBytes:
+		return nil, 0, fmt.Errorf("%w: %d MB match, the limit is %d MB; narrow the filters", ErrExportTooLarge, total>>20, x.MaxBytes>>20)
+	}
+	return out, total, nil
+}
+
+// exportPath resolves rel under root, refusing anything that escapes it.
+func exportPath(root, rel string) (string, bool) {
+	clean := path.Clean("/" + filepath.ToSlash(rel))
+	if clean == "/" {
+		return "", false
+	}
+	return filepath.Join(root, filepath.FromSlash(clean[1:])), true
+}
+
+// Create queues an export of planned files, or returns an earlier one of
+// the same files and options; created reports which.
+func (x *Exporter) Create(ctx context.Context, e *Export, files []ExportFile, total int64, client string) (*Export, bool, error) {
+	e.files, e.Files, e.Bytes, e.Status = files, len(files), total, "queued"
+	key := exportKey(e)
+	prev, err := x.Store.exportByKey(ctx, key, x.Keep)
+	switch {
+	case err == nil:
+		if prev.Status != "done" {
+			return prev, false, nil
+		}
+		if _, err := os.Stat(x.Path(prev)); err == nil {
+			return prev, false, nil
+		}
+	case !errors.Is(err, sql.ErrNoRows):
+		return nil, false, err
+	}
+
+	pending, mine, err := x.Store.pendingExports(ctx, client)
+	if err != nil {
+		return nil, false, err
+	}
+	if pending >= x.MaxQueued {
+		return nil, false, fmt.Errorf("%w: %d exports are waiting", ErrExportBusy, pending)
+	}
+	if mine >= x.PerClient {
+		return nil, false, fmt.Errorf("%w: you already have %d exports waiting", ErrExportBusy, mine)
+	}
+
+	e.ID, err = x.Store.insertExport(ctx, e, key, client)
+	if err != nil {
+		return nil, false, err
+	}
+	e.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	select {
+	case x.kick <- struct{}{}:
+	default:
+	}
+	return e, true, nil
+}
+
+func exportKey(e *Export) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%t|%s", e.Raw, e.Manifest)
+	for _, f := range e.files {
+		fmt.Fprintf(h, "|%s:%d", f.Path, f.Size)
+	}
+	return hex.EncodeToString(h.Sum(nil)[:12])
+}
+
+// Delete removes an export and its archive.
+func (x *Exporter) Delete(ctx context.Context, id int64) error {
+	e, err := x.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if e.Status == "running" {
+		return fmt.Errorf("%w: export is being built", ErrExportBusy)
+	}
+	if err := os.Remove(x.Path(e)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return x.Store.deleteExport(ctx, id)
+}
+
+// Start builds queued exports and deletes expired archives until ctx is
+// done. Jobs interrupted by a shutdown are built again from the start.
+func (x *Exporter) Start(ctx context.Context) {
+	if err := os.MkdirAll(x.Dir, 0o755); err != nil {
+		exportLog.Error("create export dir", "dir", x.Dir, "err", err)
+		return
+	}
+	if err := x.Store.requeueExports(ctx); err != nil {
+		exportLog.Warn("requeue interrupted exports", "err", err)
+	}
+	exportLog.Info("starting export builder", "dir", x.Dir, "max_files", x.MaxFiles, "max_mb", x.MaxBytes>>20)
+	go x.loop(ctx)
+	go x.janitor(ctx)
+}
+
+func (x *Exporter) loop(ctx context.Context) {
+	for {
+		e, err := x.Store.nextQueuedExport(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			exportLog.Error("export queue", "err", err)
+		}
+		if e != nil {
+			x.run(ctx, e)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-x.kick:
+		}
+	}
+}
+
+func (x *Exporter) janitor(ctx context.Context) {
+	t := time.NewTicker(15 * time.Minute)
+	defer t.Stop()
+	for {
+		ids, err := x.Store.expiredExports(ctx, time.Now().Add(-x.Keep))
+		if err != nil && ctx.Err() == nil {
+			exportLog.Warn("list expired exports", "err", err)
+		}
+		for _, id := range ids {
+			p := filepath.Join(x.Dir, strconv.FormatInt(id, 10)+".zip")
+			if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+				exportLog.Warn("remove expired export", "id", id, "err", err)
+				continue
+			}
+			if err := x.Store.setExportState(ctx, id, "expired", 0, 0, ""); err != nil {
+				exportLog.Warn("expire export", "id", id, "err", err)
+			}
+			exportLog.Debug("export expired", "id", id)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (x *Exporter) run(ctx context.Context, e *Export) {
+	if err := x.Store.setExportState(ctx, e.ID, "running", 0, 0, ""); err != nil {
+		exportLog.Warn("start export", "id", e.ID, "err", err)
+		return
+	}
+	start := time.Now()
+	exportLog.Info("building export", "id", e.ID, "files", e.Files, "mb", e.Bytes>>20)
+
+	written, size, err := x.build(ctx, e)
+	if ctx.Err() != nil {
+		return // requeued on the next start
+	}
+	if err != nil {
+		exportsBuilt.Inc("failed")
+		exportLog.Warn("export failed", "id", e.ID, "err", err)
+		err = x.Store.setExportState(ctx, e.ID, "failed", written, 0, err.Error())
+	} else {
+		exportsBuilt.Inc("done")
+		exportLog.Info("export done", "id", e.ID, "files", written, "bytes", size,
+			"elapsed", time.Since(start).Truncate(time.Millisecond))
+		err = x.Store.setExportState(ctx, e.ID, "done", written, size, "")
+	}
+	if err != nil {
+		exportLog.Warn("update export", "id", e.ID, "err", err)
+	}
+}
+
+func (x *Exporter) build(ctx context.Context, e *Export) (int, int64, error) {
+	tmp, err := os.CreateTemp(x.Dir, ".export-*")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	var written int
+	lastSaved := time.Now()
+	err = x.Write(ctx, tmp, e.files, e.Manifest, func(n int) {
+		written = n
+		if time.Since(lastSaved) > 2*time.Second {
+			lastSaved = time.Now()
+			_ = x.Store.exportProgress(ctx, e.ID, n)
+		}
+	})
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return written, 0, err
+	}
+	info, err := os.Stat(tmp.Name())
+	if err != nil {
+		return written, 0, err
+	}
+	if err := os.Rename(tmp.Name(), x.Path(e)); err != nil {
+		return written, 0, err
+	}
+	return written, info.Size(), nil
+}
+
+// Write streams a ZIP of files, preceded by the manifest unless it is
+// "none", to w. progress, if set, gets the number of files written so far.
+// Files that vanished since planning are skipped.
+func (x *Exporter) Write(ctx context.Context, w io.Writer, files []ExportFile, manifest string, progress func(int)) error {
+	root, err := filepath.Abs(x.LiveOutputDir)
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(w)
+	if name, data, err := exportManifest(files, manifest); err != nil {
+		return err
+	} else if name != "" {
+		wr, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+		if err != nil {
+			return err
+		}
+		if _, err := wr.Write(data); err != nil {
+			return err
+		}
+	}
+	for i, f := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		full, ok := exportPath(root, f.Path)
+		if !ok {
+			continue
+		}
+		if err := addExportFile(zw, full, f); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		if progress != nil {
+			progress(i + 1)
+		}
+	}
+	return zw.Close()
+}
+
+func addExportFile(zw *zip.Writer, full string, f ExportFile) error {
+	src, err := os.Open(full)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	hdr, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	hdr.Name = strings.TrimPrefix(path.Clean("/"+f.Path), "/")
+	hdr.Method = zip.Deflate
+	switch strings.ToLower(path.Ext(f.Path)) {
+	case ".png", ".jpg", ".jpeg", ".webp", ".avif", ".gif", ".zip", ".gz", ".zst", ".7z":
+		hdr.Method = zip.Store // already compressed; deflate would only cost CPU
+	}
+	wr, err := zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(wr, src)
+	return err
+}
+
+func exportManifest(files []ExportFile, format string) (string, []byte, error) {
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(files, "", "  ")
+		return "manifest.json", data, err
+	case "csv":
+		var buf bytes.Buffer
+		cw := csv.NewWriter(&buf)
+		_ = cw.Write([]string{"path", "kind", "satellite", "composite", "sensor", "pass", "timestamp", "size"})
+		for _, f := range files {
+			ts := ""
+			if f.Timestamp > 0 {
+				ts = time.Unix(f.Timestamp, 0).UTC().Format(time.RFC3339)
+			}
+			_ = cw.Write([]string{f.Path, f.Kind, f.Satellite, f.Composite, f.Sensor, f.Pass, ts, strconv.FormatInt(f.Size, 10)})
+		}
+		cw.Flush()
+		return "manifest.csv", buf.Bytes(), cw.Error()
+	}
+	return "", nil, nil
+}
@@ -0,0 +1,256 @@
+package com
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNoGeoRef means no SatDump product metadata was found for an image.
+	ErrNoGeoRef = errors.New("no product metadata with a projection for this image")
+	// ErrProjection means the metadata exists but can't be turned into a
+	// lat/lon box (swath geometry, geostationary disk, ...).
+	ErrProjection = errors.New("unsupported projection")
+)
+
+// GeoRef places an equirectangular (plate carrée) image on the globe.
+type GeoRef struct {
+	Projection string
+	Width      int
+	Height     int
+	West       float64 // outer edge of the first column, degrees
+	North      float64 // outer edge of the first row, degrees
+	LonPerPx   float64
+	LatPerPx   float64 // negative: rows run southwards
+	Metadata   string  // product file the projection was read from
+}
+
+// productFiles are the names SatDump gives product metadata, preferred first.
+var productFiles = []string{"product.cbor", "product.json"}
+
+// LoadGeoRef reads the projection of the image at path from the nearest
+// product file in its directory or a parent, stopping below root.
+func LoadGeoRef(root, path string) (*GeoRef, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, _, err := image.DecodeConfig(f)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("read image size: %w", err)
+	}
+
+	root = filepath.Clean(root)
+	for dir := filepath.Dir(path); dir != root && strings.HasPrefix(dir, root+string(filepath.Separator)); dir = filepath.Dir(dir) {
+		for _, name := range productFiles {
+			p := filepath.Join(dir, name)
+			data, err := os.ReadFile(p)
+			if err != nil {
+				continue
+			}
+			product, err := parseProduct(name, data)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", p, err)
+			}
+			proj, ok := product["projection_cfg"].(map[string]any)
+			if !ok {
+				return nil, ErrNoGeoRef
+			}
+			g, err := parseProjection(proj, cfg.Width, cfg.Height)
+			if err != nil {
+				return nil, err
+			}
+			g.Metadata = p
+			return g, nil
+		}
+	}
+	return nil, ErrNoGeoRef
+}
+
+func parseProduct(name string, data []byte) (map[string]any, error) {
+	var v any
+	var err error
+	if strings.HasSuffix(name, ".cbor") {
+		v, err = decodeCBOR(data)
+	} else {
+		err = json.Unmarshal(data, &v)
+	}
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, errors.New("product metadata is not an object")
+	}
+	return m, nil
+}
+
+// parseProjection understands both forms SatDump has used for
+// equirectangular output: corner coordinates (tl_lon, tl_lat, br_lon,
+// br_lat) and an origin with per-pixel scale (offset_x/y, scalar_x/y).
+func parseProjection(cfg map[string]any, w, h int) (*GeoRef, error) {
+	typ, _ := cfg["type"].(string)
+	switch typ {
+	case "equirec", "equirectangular":
+	case "":
+		return nil, fmt.Errorf("%w: projection_cfg has no type", ErrProjection)
+	default:
+		return nil, fmt.Errorf("%w: %q is not a lat/lon grid; only equirectangular products can be exported", ErrProjection, typ)
+	}
+	g := &GeoRef{Projection: typ, Width: w, Height: h}
+
+	if west, ok := cfgNum(cfg, "tl_lon"); ok {
+		north, ok1 := cfgNum(cfg, "tl_lat")
+		east, ok2 := cfgNum(cfg, "br_lon")
+		south, ok3 := cfgNum(cfg, "br_lat")
+		if !ok1 || !ok2 || !ok3 {
+			return nil, fmt.Errorf("%w: incomplete corner coordinates", ErrProjection)
+		}
+		if east <= west {
+			east += 360 // crosses the antimeridian
+		}
+		g.West, g.North = west, north
+		g.LonPerPx = (east - west) / float64(w)
+		g.LatPerPx = (south - north) / float64(h)
+	} else {
+		ox, ok1 := cfgNum(cfg, "offset_x")
+		oy, ok2 := cfgNum(cfg, "offset_y")
+		sx, ok3 := cfgNum(cfg, "scalar_x")
+		sy, ok4 := cfgNum(cfg, "scalar_y")
+		if !ok1 || !ok2 || !ok3 || !ok4 {
+			return nil, fmt.Errorf("%w: projection_cfg has neither corners nor offset/scalar", ErrProjection)
+		}
+		// scalars are per pixel of the product; composites may be resized
+		if pw, ok := cfgNum(cfg, "width"); ok && pw > 0 && int(pw) != w {
+			sx *= pw / float64(w)
+		}
+		if ph, ok := cfgNum(cfg, "height"); ok && ph > 0 && int(ph) != h {
+			sy *= ph / float64(h)
+		}
+		g.West, g.North, g.LonPerPx, g.LatPerPx = ox, oy, sx, sy
+	}
+
+	west, south, east, north := g.Bounds()
+	switch {
+	case g.LonPerPx <= 0 || g.LatPerPx >= 0:
+		return nil, fmt.Errorf("%w: image must run west to east and north to south", ErrProjection)
+	case north > 90.0001 || south < -90.0001 || east-west > 360.0001:
+		return nil, fmt.Errorf("%w: bounds out of range", ErrProjection)
+	}
+	return g, nil
+}
+
+func cfgNum(cfg map[string]any, key string) (float64, bool) {
+	switch v := cfg[key].(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// Bounds returns the outer edges in degrees. east may exceed 180 when the
+// image crosses the antimeridian.
+func (g *GeoRef) Bounds() (west, south, east, north float64) {
+	return g.West, g.North + g.LatPerPx*float64(g.Height), g.West + g.LonPerPx*float64(g.Width), g.North
+}
+
+func normLon(lon float64) float64 {
+	for lon > 180 {
+		lon -= 360
+	}
+	for lon < -180 {
+		lon += 360
+	}
+	return lon
+}
+
+// WorldFile returns the six-line ESRI world file; coordinates are of the
+// centre of the top-left pixel.
+func (g *GeoRef) WorldFile() []byte {
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	return []byte(strings.Join([]string{
+		f(g.LonPerPx), "0", "0", f(g.LatPerPx),
+		f(g.West + g.LonPerPx/2), f(g.North + g.LatPerPx/2),
+	}, "\n") + "\n")
+}
+
+// WorldFileExt is the world file extension GIS tools look for beside an
+// image with extension ext (.pgw for .png and so on).
+func WorldFileExt(ext string) string {
+	switch strings.ToLower(ext) {
+	case ".png":
+		return ".pgw"
+	case ".jpg", ".jpeg":
+		return ".jgw"
+	case ".tif", ".tiff":
+		return ".tfw"
+	}
+	return ".wld"
+}
+
+// WGS84PRJ is the .prj that tells GIS tools world-file coordinates are
+// plain WGS 84 longitude/latitude.
+const WGS84PRJ = `GEOGCS["GCS_WGS_1984",DATUM["D_WGS_1984",SPHEROID["WGS_1984",6378137.0,298.257223563]],PRIMEM["Greenwich",0.0],UNIT["Degree",0.0174532925199433]]`
+
+// KML returns a document with one GroundOverlay of href. when is optional.
+func (g *GeoRef) KML(name, description, href string, when time.Time) []byte {
+	west, south, east, north := g.Bounds()
+	esc := xmlEscape
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	b.WriteString(`<kml xmlns="http://www.opengis.net/kml/2.2">` + "\n<Document>\n")
+	fmt.Fprintf(&b, "<name>%s</name>\n<GroundOverlay>\n<name>%s</name>\n", esc(name), esc(name))
+	if description != "" {
+		fmt.Fprintf(&b, "<description>%s</description>\n", esc(description))
+	}
+	if !when.IsZero() {
+		fmt.Fprintf(&b, "<TimeStamp><when>%s</when></TimeStamp>\n", when.UTC().Format(time.RFC3339))
+	}
+	fmt.Fprintf(&b, "<Icon><href>%s</href></Icon>\n", esc(href))
+	// KML wants longitudes in -180..180; west > east marks an antimeridian crossing
+	fmt.Fprintf(&b, "<LatLonBox><north>%s</north><south>%s</south><east>%s</east><west>%s</west></LatLonBox>\n",
+		f(north), f(south), f(normLon(east)), f(normLon(west)))
+	b.WriteString("</GroundOverlay>\n</Document>\n</kml>\n")
+	return []byte(b.String())
+}
+
+// Footprint returns a GeoJSON Feature of the image's outline, split at the
+// antimeridian as RFC 7946 asks.
+func (g *GeoRef) Footprint(props map[string]any) map[string]any {
+	west, south, east, north := g.Bounds()
+	box := func(w, e float64) [][][2]float64 {
+		return [][][2]float64{{{w, south}, {e, south}, {e, north}, {w, north}, {w, south}}}
+	}
+	w, e := normLon(west), normLon(east)
+	if east-west >= 360 {
+		w, e = -180, 180
+	}
+
+	var geom map[string]any
+	if w <= e {
+		geom = map[string]any{"type": "Polygon", "coordinates": box(w, e)}
+	} else {
+		geom = map[string]any{"type": "MultiPolygon", "coordinates": [][][][2]float64{box(w, 180), box(-180, e)}}
+	}
+	return map[string]any{
+		"type":       "Feature",
+		"bbox":       []float64{w, south, e, north},
+		"geometry":   geom,
+		"properties": props,
+	}
+}
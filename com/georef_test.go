@@ -0,0 +1,73 @@
+package com
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParseProjection(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		cfg   map[string]any
+		w, h  int
+		west  float64
+		north float64
+		lonPx float64
+		latPx float64
+		east  float64
+		south float64
+	}{
+		{
+			"corners", map[string]any{"type": "equirec", "tl_lon": -10.0, "tl_lat": 60.0, "br_lon": 30.0, "br_lat": 20.0},
+			400, 200, -10, 60, 0.1, -0.2, 30, 20,
+		},
+		{
+			"corners across the antimeridian", map[string]any{"type": "equirectangular", "tl_lon": 170.0, "tl_lat": 10.0, "br_lon": int64(-170), "br_lat": int64(-10)},
+			200, 100, 170, 10, 0.1, -0.2, 190, -10,
+		},
+		{
+			"offset and scalar", map[string]any{"type": "equirec", "offset_x": int64(-180), "offset_y": uint64(90), "scalar_x": 0.5, "scalar_y": -0.5},
+			720, 360, -180, 90, 0.5, -0.5, 180, -90,
+		},
+		{
+			"offset and scalar on a resized composite", map[string]any{"type": "equirec", "offset_x": -180.0, "offset_y": 90.0, "scalar_x": 0.25, "scalar_y": -0.25, "width": 1440.0, "height": int64(720)},
+			720, 360, -180, 90, 0.5, -0.5, 180, -90,
+		},
+	} {
+		g, err := parseProjection(tc.cfg, tc.w, tc.h)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		near := func(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+		west, south, east, north := g.Bounds()
+		if !near(g.West, tc.west) || !near(g.North, tc.north) || !near(g.LonPerPx, tc.lonPx) || !near(g.LatPerPx, tc.latPx) {
+			t.Errorf("%s: origin %v,%v step %v,%v; want %v,%v step %v,%v", tc.name, g.West, g.North, g.LonPerPx, g.LatPerPx, tc.west, tc.north, tc.lonPx, tc.latPx)
+		}
+		if !near(west, tc.west) || !near(south, tc.south) || !near(east, tc.east) || !near(north, tc.north) {
+			t.Errorf("%s: bounds %v %v %v %v, want %v %v %v %v", tc.name, west, south, east, north, tc.west, tc.south, tc.east, tc.north)
+		}
+	}
+}
+
+func TestParseProjectionErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		cfg  map[string]any
+	}{
+		{"no type", map[string]any{"tl_lon": -10.0, "tl_lat": 60.0, "br_lon": 30.0, "br_lat": 20.0}},
+		{"not a lat/lon grid", map[string]any{"type": "stereo", "tl_lon": -10.0, "tl_lat": 60.0, "br_lon": 30.0, "br_lat": 20.0}},
+		{"incomplete corners", map[string]any{"type": "equirec", "tl_lon": -10.0, "tl_lat": 60.0, "br_lon": 30.0}},
+		{"incomplete offset/scalar", map[string]any{"type": "equirec", "offset_x": -180.0, "offset_y": 90.0, "scalar_x": 0.5}},
+		{"string numbers", map[string]any{"type": "equirec", "offset_x": "-180", "offset_y": 90.0, "scalar_x": 0.5, "scalar_y": -0.5}},
+		{"rows run northwards", map[string]any{"type": "equirec", "tl_lon": -10.0, "tl_lat": 20.0, "br_lon": 30.0, "br_lat": 60.0}},
+		{"negative lon scalar", map[string]any{"type": "equirec", "offset_x": 180.0, "offset_y": 90.0, "scalar_x": -0.5, "scalar_y": -0.5}},
+		{"north of the pole", map[string]any{"type": "equirec", "tl_lon": -10.0, "tl_lat": 100.0, "br_lon": 30.0, "br_lat": 20.0}},
+		{"wider than the globe", map[string]any{"type": "equirec", "offset_x": -180.0, "offset_y": 90.0, "scalar_x": 1.0, "scalar_y": -0.5}},
+	} {
+		if _, err := parseProjection(tc.cfg, 720, 360); !errors.Is(err, ErrProjection) {
+			t.Errorf("%s: err = %v, want ErrProjection", tc.name, err)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package imaging
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Backend resizes and encodes thumbnails. The libvips backend (bimg) is only
+// compiled with cgo and without the novips build tag; the pure-Go backend is
+// always available.
+type Backend interface {
+	Name() string
+	// Supports reports whether the backend can encode format (webp, jpeg, avif, png).
+	Supports(format string) bool
+	// Size returns the pixel dimensions of an encoded image.
+	Size(data []byte) (width, height int, err error)
+	// Resize scales data to exactly width×height and encodes it as format.
+	Resize(data []byte, width, height int, format string, quality int) ([]byte, error)
+}
+
+const (
+	BackendAuto = "auto"
+	BackendVips = "vips"
+	BackendGo   = "go"
+)
+
+var (
+	mu       sync.RWMutex
+	backends = map[string]Backend{}
+)
+
+func register(b Backend) {
+	mu.Lock()
+	backends[b.Name()] = b
+	mu.Unlock()
+}
+
+// Available lists the backends compiled into this binary.
+func Available() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	out := make([]string, 0, len(backends))
+	for name := range backends {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Get returns the named backend. "auto" (or "") prefers libvips and falls
+// back to pure Go.
+func Get(name string) (Backend, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	mu.RLock()
+	defer mu.RUnlock()
+	switch name {
+	case "", BackendAuto:
+		if b, ok := backends[BackendVips]; ok {
+			return b, nil
+		}
+		return backends[BackendGo], nil
+	case "libvips", "bimg":
+		name = BackendVips
+	case "pure", "purego", "native":
+		name = BackendGo
+	}
+	if b, ok := backends[name]; ok {
+		return b, nil
+	}
+	if name == BackendVips {
+		return backends[BackendGo], fmt.Errorf("libvips backend not compiled in (built without cgo or with -tags novips)")
+	}
+	return backends[BackendGo], fmt.Errorf("unknown image backend %q", name)
+}
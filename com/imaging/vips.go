@@ -0,0 +1,58 @@
+//go:build cgo && !novips
+
+package imaging
+
+import (
+	"github.com/h2non/bimg"
+)
+
+// vipsBackend wraps h2non/bimg (libvips via cgo).
+type vipsBackend struct{}
+
+func init() { register(vipsBackend{}) }
+
+func (vipsBackend) Name() string { return BackendVips }
+
+func (vipsBackend) Supports(format string) bool {
+	switch format {
+	case "webp":
+		return bimg.IsTypeSupportedSave(bimg.WEBP)
+	case "jpeg":
+		return bimg.IsTypeSupportedSave(bimg.JPEG)
+	case "avif":
+		return bimg.IsTypeSupportedSave(bimg.AVIF)
+	case "png":
+		return bimg.IsTypeSupportedSave(bimg.PNG)
+	}
+	return false
+}
+
+func (vipsBackend) Size(data []byte) (int, int, error) {
+	size, err := bimg.NewImage(data).Size()
+	if err != nil {
+		return 0, 0, err
+	}
+	return size.Width, size.Height, nil
+}
+
+func (vipsBackend) Resize(data []byte, width, height int, format string, quality int) ([]byte, error) {
+	return bimg.NewImage(data).Process(bimg.Options{
+		Width:   width,
+		Height:  height,
+		Force:   true,
+		Quality: quality,
+		Type:    vipsType(format),
+	})
+}
+
+func vipsType(format string) bimg.ImageType {
+	switch format {
+	case "jpeg":
+		return bimg.JPEG
+	case "avif":
+		return bimg.AVIF
+	case "png":
+		return bimg.PNG
+	}
+	return bimg.WEBP
+}
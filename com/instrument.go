@@ -0,0 +1,183 @@
+package com
+
+import (
+	"OnlySats/com/logging"
+	"OnlySats/com/metrics"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Prometheus series maintained by the station. Scrape-time values (hardware,
+// DB sizes) are written by the /metrics handler itself.
+var (
+	ingestPasses = metrics.NewCounter("onlysats_ingest_passes_total",
+		"Pass folders seen by the DB updater, by result (added, skipped, failed).", "result")
+	ingestRuns = metrics.NewCounter("onlysats_ingest_runs_total",
+		"Completed DB update runs.")
+	ingestDuration = metrics.NewGauge("onlysats_ingest_last_duration_seconds",
+		"Duration of the last DB update run.")
+	ingestLastRun = metrics.NewGauge("onlysats_ingest_last_run_timestamp_seconds",
+		"Unix time the last DB update run finished.")
+
+	thumbgenImages = metrics.NewCounter("onlysats_thumbgen_images_total",
+		"Images handled by thumbnail generation, by result (processed, skipped, failed, on_demand, refreshed).", "result")
+	thumbgenPending = metrics.NewGauge("onlysats_thumbgen_pending_images",
+		"Images waiting in the thumbnail queue.")
+	thumbgenDuration = metrics.NewGauge("onlysats_thumbgen_last_duration_seconds",
+		"Time the thumbnail queue took to work through its last backlog.")
+	thumbgenStale = metrics.NewGauge("onlysats_thumbgen_stale_images",
+		"Images whose thumbnails were made with older thumbgen settings and await regeneration.")
+
+	satdumpOnline = metrics.NewGauge("onlysats_satdump_online",
+		"1 if the SatDump instance answered its last poll.", "instance")
+	satdumpSNR = metrics.NewGauge("onlysats_satdump_snr_db",
+		"Last reported demodulator SNR.", "instance")
+	satdumpBER = metrics.NewGauge("onlysats_satdump_ber",
+		"Last reported Viterbi BER.", "instance")
+	satdumpLastSeen = metrics.NewGauge("onlysats_satdump_last_seen_timestamp_seconds",
+		"Unix time of the last successful poll.", "instance")
+
+	derivRequests = metrics.NewCounter("onlysats_derivative_requests_total",
+		"Resized /images/ requests, by result (hit, miss, failed).", "result")
+	derivEvictions = metrics.NewCounter("onlysats_derivative_evictions_total",
+		"Files evicted from the derivative cache.")
+	derivCacheBytes = metrics.NewGauge("onlysats_derivative_cache_bytes",
+		"Size of the derivative cache on disk.")
+
+	animationsRendered = metrics.NewCounter("onlysats_animations_total",
+		"Timelapse animations rendered, by result (done, failed).", "result")
+
+	exportsBuilt = metrics.NewCounter("onlysats_exports_total",
+		"Bulk ZIP exports built in the background, by result (done, failed).", "result")
+
+	rateLimited = metrics.NewCounter("onlysats_rate_limited_requests_total",
+		"Requests refused by the rate limiter, by class (api, images, downloads, login).", "class")
+
+	httpDuration = metrics.NewHistogram("onlysats_http_request_duration_seconds",
+		"HTTP request latency by route template.", metrics.DefBuckets, "method", "route", "code")
+)
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+// SSE and streaming handlers need Flush to reach the real writer.
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter { return s.ResponseWriter }
+
+// routeTemplate is the matched mux route template, so /api/passes/12 and
+// /api/passes/13 share one label.
+func routeTemplate(r *http.Request) string {
+	if cr := mux.CurrentRoute(r); cr != nil {
+		if tpl, err := cr.GetPathTemplate(); err == nil {
+			return tpl
+		} else if tpl, err := cr.GetPathRegexp(); err == nil {
+			return tpl
+		}
+	}
+	return "unmatched"
+}
+
+// methodLabel keeps the method label to the usual verbs; clients can send
+// any token there.
+func methodLabel(m string) string {
+	switch m {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return m
+	}
+	return "other"
+}
+
+// HTTPMetrics records request latency labelled by route template.
+func HTTPMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		code := rec.status
+		if code == 0 {
+			code = http.StatusOK
+		}
+		httpDuration.Observe(time.Since(start).Seconds(), methodLabel(r.Method), routeTemplate(r), strconv.Itoa(code))
+	})
+}
+
+var httpLog = logging.For("http")
+
+// AccessLog writes one line per request to the http component: server
+// errors at warn, everything else at debug.
+func AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		code := rec.status
+		if code == 0 {
+			code = http.StatusOK
+		}
+		lvl := slog.LevelDebug
+		if code >= 500 {
+			lvl = slog.LevelWarn
+		}
+		if !httpLog.Enabled(r.Context(), lvl) {
+			return
+		}
+		httpLog.Log(r.Context(), lvl, "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"route", routeTemplate(r),
+			"status", code,
+			"duration", time.Since(start).Truncate(time.Microsecond),
+			"remote", ClientIP(r),
+		)
+	})
+}
+
+// satdumpSignal pulls SNR and BER out of a filtered SatDump payload,
+// which is either {live_pipeline:{psk_demod, <decoder>}} or {psk_demod, <decoder>}.
+func satdumpSignal(payload map[string]any) (snr, ber float64, hasSNR, hasBER bool) {
+	root := payload
+	if lp, ok := payload["live_pipeline"].(map[string]any); ok {
+		root = lp
+	}
+	if psk, ok := root["psk_demod"].(map[string]any); ok {
+		snr, hasSNR = psk["snr"].(float64)
+	}
+	for k, v := range root {
+		if k == "psk_demod" {
+			continue
+		}
+		if m, ok := v.(map[string]any); ok {
+			if b, ok := m["viterbi_ber"].(float64); ok {
+				return snr, b, hasSNR, true
+			}
+		}
+	}
+	return snr, 0, hasSNR, false
+}
@@ -0,0 +1,228 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"OnlySats/config"
+)
+
+// Component loggers share one root handler configured from [logging]. Loggers
+// returned by For may be created before Setup runs (package-level vars); they
+// pick up the configured handler and levels on every record.
+
+var (
+	mu        sync.RWMutex
+	root      slog.Handler = slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})
+	level     slog.Level   = slog.LevelInfo
+	overrides              = map[string]slog.Level{}
+	file      *RotatingFile
+)
+
+// ParseLevel maps a config level to slog. "detailed" is the old
+// server.log_level value and means debug.
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug", "detailed", "trace":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return slog.LevelInfo, fmt.Errorf("unknown log level %q", s)
+}
+
+// Setup builds the root handler from cfg and routes the standard log package
+// through it. It is safe to call again after a config change.
+func Setup(cfg *config.AppConfig) error {
+	lc := cfg.Logging
+
+	lvl, err := ParseLevel(lc.Level)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(strings.TrimSpace(cfg.Server.LogLevel), "detailed") && lvl > slog.LevelDebug {
+		lvl = slog.LevelDebug
+	}
+	ov := make(map[string]slog.Level, len(lc.Components))
+	for name, v := range lc.Components {
+		l, err := ParseLevel(v)
+		if err != nil {
+			return fmt.Errorf("logging.components.%s: %w", name, err)
+		}
+		ov[strings.ToLower(name)] = l
+	}
+
+	var writers []io.Writer
+	if lc.Console || strings.TrimSpace(lc.File) == "" {
+		writers = append(writers, os.Stderr)
+	}
+	var rf *RotatingFile
+	if name := strings.TrimSpace(lc.File); name != "" {
+		path := name
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(cfg.Paths.LogDir, name)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return fmt.Errorf("create log dir: %w", err)
+		}
+		rf = &RotatingFile{
+			Filename:   path,
+			MaxSize:    int64(lc.MaxSize) << 20,
+			MaxBackups: lc.MaxBackups,
+			MaxAge:     time.Duration(lc.MaxAge) * 24 * time.Hour,
+			Compress:   lc.Compress,
+		}
+		writers = append(writers, rf)
+	}
+
+	writers = append(writers, liveTap)
+	out := io.MultiWriter(writers...)
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	var h slog.Handler
+	switch strings.ToLower(strings.TrimSpace(lc.Format)) {
+	case "json":
+		h = slog.NewJSONHandler(out, opts)
+	case "", "text":
+		h = slog.NewTextHandler(out, opts)
+	default:
+		return fmt.Errorf("unknown log format %q", lc.Format)
+	}
+
+	mu.Lock()
+	old := file
+	root, level, overrides, file = h, lvl, ov, rf
+	mu.Unlock()
+	if old != nil {
+		_ = old.Close()
+	}
+
+	log.SetFlags(0)
+	log.SetOutput(stdBridge{})
+	return nil
+}
+
+// Close flushes and closes the log file. Later records go to stderr.
+func Close() error {
+	mu.Lock()
+	f := file
+	root = slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})
+	file = nil
+	mu.Unlock()
+	if f != nil {
+		return f.Close()
+	}
+	return nil
+}
+
+// LogFile returns the active log file path, or "" when logging to the console only.
+func LogFile() string {
+	mu.RLock()
+	defer mu.RUnlock()
+	if file == nil {
+		return ""
+	}
+	return file.Filename
+}
+
+// For returns the logger for a component (ingest, thumbgen, scheduler, proxy, http, ...).
+func For(component string) *slog.Logger {
+	return slog.New(&componentHandler{component: component})
+}
+
+// Enabled reports whether component would log at lvl.
+func Enabled(component string, lvl slog.Level) bool {
+	return lvl >= levelFor(component)
+}
+
+func levelFor(component string) slog.Level {
+	mu.RLock()
+	defer mu.RUnlock()
+	if l, ok := overrides[strings.ToLower(component)]; ok {
+		return l
+	}
+	return level
+}
+
+type componentHandler struct {
+	component string
+	// WithAttrs/WithGroup calls, replayed onto the current root handler
+	wrap []func(slog.Handler) slog.Handler
+}
+
+func (h *componentHandler) Enabled(_ context.Context, lvl slog.Level) bool {
+	return lvl >= levelFor(h.component)
+}
+
+func (h *componentHandler) Handle(ctx context.Context, r slog.Record) error {
+	mu.RLock()
+	out := root
+	mu.RUnlock()
+	out = out.WithAttrs([]slog.Attr{slog.String("component", h.component)})
+	for _, f := range h.wrap {
+		out = f(out)
+	}
+	return out.Handle(ctx, r)
+}
+
+func (h *componentHandler) with(f func(slog.Handler) slog.Handler) *componentHandler {
+	wrap := make([]func(slog.Handler) slog.Handler, len(h.wrap), len(h.wrap)+1)
+	copy(wrap, h.wrap)
+	return &componentHandler{component: h.component, wrap: append(wrap, f)}
+}
+
+func (h *componentHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(n slog.Handler) slog.Handler { return n.WithAttrs(attrs) })
+}
+
+func (h *componentHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(n slog.Handler) slog.Handler { return n.WithGroup(name) })
+}
+
+// stdBridge receives output from the standard log package. A leading
+// "[name]" tag becomes the component; the level is guessed from the text.
+type stdBridge struct{}
+
+func (stdBridge) Write(p []byte) (int, error) {
+	msg := strings.TrimRight(string(p), "\r\n")
+	component := "app"
+	if strings.HasPrefix(msg, "[") {
+		if i := strings.Index(msg, "] "); i > 1 && i <= 32 {
+			component = strings.Trim(msg[1:i], "/ ")
+			msg = msg[i+2:]
+		}
+	}
+	lvl := guessLevel(msg)
+	h := &componentHandler{component: component}
+	if !h.Enabled(context.Background(), lvl) {
+		return len(p), nil
+	}
+	r := slog.NewRecord(time.Now(), lvl, msg, 0)
+	if err := h.Handle(context.Background(), r); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func guessLevel(msg string) slog.Level {
+	m := strings.ToLower(msg)
+	switch {
+	case strings.Contains(m, "panic"), strings.Contains(m, "error"),
+		strings.Contains(m, "failed"), strings.Contains(m, "fatal"):
+		return slog.LevelError
+	case strings.Contains(m, "warn"), strings.Contains(m, "gave up"), strings.Contains(m, "giving up"):
+		return slog.LevelWarn
+	}
+	return slog.LevelInfo
+}
@@ -0,0 +1,268 @@
+package logging
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Entry is one parsed log line. Lines that are neither slog text nor JSON
+// (older logs, frpc output) keep the whole line in Msg.
+type Entry struct {
+	Line      int               `json:"line"`
+	Time      string            `json:"time,omitempty"`
+	Level     string            `json:"level,omitempty"`
+	Component string            `json:"component,omitempty"`
+	Msg       string            `json:"msg"`
+	Attrs     map[string]string `json:"attrs,omitempty"`
+}
+
+// LevelOf returns the entry's level, treating unlevelled lines as info.
+func (e Entry) LevelOf() slog.Level {
+	var l slog.Level
+	if e.Level == "" || l.UnmarshalText([]byte(e.Level)) != nil {
+		return slog.LevelInfo
+	}
+	return l
+}
+
+// ParseLine parses a line written by the text or JSON handler.
+func ParseLine(s string) Entry {
+	s = strings.TrimRight(s, "\r\n")
+	if strings.HasPrefix(s, "{") {
+		var m map[string]any
+		if json.Unmarshal([]byte(s), &m) == nil {
+			return entryFromMap(m, s)
+		}
+	}
+	if strings.HasPrefix(s, "time=") {
+		if kv, ok := parseLogfmt(s); ok {
+			m := make(map[string]any, len(kv))
+			for k, v := range kv {
+				m[k] = v
+			}
+			return entryFromMap(m, s)
+		}
+	}
+	return Entry{Msg: s}
+}
+
+func entryFromMap(m map[string]any, raw string) Entry {
+	str := func(k string) string {
+		v, ok := m[k]
+		if !ok {
+			return ""
+		}
+		delete(m, k)
+		if s, ok := v.(string); ok {
+			return s
+		}
+		return fmt.Sprint(v)
+	}
+	e := Entry{
+		Time:      str(slog.TimeKey),
+		Level:     str(slog.LevelKey),
+		Msg:       str(slog.MessageKey),
+		Component: str("component"),
+	}
+	if len(m) > 0 {
+		e.Attrs = make(map[string]string, len(m))
+		for k, v := range m {
+			switch t := v.(type) {
+			case string:
+				e.Attrs[k] = t
+			default:
+				b, _ := json.Marshal(t)
+				e.Attrs[k] = string(b)
+			}
+		}
+	}
+	if e.Msg == "" && e.Level == "" {
+		e.Msg = raw
+	}
+	return e
+}
+
+// parseLogfmt splits key=value pairs as written by slog.TextHandler,
+// unquoting quoted values.
+func parseLogfmt(s string) (map[string]string, bool) {
+	out := map[string]string{}
+	for len(s) > 0 {
+		s = strings.TrimLeft(s, " ")
+		if s == "" {
+			break
+		}
+		eq := strings.IndexByte(s, '=')
+		if eq <= 0 {
+			return nil, false
+		}
+		key := s[:eq]
+		s = s[eq+1:]
+		var val string
+		if strings.HasPrefix(s, `"`) {
+			q, err := strconv.QuotedPrefix(s)
+			if err != nil {
+				return nil, false
+			}
+			val, _ = strconv.Unquote(q)
+			s = s[len(q):]
+		} else if sp := strings.IndexByte(s, ' '); sp >= 0 {
+			val, s = s[:sp], s[sp:]
+		} else {
+			val, s = s, ""
+		}
+		out[key] = val
+	}
+	return out, len(out) > 0
+}
+
+// Filter selects entries by minimum level and component.
+type Filter struct {
+	MinLevel   slog.Level
+	Components []string // empty matches all
+	Contains   string   // case-insensitive substring of the raw line
+}
+
+func (f Filter) Match(e Entry, raw string) bool {
+	if e.LevelOf() < f.MinLevel {
+		return false
+	}
+	if len(f.Components) > 0 {
+		ok := false
+		for _, c := range f.Components {
+			if strings.EqualFold(c, e.Component) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	if f.Contains != "" && !strings.Contains(strings.ToLower(raw), strings.ToLower(f.Contains)) {
+		return false
+	}
+	return true
+}
+
+// ReadPage returns up to limit entries matching f from the log at path,
+// oldest first, taken from the end of the file. before (a 1-based line
+// number, 0 for end of file) pages backwards; next is the cursor for the
+// previous page, or 0 when there is none. Rotated .gz files are read
+// transparently.
+func ReadPage(path string, f Filter, before, limit int) (entries []Entry, next int, err error) {
+	if limit <= 0 {
+		limit = 200
+	}
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer fh.Close()
+
+	var r io.Reader = fh
+	if strings.HasSuffix(path, ".gz") {
+		zr, err := gzip.NewReader(fh)
+		if err != nil {
+			return nil, 0, fmt.Errorf("open %s: %w", filepath.Base(path), err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	// ring of the last `limit` matches before the cursor
+	ring := make([]Entry, 0, limit)
+	start, matched := 0, 0
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	line := 0
+	for sc.Scan() {
+		line++
+		if before > 0 && line >= before {
+			break
+		}
+		raw := sc.Text()
+		e := ParseLine(raw)
+		if !f.Match(e, raw) {
+			continue
+		}
+		e.Line = line
+		matched++
+		if len(ring) < limit {
+			ring = append(ring, e)
+		} else {
+			ring[start] = e
+			start = (start + 1) % limit
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	entries = make([]Entry, 0, len(ring))
+	entries = append(entries, ring[start:]...)
+	entries = append(entries, ring[:start]...)
+	if matched > len(entries) && len(entries) > 0 {
+		next = entries[0].Line
+	}
+	return entries, next, nil
+}
+
+// ---------- live tap ----------
+
+// tap fans formatted records out to live subscribers (the admin log tail).
+// Slow subscribers drop lines rather than block logging.
+type tap struct {
+	mu   sync.Mutex
+	next int
+	subs map[int]chan string
+}
+
+var liveTap = &tap{subs: map[int]chan string{}}
+
+func (t *tap) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.subs) == 0 {
+		return len(p), nil
+	}
+	line := strings.TrimRight(string(p), "\n")
+	for _, ch := range t.subs {
+		select {
+		case ch <- line:
+		default:
+		}
+	}
+	return len(p), nil
+}
+
+// Subscribe streams every record written from now on. Call the returned
+// func to unsubscribe.
+func Subscribe(buffer int) (<-chan string, func()) {
+	if buffer <= 0 {
+		buffer = 256
+	}
+	ch := make(chan string, buffer)
+	liveTap.mu.Lock()
+	id := liveTap.next
+	liveTap.next++
+	liveTap.subs[id] = ch
+	liveTap.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			liveTap.mu.Lock()
+			delete(liveTap.subs, id)
+			liveTap.mu.Unlock()
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package logging
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// RotatingFile is an io.Writer that moves the file aside once it reaches
+// MaxSize, keeping at most MaxBackups rotated files no older than MaxAge.
+// Rotated files are named <name>-<timestamp><ext>, plus .gz when Compress is set.
+type RotatingFile struct {
+	Filename   string
+	MaxSize    int64         // bytes; 0 disables rotation
+	MaxBackups int           // 0 keeps all
+	MaxAge     time.Duration // 0 keeps all
+	Compress   bool
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+
+	millMu sync.Mutex
+}
+
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.f == nil {
+		if err := rf.open(); err != nil {
+			return 0, err
+		}
+	}
+	if rf.MaxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.MaxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// Close closes the current file; the next Write reopens it.
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.f == nil {
+		return nil
+	}
+	err := rf.f.Close()
+	rf.f = nil
+	return err
+}
+
+// Rotate forces a rotation regardless of size.
+func (rf *RotatingFile) Rotate() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.f == nil {
+		if err := rf.open(); err != nil {
+			return err
+		}
+	}
+	return rf.rotate()
+}
+
+func (rf *RotatingFile) open() error {
+	f, err := os.OpenFile(rf.Filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("open log file: %w", err)
+	}
+	st, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("stat log file: %w", err)
+	}
+	rf.f, rf.size = f, st.Size()
+	go rf.mill()
+	return nil
+}
+
+// caller holds rf.mu
+func (rf *RotatingFile) rotate() error {
+	if err := rf.f.Close(); err != nil {
+		return fmt.Errorf("close log file: %w", err)
+	}
+	rf.f = nil
+	ext := filepath.Ext(rf.Filename)
+	backup := strings.TrimSuffix(rf.Filename, ext) + "-" + time.Now().Format(backupTimeFormat) + ext
+	if err := os.Rename(rf.Filename, backup); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("rotate log file: %w", err)
+	}
+	return rf.open()
+}
+
+type backupFile struct {
+	path string
+	ts   time.Time
+}
+
+// Backups lists rotated files, newest first.
+func (rf *RotatingFile) Backups() ([]string, error) {
+	bs, err := rf.backups()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]string, len(bs))
+	for i, b := range bs {
+		out[i] = b.path
+	}
+	return out, nil
+}
+
+func (rf *RotatingFile) backups() ([]backupFile, error) {
+	dir := filepath.Dir(rf.Filename)
+	ext := filepath.Ext(rf.Filename)
+	prefix := strings.TrimSuffix(filepath.Base(rf.Filename), ext) + "-"
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []backupFile
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasPrefix(e.Name(), prefix) {
+			continue
+		}
+		stamp := strings.TrimPrefix(e.Name(), prefix)
+		stamp = strings.TrimSuffix(stamp, ".gz")
+		if ext != "" {
+			if !strings.HasSuffix(stamp, ext) {
+				continue
+			}
+			stamp = strings.TrimSuffix(stamp, ext)
+		}
+		ts, err := time.ParseInLocation(backupTimeFormat, stamp, time.Local)
+		if err != nil {
+			continue
+		}
+		out = append(out, backupFile{path: filepath.Join(dir, e.Name()), ts: ts})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ts.After(out[j].ts) })
+	return out, nil
+}
+
+// mill compresses and prunes rotated files. Runs in the background after
+// every open; concurrent runs are serialized.
+func (rf *RotatingFile) mill() {
+	rf.millMu.Lock()
+	defer rf.millMu.Unlock()
+
+	bs, err := rf.backups()
+	if err != nil {
+		return
+	}
+	cutoff := time.Time{}
+	if rf.MaxAge > 0 {
+		cutoff = time.Now().Add(-rf.MaxAge)
+	}
+	for i, b := range bs {
+		if (rf.MaxBackups > 0 && i >= rf.MaxBackups) || (!cutoff.IsZero() && b.ts.Before(cutoff)) {
+			_ = os.Remove(b.path)
+			continue
+		}
+		if rf.Compress && !strings.HasSuffix(b.path, ".gz") {
+			if err := gzipFile(b.path); err != nil {
+				fmt.Fprintf(os.Stderr, "[logging] compress %s: %v\n", b.path, err)
+			}
+		}
+	}
+}
+
+func gzipFile(src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	dst := src + ".gz"
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		_ = out.Close()
+		_ = os.Remove(dst)
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		_ = out.Close()
+		_ = os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		_ = os.Remove(dst)
+		return err
+	}
+	_ = in.Close()
+	return os.Remove(src)
+}
@@ -0,0 +1,401 @@
+package com
+
+import (
+	"OnlySats/com/logging"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+var loginLog = logging.For("login")
+
+// Login throttling. After loginFreeFailures failed attempts every further
+// attempt must wait twice as long as the last, up to loginMaxBackoff; at the
+// lockout thresholds the account (from that address) or the address is
+// refused outright for loginLockout, doubling with each repeat lockout up to
+// loginMaxLockout. A username tried from many addresses backs off after
+// loginNameFreeFailures but is never locked, so strangers can slow the
+// admin's logins down but not shut them out. Counters are forgotten after
+// loginForget without failures.
+const (
+	loginFreeFailures     = 3
+	loginNameFreeFailures = 10
+	loginMaxBackoff       = 5 * time.Minute
+	loginUserThreshold    = 10
+	loginIPThreshold      = 30
+	loginLockout          = 15 * time.Minute
+	loginMaxLockout       = 24 * time.Hour
+	loginForget           = time.Hour
+)
+
+// ErrLoginThrottled is returned by Check while a backoff or lockout runs.
+var ErrLoginThrottled = errors.New("too many failed logins")
+
+// LoginLockout is a lockout of a username or client address.
+type LoginLockout struct {
+	ID         int64      `json:"id"`
+	Kind       string     `json:"kind"`    // user | ip
+	Subject    string     `json:"subject"` // username or address
+	IP         string     `json:"ip"`      // address of the failure that triggered it
+	Failures   int        `json:"failures"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	Until      time.Time  `json:"until"`
+	UnlockedAt *time.Time `json:"unlockedAt,omitempty"`
+	UnlockedBy string     `json:"unlockedBy,omitempty"`
+	Active     bool       `json:"active"`
+}
+
+// ---------- Store ----------
+
+const lockoutCols = `id, kind, subject, ip, failures, created_ts, until_ts, unlocked_ts, COALESCE(unlocked_by, '')`
+
+func scanLockout(row rowScanner) (*LoginLockout, error) {
+	var (
+		l              LoginLockout
+		created, until int64
+		unlocked       sql.NullInt64
+	)
+	if err := row.Scan(&l.ID, &l.Kind, &l.Subject, &l.IP, &l.Failures, &created, &until, &unlocked, &l.UnlockedBy); err != nil {
+		return nil, err
+	}
+	l.CreatedAt = time.Unix(created, 0).UTC()
+	l.Until = time.Unix(until, 0).UTC()
+	if unlocked.Valid {
+		t := time.Unix(unlocked.Int64, 0).UTC()
+		l.UnlockedAt = &t
+	}
+	l.Active = l.UnlockedAt == nil && time.Now().Before(l.Until)
+	return &l, nil
+}
+
+func (s *LocalDataStore) insertLockout(ctx context.Context, l *LoginLockout) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO login_lockouts (kind, subject, ip, failures, created_ts, until_ts) VALUES (?, ?, ?, ?, ?, ?)`,
+		l.Kind, l.Subject, l.IP, l.Failures, l.CreatedAt.Unix(), l.Until.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (s *LocalDataStore) listLockouts(ctx context.Context, where string, args ...any) ([]*LoginLockout, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+lockoutCols+` FROM login_lockouts `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []*LoginLockout{}
+	for rows.Next() {
+		l, err := scanLockout(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, l)
+	}
+	return out, rows.Err()
+}
+
+func (s *LocalDataStore) unlockLockouts(ctx context.Context, kind, subject, by string) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE login_lockouts SET unlocked_ts = ?, unlocked_by = ?
+		WHERE kind = ? AND subject = ? AND unlocked_ts IS NULL AND until_ts > ?`,
+		time.Now().Unix(), by, kind, subject, time.Now().Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// ---------- Guard ----------
+
+type loginState struct {
+	free      int // failures before the backoff starts
+	threshold int // failures that lock out; 0 never locks
+
+	failures int
+	pending  int // attempts let through by Check and not settled yet
+	lastFail time.Time
+	next     time.Time // no attempts before this (backoff or lockout)
+	lockouts int       // lockouts so far, for the doubling
+	locked   bool
+}
+
+// loginUser is a username as tried from one address. Counting per address
+// keeps a stranger from locking the admin out by guessing at the account.
+type loginUser struct {
+	name, ip string
+}
+
+// LoginGuard counts failed logins per username and address, per username
+// and per address. Counters live in memory; lockouts are also stored so
+// they survive a restart and can be reviewed.
+type LoginGuard struct {
+	Store *LocalDataStore
+
+	mu    sync.Mutex
+	users map[loginUser]*loginState
+	names map[string]*loginState // backoff only
+	ips   map[string]*loginState
+}
+
+// NewLoginGuard restores lockouts that are still running.
+func NewLoginGuard(ctx context.Context, store *LocalDataStore) (*LoginGuard, error) {
+	g := &LoginGuard{
+		Store: store,
+		users: map[loginUser]*loginState{},
+		names: map[string]*loginState{},
+		ips:   map[string]*loginState{},
+	}
+	active, err := store.listLockouts(ctx, `WHERE unlocked_ts IS NULL AND until_ts > ?`, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range active {
+		if l.Kind == "user" {
+			g.users[loginUser{l.Subject, l.IP}] = restoredLockout(l, loginUserThreshold)
+		} else {
+			g.ips[l.Subject] = restoredLockout(l, loginIPThreshold)
+		}
+	}
+	return g, nil
+}
+
+func restoredLockout(l *LoginLockout, threshold int) *loginState {
+	return &loginState{free: loginFreeFailures, threshold: threshold,
+		failures: l.Failures, lastFail: l.CreatedAt, next: l.Until, lockouts: 1, locked: true}
+}
+
+func loginKey(username string) string { return strings.ToLower(strings.TrimSpace(username)) }
+
+// statesLocked returns the counters an attempt by username from ip goes
+// against, creating them: the username from ip, the username from
+// anywhere and the address. The first two are left out for an empty
+// username.
+func (g *LoginGuard) statesLocked(username, ip string) []*loginState {
+	var out []*loginState
+	if name := loginKey(username); name != "" {
+		k := loginUser{name, ip}
+		if g.users[k] == nil {
+			g.users[k] = &loginState{free: loginFreeFailures, threshold: loginUserThreshold}
+		}
+		if g.names[name] == nil {
+			g.names[name] = &loginState{free: loginNameFreeFailures}
+		}
+		out = append(out, g.users[k], g.names[name])
+	}
+	if g.ips[ip] == nil {
+		g.ips[ip] = &loginState{free: loginFreeFailures, threshold: loginIPThreshold}
+	}
+	return append(out, g.ips[ip])
+}
+
+// Check reports whether username may try to log in from ip now. While a
+// backoff or lockout runs it returns ErrLoginThrottled and how long to wait;
+// the password must not be checked then. Otherwise the attempt is reserved
+// and must be settled with Fail, Success or Release. Once the free attempts
+// are used up only one attempt at a time is let through, so parallel
+// guesses can't slip past the backoff.
+func (g *LoginGuard) Check(username, ip string) (time.Duration, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	states := g.statesLocked(username, ip)
+	var wait time.Duration
+	var locked, busy bool
+	for _, st := range states {
+		if now.Before(st.next) {
+			wait = max(wait, st.next.Sub(now))
+			locked = locked || st.locked
+		}
+		busy = busy || (st.pending > 0 && st.failures+st.pending >= st.free)
+	}
+	switch {
+	case locked:
+		return wait, fmt.Errorf("%w: locked out for %s", ErrLoginThrottled, wait.Round(time.Second))
+	case wait > 0:
+		return wait, fmt.Errorf("%w: try again in %s", ErrLoginThrottled, wait.Round(time.Second))
+	case busy:
+		return time.Second, fmt.Errorf("%w: another login attempt is in progress", ErrLoginThrottled)
+	}
+	for _, st := range states {
+		st.pending++
+	}
+	return 0, nil
+}
+
+func (st *loginState) settle() {
+	if st != nil && st.pending > 0 {
+		st.pending--
+	}
+}
+
+// Fail settles an attempt reserved by Check as failed and starts a backoff
+// or lockout.
+func (g *LoginGuard) Fail(ctx context.Context, username, ip string) {
+	g.mu.Lock()
+	now := time.Now()
+	var lockouts []*LoginLockout
+	states := g.statesLocked(username, ip)
+	for _, st := range states {
+		st.settle()
+		kind, subject := "user", loginKey(username)
+		if st == g.ips[ip] {
+			kind, subject = "ip", ip
+		}
+		if l := st.fail(kind, subject, ip, now); l != nil {
+			lockouts = append(lockouts, l)
+		}
+	}
+	g.sweepLocked(now)
+	g.mu.Unlock()
+
+	loginLog.Info("failed login", "user", username, "ip", ip)
+	for _, l := range lockouts {
+		loginLog.Warn("login lockout", "kind", l.Kind, "subject", l.Subject, "ip", l.IP,
+			"failures", l.Failures, "until", l.Until)
+		if id, err := g.Store.insertLockout(ctx, l); err != nil {
+			loginLog.Error("storing lockout failed", "err", err)
+		} else {
+			l.ID = id
+		}
+		PublishEvent(EventLoginLockout, map[string]any{
+			"kind":     l.Kind,
+			"subject":  l.Subject,
+			"ip":       l.IP,
+			"failures": l.Failures,
+			"until":    l.Until.Format(time.RFC3339),
+			"title":    fmt.Sprintf("Login lockout of %s %s after %d failed attempts", l.Kind, l.Subject, l.Failures),
+		})
+	}
+}
+
+func (st *loginState) fail(kind, subject, ip string, now time.Time) *LoginLockout {
+	if st.locked && !now.Before(st.next) {
+		// lockout over: start counting again, but remember it for the doubling
+		st.failures, st.locked = 0, false
+	}
+	st.failures++
+	st.lastFail = now
+
+	if st.threshold > 0 && st.failures >= st.threshold {
+		st.lockouts++
+		d := time.Duration(float64(loginLockout) * math.Pow(2, float64(st.lockouts-1)))
+		st.next, st.locked = now.Add(min(d, loginMaxLockout)), true
+		return &LoginLockout{Kind: kind, Subject: subject, IP: ip, Failures: st.failures,
+			CreatedAt: now.UTC().Truncate(time.Second), Until: st.next.UTC().Truncate(time.Second), Active: true}
+	}
+	if n := st.failures - st.free; n >= 0 {
+		d := time.Duration(math.Pow(2, float64(min(n, 20)))) * time.Second
+		st.next = now.Add(min(d, loginMaxBackoff))
+	}
+	return nil
+}
+
+// Success settles an attempt reserved by Check and forgets the failures of
+// username; the address keeps its count so one good account can't be used
+// to reset guessing at others.
+func (g *LoginGuard) Success(username, ip string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	name := loginKey(username)
+	delete(g.users, loginUser{name, ip})
+	delete(g.names, name)
+	if addr := g.ips[ip]; addr != nil {
+		addr.settle()
+	}
+}
+
+// Release gives back an attempt reserved by Check whose password was never
+// judged, e.g. because the user lookup failed.
+func (g *LoginGuard) Release(username, ip string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	name := loginKey(username)
+	g.users[loginUser{name, ip}].settle()
+	g.names[name].settle()
+	g.ips[ip].settle()
+}
+
+// quiet reports whether st can be forgotten: no attempt in flight, nothing
+// running and no failures for loginForget.
+func (st *loginState) quiet(now time.Time) bool {
+	return st.pending == 0 && now.After(st.next) && now.Sub(st.lastFail) > loginForget
+}
+
+// sweepLocked forgets quiet counters.
+func (g *LoginGuard) sweepLocked(now time.Time) {
+	for k, st := range g.users {
+		if st.quiet(now) {
+			delete(g.users, k)
+		}
+	}
+	for _, m := range []map[string]*loginState{g.names, g.ips} {
+		for k, st := range m {
+			if st.quiet(now) {
+				delete(m, k)
+			}
+		}
+	}
+}
+
+// Unlock lifts the lockout and backoff of a username ("user", from every
+// address and from anywhere) or client address ("ip"). It reports whether anything was
+// holding it. Attempts in flight stay reserved.
+func (g *LoginGuard) Unlock(ctx context.Context, kind, subject, by string) (bool, error) {
+	var held bool
+	now := time.Now()
+	lift := func(st *loginState) {
+		held = held || now.Before(st.next)
+		*st = loginState{free: st.free, threshold: st.threshold, pending: st.pending}
+	}
+	g.mu.Lock()
+	switch kind {
+	case "user":
+		subject = loginKey(subject)
+		for k, st := range g.users {
+			if k.name == subject {
+				lift(st)
+			}
+		}
+		if st := g.names[subject]; st != nil {
+			lift(st)
+		}
+	case "ip":
+		if st := g.ips[subject]; st != nil {
+			lift(st)
+		}
+	default:
+		g.mu.Unlock()
+		return false, fmt.Errorf("unknown lockout kind %q", kind)
+	}
+	g.mu.Unlock()
+
+	n, err := g.Store.unlockLockouts(ctx, kind, subject, by)
+	if err != nil {
+		return held, err
+	}
+	if held || n > 0 {
+		loginLog.Warn("login unlocked", "kind", kind, "subject", subject, "by", by)
+	}
+	return held || n > 0, nil
+}
+
+// Lockouts returns the running lockouts and the most recent ones overall.
+func (g *LoginGuard) Lockouts(ctx context.Context, limit int) (active, recent []*LoginLockout, err error) {
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+	now := time.Now().Unix()
+	if active, err = g.Store.listLockouts(ctx, `WHERE unlocked_ts IS NULL AND until_ts > ? ORDER BY until_ts DESC`, now); err != nil {
+		return nil, nil, err
+	}
+	if recent, err = g.Store.listLockouts(ctx, `ORDER BY id DESC LIMIT ?`, limit); err != nil {
+		return nil, nil, err
+	}
+	return active, recent, nil
+}
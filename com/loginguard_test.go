@@ -0,0 +1,236 @@
+package com
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestLoginGuard(t *testing.T) *LoginGuard {
+	t.Helper()
+	g, err := NewLoginGuard(context.Background(), openTestStore(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+// expire ends every running backoff and lockout, as if its time had
+// passed.
+func expire(g *LoginGuard) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, st := range g.users {
+		st.next = time.Now().Add(-time.Second)
+	}
+	for _, m := range []map[string]*loginState{g.names, g.ips} {
+		for _, st := range m {
+			st.next = time.Now().Add(-time.Second)
+		}
+	}
+}
+
+func TestLoginGuardBackoffAndLockout(t *testing.T) {
+	g := newTestLoginGuard(t)
+	ctx := context.Background()
+	const ip = "198.51.100.1"
+	st := func() *loginState { return g.users[loginUser{"admin", ip}] }
+
+	for _, tc := range []struct {
+		failure int
+		hold    time.Duration // after this failure; 0 for none
+		locked  bool
+	}{
+		{1, 0, false},
+		{2, 0, false},
+		{3, time.Second, false},
+		{4, 2 * time.Second, false},
+		{5, 4 * time.Second, false},
+		{9, 64 * time.Second, false},
+		{10, loginLockout, true},
+	} {
+		for st() == nil || st().failures < tc.failure {
+			expire(g)
+			if _, err := g.Check("Admin", ip); err != nil {
+				t.Fatalf("before failure %d: %v", st().failures+1, err)
+			}
+			g.Fail(ctx, "Admin", ip)
+		}
+		s := st()
+		if hold := s.next.Sub(s.lastFail); hold != tc.hold && !(tc.hold == 0 && hold <= 0) {
+			t.Errorf("after %d failures: held for %s, want %s", tc.failure, hold, tc.hold)
+		}
+		if s.locked != tc.locked {
+			t.Errorf("after %d failures: locked %v, want %v", tc.failure, s.locked, tc.locked)
+		}
+		if tc.hold > 0 {
+			if _, err := g.Check("admin", ip); !errors.Is(err, ErrLoginThrottled) {
+				t.Errorf("after %d failures: Check err = %v, want throttled", tc.failure, err)
+			}
+		}
+	}
+
+	// The next lockout lasts twice as long.
+	for i := 0; i < loginUserThreshold; i++ {
+		expire(g)
+		if _, err := g.Check("admin", ip); err != nil {
+			t.Fatalf("second round, attempt %d: %v", i+1, err)
+		}
+		g.Fail(ctx, "admin", ip)
+	}
+	if s := st(); !s.locked || s.next.Sub(s.lastFail) != 2*loginLockout {
+		t.Errorf("second lockout: locked %v for %s, want %s", s.locked, s.next.Sub(s.lastFail), 2*loginLockout)
+	}
+
+	// Other addresses only see the username's backoff, never the lockout.
+	if wait, err := g.Check("admin", "203.0.113.7"); !errors.Is(err, ErrLoginThrottled) || wait > loginMaxBackoff {
+		t.Errorf("admin from another address: waits %s, err %v; want the backoff", wait, err)
+	}
+	g.names["admin"].next = time.Now().Add(-time.Second)
+	if _, err := g.Check("admin", "203.0.113.7"); err != nil {
+		t.Errorf("admin from another address after the backoff: %v", err)
+	}
+	g.Release("admin", "203.0.113.7")
+
+	active, _, err := g.Lockouts(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// expire only ends the lockouts in memory, so both stored ones still run
+	if len(active) != 2 {
+		t.Fatalf("%d active lockouts, want 2", len(active))
+	}
+	for _, l := range active {
+		if l.Kind != "user" || l.Subject != "admin" || l.IP != ip || l.Failures != loginUserThreshold {
+			t.Errorf("lockout %+v, want admin from %s after %d failures", *l, ip, loginUserThreshold)
+		}
+	}
+
+	// A restart brings the lockout back for the same address only.
+	g2, err := NewLoginGuard(ctx, g.Store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g2.Check("admin", ip); !errors.Is(err, ErrLoginThrottled) {
+		t.Errorf("after restart: err = %v, want throttled", err)
+	}
+
+	held, err := g.Unlock(ctx, "user", "ADMIN", "test")
+	if err != nil || !held {
+		t.Fatalf("Unlock: held %v, err %v", held, err)
+	}
+	if _, err := g.Check("admin", ip); !errors.Is(err, ErrLoginThrottled) {
+		t.Errorf("address still in its own backoff: err = %v, want throttled", err)
+	}
+	if held, err := g.Unlock(ctx, "ip", ip, "test"); err != nil || !held {
+		t.Fatalf("Unlock ip: held %v, err %v", held, err)
+	}
+	if _, err := g.Check("admin", ip); err != nil {
+		t.Errorf("after unlock: %v", err)
+	}
+	if active, _, _ := g.Lockouts(ctx, 10); len(active) != 0 {
+		t.Errorf("%d lockouts still active after unlock", len(active))
+	}
+}
+
+func TestLoginGuardReservesAttempts(t *testing.T) {
+	g := newTestLoginGuard(t)
+	ctx := context.Background()
+	const ip = "198.51.100.1"
+
+	// The free attempts may run side by side, one more may not.
+	for i := 0; i < loginFreeFailures; i++ {
+		if _, err := g.Check("op", ip); err != nil {
+			t.Fatalf("free attempt %d: %v", i+1, err)
+		}
+	}
+	if _, err := g.Check("op", ip); !errors.Is(err, ErrLoginThrottled) {
+		t.Fatalf("attempt past the free ones: err = %v, want throttled", err)
+	}
+	g.Success("op", ip)
+	g.Release("op", ip)
+	g.Fail(ctx, "op", ip)
+	if g.ips[ip].pending != 0 {
+		t.Fatalf("%d attempts still reserved after settling all three", g.ips[ip].pending)
+	}
+
+	// In the backoff only one guess at a time gets through.
+	st := g.users[loginUser{"op", ip}]
+	for st.failures < loginFreeFailures {
+		expire(g)
+		if _, err := g.Check("op", ip); err != nil {
+			t.Fatal(err)
+		}
+		g.Fail(ctx, "op", ip)
+	}
+	expire(g)
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		allowed int
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := g.Check("op", ip); err == nil {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 1 {
+		t.Fatalf("%d parallel attempts got through the backoff, want 1", allowed)
+	}
+	g.Fail(ctx, "op", ip)
+	if _, err := g.Check("op", ip); !errors.Is(err, ErrLoginThrottled) {
+		t.Errorf("after the failed guess: err = %v, want throttled", err)
+	}
+}
+
+func TestLoginGuardNameBacksOffAcrossAddresses(t *testing.T) {
+	g := newTestLoginGuard(t)
+	ctx := context.Background()
+	addr := func(i int) string { return fmt.Sprintf("198.51.100.%d", i) }
+
+	// one guess from each of many addresses trips no per-address counter
+	for i := 1; i <= loginNameFreeFailures; i++ {
+		if _, err := g.Check("admin", addr(i)); err != nil {
+			t.Fatalf("guess %d: %v", i, err)
+		}
+		g.Fail(ctx, "admin", addr(i))
+	}
+	if _, err := g.Check("admin", addr(100)); !errors.Is(err, ErrLoginThrottled) {
+		t.Fatalf("after %d guesses from as many addresses: err = %v, want throttled", loginNameFreeFailures, err)
+	}
+	// the name only backs off; it is never locked
+	for i := 0; i < 3*loginUserThreshold; i++ {
+		expire(g)
+		if _, err := g.Check("admin", addr(200+i%50)); err != nil {
+			t.Fatalf("spread guess %d: %v", i, err)
+		}
+		g.Fail(ctx, "admin", addr(200+i%50))
+	}
+	if st := g.names["admin"]; st.locked || st.next.Sub(st.lastFail) > loginMaxBackoff {
+		t.Errorf("name state locked %v, held %s", st.locked, st.next.Sub(st.lastFail))
+	}
+	if active, _, _ := g.Lockouts(ctx, 10); len(active) != 0 {
+		t.Errorf("%d lockouts from spread guesses, want none", len(active))
+	}
+
+	// the owner logging in clears the name's backoff
+	expire(g)
+	if _, err := g.Check("admin", "203.0.113.1"); err != nil {
+		t.Fatal(err)
+	}
+	g.Success("admin", "203.0.113.1")
+	if _, ok := g.names["admin"]; ok {
+		t.Error("name counter kept after a successful login")
+	}
+}
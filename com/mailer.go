@@ -0,0 +1,899 @@
+package com
+
+import (
+	"OnlySats/com/logging"
+	"OnlySats/com/shared"
+	"OnlySats/config"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var mailLog = logging.For("mail")
+
+var ErrMailDisabled = errors.New("email is not configured")
+
+// ---------- Types ----------
+
+type QueuedEmail struct {
+	ID        int64      `json:"id"`
+	To        string     `json:"to"`
+	Subject   string     `json:"subject"`
+	Kind      string     `json:"kind"`
+	Status    string     `json:"status"` // pending | sent | failed
+	Attempts  int        `json:"attempts"`
+	NextTry   time.Time  `json:"next_try"`
+	LastError string     `json:"last_error,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	SentAt    *time.Time `json:"sent_at,omitempty"`
+
+	text string
+	html string
+}
+
+// ---------- User preferences ----------
+
+type NotifyPrefs struct {
+	Email         string `json:"email"`
+	NotifyAlerts  bool   `json:"notifyAlerts"`
+	NotifySummary bool   `json:"notifySummary"`
+}
+
+func (s *LocalDataStore) GetNotifyPrefs(ctx context.Context, userID int64) (*NotifyPrefs, error) {
+	var p NotifyPrefs
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(email, ''), notify_alerts, notify_summary FROM users WHERE id = ?
+	`, userID).Scan(&p.Email, &p.NotifyAlerts, &p.NotifySummary)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (s *LocalDataStore) SetNotifyPrefs(ctx context.Context, userID int64, p NotifyPrefs) error {
+	p.Email = strings.TrimSpace(p.Email)
+	if p.Email != "" {
+		addr, err := mail.ParseAddress(p.Email)
+		if err != nil {
+			return fmt.Errorf("invalid email address: %w", err)
+		}
+		p.Email = addr.Address
+	}
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE users SET email = ?, notify_alerts = ?, notify_summary = ? WHERE id = ?
+	`, nullIfEmpty(p.Email), boolToInt(p.NotifyAlerts), boolToInt(p.NotifySummary), userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// notifyRecipients returns the addresses of users opted into column (notify_alerts | notify_summary).
+func (s *LocalDataStore) notifyRecipients(ctx context.Context, column string) ([]string, error) {
+	if column != "notify_alerts" && column != "notify_summary" {
+		return nil, fmt.Errorf("unknown preference %q", column)
+	}
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT email FROM users WHERE `+column+` = 1 AND email IS NOT NULL AND email != ''`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var e string
+		if err := rows.Scan(&e); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+func nullIfEmpty(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// ---------- Queue storage ----------
+
+func (s *LocalDataStore) enqueueEmail(ctx context.Context, to, subject, text, html, kind string) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO email_queue (to_addr, subject, body_text, body_html, kind, next_ts)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		to, subject, text, html, kind, time.Now().Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (s *LocalDataStore) dueEmails(ctx context.Context, now time.Time, limit int) ([]QueuedEmail, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, to_addr, subject, body_text, COALESCE(body_html, ''), kind, attempts
+		FROM email_queue
+		WHERE status = 'pending' AND next_ts <= ?
+		ORDER BY next_ts, id
+		LIMIT ?`, now.Unix(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []QueuedEmail
+	for rows.Next() {
+		var m QueuedEmail
+		if err := rows.Scan(&m.ID, &m.To, &m.Subject, &m.text, &m.html, &m.Kind, &m.Attempts); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+func (s *LocalDataStore) markEmailSent(ctx context.Context, id int64) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE email_queue SET status='sent', attempts=attempts+1, last_error=NULL, sent_ts=? WHERE id=?`,
+		time.Now().Unix(), id)
+	return err
+}
+
+func (s *LocalDataStore) markEmailFailed(ctx context.Context, id int64, attempts int, next time.Time, giveUp bool, msg string) error {
+	status := "pending"
+	if giveUp {
+		status = "failed"
+	}
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE email_queue SET status=?, attempts=?, next_ts=?, last_error=? WHERE id=?`,
+		status, attempts, next.Unix(), msg, id)
+	return err
+}
+
+// ListQueuedEmails returns the most recent queue entries. status may be empty for all.
+func (s *LocalDataStore) ListQueuedEmails(ctx context.Context, status string, limit int) ([]QueuedEmail, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	q := `SELECT id, to_addr, subject, kind, status, attempts, next_ts, COALESCE(last_error, ''), created_ts, sent_ts
+		FROM email_queue`
+	args := []any{}
+	if status != "" {
+		q += ` WHERE status = ?`
+		args = append(args, status)
+	}
+	q += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := s.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []QueuedEmail{}
+	for rows.Next() {
+		var (
+			m             QueuedEmail
+			next, created int64
+			sent          sql.NullInt64
+		)
+		if err := rows.Scan(&m.ID, &m.To, &m.Subject, &m.Kind, &m.Status, &m.Attempts,
+			&next, &m.LastError, &created, &sent); err != nil {
+			return nil, err
+		}
+		m.NextTry = time.Unix(next, 0).UTC()
+		m.CreatedAt = time.Unix(created, 0).UTC()
+		if sent.Valid {
+			t := time.Unix(sent.Int64, 0).UTC()
+			m.SentAt = &t
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+// RetryEmail puts a failed message back in the queue.
+func (s *LocalDataStore) RetryEmail(ctx context.Context, id int64) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE email_queue SET status='pending', attempts=0, next_ts=? WHERE id=? AND status='failed'`,
+		time.Now().Unix(), id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (s *LocalDataStore) PruneEmailQueue(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx,
+		`DELETE FROM email_queue WHERE status != 'pending' AND created_ts < ?`, before.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// ---------- Templates ----------
+
+// Each email kind has a subject, text and HTML template sharing one data value.
+type mailTemplate struct {
+	subject *template.Template
+	text    *template.Template
+	html    *htmltemplate.Template
+}
+
+const mailLayoutHTML = `<!doctype html>
+<html><body style="margin:0;padding:0;background:#f4f5f7;font-family:Helvetica,Arial,sans-serif;color:#222">
+<table width="100%" cellpadding="0" cellspacing="0"><tr><td align="center" style="padding:24px">
+<table width="560" cellpadding="0" cellspacing="0" style="background:#fff;border-radius:6px;padding:24px">
+<tr><td style="font-size:13px;color:#888;padding-bottom:12px">{{.Station}}</td></tr>
+<tr><td>{{template "content" .}}</td></tr>
+{{if .BaseURL}}<tr><td style="padding-top:20px;font-size:13px"><a href="{{.BaseURL}}" style="color:#2b6cb0">Open station</a></td></tr>{{end}}
+</table>
+<p style="font-size:11px;color:#999">Sent by OnlySats. Change your notification preferences in the admin panel.</p>
+</td></tr></table>
+</body></html>`
+
+var mailTemplateSrc = map[string][3]string{
+	"alert": {
+		`[{{.Station}}] {{if .Resolved}}Resolved{{else}}{{upper .Severity}}{{end}}: {{.Title}}`,
+		`{{if .Resolved}}Resolved{{else}}Alert ({{.Severity}}){{end}}: {{.Title}}
+
+{{.Message}}
+
+Rule:  {{.Rule}}
+Since: {{.Since.Format "2006-01-02 15:04 MST"}}{{if .Resolved}}
+Lasted: {{.Duration}}{{end}}
+{{if .BaseURL}}
+{{.BaseURL}}
+{{end}}`,
+		`{{define "content"}}
+<h2 style="margin:0 0 12px;color:{{if .Resolved}}#2f855a{{else if eq .Severity "alert"}}#c53030{{else}}#b7791f{{end}}">
+{{if .Resolved}}Resolved: {{end}}{{.Title}}</h2>
+{{if .Message}}<p>{{.Message}}</p>{{end}}
+<table style="font-size:13px;color:#555">
+<tr><td style="padding-right:12px">Rule</td><td>{{.Rule}}</td></tr>
+<tr><td style="padding-right:12px">Severity</td><td>{{.Severity}}</td></tr>
+<tr><td style="padding-right:12px">Since</td><td>{{.Since.Format "2006-01-02 15:04 MST"}}</td></tr>
+{{if .Resolved}}<tr><td style="padding-right:12px">Lasted</td><td>{{.Duration}}</td></tr>{{end}}
+</table>
+{{end}}`,
+	},
+	"summary": {
+		`[{{.Station}}] Daily summary: {{.PassCount}} passes, {{.ImageCount}} images`,
+		`Station summary for the 24 hours ending {{.Until.Format "2006-01-02 15:04 MST"}}
+
+Passes: {{.PassCount}}
+Images: {{.ImageCount}}
+{{range .Satellites}}  {{.Name}}: {{.Passes}} passes, {{.Images}} images
+{{end}}{{if .Disk}}
+Disk: {{.Disk.FreeHuman}} free of {{.Disk.TotalHuman}} ({{printf "%.1f" .Disk.FreePct}}%){{end}}
+
+Active alerts: {{len .Alerts}}
+{{range .Alerts}}  [{{.Severity}}] {{.Title}}
+{{end}}{{if .BaseURL}}
+{{.BaseURL}}
+{{end}}`,
+		`{{define "content"}}
+<h2 style="margin:0 0 12px">Daily summary</h2>
+<p style="color:#555;font-size:13px">24 hours ending {{.Until.Format "2006-01-02 15:04 MST"}}</p>
+<p><b>{{.PassCount}}</b> passes, <b>{{.ImageCount}}</b> images</p>
+{{if .Satellites}}<table style="font-size:13px;border-collapse:collapse">
+<tr><th align="left" style="padding:4px 12px 4px 0">Satellite</th><th align="right" style="padding:4px 12px">Passes</th><th align="right" style="padding:4px 0">Images</th></tr>
+{{range .Satellites}}<tr><td style="padding:4px 12px 4px 0">{{.Name}}</td><td align="right" style="padding:4px 12px">{{.Passes}}</td><td align="right">{{.Images}}</td></tr>
+{{end}}</table>{{end}}
+{{if .Disk}}<p>Disk: {{.Disk.FreeHuman}} free of {{.Disk.TotalHuman}} ({{printf "%.1f" .Disk.FreePct}}%)</p>{{end}}
+<h3 style="margin:16px 0 8px">Active alerts ({{len .Alerts}})</h3>
+{{if .Alerts}}<ul>{{range .Alerts}}<li><b>{{.Severity}}</b> {{.Title}}</li>{{end}}</ul>{{else}}<p style="color:#2f855a">None</p>{{end}}
+{{end}}`,
+	},
+	"password_reset": {
+		`[{{.Station}}] Your password was reset`,
+		`Hi {{.Username}},
+
+An administrator reset your OnlySats password.
+
+Username: {{.Username}}
+New password: {{.Password}}
+
+Please sign in and change it.
+{{if .BaseURL}}
+{{.BaseURL}}/login
+{{end}}`,
+		`{{define "content"}}
+<h2 style="margin:0 0 12px">Password reset</h2>
+<p>Hi {{.Username}}, an administrator reset your OnlySats password.</p>
+<p>Username: <b>{{.Username}}</b><br>New password: <code style="font-size:15px">{{.Password}}</code></p>
+<p>Please sign in and change it.</p>
+{{if .BaseURL}}<p><a href="{{.BaseURL}}/login" style="color:#2b6cb0">Sign in</a></p>{{end}}
+{{end}}`,
+	},
+	"test": {
+		`[{{.Station}}] Test email`,
+		`This is a test email from OnlySats. If you can read this, outgoing mail works.
+`,
+		`{{define "content"}}<h2 style="margin:0 0 12px">Test email</h2>
+<p>If you can read this, outgoing mail works.</p>{{end}}`,
+	},
+}
+
+var mailTemplates = func() map[string]mailTemplate {
+	funcs := template.FuncMap{"upper": strings.ToUpper}
+	out := map[string]mailTemplate{}
+	for name, src := range mailTemplateSrc {
+		out[name] = mailTemplate{
+			subject: template.Must(template.New(name + "_subject").Funcs(funcs).Parse(src[0])),
+			text:    template.Must(template.New(name + "_text").Funcs(funcs).Parse(src[1])),
+			html: htmltemplate.Must(htmltemplate.Must(
+				htmltemplate.New(name + "_html").Funcs(htmltemplate.FuncMap(funcs)).Parse(mailLayoutHTML)).Parse(src[2])),
+		}
+	}
+	return out
+}()
+
+// renderMail fills in Station/BaseURL and executes the kind's templates.
+func renderMail(kind string, data map[string]any) (subject, text, html string, err error) {
+	t, ok := mailTemplates[kind]
+	if !ok {
+		return "", "", "", fmt.Errorf("unknown email template %q", kind)
+	}
+	var b bytes.Buffer
+	if err := t.subject.Execute(&b, data); err != nil {
+		return "", "", "", fmt.Errorf("subject: %w", err)
+	}
+	subject = strings.TrimSpace(b.String())
+	b.Reset()
+	if err := t.text.Execute(&b, data); err != nil {
+		return "", "", "", fmt.Errorf("text body: %w", err)
+	}
+	text = b.String()
+	b.Reset()
+	if err := t.html.Execute(&b, data); err != nil {
+		return "", "", "", fmt.Errorf("html body: %w", err)
+	}
+	return subject, text, b.String(), nil
+}
+
+// ---------- Mailer ----------
+
+// Mailer renders templated emails into a persistent queue and delivers them
+// over SMTP, retrying failures with exponential backoff.
+type Mailer struct {
+	Cfg         config.SMTPConfig
+	Store       *LocalDataStore
+	ImageDB     *sql.DB
+	Station     string
+	LiveOutput  string
+	MaxAttempts int
+	BaseBackoff time.Duration
+
+	wake chan struct{}
+}
+
+func NewMailer(cfg *config.AppConfig, store *LocalDataStore, imageDB *sql.DB) *Mailer {
+	station := cfg.StationProxy.StationId
+	if station == "" {
+		station = "OnlySats"
+	}
+	return &Mailer{
+		Cfg:         cfg.SMTP,
+		Store:       store,
+		ImageDB:     imageDB,
+		Station:     station,
+		LiveOutput:  cfg.Paths.LiveOutputDir,
+		MaxAttempts: 8,
+		BaseBackoff: 30 * time.Second,
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+func (m *Mailer) Enabled() bool {
+	return m != nil && m.Cfg.Enabled && m.Cfg.Host != ""
+}
+
+// Start runs the delivery loop, the daily summary and alert notifications.
+// Does nothing unless [smtp] is enabled.
+func (m *Mailer) Start(ctx context.Context) {
+	if !m.Enabled() {
+		return
+	}
+	mailLog.Info("sending mail", "host", m.Cfg.Host, "port", m.port(), "security", m.security())
+	go m.deliverLoop(ctx)
+	go m.summaryLoop(ctx)
+	SubscribeEvents(func(ev Event) {
+		if ev.Type != EventAlertRaised && ev.Type != EventAlertResolved {
+			return
+		}
+		go m.notifyAlert(ctx, ev)
+	})
+}
+
+// Enqueue renders kind with data and queues one email per recipient.
+func (m *Mailer) Enqueue(ctx context.Context, kind string, data map[string]any, to ...string) error {
+	if !m.Enabled() {
+		return ErrMailDisabled
+	}
+	if len(to) == 0 {
+		return nil
+	}
+	if data == nil {
+		data = map[string]any{}
+	}
+	data["Station"] = m.Station
+	data["BaseURL"] = strings.TrimRight(m.Cfg.BaseURL, "/")
+
+	subject, text, html, err := renderMail(kind, data)
+	if err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if _, err := m.Store.enqueueEmail(ctx, addr, subject, text, html, kind); err != nil {
+			return fmt.Errorf("queue email to %s: %w", addr, err)
+		}
+	}
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// SendTest delivers a test message synchronously so config errors surface to the caller.
+func (m *Mailer) SendTest(ctx context.Context, to string) error {
+	if !m.Enabled() {
+		return ErrMailDisabled
+	}
+	addr, err := mail.ParseAddress(to)
+	if err != nil {
+		return fmt.Errorf("invalid address: %w", err)
+	}
+	return m.sendNow(ctx, "test", nil, addr.Address)
+}
+
+// sendNow renders kind and delivers it in one attempt without touching the
+// queue, for mail the caller waits on or that must not be stored.
+func (m *Mailer) sendNow(ctx context.Context, kind string, data map[string]any, to string) error {
+	if data == nil {
+		data = map[string]any{}
+	}
+	data["Station"] = m.Station
+	data["BaseURL"] = strings.TrimRight(m.Cfg.BaseURL, "/")
+	subject, text, html, err := renderMail(kind, data)
+	if err != nil {
+		return err
+	}
+	return m.send(ctx, to, subject, text, html)
+}
+
+func (m *Mailer) deliverLoop(ctx context.Context) {
+	t := time.NewTicker(15 * time.Second)
+	defer t.Stop()
+	prune := time.NewTicker(24 * time.Hour)
+	defer prune.Stop()
+
+	for {
+		m.flush(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		case <-m.wake:
+		case <-prune.C:
+			if _, err := m.Store.PruneEmailQueue(ctx, time.Now().Add(-30*24*time.Hour)); err != nil {
+				mailLog.Warn("prune queue", "err", err)
+			}
+		}
+	}
+}
+
+func (m *Mailer) flush(ctx context.Context) {
+	due, err := m.Store.dueEmails(ctx, time.Now(), 20)
+	if err != nil {
+		if ctx.Err() == nil {
+			mailLog.Error("read queue", "err", err)
+		}
+		return
+	}
+	for _, q := range due {
+		if ctx.Err() != nil {
+			return
+		}
+		sendCtx, cancel := context.WithTimeout(ctx, 60*time.Second)
+		err := m.send(sendCtx, q.To, q.Subject, q.text, q.html)
+		cancel()
+		if err == nil {
+			if err := m.Store.markEmailSent(ctx, q.ID); err != nil {
+				mailLog.Error("mark sent", "id", q.ID, "err", err)
+			}
+			continue
+		}
+		attempts := q.Attempts + 1
+		giveUp := attempts >= m.MaxAttempts
+		next := time.Now().Add(m.backoff(attempts))
+		if giveUp {
+			mailLog.Warn("giving up on email", "subject", q.Subject, "to", q.To, "attempts", attempts, "err", err)
+		} else {
+			mailLog.Info("send failed, will retry", "subject", q.Subject, "to", q.To,
+				"attempt", attempts, "next", next.Format(time.Kitchen), "err", err)
+		}
+		if err := m.Store.markEmailFailed(ctx, q.ID, attempts, next, giveUp, err.Error()); err != nil {
+			mailLog.Error("mark failed", "id", q.ID, "err", err)
+		}
+	}
+}
+
+func (m *Mailer) backoff(attempt int) time.Duration {
+	base := m.BaseBackoff
+	if base <= 0 {
+		base = 30 * time.Second
+	}
+	d := base << (attempt - 1)
+	if d <= 0 || d > time.Hour {
+		d = time.Hour
+	}
+	return d
+}
+
+func (m *Mailer) security() string {
+	s := strings.ToLower(strings.TrimSpace(m.Cfg.Security))
+	switch s {
+	case "tls", "ssl":
+		return "tls"
+	case "none", "plain":
+		return "none"
+	}
+	return "starttls"
+}
+
+func (m *Mailer) port() int {
+	if m.Cfg.Port > 0 {
+		return m.Cfg.Port
+	}
+	if m.security() == "tls" {
+		return 465
+	}
+	return 587
+}
+
+// send performs one SMTP transaction.
+func (m *Mailer) send(ctx context.Context, to, subject, text, html string) error {
+	from, err := mail.ParseAddress(m.fromHeader())
+	if err != nil {
+		return fmt.Errorf("invalid from address: %w", err)
+	}
+	msg, err := buildMessage(from, to, subject, text, html)
+	if err != nil {
+		return err
+	}
+
+	host := m.Cfg.Host
+	addr := net.JoinHostPort(host, strconv.Itoa(m.port()))
+	tlsCfg := &tls.Config{ServerName: host, InsecureSkipVerify: m.Cfg.SkipVerify}
+	dialer := &net.Dialer{Timeout: 15 * time.Second}
+
+	var conn net.Conn
+	if m.security() == "tls" {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsCfg}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("dial %s: %w", addr, err)
+	}
+	if dl, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(dl)
+	}
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("smtp handshake: %w", err)
+	}
+	defer c.Close()
+
+	if m.security() == "starttls" {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("server does not support STARTTLS (set security = \"none\" or \"tls\")")
+		}
+		if err := c.StartTLS(tlsCfg); err != nil {
+			return fmt.Errorf("starttls: %w", err)
+		}
+	}
+	if m.Cfg.Username != "" {
+		if ok, _ := c.Extension("AUTH"); ok {
+			if err := c.Auth(smtp.PlainAuth("", m.Cfg.Username, m.Cfg.Password, host)); err != nil {
+				return fmt.Errorf("auth: %w", err)
+			}
+		}
+	}
+	if err := c.Mail(from.Address); err != nil {
+		return fmt.Errorf("MAIL FROM: %w", err)
+	}
+	if err := c.Rcpt(to); err != nil {
+		return fmt.Errorf("RCPT TO: %w", err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("DATA: %w", err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return fmt.Errorf("write body: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("end DATA: %w", err)
+	}
+	return c.Quit()
+}
+
+func (m *Mailer) fromHeader() string {
+	if f := strings.TrimSpace(m.Cfg.From); f != "" {
+		return f
+	}
+	if strings.Contains(m.Cfg.Username, "@") {
+		return m.Cfg.Username
+	}
+	return "onlysats@" + m.Cfg.Host
+}
+
+// buildMessage assembles a multipart/alternative RFC 5322 message.
+func buildMessage(from *mail.Address, to, subject, text, html string) ([]byte, error) {
+	var b bytes.Buffer
+	mw := multipart.NewWriter(&b)
+
+	domain := "onlysats.local"
+	if i := strings.LastIndex(from.Address, "@"); i >= 0 {
+		domain = from.Address[i+1:]
+	}
+	var id [12]byte
+	_, _ = rand.Read(id[:])
+
+	hdr := []string{
+		"From: " + from.String(),
+		"To: " + to,
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"Message-ID: <" + hex.EncodeToString(id[:]) + "@" + domain + ">",
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/alternative; boundary=" + mw.Boundary(),
+	}
+	var out bytes.Buffer
+	out.WriteString(strings.Join(hdr, "\r\n"))
+	out.WriteString("\r\n\r\n")
+
+	parts := []struct{ ctype, body string }{{"text/plain; charset=utf-8", text}}
+	if html != "" {
+		parts = append(parts, struct{ ctype, body string }{"text/html; charset=utf-8", html})
+	}
+	for _, p := range parts {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {p.ctype},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(pw)
+		if _, err := qp.Write([]byte(p.body)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	out.Write(b.Bytes())
+	return out.Bytes(), nil
+}
+
+// ---------- Notifications ----------
+
+func (m *Mailer) notifyAlert(ctx context.Context, ev Event) {
+	to, err := m.Store.notifyRecipients(ctx, "notify_alerts")
+	if err != nil {
+		mailLog.Error("alert recipients", "err", err)
+		return
+	}
+	if len(to) == 0 {
+		return
+	}
+	str := func(k string) string {
+		if v, ok := ev.Data[k]; ok && v != nil {
+			return fmt.Sprint(v)
+		}
+		return ""
+	}
+	since := ev.Time
+	if unix, ok := ev.Data["since"].(int64); ok {
+		since = time.Unix(unix, 0)
+	}
+	data := map[string]any{
+		"Resolved": ev.Type == EventAlertResolved,
+		"Title":    str("title"),
+		"Message":  str("message"),
+		"Severity": str("severity"),
+		"Rule":     str("rule"),
+		"Duration": str("duration"),
+		"Since":    since.Local(),
+	}
+	if err := m.Enqueue(ctx, "alert", data, to...); err != nil {
+		mailLog.Error("queue alert email", "err", err)
+	}
+}
+
+// SendPasswordReset mails the new credentials to the user's own address.
+// It is sent directly rather than queued so the password never lands in
+// email_queue, which keeps sent messages for 30 days.
+func (m *Mailer) SendPasswordReset(ctx context.Context, userID int64, username, password string) error {
+	if !m.Enabled() {
+		return ErrMailDisabled
+	}
+	p, err := m.Store.GetNotifyPrefs(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if p.Email == "" {
+		return errors.New("user has no email address")
+	}
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+	return m.sendNow(ctx, "password_reset", map[string]any{
+		"Username": username,
+		"Password": password,
+	}, p.Email)
+}
+
+type summarySat struct {
+	Name   string
+	Passes int
+	Images int
+}
+
+type summaryDisk struct {
+	FreePct    float64
+	FreeHuman  string
+	TotalHuman string
+}
+
+// SendDailySummary queues the 24h summary to everyone opted in.
+func (m *Mailer) SendDailySummary(ctx context.Context) (int, error) {
+	if !m.Enabled() {
+		return 0, ErrMailDisabled
+	}
+	to, err := m.Store.notifyRecipients(ctx, "notify_summary")
+	if err != nil {
+		return 0, err
+	}
+	if len(to) == 0 {
+		return 0, nil
+	}
+	data, err := m.summaryData(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if err := m.Enqueue(ctx, "summary", data, to...); err != nil {
+		return 0, err
+	}
+	return len(to), nil
+}
+
+func (m *Mailer) summaryData(ctx context.Context) (map[string]any, error) {
+	until := time.Now()
+	from := until.Add(-24 * time.Hour)
+	data := map[string]any{"Until": until}
+
+	var sats []summarySat
+	passes, images := 0, 0
+	if m.ImageDB != nil {
+		rows, err := m.ImageDB.QueryContext(ctx, `
+			SELECT p.satellite, COUNT(DISTINCT p.id), COUNT(i.id)
+			FROM passes p LEFT JOIN images i ON i.passId = p.id
+			WHERE p.timestamp >= ? AND p.timestamp < ?
+			GROUP BY p.satellite
+			ORDER BY COUNT(DISTINCT p.id) DESC`, from.Unix(), until.Unix())
+		if err != nil {
+			return nil, fmt.Errorf("summary passes: %w", err)
+		}
+		for rows.Next() {
+			var s summarySat
+			var name sql.NullString
+			if err := rows.Scan(&name, &s.Passes, &s.Images); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			s.Name = name.String
+			if s.Name == "" {
+				s.Name = "Unknown"
+			}
+			passes += s.Passes
+			images += s.Images
+			sats = append(sats, s)
+		}
+		rows.Close()
+	}
+	data["Satellites"] = sats
+	data["PassCount"] = passes
+	data["ImageCount"] = images
+
+	alerts, err := m.Store.ListAlerts(ctx, "active", 50)
+	if err != nil {
+		return nil, fmt.Errorf("summary alerts: %w", err)
+	}
+	data["Alerts"] = alerts
+
+	data["Disk"] = (*summaryDisk)(nil)
+	if m.LiveOutput != "" {
+		if total, free, err := shared.DiskTotalsForPath(m.LiveOutput); err == nil && total > 0 {
+			data["Disk"] = &summaryDisk{
+				FreePct:    float64(free) / float64(total) * 100,
+				FreeHuman:  humanBytes(free),
+				TotalHuman: humanBytes(total),
+			}
+		}
+	}
+	return data, nil
+}
+
+// mailSummarySettingKey keeps the date of the last daily summary.
+const mailSummarySettingKey = "mail_last_summary"
+
+// summaryLoop sends the summary once per day at Cfg.SummaryHour local time.
+// The last sent date is kept in app_settings so restarts don't resend it.
+func (m *Mailer) summaryLoop(ctx context.Context) {
+	hour := m.Cfg.SummaryHour
+	if hour < 0 || hour > 23 {
+		hour = 7
+	}
+	t := time.NewTicker(time.Minute)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			if now.Hour() != hour {
+				continue
+			}
+			today := now.Format("2006-01-02")
+			last, err := m.Store.GetSetting(ctx, mailSummarySettingKey)
+			if err != nil || last == today {
+				continue
+			}
+			n, err := m.SendDailySummary(ctx)
+			if err != nil {
+				mailLog.Warn("daily summary", "err", err)
+				continue
+			}
+			if n > 0 {
+				mailLog.Info("daily summary queued", "recipients", n)
+			}
+			_ = m.Store.SetSetting(ctx, mailSummarySettingKey, today)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package com
+
+import (
+	"OnlySats/config"
+	"context"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSMTP is a minimal in-process SMTP server. It refuses RCPT TO with a
+// temporary error for the first failRcpt transactions, then accepts mail.
+type fakeSMTP struct {
+	ln net.Listener
+
+	mu       sync.Mutex
+	startTLS bool // advertise STARTTLS
+	failRcpt int
+	rcpts    int
+	messages []string
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeSMTP{ln: ln}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(c)
+		}
+	}()
+	return f
+}
+
+func (f *fakeSMTP) port() int { return f.ln.Addr().(*net.TCPAddr).Port }
+
+func (f *fakeSMTP) serve(c net.Conn) {
+	defer c.Close()
+	tp := textproto.NewConn(c)
+	tp.PrintfLine("220 fake ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.Fields(line + " ")[0])
+		switch cmd {
+		case "EHLO", "HELO":
+			f.mu.Lock()
+			tls := f.startTLS
+			f.mu.Unlock()
+			if tls {
+				tp.PrintfLine("250-fake")
+				tp.PrintfLine("250 STARTTLS")
+			} else {
+				tp.PrintfLine("250 fake")
+			}
+		case "MAIL", "RSET", "NOOP":
+			tp.PrintfLine("250 ok")
+		case "RCPT":
+			f.mu.Lock()
+			f.rcpts++
+			fail := f.rcpts <= f.failRcpt
+			f.mu.Unlock()
+			if fail {
+				tp.PrintfLine("451 try again later")
+			} else {
+				tp.PrintfLine("250 ok")
+			}
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			body, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			f.mu.Lock()
+			f.messages = append(f.messages, string(body))
+			f.mu.Unlock()
+			tp.PrintfLine("250 queued")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+func (f *fakeSMTP) received() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.messages...)
+}
+
+func newTestMailer(t *testing.T, f *fakeSMTP, security string) *Mailer {
+	t.Helper()
+	cfg, _ := config.DefaultConfig()
+	cfg.SMTP = config.SMTPConfig{
+		Enabled:  true,
+		Host:     "127.0.0.1",
+		Port:     f.port(),
+		Security: security,
+		From:     "station@example.org",
+	}
+	m := NewMailer(cfg, openTestStore(t), nil)
+	m.BaseBackoff = time.Nanosecond // retries come due within the same second
+	return m
+}
+
+func queuedEmail(t *testing.T, m *Mailer) QueuedEmail {
+	t.Helper()
+	q, err := m.Store.ListQueuedEmails(context.Background(), "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(q) != 1 {
+		t.Fatalf("queue has %d emails, want 1", len(q))
+	}
+	return q[0]
+}
+
+func TestMailerSendPlain(t *testing.T) {
+	f := newFakeSMTP(t)
+	m := newTestMailer(t, f, "none")
+	if err := m.send(context.Background(), "op@example.org", "Hello", "text body", "<p>html</p>"); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	msgs := f.received()
+	if len(msgs) != 1 {
+		t.Fatalf("got %d messages, want 1", len(msgs))
+	}
+	for _, want := range []string{"To: op@example.org", "Subject: Hello", "text body", "multipart/alternative"} {
+		if !strings.Contains(msgs[0], want) {
+			t.Errorf("message is missing %q", want)
+		}
+	}
+}
+
+func TestMailerRefusesMissingStartTLS(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		advertise bool
+		want      string
+	}{
+		{"not advertised", false, "does not support STARTTLS"},
+		{"handshake refused", true, "starttls:"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFakeSMTP(t)
+			f.startTLS = tc.advertise
+			m := newTestMailer(t, f, "starttls")
+			err := m.send(context.Background(), "op@example.org", "Hello", "body", "")
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("err = %v, want %q", err, tc.want)
+			}
+			if n := len(f.received()); n != 0 {
+				t.Fatalf("%d messages delivered in plain text", n)
+			}
+		})
+	}
+}
+
+func TestMailerFlushRetries(t *testing.T) {
+	f := newFakeSMTP(t)
+	f.failRcpt = 2
+	m := newTestMailer(t, f, "none")
+	ctx := context.Background()
+	if err := m.Enqueue(ctx, "test", nil, "op@example.org"); err != nil {
+		t.Fatal(err)
+	}
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		m.flush(ctx)
+		q := queuedEmail(t, m)
+		if q.Status != "pending" || q.Attempts != attempt || q.LastError == "" {
+			t.Fatalf("after failure %d: status %s, attempts %d, error %q", attempt, q.Status, q.Attempts, q.LastError)
+		}
+	}
+	m.flush(ctx)
+	if q := queuedEmail(t, m); q.Status != "sent" || q.SentAt == nil {
+		t.Fatalf("after success: status %s, sent %v", q.Status, q.SentAt)
+	}
+	if n := len(f.received()); n != 1 {
+		t.Fatalf("got %d messages, want 1", n)
+	}
+}
+
+func TestMailerFlushGivesUp(t *testing.T) {
+	f := newFakeSMTP(t)
+	f.failRcpt = 100
+	m := newTestMailer(t, f, "none")
+	m.MaxAttempts = 3
+	ctx := context.Background()
+	if err := m.Enqueue(ctx, "test", nil, "op@example.org"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		m.flush(ctx)
+	}
+	q := queuedEmail(t, m)
+	if q.Status != "failed" || q.Attempts != 3 {
+		t.Fatalf("status %s after %d attempts, want failed after 3", q.Status, q.Attempts)
+	}
+	if !strings.Contains(q.LastError, "451") {
+		t.Errorf("last error %q does not carry the server reply", q.LastError)
+	}
+}
+
+func TestMailerBackoff(t *testing.T) {
+	m := &Mailer{BaseBackoff: 30 * time.Second}
+	for _, tc := range []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{7, 32 * time.Minute},
+		{8, time.Hour},
+		{40, time.Hour},
+		{70, time.Hour}, // shift overflow
+	} {
+		if got := m.backoff(tc.attempt); got != tc.want {
+			t.Errorf("backoff(%d) = %s, want %s", tc.attempt, got, tc.want)
+		}
+	}
+}
+
+func TestPasswordResetIsNotQueued(t *testing.T) {
+	f := newFakeSMTP(t)
+	m := newTestMailer(t, f, "none")
+	ctx := context.Background()
+	id, err := m.Store.CreateUser(ctx, "op", 3, "old-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Store.SetNotifyPrefs(ctx, id, NotifyPrefs{Email: "op@example.org"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SendPasswordReset(ctx, id, "op", "s3cret-"+strconv.Itoa(int(id))); err != nil {
+		t.Fatalf("SendPasswordReset: %v", err)
+	}
+	if msgs := f.received(); len(msgs) != 1 {
+		t.Fatalf("got %d messages, want 1", len(msgs))
+	}
+	q, err := m.Store.ListQueuedEmails(ctx, "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(q) != 0 {
+		t.Fatalf("password reset left %d rows in email_queue", len(q))
+	}
+}
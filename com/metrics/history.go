@@ -0,0 +1,400 @@
+package metrics
+
+import (
+	"OnlySats/com/logging"
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/mem"
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+var histLog = logging.For("hwhistory")
+
+// Resolutions kept in hardware_samples, finest first.
+const (
+	ResRaw     = 0
+	ResMinute  = 60
+	ResQuarter = 900
+)
+
+// HistorySampler records hardware readings into aggregateData.db and rolls
+// them up as they age: raw samples for a day, 1-minute buckets for a week,
+// 15-minute buckets for a year.
+//
+// Counters that the OS reports cumulatively (network bytes, disk bytes,
+// RAPL energy, CPU times) are differenced between ticks, so stored rates
+// describe the interval rather than the average since boot.
+type HistorySampler struct {
+	DB         *sql.DB
+	LiveOutput string
+	Interval   time.Duration
+
+	// Enabled, if set, is consulted every tick (e.g. the hwmonitor setting).
+	Enabled func(ctx context.Context) bool
+
+	RawRetention     time.Duration
+	MinuteRetention  time.Duration
+	QuarterRetention time.Duration
+
+	prev *counterState
+}
+
+type counterState struct {
+	at       time.Time
+	cpuBusy  float64
+	cpuTotal float64
+	netRx    uint64
+	netTx    uint64
+	diskRd   uint64
+	diskWr   uint64
+	hasDisk  bool
+	energyJ  float64
+	hasRAPL  bool
+}
+
+func NewHistorySampler(db *sql.DB, liveOutput string) *HistorySampler {
+	return &HistorySampler{
+		DB:               db,
+		LiveOutput:       liveOutput,
+		Interval:         15 * time.Second,
+		RawRetention:     24 * time.Hour,
+		MinuteRetention:  7 * 24 * time.Hour,
+		QuarterRetention: 365 * 24 * time.Hour,
+	}
+}
+
+func (s *HistorySampler) Start(ctx context.Context) {
+	go s.loop(ctx)
+}
+
+func (s *HistorySampler) loop(ctx context.Context) {
+	every := s.Interval
+	if every <= 0 {
+		every = 15 * time.Second
+	}
+	t := time.NewTicker(every)
+	defer t.Stop()
+	rollup := time.NewTicker(time.Minute)
+	defer rollup.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if s.Enabled != nil && !s.Enabled(ctx) {
+				s.prev = nil
+				continue
+			}
+			if err := s.sampleOnce(ctx); err != nil && ctx.Err() == nil {
+				histLog.Warn("sample failed", "err", err)
+			}
+		case <-rollup.C:
+			if err := s.Rollup(ctx, time.Now()); err != nil && ctx.Err() == nil {
+				histLog.Warn("rollup failed", "err", err)
+			}
+		}
+	}
+}
+
+func (s *HistorySampler) sampleOnce(ctx context.Context) error {
+	now := time.Now()
+	vals := map[string]float64{}
+	cur := &counterState{at: now}
+
+	if ts, err := cpu.TimesWithContext(ctx, false); err == nil && len(ts) > 0 {
+		t := ts[0]
+		idle := t.Idle + t.Iowait
+		total := t.User + t.System + t.Idle + t.Nice + t.Iowait + t.Irq + t.Softirq + t.Steal
+		cur.cpuBusy, cur.cpuTotal = total-idle, total
+	}
+	if v := getCPUTemperature(ctx); v != nil {
+		vals["cpu.temp"] = *v
+	}
+	if v := getSystemPower(ctx); v != nil {
+		vals["system.power"] = *v
+	}
+	if j, ok := raplEnergyJoules(); ok {
+		cur.energyJ, cur.hasRAPL = j, true
+	}
+
+	if vm, err := mem.VirtualMemoryWithContext(ctx); err == nil {
+		vals["mem.used_pct"] = vm.UsedPercent
+		vals["mem.used"] = float64(vm.Total - vm.Available)
+	}
+
+	if ns, err := net.IOCountersWithContext(ctx, false); err == nil && len(ns) > 0 {
+		cur.netRx, cur.netTx = ns[0].BytesRecv, ns[0].BytesSent
+	}
+
+	// the filesystem holding live_output is the one that matters for a station
+	if abs, err := filepath.Abs(s.LiveOutput); err == nil && s.LiveOutput != "" {
+		if u, err := disk.UsageWithContext(ctx, abs); err == nil {
+			vals["disk.used_pct"] = u.UsedPercent
+			vals["disk.free"] = float64(u.Free)
+		}
+		if dev := deviceOfPath(ctx, abs); dev != "" {
+			if io, err := disk.IOCountersWithContext(ctx, dev); err == nil {
+				for _, c := range io {
+					cur.diskRd, cur.diskWr, cur.hasDisk = c.ReadBytes, c.WriteBytes, true
+					break
+				}
+			}
+		}
+	}
+
+	for i, g := range collectGPUMetrics(ctx) {
+		key := "gpu." + strconv.Itoa(i)
+		if g.UtilizationPct != nil {
+			vals[key+".util"] = *g.UtilizationPct
+		}
+		if g.TemperatureC != nil {
+			vals[key+".temp"] = *g.TemperatureC
+		}
+		if g.PowerW != nil {
+			vals[key+".power"] = *g.PowerW
+		}
+	}
+
+	if p := s.prev; p != nil {
+		dt := now.Sub(p.at).Seconds()
+		if dt > 0 {
+			if d := cur.cpuTotal - p.cpuTotal; d > 0 {
+				vals["cpu.util"] = clampPct((cur.cpuBusy - p.cpuBusy) / d * 100)
+			}
+			// counters can reset (reboot, interface reset, wraparound); skip those intervals
+			if cur.netRx >= p.netRx && cur.netTx >= p.netTx {
+				vals["net.rx_bps"] = float64(cur.netRx-p.netRx) / dt
+				vals["net.tx_bps"] = float64(cur.netTx-p.netTx) / dt
+			}
+			if cur.hasDisk && p.hasDisk && cur.diskRd >= p.diskRd && cur.diskWr >= p.diskWr {
+				vals["disk.read_bps"] = float64(cur.diskRd-p.diskRd) / dt
+				vals["disk.write_bps"] = float64(cur.diskWr-p.diskWr) / dt
+			}
+			if cur.hasRAPL && p.hasRAPL && cur.energyJ >= p.energyJ {
+				vals["cpu.power"] = (cur.energyJ - p.energyJ) / dt
+			}
+		}
+	}
+	s.prev = cur
+
+	if len(vals) == 0 {
+		return nil
+	}
+	return s.insertRaw(ctx, now.Unix(), vals)
+}
+
+func (s *HistorySampler) insertRaw(ctx context.Context, ts int64, vals map[string]float64) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.PrepareContext(ctx,
+		`INSERT INTO hardware_samples (ts, res, metric, avg, min, max, n) VALUES (?, 0, ?, ?, ?, ?, 1)`)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	for k, v := range vals {
+		if _, err := stmt.ExecContext(ctx, ts, k, v, v, v); err != nil {
+			_ = stmt.Close()
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	_ = stmt.Close()
+	return tx.Commit()
+}
+
+// Rollup aggregates complete buckets from the next finer resolution and
+// prunes rows past their retention.
+func (s *HistorySampler) Rollup(ctx context.Context, now time.Time) error {
+	if err := s.rollupLevel(ctx, ResRaw, ResMinute, now); err != nil {
+		return fmt.Errorf("1m: %w", err)
+	}
+	if err := s.rollupLevel(ctx, ResMinute, ResQuarter, now); err != nil {
+		return fmt.Errorf("15m: %w", err)
+	}
+	cut := []struct {
+		res int
+		ret time.Duration
+	}{
+		{ResRaw, s.RawRetention},
+		{ResMinute, s.MinuteRetention},
+		{ResQuarter, s.QuarterRetention},
+	}
+	for _, c := range cut {
+		if c.ret <= 0 {
+			continue
+		}
+		if _, err := s.DB.ExecContext(ctx,
+			`DELETE FROM hardware_samples WHERE res = ? AND ts < ?`, c.res, now.Add(-c.ret).Unix()); err != nil {
+			return fmt.Errorf("prune res=%d: %w", c.res, err)
+		}
+	}
+	return nil
+}
+
+func (s *HistorySampler) rollupLevel(ctx context.Context, from, to int, now time.Time) error {
+	// resume after the newest bucket already written
+	var last sql.NullInt64
+	if err := s.DB.QueryRowContext(ctx,
+		`SELECT MAX(ts) FROM hardware_samples WHERE res = ?`, to).Scan(&last); err != nil {
+		return err
+	}
+	var start int64
+	if last.Valid {
+		start = last.Int64 + int64(to)
+	} else {
+		var first sql.NullInt64
+		if err := s.DB.QueryRowContext(ctx,
+			`SELECT MIN(ts) FROM hardware_samples WHERE res = ?`, from).Scan(&first); err != nil {
+			return err
+		}
+		if !first.Valid {
+			return nil
+		}
+		start = first.Int64 / int64(to) * int64(to)
+	}
+	end := now.Unix() / int64(to) * int64(to) // only complete buckets
+	if end <= start {
+		return nil
+	}
+	_, err := s.DB.ExecContext(ctx, `
+		INSERT INTO hardware_samples (ts, res, metric, avg, min, max, n)
+		SELECT (ts / ?) * ?, ?, metric, SUM(avg * n) / SUM(n), MIN(min), MAX(max), SUM(n)
+		FROM hardware_samples
+		WHERE res = ? AND ts >= ? AND ts < ?
+		GROUP BY (ts / ?), metric`,
+		to, to, to, from, start, end, to)
+	return err
+}
+
+// ---------- Queries ----------
+
+type HistoryPoint struct {
+	T   int64   `json:"t"`
+	Avg float64 `json:"v"`
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+type History struct {
+	Metric     string         `json:"metric"`
+	Resolution int            `json:"resolution"` // seconds per point, 0 = raw samples
+	From       int64          `json:"from"`
+	To         int64          `json:"to"`
+	Points     []HistoryPoint `json:"points"`
+}
+
+// PickResolution chooses the coarsest-needed resolution that still has data
+// for the window: raw for short recent ranges, then 1 min, then 15 min.
+func (s *HistorySampler) PickResolution(from, to time.Time, now time.Time) int {
+	span := to.Sub(from)
+	switch {
+	case span <= 6*time.Hour && now.Sub(from) <= s.RawRetention:
+		return ResRaw
+	case span <= 3*24*time.Hour && now.Sub(from) <= s.MinuteRetention:
+		return ResMinute
+	}
+	return ResQuarter
+}
+
+func QueryHistory(ctx context.Context, db *sql.DB, metric string, res int, from, to time.Time) (*History, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT ts, avg, min, max FROM hardware_samples
+		WHERE metric = ? AND res = ? AND ts >= ? AND ts <= ?
+		ORDER BY ts`, metric, res, from.Unix(), to.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	h := &History{Metric: metric, Resolution: res, From: from.Unix(), To: to.Unix(), Points: []HistoryPoint{}}
+	for rows.Next() {
+		var p HistoryPoint
+		if err := rows.Scan(&p.T, &p.Avg, &p.Min, &p.Max); err != nil {
+			return nil, err
+		}
+		h.Points = append(h.Points, p)
+	}
+	return h, rows.Err()
+}
+
+// HistoryMetrics lists metric names recorded at any resolution.
+func HistoryMetrics(ctx context.Context, db *sql.DB) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `SELECT DISTINCT metric FROM hardware_samples ORDER BY metric`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []string{}
+	for rows.Next() {
+		var m string
+		if err := rows.Scan(&m); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+// ---------- helpers ----------
+
+func clampPct(v float64) float64 {
+	if v < 0 {
+		return 0
+	}
+	if v > 100 {
+		return 100
+	}
+	return v
+}
+
+// deviceOfPath returns the IO-counter name (e.g. "sda1", "nvme0n1p2") of the
+// partition mounted at the longest prefix of path.
+func deviceOfPath(ctx context.Context, path string) string {
+	parts, err := disk.PartitionsWithContext(ctx, false)
+	if err != nil {
+		return ""
+	}
+	mp := mountOfPath(path, parts)
+	for _, p := range parts {
+		if p.Mountpoint == mp {
+			dev := p.Device
+			if runtime.GOOS != "windows" {
+				dev = filepath.Base(dev)
+			}
+			return dev
+		}
+	}
+	return ""
+}
+
+// raplEnergyJoules reads the cumulative Intel/AMD RAPL package energy counter.
+func raplEnergyJoules() (float64, bool) {
+	if runtime.GOOS != "linux" {
+		return 0, false
+	}
+	for _, path := range []string{
+		"/sys/class/powercap/intel-rapl/intel-rapl:0/energy_uj",
+		"/sys/devices/virtual/powercap/intel-rapl/intel-rapl:0/energy_uj",
+	} {
+		if data, err := os.ReadFile(path); err == nil {
+			if uj, err := strconv.ParseFloat(strings.TrimSpace(string(data)), 64); err == nil {
+				return uj / 1e6, true
+			}
+		}
+	}
+	return 0, false
+}
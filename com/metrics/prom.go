@@ -0,0 +1,284 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Minimal Prometheus text exposition (format 0.0.4). Counters, gauges and
+// histograms register themselves on creation and are written by WritePrometheus.
+
+type collector interface {
+	write(w io.Writer)
+}
+
+var (
+	regMu    sync.Mutex
+	registry []collector
+)
+
+func register(c collector) {
+	regMu.Lock()
+	registry = append(registry, c)
+	regMu.Unlock()
+}
+
+// WritePrometheus writes every registered metric.
+func WritePrometheus(w io.Writer) {
+	regMu.Lock()
+	cs := make([]collector, len(registry))
+	copy(cs, registry)
+	regMu.Unlock()
+	for _, c := range cs {
+		c.write(w)
+	}
+}
+
+// Sample is one labelled value for WriteGauge.
+type Sample struct {
+	Labels map[string]string
+	Value  float64
+}
+
+// WriteGauge writes an ad-hoc gauge family computed at scrape time.
+func WriteGauge(w io.Writer, name, help string, samples ...Sample) {
+	writeSamples(w, name, help, "gauge", samples)
+}
+
+// WriteCounter is WriteGauge for values that only grow (e.g. cumulative OS counters).
+func WriteCounter(w io.Writer, name, help string, samples ...Sample) {
+	writeSamples(w, name, help, "counter", samples)
+}
+
+func writeSamples(w io.Writer, name, help, typ string, samples []Sample) {
+	if len(samples) == 0 {
+		return
+	}
+	writeHeader(w, name, help, typ)
+	for _, s := range samples {
+		keys := make([]string, 0, len(s.Labels))
+		for k := range s.Labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		vals := make([]string, len(keys))
+		for i, k := range keys {
+			vals[i] = s.Labels[k]
+		}
+		fmt.Fprintf(w, "%s%s %s\n", name, labelString(keys, vals), formatFloat(s.Value))
+	}
+}
+
+func writeHeader(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, typ)
+}
+
+// ---------- Counter / Gauge ----------
+
+type vec struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+
+	mu   sync.Mutex
+	vals map[string]*vecEntry
+}
+
+type vecEntry struct {
+	lv  []string
+	val float64
+}
+
+func newVec(name, help, typ string, labels []string) *vec {
+	v := &vec{name: name, help: help, typ: typ, labels: labels, vals: map[string]*vecEntry{}}
+	register(v)
+	return v
+}
+
+func (v *vec) entry(lv []string) *vecEntry {
+	if len(lv) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(lv)))
+	}
+	k := strings.Join(lv, "\xff")
+	e, ok := v.vals[k]
+	if !ok {
+		e = &vecEntry{lv: append([]string(nil), lv...)}
+		v.vals[k] = e
+	}
+	return e
+}
+
+func (v *vec) write(w io.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if len(v.vals) == 0 && len(v.labels) > 0 {
+		return
+	}
+	writeHeader(w, v.name, v.help, v.typ)
+	if len(v.vals) == 0 {
+		fmt.Fprintf(w, "%s 0\n", v.name)
+		return
+	}
+	for _, k := range sortedKeys(v.vals) {
+		e := v.vals[k]
+		fmt.Fprintf(w, "%s%s %s\n", v.name, labelString(v.labels, e.lv), formatFloat(e.val))
+	}
+}
+
+type Counter struct{ v *vec }
+
+func NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{v: newVec(name, help, "counter", labels)}
+}
+
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		return
+	}
+	c.v.mu.Lock()
+	c.v.entry(labelValues).val += delta
+	c.v.mu.Unlock()
+}
+
+func (c *Counter) Inc(labelValues ...string) { c.Add(1, labelValues...) }
+
+type Gauge struct{ v *vec }
+
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{v: newVec(name, help, "gauge", labels)}
+}
+
+func (g *Gauge) Set(val float64, labelValues ...string) {
+	g.v.mu.Lock()
+	g.v.entry(labelValues).val = val
+	g.v.mu.Unlock()
+}
+
+// ---------- Histogram ----------
+
+// DefBuckets suit request latencies in seconds.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type Histogram struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu   sync.Mutex
+	vals map[string]*histEntry
+}
+
+type histEntry struct {
+	lv     []string
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefBuckets
+	}
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+	h := &Histogram{name: name, help: help, labels: labels, buckets: b, vals: map[string]*histEntry{}}
+	register(h)
+	return h
+}
+
+func (h *Histogram) Observe(val float64, labelValues ...string) {
+	if len(labelValues) != len(h.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", h.name, len(h.labels), len(labelValues)))
+	}
+	k := strings.Join(labelValues, "\xff")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e, ok := h.vals[k]
+	if !ok {
+		e = &histEntry{lv: append([]string(nil), labelValues...), counts: make([]uint64, len(h.buckets))}
+		h.vals[k] = e
+	}
+	for i, ub := range h.buckets {
+		if val <= ub {
+			e.counts[i]++
+		}
+	}
+	e.sum += val
+	e.count++
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.vals) == 0 {
+		return
+	}
+	writeHeader(w, h.name, h.help, "histogram")
+	names := append(append([]string(nil), h.labels...), "le")
+	for _, k := range sortedKeys(h.vals) {
+		e := h.vals[k]
+		for i, ub := range h.buckets {
+			lv := append(append([]string(nil), e.lv...), formatFloat(ub))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelString(names, lv), e.counts[i])
+		}
+		lv := append(append([]string(nil), e.lv...), "+Inf")
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelString(names, lv), e.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labelString(h.labels, e.lv), formatFloat(e.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labelString(h.labels, e.lv), e.count)
+	}
+}
+
+// ---------- helpers ----------
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func labelString(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, n := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(n)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(values[i]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,276 @@
+package com
+
+import (
+	"OnlySats/com/logging"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/sessions"
+)
+
+var policyLog = logging.For("policy")
+
+// AccessPolicySettingKey is the app_settings key the policy is kept under.
+const AccessPolicySettingKey = "access_policy"
+
+// ErrBadPolicy wraps validation errors for AccessPolicy updates.
+var ErrBadPolicy = errors.New("invalid access policy")
+
+// AccessLevel is the most limited user level allowed into a route group
+// (0 admin .. 3 user), or AccessPublic.
+type AccessLevel int
+
+// AccessPublic lets everyone in, signed in or not.
+const AccessPublic AccessLevel = -1
+
+func (l AccessLevel) MarshalJSON() ([]byte, error) {
+	if l == AccessPublic {
+		return []byte(`"public"`), nil
+	}
+	return []byte(strconv.Itoa(int(l))), nil
+}
+
+func (l *AccessLevel) UnmarshalJSON(b []byte) error {
+	var s string
+	if json.Unmarshal(b, &s) == nil {
+		if strings.EqualFold(strings.TrimSpace(s), "public") {
+			*l = AccessPublic
+			return nil
+		}
+		b = []byte(strings.TrimSpace(s))
+	}
+	n, err := strconv.Atoi(string(b))
+	if err != nil || n < 0 || n > 3 {
+		return fmt.Errorf("%w: level must be \"public\" or 0 to 3", ErrBadPolicy)
+	}
+	*l = AccessLevel(n)
+	return nil
+}
+
+// RouteGroup is a set of routes sharing one entry in the policy. A path
+// ending in / covers everything below it; otherwise the exact path and
+// anything below it. The longest matching path decides the group. Fixed
+// groups always need their default level and can't be edited.
+type RouteGroup struct {
+	Key     string      `json:"key"`
+	Label   string      `json:"label"`
+	Paths   []string    `json:"paths"`
+	Default AccessLevel `json:"default"`
+	Fixed   bool        `json:"fixed,omitempty"`
+}
+
+// RouteGroups are every route that needs a level. The first five can be
+// restricted by admins; the rest are the admin pages and the /local API,
+// which keep fixed levels so the policy can't lock admins out. Anything
+// under /local/ that isn't listed needs an admin.
+var RouteGroups = []RouteGroup{
+	{Key: "gallery", Label: "Gallery, images and thumbnails", Default: AccessPublic, Paths: []string{
+		"/gallery", "/api/images", "/api/thumbnails", "/api/satellites", "/api/bands", "/api/composites",
+		"/api/export/geo", "/api/animations", "/images/", "/thumbnails/", "/tiles/"}},
+	{Key: "raw", Label: "Raw data downloads", Default: AccessPublic, Paths: []string{"/api/export"}},
+	{Key: "zip", Label: "Folder and bulk ZIP downloads", Default: AccessPublic, Paths: []string{"/api/zip", "/api/exports"}},
+	{Key: "analytics", Label: "Pass analytics", Default: AccessPublic, Paths: []string{
+		"/api/analytics/tracks", "/api/analytics/decoder", "/api/satdump/names"}},
+	{Key: "update", Label: "Database update trigger", Default: AccessPublic, Paths: []string{"/api/update"}},
+
+	{Key: "station", Label: "Station stats, hardware and SatDump views", Default: 3, Fixed: true, Paths: []string{
+		"/local/stats", "/local/satdump", "/local/api/disk-stats", "/local/api/hardware", "/api/stats", "/api/repopulate"}},
+	{Key: "editor", Label: "Admin center, settings, about page, messages and pass templates", Default: 1, Fixed: true, Paths: []string{
+		"/local/admin", "/local/configure-passes", "/local/configure-about", "/local/messages-admin",
+		"/local/api/settings", "/local/api/about", "/local/api/animations", "/local/api/exports", "/local/api/messages",
+		"/local/api/pass-types", "/local/api/folder-includes", "/local/api/composites", "/api/config/theme"}},
+	{Key: "admin", Label: "Users, access policy, webhooks, alerts, email, logs, SatDump instances, share links and thumbnails", Default: 0, Fixed: true, Paths: []string{
+		"/local/", "/local/api/users", "/local/api/settings/access", "/local/api/webhooks", "/local/api/alerts",
+		"/local/api/email", "/local/api/logs", "/local/api/satdump", "/local/api/share-links", "/local/api/thumbnails"}},
+	{Key: "info", Label: "Station info", Default: AccessPublic, Fixed: true, Paths: []string{"/local/api/info"}},
+}
+
+func routeGroup(key string) *RouteGroup {
+	for i := range RouteGroups {
+		if RouteGroups[i].Key == key {
+			return &RouteGroups[i]
+		}
+	}
+	return nil
+}
+
+// RouteGroupFor returns the group covering urlPath, or nil.
+func RouteGroupFor(urlPath string) *RouteGroup {
+	var (
+		best    *RouteGroup
+		bestLen int
+	)
+	for i := range RouteGroups {
+		for _, p := range RouteGroups[i].Paths {
+			var ok bool
+			if strings.HasSuffix(p, "/") {
+				ok = strings.HasPrefix(urlPath, p)
+			} else {
+				ok = urlPath == p || strings.HasPrefix(urlPath, p+"/")
+			}
+			if ok && len(p) > bestLen {
+				best, bestLen = &RouteGroups[i], len(p)
+			}
+		}
+	}
+	return best
+}
+
+// AccessPolicy enforces the level set for each route group. Requests a
+// share link was accepted for (ShareLinks.Middleware runs first) get in
+// regardless.
+type AccessPolicy struct {
+	Store    *LocalDataStore
+	Sessions *sessions.CookieStore
+
+	mu     sync.Mutex
+	levels savedSetting[map[string]AccessLevel]
+}
+
+// NewAccessPolicy returns a policy reading its levels from store.
+func NewAccessPolicy(store *LocalDataStore, sessions *sessions.CookieStore) *AccessPolicy {
+	return &AccessPolicy{
+		Store:    store,
+		Sessions: sessions,
+		levels: savedSetting[map[string]AccessLevel]{
+			key:      AccessPolicySettingKey,
+			defaults: defaultAccessLevels,
+			check:    checkAccessLevels,
+			log:      policyLog,
+		},
+	}
+}
+
+func defaultAccessLevels() map[string]AccessLevel {
+	m := make(map[string]AccessLevel, len(RouteGroups))
+	for _, g := range RouteGroups {
+		m[g.Key] = g.Default
+	}
+	return m
+}
+
+// checkAccessLevels drops groups that no longer exist and puts fixed
+// groups back to their level.
+func checkAccessLevels(m *map[string]AccessLevel) error {
+	for k := range *m {
+		if g := routeGroup(k); g == nil {
+			delete(*m, k)
+		} else if g.Fixed {
+			(*m)[k] = g.Default
+		}
+	}
+	return nil
+}
+
+// Levels returns the level of every group.
+func (p *AccessPolicy) Levels(ctx context.Context) map[string]AccessLevel {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.levelsLocked(ctx)
+}
+
+func (p *AccessPolicy) levelsLocked(ctx context.Context) map[string]AccessLevel {
+	saved := p.levels.get(ctx, p.Store)
+	out := make(map[string]AccessLevel, len(saved))
+	for k, v := range saved {
+		out[k] = v
+	}
+	return out
+}
+
+// Update sets the groups named in changes and stores the whole policy.
+func (p *AccessPolicy) Update(ctx context.Context, changes map[string]AccessLevel) (map[string]AccessLevel, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	m := p.levelsLocked(ctx)
+	for k, v := range changes {
+		g := routeGroup(k)
+		if g == nil {
+			return nil, fmt.Errorf("%w: unknown route group %q", ErrBadPolicy, k)
+		}
+		if g.Fixed {
+			if v == g.Default {
+				continue
+			}
+			return nil, fmt.Errorf("%w: route group %q has a fixed level", ErrBadPolicy, k)
+		}
+		if v != AccessPublic && (v < 0 || v > 3) {
+			return nil, fmt.Errorf("%w: level must be \"public\" or 0 to 3", ErrBadPolicy)
+		}
+		m[k] = v
+	}
+	if _, err := p.levels.save(ctx, p.Store, m); err != nil {
+		return nil, err
+	}
+	policyLog.Info("access policy updated", "changes", len(changes))
+	return p.levelsLocked(ctx), nil
+}
+
+// Middleware checks every request in a route group against the group's
+// level. Visitors who aren't signed in are sent to /login (API routes get
+// 401), users below the level get 403.
+func (p *AccessPolicy) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g := RouteGroupFor(r.URL.Path)
+		if g == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		level := g.Default
+		if !g.Fixed {
+			level = p.Levels(r.Context())[g.Key]
+		}
+		if level == AccessPublic || (!g.Fixed && SharedLink(r.Context()) != nil) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		p.requireLevel(level, next).ServeHTTP(w, r)
+	})
+}
+
+// Admit holds r to the level of group key, for handlers that can also
+// serve data from another group than their route's (ZIP exports with raw
+// recordings). It writes the refusal and returns false when r is kept out.
+func (p *AccessPolicy) Admit(w http.ResponseWriter, r *http.Request, key string) bool {
+	g := routeGroup(key)
+	if g == nil {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return false
+	}
+	level := g.Default
+	if !g.Fixed {
+		level = p.Levels(r.Context())[g.Key]
+	}
+	if level == AccessPublic {
+		return true
+	}
+	var ok bool
+	p.requireLevel(level, http.HandlerFunc(func(http.ResponseWriter, *http.Request) { ok = true })).ServeHTTP(w, r)
+	return ok
+}
+
+func (p *AccessPolicy) requireLevel(minLevel AccessLevel, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, level, err := RequireAuthQuick(p.Sessions, r, math.MaxInt)
+		if err != nil || !RefreshIdle(p.Sessions, w, r, 30*60) {
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				http.Error(w, "login required", http.StatusUnauthorized)
+				return
+			}
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+		if level > int(minLevel) {
+			http.Error(w, "Access denied", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
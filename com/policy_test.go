@@ -0,0 +1,146 @@
+package com
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouteGroupFor(t *testing.T) {
+	for _, tc := range []struct {
+		path string
+		want string // "" for no group
+	}{
+		{"/", ""},
+		{"/login", ""},
+		{"/gallery", "gallery"},
+		{"/gallery/", "gallery"},
+		{"/galleryx", ""},
+		{"/images/pass1/a.png", "gallery"},
+		{"/images", ""}, // only below /images/
+		{"/api/export", "raw"},
+		{"/api/export/geo", "gallery"},
+		{"/api/export/geo/x", "gallery"},
+		{"/api/exports", "zip"},
+		{"/api/exports/3/file", "zip"},
+		{"/api/exportsx", ""},
+		{"/api/zip", "zip"},
+		{"/api/update", "update"},
+		{"/api/stats", "station"},
+		{"/local/", "admin"},
+		{"/local/foo", "admin"},
+		{"/local/api", "admin"},
+		{"/local/api/info", "info"},
+		{"/local/api/settings", "editor"},
+		{"/local/api/settings/watermark", "editor"},
+		{"/local/api/settings/access", "admin"},
+		{"/local/api/users/lockouts", "admin"},
+		{"/local/api/pass-types/NOAA", "editor"},
+		{"/local/satdump/live", "station"},
+		{"/local", ""},
+	} {
+		got := ""
+		if g := RouteGroupFor(tc.path); g != nil {
+			got = g.Key
+		}
+		if got != tc.want {
+			t.Errorf("RouteGroupFor(%q) = %q, want %q", tc.path, got, tc.want)
+		}
+	}
+}
+
+func newTestPolicy(t *testing.T) *AccessPolicy {
+	t.Helper()
+	keys, err := LoadOrGenerateSessionKeys("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewAccessPolicy(openTestStore(t), NewCookieStore(keys, false, 3600))
+}
+
+// sessionCookie returns a cookie for a signed-in user of the given level.
+func sessionCookie(t *testing.T, p *AccessPolicy, level int) *http.Cookie {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	s, _ := p.Sessions.New(r, "session")
+	s.Values["authenticated"] = true
+	s.Values["level"] = level
+	if err := s.Save(r, w); err != nil {
+		t.Fatal(err)
+	}
+	return w.Result().Cookies()[0]
+}
+
+func TestAccessPolicyFixedGroups(t *testing.T) {
+	p := newTestPolicy(t)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for _, tc := range []struct {
+		path  string
+		level int // -1 for no session
+		want  int
+	}{
+		{"/local/api/info", -1, http.StatusOK},
+		{"/local/api/users", -1, http.StatusSeeOther},
+		{"/local/api/users", 1, http.StatusForbidden},
+		{"/local/api/users", 0, http.StatusOK},
+		{"/local/api/settings", 1, http.StatusOK},
+		{"/local/api/settings", 2, http.StatusForbidden},
+		{"/local/stats", 3, http.StatusOK},
+		{"/api/stats", -1, http.StatusUnauthorized},
+		{"/api/export", -1, http.StatusOK},
+	} {
+		r := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		if tc.level >= 0 {
+			r.AddCookie(sessionCookie(t, p, tc.level))
+		}
+		w := httptest.NewRecorder()
+		p.Middleware(ok).ServeHTTP(w, r)
+		if w.Code != tc.want {
+			t.Errorf("%s as level %d: status %d, want %d", tc.path, tc.level, w.Code, tc.want)
+		}
+	}
+
+	ctx := context.Background()
+	if _, err := p.Update(ctx, map[string]AccessLevel{"admin": 3}); !errors.Is(err, ErrBadPolicy) {
+		t.Errorf("raising the admin group: err = %v, want ErrBadPolicy", err)
+	}
+	// A stored policy can't open a fixed group either.
+	if err := p.Store.SetSetting(ctx, AccessPolicySettingKey, `{"admin":"public","raw":2,"gone":1}`); err != nil {
+		t.Fatal(err)
+	}
+	l := NewAccessPolicy(p.Store, p.Sessions).Levels(ctx)
+	if l["admin"] != 0 || l["raw"] != 2 || len(l) != len(RouteGroups) {
+		t.Errorf("levels loaded from a hand-edited policy: %v", l)
+	}
+}
+
+func TestAccessPolicyAdmit(t *testing.T) {
+	p := newTestPolicy(t)
+	if _, err := p.Update(context.Background(), map[string]AccessLevel{"raw": 2}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		group string
+		level int // -1 for no session
+		want  bool
+		code  int
+	}{
+		{"zip", -1, true, http.StatusOK},
+		{"raw", -1, false, http.StatusUnauthorized},
+		{"raw", 3, false, http.StatusForbidden},
+		{"raw", 2, true, http.StatusOK},
+		{"nope", 0, false, http.StatusForbidden},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/api/exports/zip?raw=1", nil)
+		if tc.level >= 0 {
+			r.AddCookie(sessionCookie(t, p, tc.level))
+		}
+		w := httptest.NewRecorder()
+		if got := p.Admit(w, r, tc.group); got != tc.want || w.Code != tc.code {
+			t.Errorf("%s as level %d: admitted %v with %d, want %v with %d", tc.group, tc.level, got, w.Code, tc.want, tc.code)
+		}
+	}
+}
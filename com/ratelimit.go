@@ -0,0 +1,406 @@
+package com
+
+import (
+	"OnlySats/com/logging"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var rlLog = logging.For("ratelimit")
+
+// ---------- Client address ----------
+
+type clientIPKey struct{}
+
+// ParseTrustedProxies reads IPs and CIDRs from [server] trusted_proxies.
+func ParseTrustedProxies(list []string) ([]*net.IPNet, error) {
+	var out []*net.IPNet
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("trusted proxy %q is not an IP or CIDR", s)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			out = append(out, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: %w", s, err)
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+func trusted(proxies []*net.IPNet, addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range proxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveClientIP works out each request's client address for ClientIP.
+// X-Forwarded-For is only believed when the connection comes from one of
+// proxies (the FRP tunnel, a local reverse proxy); the client is then the
+// rightmost address that isn't itself a trusted proxy.
+func ResolveClientIP(proxies []*net.IPNet) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := remoteHost(r.RemoteAddr)
+			if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 && trusted(proxies, ip) {
+				hops := strings.Split(strings.Join(xff, ","), ",")
+				for i := len(hops) - 1; i >= 0; i-- {
+					hop := strings.TrimSpace(hops[i])
+					if net.ParseIP(hop) == nil {
+						break // garbage from the client side; keep the last good hop
+					}
+					ip = hop
+					if !trusted(proxies, hop) {
+						break
+					}
+				}
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip)))
+		})
+	}
+}
+
+func remoteHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// ClientIP is the address a request came from, as worked out by
+// ResolveClientIP, or the connection's address outside it.
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	return remoteHost(r.RemoteAddr)
+}
+
+// ---------- Settings ----------
+
+// RateLimitSettingKey is the app_settings key the limits are kept under.
+const RateLimitSettingKey = "rate_limits"
+
+// ErrBadRateLimit wraps validation errors for RateLimitSettings.
+var ErrBadRateLimit = errors.New("invalid rate limits")
+
+// Rate limit classes. Routes outside them (pages, css, js) aren't limited.
+const (
+	RateAPI       = "api"
+	RateImages    = "images"
+	RateDownloads = "downloads"
+	RateLogin     = "login"
+)
+
+var rateClasses = []string{RateAPI, RateImages, RateDownloads, RateLogin}
+
+// RateClass is one token bucket per client: PerMinute requests on average,
+// up to Burst at once. PerMinute 0 turns the class off.
+type RateClass struct {
+	PerMinute int `json:"perMinute"`
+	Burst     int `json:"burst"`
+}
+
+// RateLimitSettings are edited at /local/api/settings/ratelimit.
+type RateLimitSettings struct {
+	Enabled     bool                 `json:"enabled"`
+	Classes     map[string]RateClass `json:"classes"`
+	ExemptLevel int                  `json:"exemptLevel"` // signed-in users at this level or better aren't limited (login always is); -1 exempts nobody
+}
+
+// DefaultRateLimitSettings are loose enough for a gallery page loading a
+// screenful of thumbnails.
+func DefaultRateLimitSettings() RateLimitSettings {
+	return RateLimitSettings{
+		Enabled: true,
+		Classes: map[string]RateClass{
+			RateAPI:       {PerMinute: 300, Burst: 60},
+			RateImages:    {PerMinute: 1200, Burst: 300},
+			RateDownloads: {PerMinute: 30, Burst: 10},
+			RateLogin:     {PerMinute: 10, Burst: 5},
+		},
+		ExemptLevel: 1,
+	}
+}
+
+// Validate fills in missing classes from the defaults and reports the
+// first problem.
+func (s *RateLimitSettings) Validate() error {
+	def := DefaultRateLimitSettings()
+	if s.Classes == nil {
+		s.Classes = map[string]RateClass{}
+	}
+	for name, c := range s.Classes {
+		if _, ok := def.Classes[name]; !ok {
+			return fmt.Errorf("%w: unknown class %q; classes are %s", ErrBadRateLimit, name, strings.Join(rateClasses, ", "))
+		}
+		switch {
+		case c.PerMinute < 0 || c.PerMinute > 100000:
+			return fmt.Errorf("%w: %s perMinute must be 0 to 100000", ErrBadRateLimit, name)
+		case c.PerMinute > 0 && (c.Burst < 1 || c.Burst > 100000):
+			return fmt.Errorf("%w: %s burst must be 1 to 100000", ErrBadRateLimit, name)
+		}
+	}
+	for name, c := range def.Classes {
+		if _, ok := s.Classes[name]; !ok {
+			s.Classes[name] = c
+		}
+	}
+	if s.ExemptLevel < -1 || s.ExemptLevel > 3 {
+		return fmt.Errorf("%w: exemptLevel must be -1 to 3", ErrBadRateLimit)
+	}
+	return nil
+}
+
+// clone copies s so callers can decode updates into it without touching
+// the cached settings.
+func (s RateLimitSettings) clone() RateLimitSettings {
+	classes := make(map[string]RateClass, len(s.Classes))
+	for k, v := range s.Classes {
+		classes[k] = v
+	}
+	s.Classes = classes
+	return s
+}
+
+// RateClassFor sorts a request into a class, or "" for unlimited routes.
+func RateClassFor(r *http.Request) string {
+	p := r.URL.Path
+	switch {
+	case p == "/login" && r.Method == http.MethodPost:
+		return RateLogin
+	case p == "/api/export", p == "/api/zip", strings.HasPrefix(p, "/api/exports"),
+		strings.HasPrefix(p, "/api/animations/") && strings.HasSuffix(p, "/file"):
+		return RateDownloads
+	case strings.HasPrefix(p, "/images/"), strings.HasPrefix(p, "/thumbnails/"), strings.HasPrefix(p, "/tiles/"):
+		return RateImages
+	case strings.HasPrefix(p, "/api/"), strings.HasPrefix(p, "/local/api/"):
+		return RateAPI
+	}
+	return ""
+}
+
+// ---------- Limiter ----------
+
+type rateBucket struct {
+	tokens   float64
+	last     time.Time
+	rejected int
+	lastHit  time.Time // last rejection
+}
+
+// RateLimiter keeps a token bucket per client address and class.
+type RateLimiter struct {
+	Store *LocalDataStore
+	Level func(r *http.Request) (int, bool) // signed-in user's level, for ExemptLevel
+
+	mu        sync.Mutex
+	settings  savedSetting[RateLimitSettings]
+	buckets   map[string]*rateBucket // class + " " + ip
+	lastSweep time.Time
+}
+
+// NewRateLimiter returns a limiter reading its settings from store.
+func NewRateLimiter(store *LocalDataStore, level func(r *http.Request) (int, bool)) *RateLimiter {
+	return &RateLimiter{
+		Store: store,
+		Level: level,
+		settings: savedSetting[RateLimitSettings]{
+			key:      RateLimitSettingKey,
+			defaults: DefaultRateLimitSettings,
+			check:    (*RateLimitSettings).Validate,
+			log:      rlLog,
+		},
+		buckets: map[string]*rateBucket{},
+	}
+}
+
+// Settings returns a copy of the saved settings.
+func (l *RateLimiter) Settings(ctx context.Context) RateLimitSettings {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.settingsLocked(ctx)
+}
+
+func (l *RateLimiter) settingsLocked(ctx context.Context) RateLimitSettings {
+	return l.settings.get(ctx, l.Store).clone()
+}
+
+// SaveSettings validates and stores s.
+func (l *RateLimiter) SaveSettings(ctx context.Context, s RateLimitSettings) (RateLimitSettings, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s, err := l.settings.save(ctx, l.Store, s.clone())
+	return s.clone(), err
+}
+
+// rateDecision is the outcome of one take from a bucket.
+type rateDecision struct {
+	allowed    bool
+	limit      int
+	remaining  int
+	reset      int // seconds until the bucket is full again
+	retryAfter int // seconds until the next token, when refused
+	perMinute  int
+}
+
+func (l *RateLimiter) take(ctx context.Context, class, ip string, now time.Time) (rateDecision, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := l.settingsLocked(ctx)
+	c, ok := s.Classes[class]
+	if !s.Enabled || !ok || c.PerMinute <= 0 {
+		return rateDecision{}, false
+	}
+	l.sweepLocked(s, now)
+
+	rate := float64(c.PerMinute) / 60 // tokens per second
+	burst := float64(c.Burst)
+	key := class + " " + ip
+	b := l.buckets[key]
+	if b == nil {
+		b = &rateBucket{tokens: burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	d := rateDecision{limit: c.Burst, perMinute: c.PerMinute}
+	if b.tokens >= 1 {
+		b.tokens--
+		d.allowed = true
+	} else {
+		b.rejected++
+		b.lastHit = now
+		d.retryAfter = int(math.Ceil((1 - b.tokens) / rate))
+	}
+	d.remaining = int(b.tokens)
+	d.reset = int(math.Ceil((burst - b.tokens) / rate))
+	return d, true
+}
+
+// sweepLocked drops buckets that have refilled and haven't refused
+// anything for ten minutes, at most once a minute.
+func (l *RateLimiter) sweepLocked(s RateLimitSettings, now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		class, _, _ := strings.Cut(key, " ")
+		c := s.Classes[class]
+		if c.PerMinute <= 0 {
+			delete(l.buckets, key)
+			continue
+		}
+		if now.Sub(b.lastHit) < 10*time.Minute {
+			continue // still listed by Throttled
+		}
+		if b.tokens+now.Sub(b.last).Seconds()*float64(c.PerMinute)/60 >= float64(c.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// ThrottledClient is a client that has been refused in the last ten minutes.
+type ThrottledClient struct {
+	IP           string    `json:"ip"`
+	Class        string    `json:"class"`
+	Rejected     int       `json:"rejected"`
+	LastRejected time.Time `json:"lastRejected"`
+	Remaining    int       `json:"remaining"`
+}
+
+// Throttled lists clients refused in the last ten minutes, most recent first.
+func (l *RateLimiter) Throttled() []ThrottledClient {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	out := []ThrottledClient{}
+	for key, b := range l.buckets {
+		if b.rejected == 0 || now.Sub(b.lastHit) > 10*time.Minute {
+			continue
+		}
+		class, ip, _ := strings.Cut(key, " ")
+		out = append(out, ThrottledClient{IP: ip, Class: class, Rejected: b.rejected,
+			LastRejected: b.lastHit.UTC(), Remaining: int(b.tokens)})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].LastRejected.After(out[j].LastRejected) })
+	return out
+}
+
+// Middleware refuses requests over their class's limit with 429 and
+// Retry-After, and reports the bucket in RateLimit-* headers.
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		class := RateClassFor(r)
+		if class == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if class != RateLogin && l.Level != nil {
+			if level, ok := l.Level(r); ok {
+				if exempt := l.Settings(r.Context()).ExemptLevel; exempt >= 0 && level <= exempt {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+		}
+		ip := ClientIP(r)
+		d, limited := l.take(r.Context(), class, ip, time.Now())
+		if !limited {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		h.Set("RateLimit-Limit", strconv.Itoa(d.limit))
+		h.Set("RateLimit-Remaining", strconv.Itoa(d.remaining))
+		h.Set("RateLimit-Reset", strconv.Itoa(d.reset))
+		h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=60;burst=%d", d.perMinute, d.limit))
+		if !d.allowed {
+			rateLimited.Inc(class)
+			rlLog.Debug("rate limited", "ip", ip, "class", class, "path", r.URL.Path)
+			h.Set("Retry-After", strconv.Itoa(d.retryAfter))
+			h.Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"ok":         false,
+				"error":      "too many requests; slow down",
+				"retryAfter": d.retryAfter,
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,95 @@
+package com
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"127.0.0.1", " 10.0.0.0/8 ", "", "fd00::/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name   string
+		remote string
+		xff    []string
+		want   string
+	}{
+		{"direct, no header", "203.0.113.5:1234", nil, "203.0.113.5"},
+		{"direct client forging the header", "203.0.113.5:1234", []string{"198.51.100.1"}, "203.0.113.5"},
+		{"trusted proxy, one hop", "127.0.0.1:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"client prepends a fake hop", "127.0.0.1:1234", []string{"192.0.2.9, 198.51.100.1"}, "198.51.100.1"},
+		{"chain of trusted proxies", "127.0.0.1:1234", []string{"198.51.100.1, 10.1.2.3, 10.0.0.1"}, "198.51.100.1"},
+		{"hops split over headers", "127.0.0.1:1234", []string{"192.0.2.9", "198.51.100.1, 10.1.2.3"}, "198.51.100.1"},
+		{"only trusted hops", "127.0.0.1:1234", []string{"10.1.2.3, 10.0.0.1"}, "10.1.2.3"},
+		{"garbage on the left", "127.0.0.1:1234", []string{"not-an-ip, 198.51.100.1"}, "198.51.100.1"},
+		{"garbage behind a trusted hop", "127.0.0.1:1234", []string{"not-an-ip, 10.0.0.1"}, "10.0.0.1"},
+		{"garbage on the right", "127.0.0.1:1234", []string{"198.51.100.1, unknown"}, "127.0.0.1"},
+		{"ipv6 proxy", "[fd00::1]:1234", []string{"2001:db8::7"}, "2001:db8::7"},
+		{"remote without a port", "203.0.113.5", nil, "203.0.113.5"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tc.remote
+		for _, v := range tc.xff {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		var got string
+		ResolveClientIP(proxies)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = ClientIP(r)
+		})).ServeHTTP(httptest.NewRecorder(), r)
+		if got != tc.want {
+			t.Errorf("%s: ClientIP = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestParseTrustedProxiesRejectsGarbage(t *testing.T) {
+	for _, s := range []string{"localhost", "10.0.0.0/33", "1.2.3"} {
+		if _, err := ParseTrustedProxies([]string{s}); err == nil {
+			t.Errorf("ParseTrustedProxies(%q) accepted it", s)
+		}
+	}
+}
+
+func TestRateLimiterTake(t *testing.T) {
+	l := NewRateLimiter(nil, nil)
+	l.settings.v = &RateLimitSettings{Enabled: true, Classes: map[string]RateClass{
+		RateLogin: {PerMinute: 60, Burst: 2}, // one token a second
+		RateAPI:   {PerMinute: 0, Burst: 5},
+	}}
+
+	start := time.Unix(1_000_000, 0)
+	ctx := context.Background()
+	for _, st := range []struct {
+		name       string
+		ip         string
+		after      time.Duration // since start
+		allowed    bool
+		remaining  int
+		retryAfter int
+	}{
+		{"first of the burst", "a", 0, true, 1, 0},
+		{"second of the burst", "a", 0, true, 0, 0},
+		{"burst used up", "a", 0, false, 0, 1},
+		{"half a token later", "a", 500 * time.Millisecond, false, 0, 1},
+		{"one token later", "a", time.Second, true, 0, 0},
+		{"other client has its own bucket", "b", time.Second, true, 1, 0},
+		{"refill stops at the burst", "a", time.Hour, true, 1, 0},
+	} {
+		d, limited := l.take(ctx, RateLogin, st.ip, start.Add(st.after))
+		if !limited {
+			t.Fatalf("%s: login class not limited", st.name)
+		}
+		if d.allowed != st.allowed || d.remaining != st.remaining || d.retryAfter != st.retryAfter {
+			t.Errorf("%s: allowed %v remaining %d retryAfter %d, want %v %d %d",
+				st.name, d.allowed, d.remaining, d.retryAfter, st.allowed, st.remaining, st.retryAfter)
+		}
+	}
+	if _, limited := l.take(ctx, RateAPI, "a", start); limited {
+		t.Error("class with PerMinute 0 is limited")
+	}
+}
@@ -0,0 +1,245 @@
+package com
+
+import (
+	"OnlySats/com/imaging"
+	"OnlySats/config"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Rendition is one thumbnail size. The default rendition (thumbgen.thumbnail_width,
+// WebP, or JPEG when the image backend has no WebP encoder) keeps the original
+// layout under thumbnails/; named renditions live in a subdirectory per name.
+type Rendition struct {
+	Name    string `json:"name"`
+	Width   int    `json:"width"`
+	Format  string `json:"format"`
+	Quality int    `json:"quality"`
+	Default bool   `json:"default,omitempty"`
+	Backend string `json:"-"` // image backend that encodes it; part of the fingerprint
+}
+
+const DefaultRendition = "default"
+
+var renditionName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// Renditions resolves the configured thumbnail sizes, default first, then
+// the named ones by width. Invalid or duplicate entries are skipped with a warning.
+func Renditions(cfg *config.AppConfig) []Rendition {
+	width := cfg.Thumbgen.ThumbnailWidth
+	if width <= 0 {
+		width = 200
+	}
+	quality := cfg.Thumbgen.Quality
+	if quality <= 0 {
+		quality = 75
+	}
+	backend := ThumbBackend(cfg)
+	defFormat := "webp"
+	if !backend.Supports(defFormat) {
+		defFormat = "jpeg"
+	}
+	out := []Rendition{{Name: DefaultRendition, Width: width, Format: defFormat, Quality: quality, Default: true, Backend: backend.Name()}}
+
+	seen := map[string]bool{DefaultRendition: true}
+	var named []Rendition
+	for i, rc := range cfg.Thumbgen.Renditions {
+		r, err := resolveRendition(rc, quality)
+		if err != nil {
+			thumbLog.Warn("ignoring thumbnail rendition", "index", i, "name", rc.Name, "err", err)
+			continue
+		}
+		if !backend.Supports(r.Format) {
+			thumbLog.Warn("image backend cannot encode rendition format, using jpeg",
+				"name", r.Name, "format", r.Format, "backend", backend.Name())
+			r.Format = "jpeg"
+		}
+		if seen[r.Name] {
+			thumbLog.Warn("ignoring duplicate thumbnail rendition", "name", r.Name)
+			continue
+		}
+		seen[r.Name] = true
+		r.Backend = backend.Name()
+		named = append(named, r)
+	}
+	sort.SliceStable(named, func(i, j int) bool { return named[i].Width < named[j].Width })
+	return append(out, named...)
+}
+
+func resolveRendition(rc config.RenditionConfig, defQuality int) (Rendition, error) {
+	name := strings.TrimSpace(rc.Name)
+	if name == "" {
+		name = fmt.Sprint(rc.Width)
+	}
+	if !renditionName.MatchString(name) {
+		return Rendition{}, fmt.Errorf("name must be 1-32 letters, digits, '-' or '_'")
+	}
+	if rc.Width <= 0 {
+		return Rendition{}, fmt.Errorf("width must be positive")
+	}
+	format := strings.ToLower(strings.TrimSpace(rc.Format))
+	switch format {
+	case "", "webp":
+		format = "webp"
+	case "jpg", "jpeg":
+		format = "jpeg"
+	case "avif":
+	default:
+		return Rendition{}, fmt.Errorf("unsupported format %q", rc.Format)
+	}
+	q := rc.Quality
+	if q <= 0 || q > 100 {
+		q = defQuality
+	}
+	return Rendition{Name: name, Width: rc.Width, Format: format, Quality: q}, nil
+}
+
+// ThumbBackend returns the image backend selected by thumbgen.backend,
+// falling back to pure Go when libvips isn't compiled in.
+func ThumbBackend(cfg *config.AppConfig) imaging.Backend {
+	b, err := imaging.Get(cfg.Thumbgen.Backend)
+	if err != nil {
+		thumbLog.Warn("image backend unavailable", "requested", cfg.Thumbgen.Backend, "using", b.Name(), "err", err)
+	}
+	return b
+}
+
+// Fingerprint identifies the parameters a thumbnail was encoded with. A
+// different fingerprint means the file on disk is out of date.
+func (r Rendition) Fingerprint() string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d|%s|%d|%s", r.Width, r.Format, r.Quality, r.Backend)))
+	return hex.EncodeToString(sum[:4])
+}
+
+// ThumbParams is the fingerprint of a rendition set, plus the tile settings
+// when tiles is set, as stored in images.thumbParams: "name:fingerprint"
+// pairs joined by commas.
+func ThumbParams(rends []Rendition, tiles *Tiler) string {
+	parts := make([]string, 0, len(rends)+1)
+	for _, r := range rends {
+		parts = append(parts, r.Name+":"+r.Fingerprint())
+	}
+	if tiles != nil {
+		parts = append(parts, "tiles:"+tiles.Fingerprint())
+	}
+	return strings.Join(parts, ",")
+}
+
+// parseThumbParams splits a stored thumbParams value; nil when unknown.
+func parseThumbParams(s string) map[string]string {
+	if s == "" {
+		return nil
+	}
+	out := map[string]string{}
+	for _, part := range strings.Split(s, ",") {
+		if name, fp, ok := strings.Cut(part, ":"); ok {
+			out[name] = fp
+		}
+	}
+	return out
+}
+
+// FindRendition looks a rendition up by name.
+func FindRendition(rends []Rendition, name string) (Rendition, bool) {
+	for _, r := range rends {
+		if r.Name == name {
+			return r, true
+		}
+	}
+	return Rendition{}, false
+}
+
+func (r Rendition) Ext() string {
+	switch r.Format {
+	case "jpeg":
+		return ".jpg"
+	case "avif":
+		return ".avif"
+	case "png":
+		return ".png"
+	}
+	return ".webp"
+}
+
+func (r Rendition) ContentType() string {
+	switch r.Format {
+	case "jpeg":
+		return "image/jpeg"
+	case "avif":
+		return "image/avif"
+	case "png":
+		return "image/png"
+	}
+	return "image/webp"
+}
+
+func swapExt(rel, ext string) string {
+	return strings.TrimSuffix(rel, path.Ext(rel)) + ext
+}
+
+// ThumbLocation returns the root directory and the slash-separated path under
+// it where rendition r of the image at rel (relative to live_output) is
+// stored. thumbRoot empty means thumbnails sit beside the originals.
+//
+//	default, central:  <thumbRoot>/<rel>.webp
+//	default, beside:   <live>/<dir>/thumbnails/<name>.webp
+//	named, central:    <thumbRoot>/renditions/<r>/<rel>.<ext>
+//	named, beside:     <live>/<dir>/thumbnails/<r>/<name>.<ext>
+func ThumbLocation(liveDir, thumbRoot string, r Rendition, rel string) (root, sub string) {
+	rel = path.Clean(strings.ReplaceAll(rel, "\\", "/"))
+	file := swapExt(rel, r.Ext())
+	if strings.TrimSpace(thumbRoot) != "" {
+		if r.Default {
+			return thumbRoot, file
+		}
+		return thumbRoot, path.Join("renditions", r.Name, file)
+	}
+	dir, name := path.Split(file)
+	if r.Default {
+		return liveDir, path.Join(dir, "thumbnails", name)
+	}
+	return liveDir, path.Join(dir, "thumbnails", r.Name, name)
+}
+
+// ThumbPath is ThumbLocation joined into a filesystem path.
+func ThumbPath(liveDir, thumbRoot string, r Rendition, rel string) string {
+	root, sub := ThumbLocation(liveDir, thumbRoot, r, rel)
+	return filepath.Join(root, filepath.FromSlash(sub))
+}
+
+// URL is where ThumbnailServer serves rendition r of the image at rel.
+func (r Rendition) URL(rel string) string {
+	rel = strings.TrimPrefix(path.Clean(strings.ReplaceAll(rel, "\\", "/")), "/")
+	p := "/thumbnails/" + swapExt(rel, r.Ext())
+	if !r.Default {
+		p = "/thumbnails/" + r.Name + "/" + swapExt(rel, r.Ext())
+	}
+	return (&url.URL{Path: p}).EscapedPath()
+}
+
+// ThumbURLs maps rendition name to URL for one image, plus a srcset string
+// with width descriptors for <img srcset>.
+func ThumbURLs(rends []Rendition, rel string) (urls map[string]string, srcset string) {
+	urls = make(map[string]string, len(rends))
+	parts := make([]string, 0, len(rends))
+	byWidth := append([]Rendition(nil), rends...)
+	sort.SliceStable(byWidth, func(i, j int) bool { return byWidth[i].Width < byWidth[j].Width })
+	lastW := 0
+	for _, r := range byWidth {
+		u := r.URL(rel)
+		urls[r.Name] = u
+		if r.Width == lastW {
+			continue // srcset allows one candidate per width
+		}
+		lastW = r.Width
+		parts = append(parts, fmt.Sprintf("%s %dw", u, r.Width))
+	}
+	return urls, strings.Join(parts, ", ")
+}
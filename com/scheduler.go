@@ -1,6 +1,7 @@
 package com
 
 import (
+	"OnlySats/com/logging"
 	shared "OnlySats/com/shared"
 	"OnlySats/config"
 	"context"
@@ -17,6 +18,8 @@ import (
 	"time"
 )
 
+var schedLog = logging.For("scheduler")
+
 func buildSatdumpEndpoint(addr string, port int) string {
 	addr = strings.TrimSpace(addr)
 	if port <= 0 {
@@ -54,7 +57,7 @@ func httpGetJSON(ctx context.Context, url string) (any, error) {
 }
 
 func satdumpPoller(ctx context.Context, out chan<- satdumpLogEntry, instance, endpoint string, every time.Duration) {
-	log.Printf("[satdump] %s polling %s every %v\n", instance, endpoint, every)
+	schedLog.Info("polling satdump", "instance", instance, "endpoint", endpoint, "every", every)
 	baseEvery := every
 	slowEvery := every * 10
 	t := time.NewTicker(baseEvery)
@@ -76,30 +79,40 @@ func satdumpPoller(ctx context.Context, out chan<- satdumpLogEntry, instance, en
 					inError = true
 					downSince = time.Now()
 
-					log.Printf("[satdump] %s: %s went offline at %s, slowing polling from %v to %v\n",
-						instance,
-						endpoint,
-						downSince.Format(time.RFC3339),
-						baseEvery,
-						slowEvery,
+					schedLog.Warn("satdump went offline, slowing polling",
+						"instance", instance,
+						"endpoint", endpoint,
+						"err", err,
+						"every", slowEvery,
 					)
 					t.Stop()
 					t = time.NewTicker(slowEvery)
+					PublishEvent(EventSatdumpOffline, map[string]any{
+						"instance": instance,
+						"endpoint": endpoint,
+						"since":    downSince.Unix(),
+						"error":    err.Error(),
+					})
 				}
 				continue
 			}
 			if inError {
 				recoveredAt := time.Now()
-				log.Printf("[satdump] %s: %s back online; was down from %s to %s (duration %s)\n",
-					instance,
-					endpoint,
-					downSince.Format(time.RFC3339),
-					recoveredAt.Format(time.RFC3339),
-					recoveredAt.Sub(downSince),
+				schedLog.Info("satdump back online",
+					"instance", instance,
+					"endpoint", endpoint,
+					"down_since", downSince.Format(time.RFC3339),
+					"downtime", recoveredAt.Sub(downSince).Truncate(time.Second),
 				)
 				inError = false
 				t.Stop()
 				t = time.NewTicker(baseEvery)
+				PublishEvent(EventSatdumpOnline, map[string]any{
+					"instance": instance,
+					"endpoint": endpoint,
+					"since":    downSince.Unix(),
+					"downtime": recoveredAt.Sub(downSince).Truncate(time.Second).String(),
+				})
 			}
 		}
 	}
@@ -130,25 +143,25 @@ func startSatdumpLogger(ctx context.Context, db *sql.DB, flushEvery time.Duratio
 			}
 			tx, err := db.BeginTx(ctx, nil)
 			if err != nil {
-				log.Printf("[satdump logger] begin tx: %v", err)
+				schedLog.Error("satdump log: begin tx", "err", err)
 				buf = buf[:0]
 				return
 			}
 			stmt, err := tx.PrepareContext(ctx, `INSERT INTO satdump_readings (ts, instance, data) VALUES (?, ?, ?)`)
 			if err != nil {
-				log.Printf("[satdump logger] prepare: %v", err)
+				schedLog.Error("satdump log: prepare", "err", err)
 				_ = tx.Rollback()
 				buf = buf[:0]
 				return
 			}
 			for _, e := range buf {
 				if _, err := stmt.ExecContext(ctx, e.ts, e.instance, string(e.data)); err != nil {
-					log.Printf("[satdump logger] exec: %v", err)
+					schedLog.Error("satdump log: exec", "err", err)
 				}
 			}
 			_ = stmt.Close()
 			if err := tx.Commit(); err != nil {
-				log.Printf("[satdump logger] commit: %v", err)
+				schedLog.Error("satdump log: commit", "err", err)
 			}
 			buf = buf[:0]
 		}
@@ -188,7 +201,7 @@ func RunScheduledTasks(appCfg *config.AppConfig) {
 
 	rows, err := lds.ListSatdumpLoggingEnabled(ctx)
 	if err != nil {
-		log.Printf("[scheduler] failed to list satdump instances: %v", err)
+		schedLog.Error("listing satdump instances failed", "err", err)
 		return
 	}
 	for _, s := range rows {
@@ -116,6 +116,22 @@ CREATE TABLE IF NOT EXISTS satdump_readings (
 		return err
 	}
 
+	// hardware history; res is the bucket size in seconds (0 = raw sample)
+	if _, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS hardware_samples (
+	ts     BIGINT  NOT NULL,
+	res    INTEGER NOT NULL,
+	metric TEXT    NOT NULL,
+	avg    REAL    NOT NULL,
+	min    REAL    NOT NULL,
+	max    REAL    NOT NULL,
+	n      INTEGER NOT NULL DEFAULT 1
+);
+CREATE INDEX IF NOT EXISTS idx_hardware_samples_metric ON hardware_samples(metric, res, ts);
+CREATE INDEX IF NOT EXISTS idx_hardware_samples_res_ts ON hardware_samples(res, ts);`); err != nil {
+		return err
+	}
+
 	type colInfo struct {
 		name string
 	}
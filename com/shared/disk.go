@@ -0,0 +1,72 @@
+package shared
+
+import (
+	"io/fs"
+	"path/filepath"
+	"time"
+)
+
+// DirSize sums file sizes under root. With recentOnly set, only files
+// modified after cutoff are counted.
+func DirSize(root string, recentOnly bool, cutoff time.Time) uint64 {
+	var total uint64 = 0
+	filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // skip unreadable
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, ierr := d.Info()
+		if ierr != nil {
+			return nil
+		}
+		if recentOnly && info.ModTime().Before(cutoff) {
+			return nil
+		}
+		total += uint64(info.Size())
+		return nil
+	})
+	return total
+}
+
+// DiskRunway is the disk-usage picture for a data directory.
+type DiskRunway struct {
+	Total          uint64
+	Free           uint64
+	FullSize       uint64 // bytes under root
+	RecentSize     uint64 // bytes written under root in the last 14 days
+	RetentionDays  int
+	TimeToFullDays int
+}
+
+// EstimateDiskRunway walks root and projects how long the volume lasts at
+// the last 14 days' write rate. 9999 means "no recent growth".
+func EstimateDiskRunway(root string) (DiskRunway, error) {
+	var out DiskRunway
+	total, free, err := DiskTotalsForPath(root)
+	if err != nil {
+		return out, err
+	}
+	out.Total, out.Free = total, free
+
+	cutoff := time.Now().Add(-14 * 24 * time.Hour)
+	out.FullSize = DirSize(root, false, time.Time{})
+	out.RecentSize = DirSize(root, true, cutoff)
+
+	allocSize := out.FullSize + free
+
+	out.RetentionDays = 9999
+	out.TimeToFullDays = 9999
+	if out.RecentSize > 0 {
+		out.RetentionDays = int((float64(allocSize) / float64(out.RecentSize)) * 14.0)
+		out.TimeToFullDays = int((float64(free) / float64(out.RecentSize)) * 14.0)
+		if out.RetentionDays < 0 {
+			out.RetentionDays = 0
+		}
+		if out.TimeToFullDays < 0 {
+			out.TimeToFullDays = 0
+		}
+	}
+	return out, nil
+}
@@ -1,13 +1,14 @@
 //go:build linux || darwin || freebsd || openbsd || netbsd
 // +build linux darwin freebsd openbsd netbsd
 
-package handlers
+package shared
 
 import (
 	"golang.org/x/sys/unix"
 )
 
-func diskTotalsForPath(path string) (total, free uint64, err error) {
+// DiskTotalsForPath reports the size and free space of the volume holding path.
+func DiskTotalsForPath(path string) (total, free uint64, err error) {
 	var st unix.Statfs_t
 	if err := unix.Statfs(path, &st); err != nil {
 		return 0, 0, err
@@ -1,7 +1,7 @@
 //go:build windows
 // +build windows
 
-package handlers
+package shared
 
 import (
 	"path/filepath"
@@ -10,7 +10,7 @@ import (
 	"unsafe"
 )
 
-func diskTotalsForPath(path string) (total, free uint64, err error) {
+func DiskTotalsForPath(path string) (total, free uint64, err error) {
 	// Extract volume root like "C:\"
 	vol := windowsVolumeRoot(path)
 	return getDiskFreeSpaceEx(vol)
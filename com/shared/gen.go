@@ -1,14 +1,20 @@
 package shared
 
 import (
-	"math/rand"
+	"crypto/rand"
+	"math/big"
 )
 
+// GenerateRandomPassword returns n letters and digits from crypto/rand.
 func GenerateRandomPassword(n int) string {
 	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 	b := make([]byte, n)
 	for i := range b {
-		b[i] = letters[rand.Intn(len(letters))]
+		k, err := rand.Int(rand.Reader, big.NewInt(int64(len(letters))))
+		if err != nil {
+			panic(err)
+		}
+		b[i] = letters[k.Int64()]
 	}
 	return string(b)
 }
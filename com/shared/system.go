@@ -1,23 +1,14 @@
 package shared
 
 import (
-	"bufio"
-	"log"
+	"OnlySats/com/logging"
 	"net"
-	"os"
 	"sort"
 	"strings"
 )
 
 func GetHostIPv4() string {
-	logFile := "logs/system.log"
-	lf, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
-	if err != nil {
-		return "127.0.0.1"
-	}
-	defer lf.Close()
-	bufWriter := bufio.NewWriterSize(lf, 1<<20) // 1MB buffer to reduce disk I/O
-	logger := log.New(bufWriter, "", log.LstdFlags)
+	logger := logging.For("system")
 	ifaces, _ := net.Interfaces()
 	candidates := []struct {
 		name     string
@@ -25,12 +16,12 @@ func GetHostIPv4() string {
 		priority int
 	}{}
 
-	logger.Println("Scanning network interfaces...")
+	logger.Debug("scanning network interfaces")
 
 	for _, iface := range ifaces {
 		// Skip interfaces that are down or not running
 		if iface.Flags&net.FlagUp == 0 {
-			logger.Printf("Skipping %s: interface down", iface.Name)
+			logger.Debug("skipping interface, down", "iface", iface.Name)
 			continue
 		}
 
@@ -46,11 +37,11 @@ func GetHostIPv4() string {
 			}
 
 			ipStr := ipv4.String()
-			logger.Printf("Found candidate: iface=%s addr=%s", iface.Name, ipStr)
+			logger.Debug("found candidate", "iface", iface.Name, "addr", ipStr)
 
 			// Skip APIPA range (169.254.x.x)
 			if strings.HasPrefix(ipStr, "169.254.") {
-				logger.Printf("Skipping APIPA address: %s", ipStr)
+				logger.Debug("skipping APIPA address", "addr", ipStr)
 				continue
 			}
 
@@ -63,7 +54,7 @@ func GetHostIPv4() string {
 				priority = 2
 			}
 
-			logger.Printf("Keeping candidate: %s (priority=%d)", ipStr, priority)
+			logger.Debug("keeping candidate", "addr", ipStr, "priority", priority)
 			candidates = append(candidates, struct {
 				name     string
 				addr     string
@@ -73,7 +64,7 @@ func GetHostIPv4() string {
 	}
 
 	if len(candidates) == 0 {
-		logger.Println("No valid candidates found, falling back to 127.0.0.1")
+		logger.Info("no usable IPv4 address found, falling back to 127.0.0.1")
 		return "127.0.0.1"
 	}
 
@@ -82,7 +73,6 @@ func GetHostIPv4() string {
 	})
 
 	chosen := candidates[0]
-	logger.Printf("Chosen IP: %s (iface=%s priority=%d)", chosen.addr, chosen.name, chosen.priority)
-	bufWriter.Flush()
+	logger.Debug("chosen IP", "addr", chosen.addr, "iface", chosen.name, "priority", chosen.priority)
 	return chosen.addr
 }
@@ -0,0 +1,458 @@
+package com
+
+import (
+	"OnlySats/com/logging"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var shareLog = logging.For("sharelinks")
+
+// Share link kinds: a raw file from /api/export, a folder ZIP from /api/zip
+// (a whole pass is shared as its folder), or an image under /images/.
+const (
+	ShareExport = "export"
+	ShareZip    = "zip"
+	ShareImage  = "image"
+)
+
+const (
+	// DefaultShareTTL is how long a link lasts when the admin gives no expiry.
+	DefaultShareTTL = 72 * time.Hour
+	// MaxShareTTL bounds link lifetimes.
+	MaxShareTTL = 90 * 24 * time.Hour
+	// shareResumeWindow is how long the client a raw download was counted
+	// for may resume it with Range requests without using another download.
+	shareResumeWindow = 24 * time.Hour
+)
+
+var (
+	// ErrBadShareLink wraps validation errors when creating a link.
+	ErrBadShareLink = errors.New("invalid share link")
+	// ErrShareDenied means a signed URL is forged, altered or for another resource.
+	ErrShareDenied = errors.New("share link is not valid for this resource")
+	// ErrShareGone means a genuine link has expired, been revoked or used up.
+	ErrShareGone = errors.New("share link is no longer valid")
+)
+
+// ShareLink grants one resource to whoever holds its signed URL.
+type ShareLink struct {
+	ID           int64      `json:"id"`
+	Token        string     `json:"token"` // revocation ID, part of the URL
+	Kind         string     `json:"kind"`
+	Path         string     `json:"path"` // relative to the live output dir
+	Note         string     `json:"note,omitempty"`
+	CreatedBy    string     `json:"createdBy,omitempty"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	ExpiresAt    time.Time  `json:"expiresAt"`
+	MaxDownloads int        `json:"maxDownloads"` // 0 = unlimited
+	Downloads    int        `json:"downloads"`
+	RevokedAt    *time.Time `json:"revokedAt,omitempty"`
+	LastUsedAt   *time.Time `json:"lastUsedAt,omitempty"`
+	Active       bool       `json:"active"`
+	URL          string     `json:"url,omitempty"` // only while active
+}
+
+// ---------- Store ----------
+
+const shareCols = `id, token, kind, path, note, created_by, created_ts, expires_ts, max_downloads, downloads,
+	revoked_ts, last_used_ts`
+
+func scanShareLink(row rowScanner) (*ShareLink, error) {
+	var (
+		l                ShareLink
+		created, expires int64
+		revoked, used    sql.NullInt64
+	)
+	if err := row.Scan(&l.ID, &l.Token, &l.Kind, &l.Path, &l.Note, &l.CreatedBy, &created, &expires,
+		&l.MaxDownloads, &l.Downloads, &revoked, &used); err != nil {
+		return nil, err
+	}
+	l.CreatedAt = time.Unix(created, 0).UTC()
+	l.ExpiresAt = time.Unix(expires, 0).UTC()
+	if revoked.Valid {
+		t := time.Unix(revoked.Int64, 0).UTC()
+		l.RevokedAt = &t
+	}
+	if used.Valid {
+		t := time.Unix(used.Int64, 0).UTC()
+		l.LastUsedAt = &t
+	}
+	l.Active = l.RevokedAt == nil && time.Now().Before(l.ExpiresAt) &&
+		(l.MaxDownloads == 0 || l.Downloads < l.MaxDownloads)
+	return &l, nil
+}
+
+func (s *LocalDataStore) insertShareLink(ctx context.Context, l *ShareLink) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO share_links (token, kind, path, note, created_by, created_ts, expires_ts, max_downloads)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		l.Token, l.Kind, l.Path, l.Note, l.CreatedBy, l.CreatedAt.Unix(), l.ExpiresAt.Unix(), l.MaxDownloads)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (s *LocalDataStore) shareLinkByToken(ctx context.Context, token string) (*ShareLink, error) {
+	return scanShareLink(s.db.QueryRowContext(ctx, `SELECT `+shareCols+` FROM share_links WHERE token=?`, token))
+}
+
+func (s *LocalDataStore) shareLinkByID(ctx context.Context, id int64) (*ShareLink, error) {
+	return scanShareLink(s.db.QueryRowContext(ctx, `SELECT `+shareCols+` FROM share_links WHERE id=?`, id))
+}
+
+// useShareLink counts one download, unless the link has run out meanwhile.
+func (s *LocalDataStore) useShareLink(ctx context.Context, id int64, now time.Time) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE share_links SET downloads = downloads + 1, last_used_ts = ?
+		WHERE id = ? AND revoked_ts IS NULL AND expires_ts > ? AND (max_downloads = 0 OR downloads < max_downloads)`,
+		now.Unix(), id, now.Unix())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// ---------- Links ----------
+
+// ShareLinks signs, checks and counts share links. The signing key is
+// derived from the session key, so rotating that invalidates every link.
+type ShareLinks struct {
+	Store   *LocalDataStore
+	DB      *sql.DB // images database, for pass names
+	LiveDir string
+	key     []byte
+
+	mu      sync.Mutex
+	resumes map[shareResume]time.Time // counted raw downloads, until when they may resume
+}
+
+type shareResume struct {
+	id int64
+	ip string
+}
+
+// NewShareLinks derives the signing key from secret (the session auth key).
+func NewShareLinks(store *LocalDataStore, imageDB *sql.DB, liveDir string, secret []byte) *ShareLinks {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte("onlysats share links v1"))
+	return &ShareLinks{Store: store, DB: imageDB, LiveDir: liveDir, key: m.Sum(nil)}
+}
+
+func (s *ShareLinks) sign(token, kind, rel string, exp int64) string {
+	m := hmac.New(sha256.New, s.key)
+	fmt.Fprintf(m, "%s\n%s\n%s\n%d", token, kind, rel, exp)
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
+
+// URL returns the signed, site-relative URL of l.
+func (s *ShareLinks) URL(l *ShareLink) string {
+	q := url.Values{}
+	var p string
+	switch l.Kind {
+	case ShareExport:
+		p = "/api/export"
+		q.Set("path", l.Path)
+	case ShareZip:
+		p = "/api/zip"
+		q.Set("path", l.Path)
+	case ShareImage:
+		p = (&url.URL{Path: "/images/" + l.Path}).EscapedPath()
+	}
+	q.Set("share", l.Token)
+	q.Set("exp", strconv.FormatInt(l.ExpiresAt.Unix(), 10))
+	q.Set("sig", s.sign(l.Token, l.Kind, l.Path, l.ExpiresAt.Unix()))
+	return p + "?" + q.Encode()
+}
+
+func (s *ShareLinks) withURL(l *ShareLink) *ShareLink {
+	if l.Active {
+		l.URL = s.URL(l)
+	}
+	return l
+}
+
+// cleanSharePath normalises a path relative to the live output dir and
+// rejects anything that climbs out of it.
+func cleanSharePath(p string) (string, error) {
+	p = strings.TrimSpace(strings.ReplaceAll(p, `\`, "/"))
+	if p == "" {
+		return "", fmt.Errorf("%w: path is required", ErrBadShareLink)
+	}
+	for _, part := range strings.Split(p, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("%w: path may not contain ..", ErrBadShareLink)
+		}
+	}
+	p = strings.TrimPrefix(path.Clean("/"+p), "/")
+	if p == "" {
+		return "", fmt.Errorf("%w: the whole live output folder can't be shared", ErrBadShareLink)
+	}
+	return p, nil
+}
+
+// Create stores a new link for l.Kind and l.Path (for zip links, passID
+// picks a pass folder instead). ttl of zero uses DefaultShareTTL.
+func (s *ShareLinks) Create(ctx context.Context, l ShareLink, passID int64, ttl time.Duration) (*ShareLink, error) {
+	l.Kind = strings.ToLower(strings.TrimSpace(l.Kind))
+	l.Note = strings.TrimSpace(l.Note)
+	switch {
+	case ttl == 0:
+		ttl = DefaultShareTTL
+	case ttl < time.Minute || ttl > MaxShareTTL:
+		return nil, fmt.Errorf("%w: expiry must be between 1 minute and %d days", ErrBadShareLink, int(MaxShareTTL.Hours()/24))
+	}
+	if l.MaxDownloads < 0 {
+		return nil, fmt.Errorf("%w: maxDownloads can't be negative", ErrBadShareLink)
+	}
+	if len(l.Note) > 200 {
+		return nil, fmt.Errorf("%w: note is limited to 200 characters", ErrBadShareLink)
+	}
+
+	if l.Kind == ShareZip && passID > 0 {
+		var name sql.NullString
+		err := s.DB.QueryRowContext(ctx, `SELECT name FROM passes WHERE id=?`, passID).Scan(&name)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: no pass %d", ErrBadShareLink, passID)
+		}
+		if err != nil {
+			return nil, err
+		}
+		l.Path = name.String
+	}
+	rel, err := cleanSharePath(l.Path)
+	if err != nil {
+		return nil, err
+	}
+	l.Path = rel
+	info, err := os.Stat(filepath.Join(s.LiveDir, filepath.FromSlash(rel)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s not found in the live output folder", ErrBadShareLink, rel)
+	}
+	switch l.Kind {
+	case ShareExport, ShareImage:
+		if info.IsDir() {
+			return nil, fmt.Errorf("%w: %s is a folder; share it as a zip", ErrBadShareLink, rel)
+		}
+	case ShareZip:
+		if !info.IsDir() {
+			return nil, fmt.Errorf("%w: %s is not a folder", ErrBadShareLink, rel)
+		}
+	default:
+		return nil, fmt.Errorf("%w: kind must be %s, %s or %s", ErrBadShareLink, ShareExport, ShareZip, ShareImage)
+	}
+
+	l.Token = hex.EncodeToString(randBytes(16))
+	l.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	l.ExpiresAt = l.CreatedAt.Add(ttl)
+	if l.ID, err = s.Store.insertShareLink(ctx, &l); err != nil {
+		return nil, err
+	}
+	l.Active = true
+	shareLog.Info("share link created", "id", l.ID, "kind", l.Kind, "path", l.Path, "by", l.CreatedBy,
+		"expires", l.ExpiresAt)
+	return s.withURL(&l), nil
+}
+
+// List returns links newest first; revoked and expired ones are kept for
+// the record but carry no URL.
+func (s *ShareLinks) List(ctx context.Context, limit int) ([]*ShareLink, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	rows, err := s.Store.db.QueryContext(ctx, `SELECT `+shareCols+` FROM share_links ORDER BY id DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []*ShareLink{}
+	for rows.Next() {
+		l, err := scanShareLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s.withURL(l))
+	}
+	return out, rows.Err()
+}
+
+// Revoke disables the link with id at once. Revoking twice is not an error.
+func (s *ShareLinks) Revoke(ctx context.Context, id int64) (*ShareLink, error) {
+	res, err := s.Store.db.ExecContext(ctx,
+		`UPDATE share_links SET revoked_ts = ? WHERE id = ? AND revoked_ts IS NULL`, time.Now().Unix(), id)
+	if err != nil {
+		return nil, err
+	}
+	l, err := s.Store.shareLinkByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		shareLog.Info("share link revoked", "id", id, "kind", l.Kind, "path", l.Path)
+	}
+	return l, nil
+}
+
+// Check validates the signed parameters on r against the resource the
+// route serves (kind and rel) and counts a download, refusing links that
+// are used up. Only a raw file download the same client was counted for
+// in the last shareResumeWindow may be resumed with a Range request past
+// the first byte without counting again; folders and images ignore Range
+// and every request counts.
+func (s *ShareLinks) Check(r *http.Request, kinds []string, rel string) (*ShareLink, error) {
+	q := r.URL.Query()
+	token, sig := q.Get("share"), q.Get("sig")
+	exp, err := strconv.ParseInt(q.Get("exp"), 10, 64)
+	if token == "" || sig == "" || err != nil {
+		return nil, ErrShareDenied
+	}
+	rel, err = cleanSharePath(rel)
+	if err != nil {
+		return nil, ErrShareDenied
+	}
+
+	var kind string
+	for _, k := range kinds {
+		if hmac.Equal([]byte(sig), []byte(s.sign(token, k, rel, exp))) {
+			kind = k
+			break
+		}
+	}
+	if kind == "" {
+		return nil, ErrShareDenied
+	}
+	now := time.Now()
+	if now.Unix() >= exp {
+		return nil, fmt.Errorf("%w: it expired %s", ErrShareGone, time.Unix(exp, 0).UTC().Format(time.RFC3339))
+	}
+
+	l, err := s.Store.shareLinkByToken(r.Context(), token)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrShareDenied
+	}
+	if err != nil {
+		return nil, err
+	}
+	if l.Kind != kind || l.Path != rel || l.ExpiresAt.Unix() != exp {
+		return nil, ErrShareDenied
+	}
+	if l.RevokedAt != nil {
+		return nil, fmt.Errorf("%w: it was revoked", ErrShareGone)
+	}
+	ip := ClientIP(r)
+	if kind == ShareExport && isResume(r) && s.resuming(l.ID, ip, now) {
+		return l, nil
+	}
+	ok, err := s.Store.useShareLink(r.Context(), l.ID, now)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: its %d downloads are used up", ErrShareGone, l.MaxDownloads)
+	}
+	if kind == ShareExport {
+		s.counted(l.ID, ip, now, l.ExpiresAt)
+	}
+	return l, nil
+}
+
+// isResume reports whether r asks for a range that doesn't start a file.
+func isResume(r *http.Request) bool {
+	rng := r.Header.Get("Range")
+	return rng != "" && !strings.HasPrefix(rng, "bytes=0-")
+}
+
+func (s *ShareLinks) resuming(id int64, ip string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	until, ok := s.resumes[shareResume{id, ip}]
+	return ok && now.Before(until)
+}
+
+// counted remembers a counted raw download so its client can resume it.
+func (s *ShareLinks) counted(id int64, ip string, now, expires time.Time) {
+	until := now.Add(shareResumeWindow)
+	if expires.Before(until) {
+		until = expires
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.resumes == nil {
+		s.resumes = map[shareResume]time.Time{}
+	}
+	for k, t := range s.resumes {
+		if !now.Before(t) {
+			delete(s.resumes, k)
+		}
+	}
+	s.resumes[shareResume{id, ip}] = until
+}
+
+type sharedKey struct{}
+
+// SharedLink returns the share link a request was let in by, if any.
+func SharedLink(ctx context.Context) *ShareLink {
+	l, _ := ctx.Value(sharedKey{}).(*ShareLink)
+	return l
+}
+
+// shareResource names the resource a shareable route serves and the link
+// kinds that may open it.
+func shareResource(r *http.Request) (kinds []string, rel string, ok bool) {
+	switch {
+	case r.URL.Path == "/api/export":
+		return []string{ShareExport}, r.URL.Query().Get("path"), true
+	case r.URL.Path == "/api/zip":
+		return []string{ShareZip}, r.URL.Query().Get("path"), true
+	case strings.HasPrefix(r.URL.Path, "/images/"):
+		return []string{ShareImage}, strings.TrimPrefix(r.URL.Path, "/images/"), true
+	}
+	return nil, "", false
+}
+
+// Middleware checks the signed parameters of requests that carry ?share=
+// on a route a link can open. A valid link is counted and recorded on the
+// request (see SharedLink) so the access policy lets it in; a forged or
+// mismatched one gets 403 and a spent one 410. Other requests pass as they
+// are.
+func (s *ShareLinks) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kinds, rel, ok := shareResource(r)
+		if !ok || r.URL.Query().Get("share") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		l, err := s.Check(r, kinds, rel)
+		switch {
+		case errors.Is(err, ErrShareGone):
+			http.Error(w, err.Error(), http.StatusGone)
+			return
+		case errors.Is(err, ErrShareDenied):
+			shareLog.Warn("rejected share link", "path", r.URL.Path, "token", r.URL.Query().Get("share"))
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		case err != nil:
+			shareLog.Error("share link check failed", "err", err)
+			http.Error(w, "share link check failed", http.StatusInternalServerError)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sharedKey{}, l)))
+	})
+}
@@ -0,0 +1,113 @@
+package com
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestShareLinks(t *testing.T) *ShareLinks {
+	t.Helper()
+	store := openTestStore(t)
+	live := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(live, "pass1"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(live, "pass1", "data.cadu"), []byte("0123456789"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return NewShareLinks(store, nil, live, []byte("test session key"))
+}
+
+// fetch sends a request for the link's URL from ip through the share
+// middleware and returns the status.
+func fetch(s *ShareLinks, l *ShareLink, ip, rng string) int {
+	r := httptest.NewRequest(http.MethodGet, l.URL, nil)
+	r.RemoteAddr = ip + ":40000"
+	if rng != "" {
+		r.Header.Set("Range", rng)
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if SharedLink(r.Context()) == nil {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	})
+	w := httptest.NewRecorder()
+	s.Middleware(next).ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestShareLinkDownloadLimit(t *testing.T) {
+	s := newTestShareLinks(t)
+	ctx := context.Background()
+	raw, err := s.Create(ctx, ShareLink{Kind: ShareExport, Path: "pass1/data.cadu", MaxDownloads: 1}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zip, err := s.Create(ctx, ShareLink{Kind: ShareZip, Path: "pass1", MaxDownloads: 1}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name string
+		link *ShareLink
+		ip   string
+		rng  string
+		want int
+	}{
+		{"resume before any download", raw, "10.0.0.1", "bytes=1-", http.StatusOK},
+		{"limit used by the resume", raw, "10.0.0.2", "", http.StatusGone},
+		{"counted client resumes", raw, "10.0.0.1", "bytes=5-", http.StatusOK},
+		{"other client resumes used-up link", raw, "10.0.0.3", "bytes=1-", http.StatusGone},
+		{"counted client starts again", raw, "10.0.0.1", "", http.StatusGone},
+		{"counted client restarts with range", raw, "10.0.0.1", "bytes=0-", http.StatusGone},
+		{"zip download", zip, "10.0.0.1", "", http.StatusOK},
+		{"zip ignores range, used up", zip, "10.0.0.1", "bytes=1-", http.StatusGone},
+	}
+	for _, st := range steps {
+		if got := fetch(s, st.link, st.ip, st.rng); got != st.want {
+			t.Errorf("%s: status %d, want %d", st.name, got, st.want)
+		}
+	}
+	l, err := s.Store.shareLinkByID(ctx, raw.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Downloads != 1 || l.Active {
+		t.Errorf("raw link: %d downloads, active %v; want 1 and inactive", l.Downloads, l.Active)
+	}
+}
+
+func TestShareLinkSignature(t *testing.T) {
+	s := newTestShareLinks(t)
+	l, err := s.Create(context.Background(), ShareLink{Kind: ShareExport, Path: "pass1/data.cadu"}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fetch(s, l, "10.0.0.1", ""); got != http.StatusOK {
+		t.Fatalf("genuine link: status %d", got)
+	}
+	for name, u := range map[string]string{
+		"other file":     strings.Replace(l.URL, "data.cadu", "other.cadu", 1),
+		"later expiry":   strings.Replace(l.URL, "exp=", "exp=9", 1),
+		"forged sig":     strings.Replace(l.URL, "sig=", "sig=x", 1),
+		"as zip of file": strings.Replace(l.URL, "/api/export", "/api/zip", 1),
+	} {
+		forged := *l
+		forged.URL = u
+		if got := fetch(s, &forged, "10.0.0.1", ""); got != http.StatusForbidden {
+			t.Errorf("%s: status %d, want 403", name, got)
+		}
+	}
+	if _, err := s.Revoke(context.Background(), l.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := fetch(s, l, "10.0.0.1", "bytes=1-"); got != http.StatusGone {
+		t.Errorf("revoked link resumed: status %d, want 410", got)
+	}
+}
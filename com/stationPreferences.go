@@ -4,8 +4,10 @@ import (
 	"OnlySats/config"
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"sort"
@@ -18,6 +20,12 @@ import (
 
 // ---------- Types ----------
 
+// rowScanner is a *sql.Row or *sql.Rows, for scan helpers that read one
+// record from either.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
 type Note struct {
 	ID        int64     `json:"id"`
 	Title     string    `json:"title"`
@@ -90,9 +98,12 @@ type Message struct {
 }
 
 type UserRow struct {
-	ID       int64  `json:"id"`
-	Username string `json:"username"`
-	Level    int    `json:"level"`
+	ID            int64  `json:"id"`
+	Username      string `json:"username"`
+	Level         int    `json:"level"`
+	Email         string `json:"email,omitempty"`
+	NotifyAlerts  bool   `json:"notifyAlerts"`
+	NotifySummary bool   `json:"notifySummary"`
 }
 
 // ---------- Open / Close / Migrate ----------
@@ -130,6 +141,16 @@ func OpenLocalData(cfg *config.AppConfig) (*LocalDataStore, error) {
 	if _, err := lds.db.Exec(`UPDATE satdump SET log = 0 WHERE log IS NULL`); err != nil {
 		return nil, fmt.Errorf("backfill satdump.log: %w", err)
 	}
+	// per-user email notification preferences
+	for _, c := range [][2]string{
+		{"email", "email TEXT"},
+		{"notify_alerts", "notify_alerts INTEGER NOT NULL DEFAULT 0"},
+		{"notify_summary", "notify_summary INTEGER NOT NULL DEFAULT 0"},
+	} {
+		if err := lds.migrateColumns("users", c[0], c[1]); err != nil {
+			return nil, err
+		}
+	}
 	return lds, nil
 }
 
@@ -304,6 +325,145 @@ func (s *LocalDataStore) migrateTables() error {
             type      TEXT,
             image     BLOB
         );`,
+
+		`CREATE TABLE IF NOT EXISTS webhooks (
+			id          INTEGER PRIMARY KEY AUTOINCREMENT,
+			name        TEXT NOT NULL,
+			url         TEXT NOT NULL,
+			kind        TEXT NOT NULL DEFAULT 'generic',
+			events      TEXT NOT NULL DEFAULT '*',
+			template    TEXT,
+			secret      TEXT,
+			enabled     INTEGER NOT NULL DEFAULT 1,
+			created_ts  INTEGER NOT NULL DEFAULT (strftime('%s','now'))
+		);`,
+
+		`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id          INTEGER PRIMARY KEY AUTOINCREMENT,
+			webhook_id  INTEGER NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+			ts          INTEGER NOT NULL,
+			event       TEXT NOT NULL,
+			attempt     INTEGER NOT NULL,
+			status      INTEGER,
+			ok          INTEGER NOT NULL DEFAULT 0,
+			error       TEXT,
+			duration_ms INTEGER
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_hook_ts ON webhook_deliveries(webhook_id, ts);`,
+
+		`CREATE TABLE IF NOT EXISTS email_queue (
+			id          INTEGER PRIMARY KEY AUTOINCREMENT,
+			to_addr     TEXT NOT NULL,
+			subject     TEXT NOT NULL,
+			body_text   TEXT NOT NULL,
+			body_html   TEXT,
+			kind        TEXT NOT NULL,
+			status      TEXT NOT NULL DEFAULT 'pending',
+			attempts    INTEGER NOT NULL DEFAULT 0,
+			next_ts     INTEGER NOT NULL,
+			last_error  TEXT,
+			created_ts  INTEGER NOT NULL DEFAULT (strftime('%s','now')),
+			sent_ts     INTEGER
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_email_queue_status_next ON email_queue(status, next_ts);`,
+
+		`CREATE TABLE IF NOT EXISTS alert_rules (
+			id           INTEGER PRIMARY KEY AUTOINCREMENT,
+			name         TEXT NOT NULL,
+			kind         TEXT NOT NULL,
+			target       TEXT,
+			threshold    REAL NOT NULL DEFAULT 0,
+			duration_sec INTEGER NOT NULL DEFAULT 0,
+			severity     TEXT NOT NULL DEFAULT 'warn',
+			enabled      INTEGER NOT NULL DEFAULT 1,
+			post_message INTEGER NOT NULL DEFAULT 0,
+			created_ts   INTEGER NOT NULL DEFAULT (strftime('%s','now'))
+		);`,
+
+		`CREATE TABLE IF NOT EXISTS alerts (
+			id          INTEGER PRIMARY KEY AUTOINCREMENT,
+			rule_id     INTEGER NOT NULL REFERENCES alert_rules(id) ON DELETE CASCADE,
+			key         TEXT NOT NULL,
+			severity    TEXT NOT NULL,
+			title       TEXT NOT NULL,
+			message     TEXT NOT NULL DEFAULT '',
+			value       REAL,
+			state       TEXT NOT NULL DEFAULT 'active',
+			acked       INTEGER NOT NULL DEFAULT 0,
+			first_ts    INTEGER NOT NULL,
+			last_ts     INTEGER NOT NULL,
+			resolved_ts INTEGER,
+			message_id  INTEGER
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_alerts_rule_state ON alerts(rule_id, state);`,
+		`CREATE INDEX IF NOT EXISTS idx_alerts_last_ts ON alerts(last_ts);`,
+
+		`CREATE TABLE IF NOT EXISTS animations (
+			id          INTEGER PRIMARY KEY AUTOINCREMENT,
+			key         TEXT NOT NULL,
+			satellite   TEXT NOT NULL,
+			composite   TEXT NOT NULL,
+			start_ts    INTEGER NOT NULL,
+			end_ts      INTEGER NOT NULL,
+			step_sec    INTEGER NOT NULL DEFAULT 0,
+			format      TEXT NOT NULL,
+			width       INTEGER NOT NULL,
+			delay_ms    INTEGER NOT NULL,
+			image_ids   TEXT NOT NULL DEFAULT '',
+			status      TEXT NOT NULL DEFAULT 'queued',
+			frames      INTEGER NOT NULL DEFAULT 0,
+			rendered    INTEGER NOT NULL DEFAULT 0,
+			size_bytes  INTEGER NOT NULL DEFAULT 0,
+			error       TEXT,
+			created_ts  INTEGER NOT NULL DEFAULT (strftime('%s','now')),
+			finished_ts INTEGER
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_animations_key ON animations(key);`,
+		`CREATE TABLE IF NOT EXISTS exports (
+			id          INTEGER PRIMARY KEY AUTOINCREMENT,
+			key         TEXT NOT NULL,
+			filters     TEXT NOT NULL DEFAULT '',
+			raw         INTEGER NOT NULL DEFAULT 0,
+			manifest    TEXT NOT NULL DEFAULT 'none',
+			files_json  TEXT NOT NULL DEFAULT '[]',
+			file_count  INTEGER NOT NULL DEFAULT 0,
+			total_bytes INTEGER NOT NULL DEFAULT 0,
+			status      TEXT NOT NULL DEFAULT 'queued',
+			written     INTEGER NOT NULL DEFAULT 0,
+			size_bytes  INTEGER NOT NULL DEFAULT 0,
+			error       TEXT,
+			client_ip   TEXT NOT NULL DEFAULT '',
+			created_ts  INTEGER NOT NULL DEFAULT (strftime('%s','now')),
+			finished_ts INTEGER
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_exports_key ON exports(key);`,
+		`CREATE INDEX IF NOT EXISTS idx_exports_status ON exports(status);`,
+		`CREATE TABLE IF NOT EXISTS share_links (
+			id            INTEGER PRIMARY KEY AUTOINCREMENT,
+			token         TEXT NOT NULL UNIQUE,
+			kind          TEXT NOT NULL,
+			path          TEXT NOT NULL,
+			note          TEXT NOT NULL DEFAULT '',
+			created_by    TEXT NOT NULL DEFAULT '',
+			created_ts    INTEGER NOT NULL,
+			expires_ts    INTEGER NOT NULL,
+			max_downloads INTEGER NOT NULL DEFAULT 0,
+			downloads     INTEGER NOT NULL DEFAULT 0,
+			revoked_ts    INTEGER,
+			last_used_ts  INTEGER
+		);`,
+		`CREATE TABLE IF NOT EXISTS login_lockouts (
+			id          INTEGER PRIMARY KEY AUTOINCREMENT,
+			kind        TEXT NOT NULL,
+			subject     TEXT NOT NULL,
+			ip          TEXT NOT NULL DEFAULT '',
+			failures    INTEGER NOT NULL DEFAULT 0,
+			created_ts  INTEGER NOT NULL,
+			until_ts    INTEGER NOT NULL,
+			unlocked_ts INTEGER,
+			unlocked_by TEXT
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_login_lockouts_subject ON login_lockouts(kind, subject);`,
 	)
 }
 
@@ -961,6 +1121,76 @@ func (s *LocalDataStore) ListSettings(ctx context.Context) (map[string]string, e
 	return out, rows.Err()
 }
 
+// reservedSettings are app_settings keys with their own endpoint (or kept
+// by the server, ""), which the generic settings API must not write.
+var reservedSettings = map[string]string{
+	AccessPolicySettingKey: "/local/api/settings/access",
+	RateLimitSettingKey:    "/local/api/settings/ratelimit",
+	DownloadSettingKey:     "/local/api/settings/downloads",
+	WatermarkSettingKey:    "/local/api/settings/watermark",
+	mailSummarySettingKey:  "",
+}
+
+// ReservedSetting reports whether key may only be written through its own
+// endpoint, and which one.
+func ReservedSetting(key string) (endpoint string, reserved bool) {
+	endpoint, reserved = reservedSettings[strings.TrimSpace(key)]
+	return endpoint, reserved
+}
+
+// savedSetting is a settings object kept as JSON under one app_settings
+// key. It is read on first use and cached; after that it only changes
+// through save, so the owner's endpoint is the one place it is written.
+// A stored value that doesn't decode or pass check gives way to the
+// defaults. The owner serialises calls with its own mutex.
+type savedSetting[T any] struct {
+	key      string
+	defaults func() T
+	check    func(*T) error // validates a value and fills in what's missing
+	log      *slog.Logger
+
+	v *T
+}
+
+func (c *savedSetting[T]) get(ctx context.Context, store *LocalDataStore) T {
+	if c.v != nil {
+		return *c.v
+	}
+	v := c.defaults()
+	raw, err := store.GetSetting(ctx, c.key)
+	if err != nil {
+		// not cached, so the next call tries again
+		c.log.Warn("reading saved settings failed, using defaults", "key", c.key, "err", err)
+		return v
+	}
+	if strings.TrimSpace(raw) != "" {
+		if err := json.Unmarshal([]byte(raw), &v); err != nil {
+			c.log.Warn("ignoring unreadable saved settings", "key", c.key, "err", err)
+			v = c.defaults()
+		} else if err := c.check(&v); err != nil {
+			c.log.Warn("ignoring invalid saved settings", "key", c.key, "err", err)
+			v = c.defaults()
+		}
+	}
+	c.v = &v
+	return v
+}
+
+func (c *savedSetting[T]) save(ctx context.Context, store *LocalDataStore, v T) (T, error) {
+	if err := c.check(&v); err != nil {
+		return v, err
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v, err
+	}
+	if err := store.SetSetting(ctx, c.key, string(b)); err != nil {
+		return v, err
+	}
+	c.v = &v
+	return v, nil
+}
+
 // ---------- Composites and Pass Templates ----------
 
 func (s *LocalDataStore) UpsertComposite(ctx context.Context, key, name string, enabled bool) error {
@@ -1349,8 +1579,8 @@ func (s *LocalDataStore) CreateUser(ctx context.Context, username string, level
 func (s *LocalDataStore) GetUserByUsername(ctx context.Context, username string) (*UserRow, error) {
 	var u UserRow
 	err := s.db.QueryRowContext(ctx, `
-		SELECT id, username, level FROM users WHERE username = ?
-	`, strings.TrimSpace(username)).Scan(&u.ID, &u.Username, &u.Level)
+		SELECT id, username, level, COALESCE(email, ''), notify_alerts, notify_summary FROM users WHERE username = ?
+	`, strings.TrimSpace(username)).Scan(&u.ID, &u.Username, &u.Level, &u.Email, &u.NotifyAlerts, &u.NotifySummary)
 	if err != nil {
 		return nil, err
 	}
@@ -1359,7 +1589,7 @@ func (s *LocalDataStore) GetUserByUsername(ctx context.Context, username string)
 
 func (s *LocalDataStore) ListUsers(ctx context.Context) ([]UserRow, error) {
 	rows, err := s.db.QueryContext(ctx, `
-		SELECT id, username, level FROM users ORDER BY username
+		SELECT id, username, level, COALESCE(email, ''), notify_alerts, notify_summary FROM users ORDER BY username
 	`)
 	if err != nil {
 		return nil, err
@@ -1369,7 +1599,7 @@ func (s *LocalDataStore) ListUsers(ctx context.Context) ([]UserRow, error) {
 	var out []UserRow
 	for rows.Next() {
 		var u UserRow
-		if err := rows.Scan(&u.ID, &u.Username, &u.Level); err != nil {
+		if err := rows.Scan(&u.ID, &u.Username, &u.Level, &u.Email, &u.NotifyAlerts, &u.NotifySummary); err != nil {
 			return nil, err
 		}
 		out = append(out, u)
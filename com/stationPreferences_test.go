@@ -0,0 +1,20 @@
+package com
+
+import (
+	"OnlySats/config"
+	"testing"
+)
+
+// openTestStore opens a fresh local data store in a temporary data dir,
+// closed when the test ends.
+func openTestStore(t *testing.T) *LocalDataStore {
+	t.Helper()
+	cfg, _ := config.DefaultConfig()
+	cfg.Paths.DataDir = t.TempDir()
+	store, err := OpenLocalData(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
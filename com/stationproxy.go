@@ -1,6 +1,7 @@
 package com
 
 import (
+	"OnlySats/com/logging"
 	"OnlySats/config"
 	"bytes"
 	"encoding/json"
@@ -38,10 +39,12 @@ type registerResponse struct {
 	Subdomain string `json:"subdomain"`
 }
 
+var proxyLog = logging.For("proxy")
+
 // RunStationProxy handles registration and FRPC startup
 func RunStationProxy(cfg *config.AppConfig) error {
 	if !cfg.StationProxy.Enabled {
-		fmt.Println("Station proxy disabled in config.")
+		proxyLog.Info("station proxy disabled in config")
 		return nil
 	}
 
@@ -102,7 +105,7 @@ func RunStationProxy(cfg *config.AppConfig) error {
 		return err
 	}
 
-	fmt.Printf("Starting FRPC from %s\n", binPath)
+	proxyLog.Info("starting frpc", "bin", binPath)
 	cmd := exec.Command(binPath, "-c", frpcConfig)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
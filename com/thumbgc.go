@@ -0,0 +1,415 @@
+package com
+
+import (
+	"OnlySats/com/imaging"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ThumbGCReport summarizes one cache maintenance run.
+type ThumbGCReport struct {
+	StartedAt      time.Time `json:"startedAt"`
+	Duration       string    `json:"duration"`
+	DryRun         bool      `json:"dryRun"`
+	Verified       bool      `json:"verified"`
+	Scanned        int       `json:"scanned"`
+	Orphans        int       `json:"orphans"`  // source file or DB row gone
+	Stale          int       `json:"stale"`    // unknown rendition or old format
+	Corrupt        int       `json:"corrupt"`  // zero-byte or undecodable
+	Requeued       int       `json:"requeued"` // rows reset to needsThumb = 1
+	Removed        int       `json:"removed"`
+	TilesRemoved   int       `json:"tilesRemoved"` // pyramids of deleted or untiled images
+	BytesReclaimed int64     `json:"bytesReclaimed"`
+	Errors         []string  `json:"errors,omitempty"`
+}
+
+func (r *ThumbGCReport) addErr(err error) {
+	if len(r.Errors) < 50 {
+		r.Errors = append(r.Errors, err.Error())
+	}
+}
+
+// ThumbGC walks the thumbnail locations, removes thumbnails whose image is
+// gone and queues zero-byte or corrupt ones for regeneration.
+type ThumbGC struct {
+	DB            *sql.DB
+	LiveOutputDir string
+	ThumbRoot     string
+	Renditions    []Rendition
+	Backend       imaging.Backend
+	Tiles         *Tiler        // nil when tile pyramids are disabled
+	Interval      time.Duration // background runs; 0 disables
+
+	mu      sync.Mutex
+	running bool
+	last    *ThumbGCReport
+}
+
+var ErrGCRunning = errors.New("thumbnail cleanup already running")
+
+func NewThumbGC(db *sql.DB, liveOutputDir, thumbRoot string, rends []Rendition, backend imaging.Backend, tiles *Tiler, interval time.Duration) *ThumbGC {
+	return &ThumbGC{
+		DB:            db,
+		LiveOutputDir: liveOutputDir,
+		ThumbRoot:     thumbRoot,
+		Renditions:    rends,
+		Backend:       backend,
+		Tiles:         tiles,
+		Interval:      interval,
+	}
+}
+
+// Last returns the most recent report, or nil before the first run.
+func (g *ThumbGC) Last() *ThumbGCReport {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.last
+}
+
+// Start runs a non-verifying pass every Interval.
+func (g *ThumbGC) Start(ctx context.Context) {
+	if g.Interval <= 0 {
+		return
+	}
+	go func() {
+		t := time.NewTicker(g.Interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				rep, err := g.Run(ctx, false, false)
+				if err != nil {
+					if !errors.Is(err, ErrGCRunning) && ctx.Err() == nil {
+						thumbLog.Error("thumbnail cleanup", "err", err)
+					}
+					continue
+				}
+				if rep.Removed > 0 || rep.Requeued > 0 || rep.TilesRemoved > 0 {
+					thumbLog.Info("thumbnail cleanup", "removed", rep.Removed, "requeued", rep.Requeued, "tiles", rep.TilesRemoved,
+						"reclaimed", humanBytes(uint64(rep.BytesReclaimed)))
+				}
+			}
+		}
+	}()
+}
+
+// thumbFile is a file found in a thumbnail location.
+type thumbFile struct {
+	path string
+	size int64
+	rend string // rendition name; "" for an unknown one
+	base string // source path without extension, slash-separated, relative to live_output
+	ext  string
+	mod  time.Time
+}
+
+// Run performs one pass. dryRun only reports; verify also decodes every
+// thumbnail to catch truncated files.
+func (g *ThumbGC) Run(ctx context.Context, dryRun, verify bool) (*ThumbGCReport, error) {
+	g.mu.Lock()
+	if g.running {
+		g.mu.Unlock()
+		return nil, ErrGCRunning
+	}
+	g.running = true
+	g.mu.Unlock()
+	defer func() {
+		g.mu.Lock()
+		g.running = false
+		g.mu.Unlock()
+	}()
+
+	rep := &ThumbGCReport{StartedAt: time.Now(), DryRun: dryRun, Verified: verify}
+
+	// source paths by extension-less key
+	type srcRow struct {
+		id   int64
+		path string
+	}
+	sources := map[string]srcRow{}
+	tiled := map[int64]bool{}
+	rows, err := g.DB.QueryContext(ctx, `SELECT id, path, COALESCE(tiles, 0) FROM images`)
+	if err != nil {
+		return nil, fmt.Errorf("list images: %w", err)
+	}
+	for rows.Next() {
+		var s srcRow
+		var hasTiles bool
+		if err := rows.Scan(&s.id, &s.path, &hasTiles); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("list images: %w", err)
+		}
+		s.path = strings.ReplaceAll(s.path, "\\", "/")
+		sources[strings.TrimSuffix(s.path, path.Ext(s.path))] = s
+		if hasTiles {
+			tiled[s.id] = true
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list images: %w", err)
+	}
+
+	requeue := map[int64]bool{}
+	remove := func(f thumbFile) {
+		if !dryRun {
+			if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+				rep.addErr(err)
+				return
+			}
+		}
+		rep.Removed++
+		rep.BytesReclaimed += f.size
+	}
+
+	err = g.walk(ctx, func(f thumbFile) {
+		if strings.HasPrefix(filepath.Base(f.path), ".thumb-") && time.Since(f.mod) < time.Hour {
+			return // being written by writeThumb
+		}
+		rep.Scanned++
+
+		r, known := FindRendition(g.Renditions, f.rend)
+		if !known || !strings.EqualFold(f.ext, r.Ext()) {
+			rep.Stale++
+			remove(f)
+			return
+		}
+		src, ok := sources[f.base]
+		if !ok {
+			rep.Orphans++
+			remove(f)
+			return
+		}
+		if _, err := os.Stat(filepath.Join(g.LiveOutputDir, filepath.FromSlash(src.path))); errors.Is(err, fs.ErrNotExist) {
+			rep.Orphans++
+			remove(f)
+			return
+		}
+
+		corrupt := f.size == 0
+		if !corrupt && verify {
+			if data, err := os.ReadFile(f.path); err != nil {
+				rep.addErr(err)
+			} else if _, _, err := g.Backend.Size(data); err != nil && g.canDecode(r.Format) {
+				corrupt = true
+			}
+		}
+		if corrupt {
+			rep.Corrupt++
+			remove(f)
+			requeue[src.id] = true
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(requeue) > 0 {
+		if !dryRun {
+			if err := g.requeue(ctx, requeue); err != nil {
+				rep.addErr(err)
+			}
+		}
+		rep.Requeued = len(requeue)
+	}
+	if g.Tiles != nil {
+		ids, err := g.Tiles.IDs()
+		if err != nil {
+			rep.addErr(err)
+		}
+		for _, id := range ids {
+			if tiled[id] {
+				continue
+			}
+			// just built; the row is marked once the pyramid is complete
+			if fi, err := os.Stat(g.Tiles.imageDir(id)); err == nil && time.Since(fi.ModTime()) < time.Hour {
+				continue
+			}
+			if dryRun {
+				rep.TilesRemoved++
+				continue
+			}
+			n, err := g.Tiles.Remove(id)
+			if err != nil {
+				rep.addErr(err)
+				continue
+			}
+			rep.TilesRemoved++
+			rep.BytesReclaimed += n
+		}
+	}
+
+	if !dryRun && rep.Removed > 0 && strings.TrimSpace(g.ThumbRoot) != "" {
+		pruneEmptyDirs(g.ThumbRoot)
+	}
+
+	rep.Duration = time.Since(rep.StartedAt).Truncate(time.Millisecond).String()
+	g.mu.Lock()
+	g.last = rep
+	g.mu.Unlock()
+	return rep, nil
+}
+
+// canDecode is false for formats the backend writes but can't read back
+// (AVIF under the pure-Go backend), so those aren't flagged as corrupt.
+func (g *ThumbGC) canDecode(format string) bool {
+	return g.Backend.Name() != imaging.BackendGo || format != "avif"
+}
+
+func (g *ThumbGC) requeue(ctx context.Context, ids map[int64]bool) error {
+	tx, err := g.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.PrepareContext(ctx, `UPDATE images SET needsThumb = 1 WHERE id = ?`)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	for id := range ids {
+		if _, err := stmt.ExecContext(ctx, id); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// walk visits every file in the thumbnail locations (see ThumbLocation).
+func (g *ThumbGC) walk(ctx context.Context, fn func(thumbFile)) error {
+	if strings.TrimSpace(g.ThumbRoot) != "" {
+		return g.walkCentral(ctx, fn)
+	}
+	return g.walkBeside(ctx, fn)
+}
+
+// <thumbRoot>/<rel>.<ext> and <thumbRoot>/renditions/<name>/<rel>.<ext>
+func (g *ThumbGC) walkCentral(ctx context.Context, fn func(thumbFile)) error {
+	root := g.ThumbRoot
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == root {
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			return nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if d.IsDir() || !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		rend := DefaultRendition
+		if rest, ok := strings.CutPrefix(rel, "renditions/"); ok {
+			name, sub, found := strings.Cut(rest, "/")
+			if !found {
+				return nil
+			}
+			rend, rel = name, sub
+			if _, known := FindRendition(g.Renditions, name); !known {
+				rend = ""
+			}
+		}
+		fn(newThumbFile(p, d, rend, rel))
+		return nil
+	})
+}
+
+// <live>/<dir>/thumbnails/<name>.<ext> and <live>/<dir>/thumbnails/<r>/<name>.<ext>
+func (g *ThumbGC) walkBeside(ctx context.Context, fn func(thumbFile)) error {
+	root := g.LiveOutputDir
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == root {
+				return err
+			}
+			return nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if !d.IsDir() || d.Name() != "thumbnails" {
+			return nil
+		}
+		srcDir, err := filepath.Rel(root, filepath.Dir(p))
+		if err != nil {
+			return filepath.SkipDir
+		}
+		srcDir = filepath.ToSlash(srcDir)
+
+		entries, err := os.ReadDir(p)
+		if err != nil {
+			return filepath.SkipDir
+		}
+		for _, e := range entries {
+			full := filepath.Join(p, e.Name())
+			if e.Type().IsRegular() {
+				fn(newThumbFile(full, e, DefaultRendition, path.Join(srcDir, e.Name())))
+				continue
+			}
+			if !e.IsDir() {
+				continue
+			}
+			rend := e.Name()
+			if _, known := FindRendition(g.Renditions, rend); !known {
+				rend = ""
+			}
+			sub, err := os.ReadDir(full)
+			if err != nil {
+				continue
+			}
+			for _, se := range sub {
+				if se.Type().IsRegular() {
+					fn(newThumbFile(filepath.Join(full, se.Name()), se, rend, path.Join(srcDir, se.Name())))
+				}
+			}
+		}
+		// thumbnails never contain source images
+		return filepath.SkipDir
+	})
+}
+
+func newThumbFile(p string, d fs.DirEntry, rend, rel string) thumbFile {
+	f := thumbFile{path: p, rend: rend, ext: path.Ext(rel)}
+	f.base = strings.TrimSuffix(path.Clean(rel), f.ext)
+	if info, err := d.Info(); err == nil {
+		f.size = info.Size()
+		f.mod = info.ModTime()
+	}
+	return f
+}
+
+// pruneEmptyDirs removes directories under root left empty by a cleanup.
+func pruneEmptyDirs(root string) {
+	var dirs []string
+	_ = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() && p != root {
+			dirs = append(dirs, p)
+		}
+		return nil
+	})
+	// deepest first; os.Remove fails harmlessly on non-empty directories
+	for i := len(dirs) - 1; i >= 0; i-- {
+		_ = os.Remove(dirs[i])
+	}
+}
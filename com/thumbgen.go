@@ -1,262 +1,110 @@
 package com
 
 import (
-	"OnlySats/config"
-	"bufio"
-	"database/sql"
+	"OnlySats/com/imaging"
+	"OnlySats/com/logging"
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
 	"strings"
-	"sync"
-	"sync/atomic"
-	"time"
-
-	"github.com/h2non/bimg"
 )
 
-var processedImages int64
-var skippedImages int64
-var failedImages int64
-
-func RunThumbGen(cfg *config.AppConfig, db *sql.DB) error {
-	// reset counters for each run
-	atomic.StoreInt64(&processedImages, 0)
-	atomic.StoreInt64(&skippedImages, 0)
-	atomic.StoreInt64(&failedImages, 0)
+var thumbLog = logging.For("thumbgen")
 
-	baseOutputDir := cfg.Paths.LiveOutputDir
-	thumbOutputDir := cfg.Paths.ThumbnailDir
+// processImage writes every missing rendition of relPath, and every one
+// whose fingerprint in have differs from the current settings. A nil have
+// trusts existing files. made reports whether at least one file was written.
+func processImage(backend imaging.Backend, relPath, baseOutputDir, thumbOutputDir string, rends []Rendition, have map[string]string) (bool, error) {
+	relPath = strings.ReplaceAll(relPath, "\\", "/")
+	relPath = filepath.Clean(relPath)
 
-	workers := cfg.Thumbgen.MaxWorkers
-	if workers <= 0 {
-		workers = 4
-	}
-	jobBuffer := cfg.Thumbgen.BatchSize
-	if jobBuffer <= 0 {
-		jobBuffer = 1000
-	}
-	width := cfg.Thumbgen.ThumbnailWidth
-	if width <= 0 {
-		width = 200
-	}
-	quality := cfg.Thumbgen.Quality
-	if quality <= 0 {
-		quality = 75
-	}
-	logLevel := strings.ToLower(strings.TrimSpace(cfg.Server.LogLevel))
+	src := filepath.Join(baseOutputDir, relPath)
 
-	// logging to file (buffered)
-	logFile := cfg.Paths.LogDir + "thumbgen.log"
-	if strings.TrimSpace(logFile) == "" {
-		if strings.TrimSpace(cfg.Paths.LogDir) != "" {
-			logFile = filepath.Join(cfg.Paths.LogDir, "thumbgen.log")
-		} else {
-			logFile = "thumbgen.log"
+	var todo []Rendition
+	dsts := map[string]string{}
+	for _, r := range rends {
+		dst := ThumbPath(baseOutputDir, thumbOutputDir, r, relPath)
+		// If an up-to-date thumbnail already exists, treat as success
+		if _, err := os.Stat(dst); err == nil {
+			if fp, known := have[r.Name]; !known || fp == r.Fingerprint() {
+				continue
+			}
 		}
+		todo = append(todo, r)
+		dsts[r.Name] = dst
 	}
-	if err := os.MkdirAll(filepath.Dir(logFile), 0o755); err != nil {
-		return fmt.Errorf("failed to create log dir: %w", err)
-	}
-	lf, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
-	if err != nil {
-		return fmt.Errorf("failed to open log file: %w", err)
-	}
-	defer lf.Close()
-	bufWriter := bufio.NewWriterSize(lf, 1<<20)
-	logger := log.New(bufWriter, "", log.LstdFlags)
-
-	start := time.Now()
-
-	// info only
-	var total int
-	if err := db.QueryRow("SELECT COUNT(*) FROM images WHERE needsThumb = 1").Scan(&total); err != nil {
-		return fmt.Errorf("failed to count images: %w", err)
+	if len(todo) == 0 {
+		return false, nil // not made, but OK
 	}
-	logger.Printf("Found %d images to process (workers=%d, width=%d, quality=%d, out=%s)",
-		total, workers, width, quality, thumbOutputDir)
 
-	// worker pool + successes collector
-	type imageJob struct {
-		id   int64
-		path string
+	// does source exist
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		return false, fmt.Errorf("source image does not exist: %s", src)
 	}
 
-	jobs := make(chan imageJob, jobBuffer)
-	successes := make(chan int64, jobBuffer) // IDs to mark needsThumb=0
-	var wg sync.WaitGroup
-
-	// Workers
-	for i := 0; i < workers; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for job := range jobs {
-				made, err := processImage(job.path, baseOutputDir, thumbOutputDir, width, quality)
-				if err != nil {
-					atomic.AddInt64(&failedImages, 1)
-					if logLevel == "detailed" {
-						logger.Printf("[FAIL] %s: %v", job.path, err)
-					}
-					continue
-				}
-				if made {
-					atomic.AddInt64(&processedImages, 1)
-					if logLevel == "detailed" {
-						logger.Printf("[OK] %s (created)", job.path)
-					}
-				} else {
-					atomic.AddInt64(&skippedImages, 1)
-					if logLevel == "detailed" {
-						logger.Printf("[SKIP] %s (exists)", job.path)
-					}
-				}
-				// success: mark as completed later in one batch
-				successes <- job.id
-			}
-		}()
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return false, fmt.Errorf("failed to read image %s: %w", src, err)
 	}
 
-	// Collector goroutine drains successes while workers run (prevents deadlock)
-	doneIDs := make([]int64, 0, jobBuffer)
-	var collectWg sync.WaitGroup
-	collectWg.Add(1)
-	go func() {
-		defer collectWg.Done()
-		for id := range successes {
-			doneIDs = append(doneIDs, id)
-		}
-	}()
-
-	// queue jobs from DB
-	rows, err := db.Query("SELECT id, path FROM images WHERE needsThumb = 1")
+	srcW, srcH, err := backend.Size(data)
 	if err != nil {
-		return fmt.Errorf("failed to query images: %w", err)
+		return false, fmt.Errorf("failed to get size for %s: %w", src, err)
 	}
-	sent := 0
-	for rows.Next() {
-		var id int64
-		var p string
-		if err := rows.Scan(&id, &p); err == nil {
-			jobs <- imageJob{id: id, path: p}
-			sent++
-			if logLevel != "detailed" && sent%5000 == 0 {
-				logger.Printf("Queued %d images...", sent)
-			}
-		}
+	if srcW <= 0 || srcH <= 0 {
+		return false, fmt.Errorf("invalid image size %dx%d for %s", srcW, srcH, src)
 	}
-	_ = rows.Close()
-	close(jobs)      // stop workers when queue drains
-	wg.Wait()        // wait for all workers to finish
-	close(successes) // signal collector to finish
-	collectWg.Wait()
 
-	// batch UPDATE needsThumb=0 for all successes
-	if len(doneIDs) > 0 {
-		tx, err := db.Begin()
-		if err != nil {
-			return fmt.Errorf("begin update txn: %w", err)
-		}
-		stmt, err := tx.Prepare("UPDATE images SET needsThumb = 0 WHERE id = ?")
-		if err != nil {
-			_ = tx.Rollback()
-			return fmt.Errorf("prepare update: %w", err)
+	for _, r := range todo {
+		dst := dsts[r.Name]
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return false, fmt.Errorf("failed to create thumb directory: %w", err)
 		}
-		for _, id := range doneIDs {
-			if _, err := stmt.Exec(id); err != nil {
-				_ = stmt.Close()
-				_ = tx.Rollback()
-				return fmt.Errorf("update needsThumb=0 id=%d: %w", id, err)
-			}
+
+		width := r.Width
+		if !r.Default && width > srcW {
+			width = srcW // named renditions are never upscaled
 		}
-		_ = stmt.Close()
-		if err := tx.Commit(); err != nil {
-			return fmt.Errorf("commit update: %w", err)
+		newH := int((float64(width) * float64(srcH)) / float64(srcW))
+		if newH <= 0 {
+			newH = 1
 		}
-		logger.Printf("Marked needsThumb=0 for %d images", len(doneIDs))
-	}
-
-	// flush file logs before printing summary
-	_ = bufWriter.Flush()
 
-	elapsed := time.Since(start).Truncate(time.Millisecond)
-	fmt.Printf("Thumbnail generation completed in %s: %d processed, %d skipped, %d failed\n",
-		elapsed, processedImages, skippedImages, failedImages)
-	logger.Printf("Completed in %s: %d processed, %d skipped, %d failed",
-		elapsed, processedImages, skippedImages, failedImages)
-
-	return nil
-}
+		out, err := backend.Resize(data, width, newH, r.Format, r.Quality)
+		if err != nil {
+			return false, fmt.Errorf("processing %s failed for %s: %w", r.Name, src, err)
+		}
 
-// webp helper
-func toWebP(rel string) string {
-	rel = strings.ReplaceAll(rel, "\\", "/")
-	ext := strings.ToLower(filepath.Ext(rel))
-	if ext == "" {
-		return rel + ".webp"
+		if err := writeThumb(dst, out); err != nil {
+			return false, fmt.Errorf("failed to write thumbnail %s: %w", dst, err)
+		}
 	}
-	return strings.TrimSuffix(rel, ext) + ".webp"
+	return true, nil // made a new thumbnail
 }
 
-func processImage(relPath, baseOutputDir, thumbOutputDir string, width, quality int) (bool, error) {
-	relPath = strings.ReplaceAll(relPath, "\\", "/")
-	relPath = filepath.Clean(relPath)
-
-	src := filepath.Join(baseOutputDir, relPath)
-
-	var dst string
-	if strings.TrimSpace(thumbOutputDir) == "" {
-		// side-by-side: <live>/<dir>/thumbnails/<name>.webp
-		srcDir := filepath.Dir(src)
-		dst = filepath.Join(srcDir, "thumbnails", filepath.Base(toWebP(relPath)))
-	} else {
-		// central mirror: <thumbRoot>/<rel>.webp
-		dst = filepath.Join(thumbOutputDir, toWebP(relPath))
-	}
-
-	// If thumbnail already exists, treat as success
-	if _, err := os.Stat(dst); err == nil {
-		return false, nil // not made, but OK
-	}
-
-	// does source exist
-	if _, err := os.Stat(src); os.IsNotExist(err) {
-		return false, fmt.Errorf("source image does not exist: %s", src)
-	}
-
-	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
-		return false, fmt.Errorf("failed to create thumb directory: %w", err)
-	}
-
-	data, err := bimg.Read(src)
+// writeThumb replaces dst atomically so a regenerated thumbnail is never
+// served half-written.
+func writeThumb(dst string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".thumb-*")
 	if err != nil {
-		return false, fmt.Errorf("failed to read image %s: %w", src, err)
+		return err
 	}
-
-	size, err := bimg.NewImage(data).Size()
-	if err != nil {
-		return false, fmt.Errorf("failed to get size for %s: %w", src, err)
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
 	}
-
-	newH := int((float64(width) * float64(size.Height)) / float64(size.Width))
-	if newH <= 0 {
-		newH = 1
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
 	}
-
-	out, err := bimg.NewImage(data).Process(bimg.Options{
-		Width:   width,
-		Height:  newH,
-		Force:   true,
-		Quality: quality,
-		Type:    bimg.WEBP,
-	})
-	if err != nil {
-		return false, fmt.Errorf("processing failed for %s: %w", src, err)
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		os.Remove(tmp.Name())
+		return err
 	}
-
-	if err := bimg.Write(dst, out); err != nil {
-		return false, fmt.Errorf("failed to write thumbnail %s: %w", dst, err)
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		os.Remove(tmp.Name())
+		return err
 	}
-	return true, nil // made a new thumbnail
+	return nil
 }
@@ -0,0 +1,174 @@
+package com
+
+import (
+	"OnlySats/com/imaging"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoSourceImage means no images row matches the requested thumbnail.
+var ErrNoSourceImage = errors.New("no source image for thumbnail")
+
+// OnDemandThumbs creates missing thumbnails when ThumbnailServer is asked for
+// them. Concurrent requests for the same file share one encode, and at most
+// `workers` encodes run at once.
+type OnDemandThumbs struct {
+	DB            *sql.DB
+	LiveOutputDir string
+	ThumbRoot     string
+	Renditions    []Rendition
+	Backend       imaging.Backend
+	RetryAfter    time.Duration // how long a failed source is left alone
+
+	sem      chan struct{}
+	mu       sync.Mutex
+	inflight map[string]*thumbCall
+	failed   map[string]failedThumb
+}
+
+type thumbCall struct {
+	done chan struct{}
+	err  error
+}
+
+type failedThumb struct {
+	at  time.Time
+	err error
+}
+
+func NewOnDemandThumbs(db *sql.DB, liveOutputDir, thumbRoot string, rends []Rendition, backend imaging.Backend, workers int) *OnDemandThumbs {
+	if workers <= 0 {
+		workers = 2
+	}
+	return &OnDemandThumbs{
+		DB:            db,
+		LiveOutputDir: liveOutputDir,
+		ThumbRoot:     thumbRoot,
+		Renditions:    rends,
+		Backend:       backend,
+		RetryAfter:    5 * time.Minute,
+		sem:           make(chan struct{}, workers),
+		inflight:      map[string]*thumbCall{},
+		failed:        map[string]failedThumb{},
+	}
+}
+
+// Ensure generates rendition r for the image behind thumbRel (the path from
+// the thumbnail URL, extension already swapped). It returns once the file
+// exists, or with ErrNoSourceImage, the encode error, or ctx's error.
+func (o *OnDemandThumbs) Ensure(ctx context.Context, r Rendition, thumbRel string) error {
+	thumbRel = path.Clean(strings.ReplaceAll(thumbRel, "\\", "/"))
+	key := r.Name + "|" + thumbRel
+
+	o.mu.Lock()
+	if f, ok := o.failed[key]; ok {
+		if time.Since(f.at) < o.RetryAfter {
+			o.mu.Unlock()
+			return f.err
+		}
+		delete(o.failed, key)
+	}
+	c, ok := o.inflight[key]
+	if !ok {
+		c = &thumbCall{done: make(chan struct{})}
+		o.inflight[key] = c
+		// the encode outlives the first requester so the others still get it
+		go o.run(key, c, r, thumbRel)
+	}
+	o.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (o *OnDemandThumbs) run(key string, c *thumbCall, r Rendition, thumbRel string) {
+	c.err = o.generate(r, thumbRel)
+
+	o.mu.Lock()
+	delete(o.inflight, key)
+	if c.err != nil {
+		if len(o.failed) > 10000 {
+			o.failed = map[string]failedThumb{}
+		}
+		o.failed[key] = failedThumb{at: time.Now(), err: c.err}
+	}
+	o.mu.Unlock()
+	close(c.done)
+}
+
+func (o *OnDemandThumbs) generate(r Rendition, thumbRel string) error {
+	id, src, err := o.lookup(thumbRel)
+	if err != nil {
+		return err
+	}
+
+	o.sem <- struct{}{}
+	made, err := processImage(o.Backend, src, o.LiveOutputDir, o.ThumbRoot, []Rendition{r}, nil)
+	<-o.sem
+	if err != nil {
+		thumbgenImages.Inc("failed")
+		thumbLog.Warn("on-demand thumbnail failed", "path", src, "rendition", r.Name, "err", err)
+		return err
+	}
+	if made {
+		thumbgenImages.Inc("on_demand")
+		thumbLog.Debug("on-demand thumbnail created", "path", src, "rendition", r.Name)
+	}
+
+	// thumbgen can skip this image once every rendition is on disk (rows
+	// waiting for a settings refresh are left to ThumbRefresher)
+	for _, other := range o.Renditions {
+		if _, err := os.Stat(ThumbPath(o.LiveOutputDir, o.ThumbRoot, other, src)); err != nil {
+			return nil
+		}
+	}
+	if _, err := o.DB.Exec(`UPDATE images SET needsThumb = 0 WHERE id = ? AND needsThumb = 1`, id); err != nil {
+		thumbLog.Warn("on-demand thumbnail: mark done", "id", id, "err", err)
+	}
+	return nil
+}
+
+// lookup finds the images row whose path matches thumbRel apart from the
+// extension. Paths are stored with forward slashes.
+func (o *OnDemandThumbs) lookup(thumbRel string) (int64, string, error) {
+	base := strings.TrimSuffix(thumbRel, path.Ext(thumbRel))
+	// base+"." <= path < base+"/" covers every extension of base (and a few
+	// longer names, filtered below)
+	rows, err := o.DB.Query(`
+		SELECT id, path FROM images
+		WHERE path = ? OR (path >= ? AND path < ?)
+		ORDER BY id`,
+		base, base+".", base+"/",
+	)
+	if err != nil {
+		return 0, "", fmt.Errorf("lookup image: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			id  int64
+			src string
+		)
+		if err := rows.Scan(&id, &src); err != nil {
+			return 0, "", fmt.Errorf("lookup image: %w", err)
+		}
+		if strings.TrimSuffix(src, path.Ext(src)) == base {
+			return id, src, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, "", fmt.Errorf("lookup image: %w", err)
+	}
+	return 0, "", ErrNoSourceImage
+}
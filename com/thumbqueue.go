@@ -0,0 +1,423 @@
+package com
+
+import (
+	"OnlySats/com/imaging"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// needsThumb value for rows whose last attempt failed. They are retried when
+// the queue starts and on every Kick, not on every poll.
+const thumbFailed = 3
+
+// thumbMaxStall caps the pause after a batch whose rows could not be marked
+// done; those rows are still needsThumb = 1 and would be fetched again.
+const thumbMaxStall = time.Minute
+
+// ThumbQueue generates thumbnails for needsThumb = 1 rows, newest passes
+// first. The queue itself is the images table, so it survives restarts; the
+// service polls it and is woken early by Kick after an ingest.
+type ThumbQueue struct {
+	DB            *sql.DB
+	LiveOutputDir string
+	ThumbRoot     string
+	Renditions    []Rendition
+	Backend       imaging.Backend
+	Tiles         *Tiler // nil when tile pyramids are disabled
+	Workers       int
+	BatchSize     int           // rows fetched per batch; newer passes jump ahead between batches
+	PollInterval  time.Duration // how often to look for rows nobody kicked us about
+
+	kick chan struct{}
+
+	mu        sync.Mutex
+	running   bool
+	kickGen   uint64 // incremented by Kick
+	waiters   []queueWaiter
+	inFlight  int
+	processed int64
+	skipped   int64
+	failed    int64
+	recent    []time.Time // completion times within the last minute
+	lastDrain time.Time
+	drainTook time.Duration
+}
+
+type queueWaiter struct {
+	gen uint64
+	ch  chan struct{}
+}
+
+// ThumbQueueStatus is reported by the admin API.
+type ThumbQueueStatus struct {
+	Running   bool       `json:"running"`
+	Workers   int        `json:"workers"`
+	Depth     int64      `json:"depth"`   // rows waiting (needsThumb = 1)
+	Failing   int64      `json:"failing"` // rows held back until the next kick
+	InFlight  int        `json:"inFlight"`
+	Processed int64      `json:"processed"` // since startup
+	Skipped   int64      `json:"skipped"`
+	Failed    int64      `json:"failed"`
+	PerMinute int        `json:"perMinute"` // images finished in the last 60s
+	LastDrain *time.Time `json:"lastDrain,omitempty"`
+	DrainTook string     `json:"drainTook,omitempty"`
+}
+
+func NewThumbQueue(db *sql.DB, liveOutputDir, thumbRoot string, rends []Rendition, backend imaging.Backend, tiles *Tiler, workers, batch int) *ThumbQueue {
+	if workers <= 0 {
+		workers = 4
+	}
+	// small batches so a freshly ingested pass doesn't wait behind a backlog
+	if batch <= 0 || batch > workers*16 {
+		batch = workers * 16
+	}
+	return &ThumbQueue{
+		DB:            db,
+		LiveOutputDir: liveOutputDir,
+		ThumbRoot:     thumbRoot,
+		Renditions:    rends,
+		Backend:       backend,
+		Tiles:         tiles,
+		Workers:       workers,
+		BatchSize:     batch,
+		PollInterval:  time.Minute,
+		kick:          make(chan struct{}, 1),
+	}
+}
+
+// Start runs the queue until ctx is done. Rows that failed before are
+// given another chance.
+func (q *ThumbQueue) Start(ctx context.Context) {
+	q.mu.Lock()
+	if q.running {
+		q.mu.Unlock()
+		return
+	}
+	q.running = true
+	q.mu.Unlock()
+
+	names := make([]string, len(q.Renditions))
+	for i, r := range q.Renditions {
+		names[i] = fmt.Sprintf("%s(%dpx %s q%d)", r.Name, r.Width, r.Format, r.Quality)
+	}
+	thumbLog.Info("starting thumbnail queue",
+		"workers", q.Workers, "backend", q.Backend.Name(), "renditions", names, "out", q.ThumbRoot)
+
+	q.retryFailed(ctx)
+	go q.loop(ctx)
+}
+
+// Kick wakes the queue, e.g. after a DB update added rows, and releases
+// rows held back by earlier failures.
+func (q *ThumbQueue) Kick() {
+	q.mu.Lock()
+	q.kickGen++
+	q.mu.Unlock()
+	select {
+	case q.kick <- struct{}{}:
+	default:
+	}
+}
+
+// Flush kicks the queue and waits until everything queued so far is done.
+// Cancelling ctx stops the wait, not the work.
+func (q *ThumbQueue) Flush(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.running {
+		q.mu.Unlock()
+		return errors.New("thumbnail queue not running")
+	}
+	q.kickGen++
+	w := queueWaiter{gen: q.kickGen, ch: make(chan struct{})}
+	q.waiters = append(q.waiters, w)
+	q.mu.Unlock()
+	select {
+	case q.kick <- struct{}{}:
+	default:
+	}
+
+	select {
+	case <-w.ch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (q *ThumbQueue) Status(ctx context.Context) (ThumbQueueStatus, error) {
+	var depth, failing int64
+	if err := q.DB.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(needsThumb = 1), 0), COALESCE(SUM(needsThumb = ?), 0)
+		FROM images WHERE needsThumb IN (1, ?)`, thumbFailed, thumbFailed,
+	).Scan(&depth, &failing); err != nil {
+		return ThumbQueueStatus{}, err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.trimRecent(time.Now())
+	st := ThumbQueueStatus{
+		Running:   q.running,
+		Workers:   q.Workers,
+		Depth:     depth,
+		Failing:   failing,
+		InFlight:  q.inFlight,
+		Processed: q.processed,
+		Skipped:   q.skipped,
+		Failed:    q.failed,
+		PerMinute: len(q.recent),
+	}
+	if !q.lastDrain.IsZero() {
+		t := q.lastDrain
+		st.LastDrain = &t
+		st.DrainTook = q.drainTook.Truncate(time.Millisecond).String()
+	}
+	return st, nil
+}
+
+func (q *ThumbQueue) retryFailed(ctx context.Context) {
+	if _, err := q.DB.ExecContext(ctx,
+		`UPDATE images SET needsThumb = 1 WHERE needsThumb = ?`, thumbFailed); err != nil && ctx.Err() == nil {
+		thumbLog.Warn("thumbnail queue: reset failed rows", "err", err)
+	}
+}
+
+type thumbJob struct {
+	id   int64
+	path string
+	have map[string]string // fingerprints the existing files were made with
+}
+
+func (q *ThumbQueue) loop(ctx context.Context) {
+	defer func() {
+		q.mu.Lock()
+		q.running = false
+		for _, w := range q.waiters {
+			close(w.ch)
+		}
+		q.waiters = nil
+		q.mu.Unlock()
+	}()
+
+	jobs := make(chan thumbJob)
+	var (
+		wg       sync.WaitGroup
+		unmarked atomic.Int64 // rows in the batch whose update failed
+	)
+	for i := 0; i < q.Workers; i++ {
+		go func() {
+			for job := range jobs {
+				if !q.process(ctx, job) {
+					unmarked.Add(1)
+				}
+				wg.Done()
+			}
+		}()
+	}
+	defer close(jobs)
+
+	poll := time.NewTicker(q.PollInterval)
+	defer poll.Stop()
+
+	var (
+		backlogStart time.Time
+		atStart      [3]int64 // processed, skipped, failed when the backlog began
+		lastGen      uint64
+		stall        time.Duration
+	)
+	for {
+		q.mu.Lock()
+		gen := q.kickGen
+		q.mu.Unlock()
+		if gen != lastGen {
+			lastGen = gen
+			q.retryFailed(ctx)
+		}
+
+		batch, depth, err := q.next(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			thumbLog.Error("thumbnail queue", "err", err)
+		}
+		thumbgenPending.Set(float64(depth))
+
+		if len(batch) > 0 {
+			if backlogStart.IsZero() {
+				backlogStart = time.Now()
+				atStart = q.totals()
+				thumbLog.Info("thumbnail queue working", "pending", depth)
+			}
+			for _, job := range batch {
+				wg.Add(1)
+				select {
+				case jobs <- job:
+				case <-ctx.Done():
+					wg.Done()
+					wg.Wait()
+					return
+				}
+			}
+			wg.Wait()
+			if n := unmarked.Swap(0); n > 0 {
+				// the database refused the writes; don't spin on the same rows
+				stall = min(max(2*stall, time.Second), thumbMaxStall)
+				thumbLog.Warn("thumbnail queue: rows not updated, pausing", "rows", n, "pause", stall)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(stall):
+				}
+			} else {
+				stall = 0
+			}
+			continue
+		}
+
+		if !backlogStart.IsZero() {
+			q.drained(backlogStart, atStart)
+			backlogStart = time.Time{}
+		}
+		q.release(gen)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.kick:
+		case <-poll.C:
+		}
+	}
+}
+
+// next returns the next batch, newest pass first, and the queue depth.
+func (q *ThumbQueue) next(ctx context.Context) ([]thumbJob, int64, error) {
+	var depth int64
+	if err := q.DB.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM images WHERE needsThumb = 1`).Scan(&depth); err != nil {
+		return nil, 0, fmt.Errorf("count queue: %w", err)
+	}
+	if depth == 0 {
+		return nil, 0, nil
+	}
+	rows, err := q.DB.QueryContext(ctx, `
+		SELECT i.id, i.path, COALESCE(i.thumbParams, '')
+		FROM images i LEFT JOIN passes p ON p.id = i.passId
+		WHERE i.needsThumb = 1
+		ORDER BY p.timestamp DESC, i.id DESC
+		LIMIT ?`, q.BatchSize)
+	if err != nil {
+		return nil, depth, fmt.Errorf("query queue: %w", err)
+	}
+	defer rows.Close()
+	var batch []thumbJob
+	for rows.Next() {
+		var (
+			job  thumbJob
+			have string
+		)
+		if err := rows.Scan(&job.id, &job.path, &have); err != nil {
+			return nil, depth, fmt.Errorf("query queue: %w", err)
+		}
+		job.have = parseThumbParams(have)
+		batch = append(batch, job)
+	}
+	return batch, depth, rows.Err()
+}
+
+// process makes the thumbnails for one row and records the result. It
+// reports false when the row could not be updated and is still queued.
+func (q *ThumbQueue) process(ctx context.Context, job thumbJob) bool {
+	q.mu.Lock()
+	q.inFlight++
+	q.mu.Unlock()
+
+	made, err := processImage(q.Backend, job.path, q.LiveOutputDir, q.ThumbRoot, q.Renditions, job.have)
+
+	var result string
+	var dbErr error
+	switch {
+	case err != nil:
+		result = "failed"
+		thumbLog.Debug("thumbnail failed", "path", job.path, "err", err)
+		_, dbErr = q.DB.ExecContext(ctx,
+			`UPDATE images SET needsThumb = ? WHERE id = ? AND needsThumb = 1`, thumbFailed, job.id)
+	default:
+		result = "skipped"
+		if made {
+			result = "processed"
+			thumbLog.Debug("thumbnail created", "path", job.path)
+		} else {
+			thumbLog.Debug("thumbnail exists", "path", job.path)
+		}
+		params, hasTiles := finishThumbs(q.Tiles, q.Renditions, job.id, q.LiveOutputDir, job.path)
+		_, dbErr = q.DB.ExecContext(ctx,
+			`UPDATE images SET needsThumb = 0, thumbParams = ?, tiles = ? WHERE id = ? AND needsThumb = 1`,
+			params, hasTiles, job.id)
+	}
+	if dbErr != nil && ctx.Err() == nil {
+		thumbLog.Warn("thumbnail queue: update row", "id", job.id, "err", dbErr)
+	}
+	thumbgenImages.Inc(result)
+
+	now := time.Now()
+	q.mu.Lock()
+	q.inFlight--
+	switch result {
+	case "processed":
+		q.processed++
+	case "skipped":
+		q.skipped++
+	default:
+		q.failed++
+	}
+	q.recent = append(q.recent, now)
+	q.trimRecent(now)
+	q.mu.Unlock()
+	return dbErr == nil
+}
+
+func (q *ThumbQueue) trimRecent(now time.Time) {
+	i := 0
+	for i < len(q.recent) && now.Sub(q.recent[i]) > time.Minute {
+		i++
+	}
+	q.recent = q.recent[i:]
+}
+
+func (q *ThumbQueue) totals() [3]int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return [3]int64{q.processed, q.skipped, q.failed}
+}
+
+func (q *ThumbQueue) drained(start time.Time, before [3]int64) {
+	took := time.Since(start)
+	thumbgenDuration.Set(took.Seconds())
+	q.mu.Lock()
+	q.lastDrain, q.drainTook = time.Now(), took
+	q.mu.Unlock()
+	now := q.totals()
+	thumbLog.Info("thumbnail queue drained", "elapsed", took.Truncate(time.Millisecond),
+		"processed", now[0]-before[0], "skipped", now[1]-before[1], "failed", now[2]-before[2])
+}
+
+// release wakes Flush callers whose kick was seen before the queue went idle.
+func (q *ThumbQueue) release(gen uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	keep := q.waiters[:0]
+	for _, w := range q.waiters {
+		if w.gen <= gen {
+			close(w.ch)
+		} else {
+			keep = append(keep, w)
+		}
+	}
+	q.waiters = keep
+}
@@ -0,0 +1,202 @@
+package com
+
+import (
+	"OnlySats/com/imaging"
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// needsThumb value for rows whose thumbnails exist but were made with other
+// [thumbgen] settings. They keep being served until ThumbRefresher replaces them.
+const thumbStale = 2
+
+// ThumbRefresher regenerates out-of-date thumbnails one image at a time,
+// newest first, pausing between images so gallery requests aren't starved.
+type ThumbRefresher struct {
+	DB            *sql.DB
+	LiveOutputDir string
+	ThumbRoot     string
+	Renditions    []Rendition
+	Backend       imaging.Backend
+	Tiles         *Tiler        // nil when tile pyramids are disabled
+	Pause         time.Duration // between images
+	BatchSize     int
+
+	mu     sync.Mutex
+	status ThumbRefreshStatus
+}
+
+// ThumbRefreshStatus is reported by the admin API.
+type ThumbRefreshStatus struct {
+	Params    string     `json:"params"` // current ThumbParams
+	Running   bool       `json:"running"`
+	Marked    int64      `json:"marked"` // rows found stale at startup
+	Remaining int64      `json:"remaining"`
+	Refreshed int64      `json:"refreshed"`
+	Failed    int64      `json:"failed"`
+	Finished  *time.Time `json:"finished,omitempty"`
+}
+
+func NewThumbRefresher(db *sql.DB, liveOutputDir, thumbRoot string, rends []Rendition, backend imaging.Backend, tiles *Tiler) *ThumbRefresher {
+	return &ThumbRefresher{
+		DB:            db,
+		LiveOutputDir: liveOutputDir,
+		ThumbRoot:     thumbRoot,
+		Renditions:    rends,
+		Backend:       backend,
+		Tiles:         tiles,
+		Pause:         100 * time.Millisecond,
+		BatchSize:     50,
+		status:        ThumbRefreshStatus{Params: ThumbParams(rends, tiles)},
+	}
+}
+
+func (t *ThumbRefresher) Status() ThumbRefreshStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.status
+}
+
+// MarkStale flags every thumbnailed row whose fingerprint differs from the
+// current settings. Rows from before fingerprints existed are assumed to
+// match, so upgrading doesn't regenerate the whole archive.
+func (t *ThumbRefresher) MarkStale(ctx context.Context) (int64, error) {
+	params := ThumbParams(t.Renditions, t.Tiles)
+	if _, err := t.DB.ExecContext(ctx,
+		`UPDATE images SET thumbParams = ? WHERE thumbParams IS NULL AND needsThumb = 0`, params); err != nil {
+		return 0, fmt.Errorf("adopt thumbnail params: %w", err)
+	}
+	res, err := t.DB.ExecContext(ctx,
+		`UPDATE images SET needsThumb = ? WHERE needsThumb = 0 AND thumbParams != ?`, thumbStale, params)
+	if err != nil {
+		return 0, fmt.Errorf("mark stale thumbnails: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// Start marks stale rows and regenerates them in the background. It returns
+// immediately; the goroutine exits once nothing is left or ctx is done.
+func (t *ThumbRefresher) Start(ctx context.Context) {
+	t.mu.Lock()
+	if t.status.Running {
+		t.mu.Unlock()
+		return
+	}
+	t.status.Running = true
+	t.mu.Unlock()
+
+	go func() {
+		defer func() {
+			now := time.Now()
+			t.mu.Lock()
+			t.status.Running = false
+			t.status.Finished = &now
+			t.mu.Unlock()
+		}()
+
+		marked, err := t.MarkStale(ctx)
+		if err != nil {
+			thumbLog.Error("thumbnail refresh", "err", err)
+			return
+		}
+		var remaining int64
+		if err := t.DB.QueryRowContext(ctx,
+			`SELECT COUNT(*) FROM images WHERE needsThumb = ?`, thumbStale).Scan(&remaining); err != nil {
+			thumbLog.Error("thumbnail refresh", "err", err)
+			return
+		}
+		t.mu.Lock()
+		t.status.Marked, t.status.Remaining = marked, remaining
+		t.mu.Unlock()
+		thumbgenStale.Set(float64(remaining))
+		if remaining == 0 {
+			return
+		}
+		thumbLog.Info("thumbnail settings changed, regenerating in background",
+			"images", remaining, "params", ThumbParams(t.Renditions, t.Tiles))
+
+		if err := t.run(ctx); err != nil && ctx.Err() == nil {
+			thumbLog.Error("thumbnail refresh", "err", err)
+			return
+		}
+		st := t.Status()
+		if ctx.Err() == nil {
+			thumbLog.Info("thumbnail refresh completed", "refreshed", st.Refreshed, "failed", st.Failed)
+		}
+	}()
+}
+
+func (t *ThumbRefresher) run(ctx context.Context) error {
+	type staleRow struct {
+		id   int64
+		path string
+		have string
+	}
+	for {
+		rows, err := t.DB.QueryContext(ctx, `
+			SELECT id, path, COALESCE(thumbParams, '') FROM images
+			WHERE needsThumb = ? ORDER BY id DESC LIMIT ?`, thumbStale, t.BatchSize)
+		if err != nil {
+			return err
+		}
+		var batch []staleRow
+		for rows.Next() {
+			var r staleRow
+			if err := rows.Scan(&r.id, &r.path, &r.have); err != nil {
+				rows.Close()
+				return err
+			}
+			batch = append(batch, r)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+
+		for _, r := range batch {
+			if _, err := processImage(t.Backend, r.path, t.LiveOutputDir, t.ThumbRoot, t.Renditions, parseThumbParams(r.have)); err != nil {
+				// hand it back to thumbgen, which retries on every update run
+				thumbgenImages.Inc("failed")
+				thumbLog.Warn("thumbnail refresh failed", "path", r.path, "err", err)
+				_, err = t.DB.ExecContext(ctx, `UPDATE images SET needsThumb = 1 WHERE id = ? AND needsThumb = ?`, r.id, thumbStale)
+				t.count(false)
+			} else {
+				thumbgenImages.Inc("refreshed")
+				params, hasTiles := finishThumbs(t.Tiles, t.Renditions, r.id, t.LiveOutputDir, r.path)
+				_, err = t.DB.ExecContext(ctx, `UPDATE images SET needsThumb = 0, thumbParams = ?, tiles = ? WHERE id = ? AND needsThumb = ?`,
+					params, hasTiles, r.id, thumbStale)
+				t.count(true)
+			}
+			if err != nil {
+				return err
+			}
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(t.Pause):
+			}
+		}
+	}
+}
+
+func (t *ThumbRefresher) count(ok bool) {
+	t.mu.Lock()
+	if ok {
+		t.status.Refreshed++
+	} else {
+		t.status.Failed++
+	}
+	if t.status.Remaining > 0 {
+		t.status.Remaining--
+	}
+	remaining := t.status.Remaining
+	t.mu.Unlock()
+	thumbgenStale.Set(float64(remaining))
+}
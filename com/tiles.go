@@ -0,0 +1,273 @@
+package com
+
+import (
+	"OnlySats/com/imaging"
+	"OnlySats/config"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Tiler builds deep-zoom tile pyramids for large originals. Levels follow
+// Deep Zoom (DZI) numbering: level z is the image scaled by 2^(z-MaxZoom),
+// so MaxZoom is full resolution. Levels below the first that fits in one
+// tile are skipped.
+//
+//	<Dir>/<imageId>/info.json
+//	<Dir>/<imageId>/<z>/<x>/<y>.<ext>
+type Tiler struct {
+	Dir      string
+	MinSize  int
+	TileSize int
+	Format   string
+	Quality  int
+	Backend  imaging.Backend
+
+	mu sync.Mutex // one pyramid at a time; full-size RGBA buffers are large
+}
+
+// TileInfo is written to info.json and served to viewers.
+type TileInfo struct {
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	TileSize int    `json:"tileSize"`
+	Format   string `json:"format"`
+	MinZoom  int    `json:"minZoom"`
+	MaxZoom  int    `json:"maxZoom"`
+	URL      string `json:"url"` // template with {z}, {x} and {y}
+	Source   string `json:"source"`
+	Params   string `json:"params"`
+}
+
+// NewTiler returns nil when thumbgen.tiles is disabled.
+func NewTiler(cfg *config.AppConfig, backend imaging.Backend) *Tiler {
+	tc := cfg.Thumbgen.Tiles
+	if !tc.Enabled {
+		return nil
+	}
+	t := &Tiler{
+		Dir:      tc.Dir,
+		MinSize:  tc.MinSize,
+		TileSize: tc.TileSize,
+		Format:   strings.ToLower(strings.TrimSpace(tc.Format)),
+		Quality:  tc.Quality,
+		Backend:  backend,
+	}
+	if strings.TrimSpace(t.Dir) == "" {
+		t.Dir = filepath.Join(cfg.Paths.DataDir, "tiles")
+	}
+	if t.MinSize <= 0 {
+		t.MinSize = 4096
+	}
+	if t.TileSize < 64 || t.TileSize > 1024 {
+		t.TileSize = 256
+	}
+	if t.Quality <= 0 || t.Quality > 100 {
+		t.Quality = 85
+	}
+	switch t.Format {
+	case "jpg":
+		t.Format = "jpeg"
+	case "webp", "png", "jpeg", "avif":
+	default:
+		t.Format = "webp"
+	}
+	if t.Format != "jpeg" && t.Format != "png" && !backend.Supports(t.Format) {
+		thumbLog.Warn("image backend cannot encode tile format, using png", "format", t.Format, "backend", backend.Name())
+		t.Format = "png"
+	}
+	return t
+}
+
+// Fingerprint changes whenever existing pyramids would come out differently.
+func (t *Tiler) Fingerprint() string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d|%d|%s|%d|%s", t.MinSize, t.TileSize, t.Format, t.Quality, t.Backend.Name())))
+	return hex.EncodeToString(sum[:4])
+}
+
+func (t *Tiler) Ext() string {
+	return Rendition{Format: t.Format}.Ext()
+}
+
+func (t *Tiler) ContentType() string {
+	return Rendition{Format: t.Format}.ContentType()
+}
+
+func (t *Tiler) imageDir(id int64) string {
+	return filepath.Join(t.Dir, strconv.FormatInt(id, 10))
+}
+
+// TilePath is where tile x,y of level z of image id is stored.
+func (t *Tiler) TilePath(id int64, z, x, y int) string {
+	return filepath.Join(t.imageDir(id), strconv.Itoa(z), strconv.Itoa(x), strconv.Itoa(y)+t.Ext())
+}
+
+// Info reads the descriptor of image id's pyramid.
+func (t *Tiler) Info(id int64) (TileInfo, error) {
+	var info TileInfo
+	data, err := os.ReadFile(filepath.Join(t.imageDir(id), "info.json"))
+	if err != nil {
+		return info, err
+	}
+	err = json.Unmarshal(data, &info)
+	return info, err
+}
+
+// Ensure builds the pyramid for image id (rel is its path under liveDir)
+// unless an up-to-date one exists. has reports whether the image has a
+// pyramid afterwards; small images don't get one.
+func (t *Tiler) Ensure(id int64, liveDir, rel string) (has bool, err error) {
+	rel = filepath.ToSlash(filepath.Clean(strings.ReplaceAll(rel, "\\", "/")))
+	if info, err := t.Info(id); err == nil && info.Source == rel && info.Params == t.Fingerprint() {
+		return true, nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(liveDir, filepath.FromSlash(rel)))
+	if err != nil {
+		return false, err
+	}
+	w, h, err := t.Backend.Size(data)
+	if err != nil {
+		return false, err
+	}
+	if max(w, h) < t.MinSize {
+		// settings may have changed since a pyramid was built
+		return false, os.RemoveAll(t.imageDir(id))
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	img, err := imaging.Decode(data)
+	data = nil
+	if err != nil {
+		return false, fmt.Errorf("decode for tiles: %w", err)
+	}
+	if err := t.build(id, rel, img); err != nil {
+		return false, err
+	}
+	thumbLog.Debug("tile pyramid built", "id", id, "path", rel, "width", w, "height", h)
+	return true, nil
+}
+
+func (t *Tiler) build(id int64, rel string, img *image.RGBA) error {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	maxZ := bits.Len(uint(max(w, h) - 1)) // ceil(log2(longer side))
+
+	// build beside the live pyramid, then swap, so viewers never see half of one
+	final := t.imageDir(id)
+	tmp := final + ".tmp"
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+
+	ts := t.TileSize
+	z := maxZ
+	for {
+		lw, lh := img.Rect.Dx(), img.Rect.Dy()
+		cols, rows := (lw+ts-1)/ts, (lh+ts-1)/ts
+		for x := 0; x < cols; x++ {
+			dir := filepath.Join(tmp, strconv.Itoa(z), strconv.Itoa(x))
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				return err
+			}
+			for y := 0; y < rows; y++ {
+				r := image.Rect(x*ts, y*ts, min((x+1)*ts, lw), min((y+1)*ts, lh))
+				out, err := imaging.Encode(t.Backend, img.SubImage(r), t.Format, t.Quality)
+				if err != nil {
+					os.RemoveAll(tmp)
+					return fmt.Errorf("tile %d/%d/%d: %w", z, x, y, err)
+				}
+				if err := os.WriteFile(filepath.Join(dir, strconv.Itoa(y)+t.Ext()), out, 0o644); err != nil {
+					os.RemoveAll(tmp)
+					return err
+				}
+			}
+		}
+		if (lw <= ts && lh <= ts) || z == 0 {
+			break
+		}
+		img = imaging.Halve(img)
+		z--
+	}
+
+	info := TileInfo{
+		Width:    w,
+		Height:   h,
+		TileSize: ts,
+		Format:   t.Format,
+		MinZoom:  z,
+		MaxZoom:  maxZ,
+		URL:      fmt.Sprintf("/tiles/%d/{z}/{x}/{y}", id),
+		Source:   rel,
+		Params:   t.Fingerprint(),
+	}
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(tmp, "info.json"), data, 0o644); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+
+	if err := os.RemoveAll(final); err != nil {
+		return err
+	}
+	return os.Rename(tmp, final)
+}
+
+// Remove deletes image id's pyramid and returns the bytes freed.
+func (t *Tiler) Remove(id int64) (int64, error) {
+	var size int64
+	_ = filepath.Walk(t.imageDir(id), func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, os.RemoveAll(t.imageDir(id))
+}
+
+// IDs lists the images that have a pyramid directory.
+func (t *Tiler) IDs() ([]int64, error) {
+	entries, err := os.ReadDir(t.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var ids []int64
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		if id, err := strconv.ParseInt(e.Name(), 10, 64); err == nil && id > 0 {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// finishThumbs builds the pyramid (if t is set) for an image whose
+// thumbnails were just made. It returns the thumbParams value to store;
+// a failed pyramid is left out of it so the refresher retries it.
+func finishThumbs(t *Tiler, rends []Rendition, id int64, liveDir, rel string) (params string, hasTiles bool) {
+	if t == nil {
+		return ThumbParams(rends, nil), false
+	}
+	has, err := t.Ensure(id, liveDir, rel)
+	if err != nil {
+		thumbLog.Warn("tile pyramid failed", "id", id, "path", rel, "err", err)
+		return ThumbParams(rends, nil), false
+	}
+	return ThumbParams(rends, t), has
+}
@@ -0,0 +1,416 @@
+package com
+
+import (
+	"OnlySats/com/imaging"
+	"OnlySats/com/logging"
+	"OnlySats/config"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+var wmLog = logging.For("watermark")
+
+// WatermarkSettingKey is the app_settings key the settings are kept under.
+const WatermarkSettingKey = "watermark"
+
+// ErrBadWatermark wraps validation errors for settings and logos.
+var ErrBadWatermark = errors.New("invalid watermark")
+
+// Watermark routes: originals under /images/ and their resized copies.
+const (
+	WatermarkImages      = "images"
+	WatermarkDerivatives = "derivatives"
+)
+
+var watermarkPositions = []string{"top-left", "top-right", "bottom-left", "bottom-right", "center"}
+
+// WatermarkSettings is the overlay configuration edited on the settings page.
+type WatermarkSettings struct {
+	Enabled       bool     `json:"enabled"`
+	Text          string   `json:"text"` // extra caption line, e.g. the station URL
+	ShowStation   bool     `json:"showStation"`
+	Station       string   `json:"station"` // empty uses the about page name
+	ShowSatellite bool     `json:"showSatellite"`
+	ShowTime      bool     `json:"showTime"`
+	Logo          bool     `json:"logo"`        // draw the uploaded logo left of the caption
+	Position      string   `json:"position"`    // top-left, top-right, bottom-left, bottom-right, center
+	Opacity       float64  `json:"opacity"`     // 0.05-1
+	Size          float64  `json:"size"`        // caption line height, percent of the image's shorter side
+	Routes        []string `json:"routes"`      // images, derivatives
+	ExemptLevel   int      `json:"exemptLevel"` // signed-in users at this level or better get clean images; -1 exempts nobody
+}
+
+// DefaultWatermarkSettings is what an unconfigured station starts from.
+func DefaultWatermarkSettings() WatermarkSettings {
+	return WatermarkSettings{
+		ShowStation:   true,
+		ShowSatellite: true,
+		ShowTime:      true,
+		Logo:          true,
+		Position:      "bottom-right",
+		Opacity:       0.7,
+		Size:          2.5,
+		Routes:        []string{WatermarkImages, WatermarkDerivatives},
+		ExemptLevel:   1,
+	}
+}
+
+// Validate normalises s and reports the first problem.
+func (s *WatermarkSettings) Validate() error {
+	s.Text = strings.TrimSpace(s.Text)
+	s.Station = strings.TrimSpace(s.Station)
+	s.Position = strings.ToLower(strings.TrimSpace(s.Position))
+	switch {
+	case len(s.Text) > 200:
+		return fmt.Errorf("%w: text is limited to 200 characters", ErrBadWatermark)
+	case len(s.Station) > 100:
+		return fmt.Errorf("%w: station is limited to 100 characters", ErrBadWatermark)
+	case !slices.Contains(watermarkPositions, s.Position):
+		return fmt.Errorf("%w: position must be one of %s", ErrBadWatermark, strings.Join(watermarkPositions, ", "))
+	case s.Opacity < 0.05 || s.Opacity > 1:
+		return fmt.Errorf("%w: opacity must be between 0.05 and 1", ErrBadWatermark)
+	case s.Size < 0.5 || s.Size > 20:
+		return fmt.Errorf("%w: size must be between 0.5 and 20 percent", ErrBadWatermark)
+	case s.ExemptLevel < -1 || s.ExemptLevel > 3:
+		return fmt.Errorf("%w: exemptLevel must be -1 to 3", ErrBadWatermark)
+	}
+	var routes []string
+	for _, r := range s.Routes {
+		r = strings.ToLower(strings.TrimSpace(r))
+		if r != WatermarkImages && r != WatermarkDerivatives {
+			return fmt.Errorf("%w: routes may contain %q and %q", ErrBadWatermark, WatermarkImages, WatermarkDerivatives)
+		}
+		if !slices.Contains(routes, r) {
+			routes = append(routes, r)
+		}
+	}
+	s.Routes = routes
+	return nil
+}
+
+// Watermarker decides which responses get an overlay and prepares it.
+// Settings live in app_settings and the logo in <data_dir>/watermark.
+type Watermarker struct {
+	Store    *LocalDataStore
+	DB       *sql.DB
+	LogoPath string
+	Fallback string // station name when neither the settings nor the about page have one
+
+	mu       sync.Mutex
+	settings savedSetting[WatermarkSettings]
+	logo     image.Image
+	logoKey  string
+}
+
+// NewWatermarker loads the logo, if one was uploaded.
+func NewWatermarker(cfg *config.AppConfig, store *LocalDataStore, imageDB *sql.DB) *Watermarker {
+	w := &Watermarker{
+		Store:    store,
+		DB:       imageDB,
+		LogoPath: filepath.Join(cfg.Paths.DataDir, "watermark", "logo.png"),
+		Fallback: strings.TrimSpace(cfg.StationProxy.StationId),
+		settings: savedSetting[WatermarkSettings]{
+			key:      WatermarkSettingKey,
+			defaults: DefaultWatermarkSettings,
+			check:    (*WatermarkSettings).Validate,
+			log:      wmLog,
+		},
+	}
+	if data, err := os.ReadFile(w.LogoPath); err == nil {
+		if img, err := png.Decode(bytes.NewReader(data)); err == nil {
+			w.setLogoLocked(img, data)
+		} else {
+			wmLog.Warn("unreadable watermark logo", "path", w.LogoPath, "err", err)
+		}
+	}
+	return w
+}
+
+// Settings returns the saved settings.
+func (w *Watermarker) Settings(ctx context.Context) WatermarkSettings {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.settings.get(ctx, w.Store)
+}
+
+// SaveSettings validates and stores s.
+func (w *Watermarker) SaveSettings(ctx context.Context, s WatermarkSettings) (WatermarkSettings, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.settings.save(ctx, w.Store, s)
+}
+
+// HasLogo reports whether a logo has been uploaded.
+func (w *Watermarker) HasLogo() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.logo != nil
+}
+
+// SetLogo stores an uploaded image as the logo, scaled down to at most
+// 512 px and re-encoded as PNG.
+func (w *Watermarker) SetLogo(data []byte) error {
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%w: unsupported or corrupt image", ErrBadWatermark)
+	}
+	b := src.Bounds()
+	if b.Dx() < 1 || b.Dy() < 1 {
+		return fmt.Errorf("%w: empty image", ErrBadWatermark)
+	}
+	if long := max(b.Dx(), b.Dy()); long > 512 {
+		dst := image.NewRGBA(image.Rect(0, 0, max(1, b.Dx()*512/long), max(1, b.Dy()*512/long)))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Src, nil)
+		src = dst
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, src); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(w.LogoPath), 0o755); err != nil {
+		return err
+	}
+	if err := writeThumb(w.LogoPath, buf.Bytes()); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	w.setLogoLocked(src, buf.Bytes())
+	w.mu.Unlock()
+	return nil
+}
+
+// RemoveLogo deletes the uploaded logo.
+func (w *Watermarker) RemoveLogo() error {
+	if err := os.Remove(w.LogoPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	w.mu.Lock()
+	w.logo, w.logoKey = nil, ""
+	w.mu.Unlock()
+	return nil
+}
+
+func (w *Watermarker) setLogoLocked(img image.Image, encoded []byte) {
+	sum := sha256.Sum256(encoded)
+	w.logo, w.logoKey = img, hex.EncodeToString(sum[:8])
+}
+
+// Active reports whether route is watermarked for anyone, so responses can
+// say they vary by session.
+func (w *Watermarker) Active(ctx context.Context, route string) bool {
+	s := w.Settings(ctx)
+	return s.Enabled && slices.Contains(s.Routes, route)
+}
+
+// For returns the overlay for the image at rel (an images.path) served on
+// route, or nil when this viewer gets it clean. level is ignored unless
+// signedIn.
+func (w *Watermarker) For(ctx context.Context, route string, level int, signedIn bool, rel string) (*Watermark, error) {
+	s := w.Settings(ctx)
+	if !s.Enabled || !slices.Contains(s.Routes, route) {
+		return nil, nil
+	}
+	if signedIn && level <= s.ExemptLevel {
+		return nil, nil
+	}
+
+	wm := &Watermark{Position: s.Position, Opacity: s.Opacity, Size: s.Size}
+	if s.Text != "" {
+		wm.Lines = append(wm.Lines, s.Text)
+	}
+	if s.ShowStation {
+		if name := w.stationName(ctx, s); name != "" {
+			wm.Lines = append(wm.Lines, name)
+		}
+	}
+	if s.ShowSatellite || s.ShowTime {
+		var m CaptureMeta
+		if err := lookupCapture(ctx, w.DB, rel, &m); err != nil {
+			return nil, err
+		}
+		var parts []string
+		if s.ShowSatellite && m.Satellite != "" {
+			parts = append(parts, m.Satellite)
+		}
+		if s.ShowTime && !m.Time.IsZero() {
+			parts = append(parts, m.Time.Format("2006-01-02 15:04 UTC"))
+		}
+		if len(parts) > 0 {
+			wm.Lines = append(wm.Lines, strings.Join(parts, "  "))
+		}
+	}
+	for i, l := range wm.Lines {
+		wm.Lines[i] = toASCII(l) // the bitmap font has no other glyphs
+	}
+	if s.Logo {
+		w.mu.Lock()
+		wm.logo, wm.logoKey = w.logo, w.logoKey
+		w.mu.Unlock()
+	}
+	if len(wm.Lines) == 0 && wm.logo == nil {
+		return nil, nil
+	}
+	return wm, nil
+}
+
+func (w *Watermarker) stationName(ctx context.Context, s WatermarkSettings) string {
+	if s.Station != "" {
+		return s.Station
+	}
+	if about, err := w.Store.GetAllAboutMeta(ctx); err == nil {
+		if name := strings.TrimSpace(about["name"]); name != "" {
+			return name
+		}
+	}
+	return w.Fallback
+}
+
+// Watermark is the overlay prepared for one image.
+type Watermark struct {
+	Lines    []string
+	Position string
+	Opacity  float64
+	Size     float64
+
+	logo    image.Image
+	logoKey string
+}
+
+// Key changes whenever the rendered overlay would, so cached copies made
+// under older settings are never served.
+func (m *Watermark) Key() string {
+	sum := sha256.Sum256([]byte(strings.Join(m.Lines, "\n") + "|" + m.Position + "|" +
+		strconv.FormatFloat(m.Opacity, 'f', 3, 64) + "|" + strconv.FormatFloat(m.Size, 'f', 2, 64) + "|" + m.logoKey))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Apply draws the overlay onto img.
+func (m *Watermark) Apply(img *image.RGBA) {
+	b := img.Bounds()
+	face := basicfont.Face7x13
+	const pad = 3
+	lineH := face.Height + 2
+
+	// caption at 1x; scaled up as a whole so the bitmap font stays crisp
+	var label *image.RGBA
+	if len(m.Lines) > 0 {
+		tw := 0
+		for _, l := range m.Lines {
+			tw = max(tw, font.MeasureString(face, l).Ceil())
+		}
+		label = image.NewRGBA(image.Rect(0, 0, tw+2*pad+1, len(m.Lines)*lineH+2*pad))
+		for i, l := range m.Lines {
+			y := pad + i*lineH + face.Ascent
+			shadow := font.Drawer{Dst: label, Src: image.Black, Face: face, Dot: fixed.P(pad+1, y+1)}
+			shadow.DrawString(l)
+			text := font.Drawer{Dst: label, Src: image.White, Face: face, Dot: fixed.P(pad, y)}
+			text.DrawString(l)
+		}
+	}
+
+	short := min(b.Dx(), b.Dy())
+	margin := max(4, short/100)
+	unitH := lineH * max(1, len(m.Lines))
+	if label != nil {
+		unitH = label.Bounds().Dy()
+	}
+	// block width at a given scale: logo (as tall as the caption), gap, caption
+	blockW := func(scale int) int {
+		w := 0
+		if m.logo != nil {
+			lb := m.logo.Bounds()
+			w += int(math.Ceil(float64(lb.Dx()*unitH*scale) / float64(lb.Dy())))
+		}
+		if label != nil {
+			if w > 0 {
+				w += pad * scale
+			}
+			w += label.Bounds().Dx() * scale
+		}
+		return w
+	}
+	scale := max(1, int(math.Round(float64(short)*m.Size/100/float64(lineH))))
+	for scale > 1 && (blockW(scale) > b.Dx()-2*margin || unitH*scale > b.Dy()-2*margin) {
+		scale--
+	}
+
+	bw, bh := blockW(scale), unitH*scale
+	block := image.NewRGBA(image.Rect(0, 0, bw, bh))
+	x := 0
+	if m.logo != nil {
+		lb := m.logo.Bounds()
+		lw := int(math.Ceil(float64(lb.Dx()*bh) / float64(lb.Dy())))
+		draw.ApproxBiLinear.Scale(block, image.Rect(0, 0, lw, bh), m.logo, lb, draw.Over, nil)
+		x = lw + pad*scale
+	}
+	if label != nil {
+		// a light shade behind the text keeps it readable on bright cloud
+		r := image.Rect(x, 0, bw, bh)
+		draw.Draw(block, r, image.NewUniform(color.RGBA{A: 64}), image.Point{}, draw.Over)
+		draw.NearestNeighbor.Scale(block, r, label, label.Bounds(), draw.Over, nil)
+	}
+
+	var at image.Point
+	switch m.Position {
+	case "top-left":
+		at = image.Pt(b.Min.X+margin, b.Min.Y+margin)
+	case "top-right":
+		at = image.Pt(b.Max.X-margin-bw, b.Min.Y+margin)
+	case "bottom-left":
+		at = image.Pt(b.Min.X+margin, b.Max.Y-margin-bh)
+	case "center":
+		at = image.Pt(b.Min.X+(b.Dx()-bw)/2, b.Min.Y+(b.Dy()-bh)/2)
+	default:
+		at = image.Pt(b.Max.X-margin-bw, b.Max.Y-margin-bh)
+	}
+	mask := image.NewUniform(color.Alpha{A: uint8(math.Round(clampFloat(m.Opacity, 0, 1) * 255))})
+	draw.DrawMask(img, image.Rectangle{Min: at, Max: at.Add(image.Pt(bw, bh))}, block, image.Point{}, mask, image.Point{}, draw.Over)
+}
+
+func clampFloat(v, lo, hi float64) float64 {
+	return math.Max(lo, math.Min(hi, v))
+}
+
+// watermarked decodes data (resizing it to width×height first when that
+// differs from its size), applies m and encodes the result as format.
+func watermarked(backend imaging.Backend, data []byte, width, height int, srcW, srcH int, m *Watermark, format string, quality int) ([]byte, error) {
+	var img *image.RGBA
+	var err error
+	if width == srcW && height == srcH {
+		img, err = imaging.Decode(data)
+	}
+	if img == nil {
+		// resize (or decode a format Go can't read) through the backend
+		var raw []byte
+		raw, err = backend.Resize(data, width, height, "png", 0)
+		if err != nil {
+			return nil, err
+		}
+		img, err = imaging.Decode(raw)
+	}
+	if err != nil {
+		return nil, err
+	}
+	m.Apply(img)
+	return imaging.Encode(backend, img, format, quality)
+}
@@ -0,0 +1,592 @@
+package com
+
+import (
+	"OnlySats/com/logging"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var webhookLog = logging.For("webhooks")
+
+// ---------- Types ----------
+
+type Webhook struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	URL       string    `json:"url"`
+	Kind      string    `json:"kind"`   // generic | discord | slack
+	Events    []string  `json:"events"` // empty or "*" = all
+	Template  string    `json:"template,omitempty"`
+	Secret    string    `json:"secret,omitempty"`
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type WebhookDelivery struct {
+	ID         int64     `json:"id"`
+	WebhookID  int64     `json:"webhook_id"`
+	Timestamp  time.Time `json:"timestamp"`
+	Event      string    `json:"event"`
+	Attempt    int       `json:"attempt"`
+	Status     int       `json:"status"`
+	OK         bool      `json:"ok"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+}
+
+var webhookKinds = map[string]bool{"generic": true, "discord": true, "slack": true}
+
+// ErrBadWebhook wraps validation errors from CreateWebhook and UpdateWebhook.
+var ErrBadWebhook = errors.New("invalid webhook")
+
+// matches reports whether the webhook is subscribed to the event type.
+// Entries ending in ".*" match a whole family (e.g. "satdump.*").
+func (h *Webhook) matches(typ string) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, e := range h.Events {
+		e = strings.TrimSpace(e)
+		switch {
+		case e == "*" || e == typ:
+			return true
+		case strings.HasSuffix(e, ".*") && strings.HasPrefix(typ, strings.TrimSuffix(e, "*")):
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeWebhook(h *Webhook) error {
+	h.Name = strings.TrimSpace(h.Name)
+	h.URL = strings.TrimSpace(h.URL)
+	h.Kind = strings.ToLower(strings.TrimSpace(h.Kind))
+	if h.Kind == "" {
+		h.Kind = "generic"
+	}
+	if h.Name == "" {
+		return fmt.Errorf("%w: name required", ErrBadWebhook)
+	}
+	if !strings.HasPrefix(h.URL, "http://") && !strings.HasPrefix(h.URL, "https://") {
+		return fmt.Errorf("%w: url must be http(s)", ErrBadWebhook)
+	}
+	if !webhookKinds[h.Kind] {
+		return fmt.Errorf("%w: unknown kind %q", ErrBadWebhook, h.Kind)
+	}
+	if strings.TrimSpace(h.Template) != "" {
+		if _, err := parseWebhookTemplate(h.Template); err != nil {
+			return fmt.Errorf("%w: template: %v", ErrBadWebhook, err)
+		}
+	}
+	return nil
+}
+
+func joinEvents(ev []string) string {
+	out := make([]string, 0, len(ev))
+	for _, e := range ev {
+		if e = strings.TrimSpace(e); e != "" {
+			out = append(out, e)
+		}
+	}
+	if len(out) == 0 {
+		return "*"
+	}
+	return strings.Join(out, ",")
+}
+
+func splitEvents(s string) []string {
+	if strings.TrimSpace(s) == "" || strings.TrimSpace(s) == "*" {
+		return nil
+	}
+	parts := strings.Split(s, ",")
+	out := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// ---------- Webhooks (CRUD) ----------
+
+func (s *LocalDataStore) CreateWebhook(ctx context.Context, h Webhook) (int64, error) {
+	if err := normalizeWebhook(&h); err != nil {
+		return 0, err
+	}
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO webhooks (name, url, kind, events, template, secret, enabled)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		h.Name, h.URL, h.Kind, joinEvents(h.Events), h.Template, h.Secret, boolToInt(h.Enabled))
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (s *LocalDataStore) UpdateWebhook(ctx context.Context, h Webhook) error {
+	if err := normalizeWebhook(&h); err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE webhooks
+		SET name=?, url=?, kind=?, events=?, template=?, secret=?, enabled=?
+		WHERE id=?`,
+		h.Name, h.URL, h.Kind, joinEvents(h.Events), h.Template, h.Secret, boolToInt(h.Enabled), h.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func scanWebhook(sc interface{ Scan(...any) error }) (*Webhook, error) {
+	var h Webhook
+	var events string
+	var tpl, secret sql.NullString
+	var enabled int
+	var created int64
+	if err := sc.Scan(&h.ID, &h.Name, &h.URL, &h.Kind, &events, &tpl, &secret, &enabled, &created); err != nil {
+		return nil, err
+	}
+	h.Events = splitEvents(events)
+	h.Template = tpl.String
+	h.Secret = secret.String
+	h.Enabled = enabled != 0
+	h.CreatedAt = time.Unix(created, 0).UTC()
+	return &h, nil
+}
+
+func (s *LocalDataStore) GetWebhook(ctx context.Context, id int64) (*Webhook, error) {
+	row := s.db.QueryRowContext(ctx, `
+		SELECT id, name, url, kind, events, template, secret, enabled, created_ts
+		FROM webhooks WHERE id=?`, id)
+	return scanWebhook(row)
+}
+
+func (s *LocalDataStore) ListWebhooks(ctx context.Context) ([]Webhook, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, name, url, kind, events, template, secret, enabled, created_ts
+		FROM webhooks ORDER BY name, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Webhook
+	for rows.Next() {
+		h, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *h)
+	}
+	return out, rows.Err()
+}
+
+func (s *LocalDataStore) DeleteWebhook(ctx context.Context, id int64) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM webhooks WHERE id=?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ---------- Delivery log ----------
+
+func (s *LocalDataStore) AddWebhookDelivery(ctx context.Context, d WebhookDelivery) error {
+	if d.Timestamp.IsZero() {
+		d.Timestamp = time.Now()
+	}
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO webhook_deliveries (webhook_id, ts, event, attempt, status, ok, error, duration_ms)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		d.WebhookID, d.Timestamp.Unix(), d.Event, d.Attempt, d.Status, boolToInt(d.OK), d.Error, d.DurationMs)
+	return err
+}
+
+func (s *LocalDataStore) ListWebhookDeliveries(ctx context.Context, webhookID int64, limit int) ([]WebhookDelivery, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, webhook_id, ts, event, attempt, IFNULL(status, 0), ok, IFNULL(error, ''), IFNULL(duration_ms, 0)
+		FROM webhook_deliveries
+		WHERE webhook_id = ?
+		ORDER BY ts DESC, id DESC
+		LIMIT ?`, webhookID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []WebhookDelivery
+	for rows.Next() {
+		var d WebhookDelivery
+		var unix int64
+		var ok int
+		if err := rows.Scan(&d.ID, &d.WebhookID, &unix, &d.Event, &d.Attempt, &d.Status, &ok, &d.Error, &d.DurationMs); err != nil {
+			return nil, err
+		}
+		d.Timestamp = time.Unix(unix, 0).UTC()
+		d.OK = ok != 0
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+func (s *LocalDataStore) PruneWebhookDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE ts < ?`, before.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// ---------- Templates ----------
+
+// default bodies per kind; generic sends the raw event.
+const (
+	discordTemplate = `{"username":"OnlySats","content":{{ json (summary .) }}}`
+	slackTemplate   = `{"text":{{ json (summary .) }}}`
+)
+
+type webhookTplData struct {
+	Event
+	Station string
+}
+
+func parseWebhookTemplate(src string) (*template.Template, error) {
+	return template.New("webhook").Funcs(template.FuncMap{
+		"json": func(v any) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+		"summary": eventSummary,
+		"unix":    func(t time.Time) int64 { return t.Unix() },
+	}).Parse(src)
+}
+
+// one-line human readable description of an event, used by chat templates.
+func eventSummary(d webhookTplData) string {
+	prefix := ""
+	if d.Station != "" {
+		prefix = "[" + d.Station + "] "
+	}
+	str := func(k string) string {
+		if v, ok := d.Data[k]; ok && v != nil {
+			return fmt.Sprint(v)
+		}
+		return ""
+	}
+	switch d.Type {
+	case EventPassIngested:
+		return fmt.Sprintf("%sNew pass: %s (%s, %s images)", prefix, str("satellite"), str("name"), str("images"))
+	case EventMessagePosted:
+		return fmt.Sprintf("%sNew message: %s", prefix, str("title"))
+	case EventSatdumpOffline:
+		return fmt.Sprintf("%sSatDump %s went offline (%s)", prefix, str("instance"), str("error"))
+	case EventSatdumpOnline:
+		return fmt.Sprintf("%sSatDump %s is back online after %s", prefix, str("instance"), str("downtime"))
+	case EventAlertRaised:
+		return fmt.Sprintf("%s[%s] %s: %s", prefix, strings.ToUpper(str("severity")), str("title"), str("message"))
+	case EventAlertResolved:
+		return fmt.Sprintf("%sResolved: %s (after %s)", prefix, str("title"), str("duration"))
+	case EventLoginLockout:
+		return fmt.Sprintf("%sLogin lockout: %s %s after %s failed attempts, until %s", prefix, str("kind"), str("subject"), str("failures"), str("until"))
+	case EventTest:
+		return prefix + "Test event from OnlySats"
+	}
+	if t := str("title"); t != "" {
+		return prefix + t
+	}
+	return prefix + d.Type
+}
+
+func renderWebhookBody(h *Webhook, station string, ev Event) ([]byte, error) {
+	src := strings.TrimSpace(h.Template)
+	if src == "" {
+		switch h.Kind {
+		case "discord":
+			src = discordTemplate
+		case "slack":
+			src = slackTemplate
+		default:
+			return json.Marshal(webhookTplData{Event: ev, Station: station})
+		}
+	}
+	tpl, err := parseWebhookTemplate(src)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, webhookTplData{Event: ev, Station: station}); err != nil {
+		return nil, err
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, errors.New("template did not produce valid JSON")
+	}
+	return buf.Bytes(), nil
+}
+
+// SignWebhook returns the hex HMAC-SHA256 of "<ts>.<body>" keyed by secret.
+func SignWebhook(secret string, ts int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(ts, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ---------- Dispatcher ----------
+
+type webhookJob struct {
+	hookID  int64
+	ev      Event
+	attempt int
+}
+
+// delivers bus events to configured webhooks with retry and backoff.
+type WebhookDispatcher struct {
+	Store       *LocalDataStore
+	Station     string
+	Client      *http.Client
+	Workers     int
+	MaxAttempts int
+	BaseBackoff time.Duration
+
+	queue  chan webhookJob
+	events chan Event
+	ctx    context.Context
+}
+
+func NewWebhookDispatcher(store *LocalDataStore, station string) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		Store:       store,
+		Station:     strings.TrimSpace(station),
+		Client:      &http.Client{Timeout: 10 * time.Second},
+		Workers:     2,
+		MaxAttempts: 5,
+		BaseBackoff: 5 * time.Second,
+		queue:       make(chan webhookJob, 256),
+		events:      make(chan Event, 64),
+	}
+}
+
+// starts the workers and subscribes to the event bus. Returns immediately.
+func (d *WebhookDispatcher) Start(ctx context.Context) {
+	d.ctx = ctx
+	workers := d.Workers
+	if workers <= 0 {
+		workers = 2
+	}
+	for i := 0; i < workers; i++ {
+		go d.worker(ctx)
+	}
+	go d.pruneLoop(ctx)
+	go d.fanOut(ctx)
+	SubscribeEvents(d.enqueueEvent)
+}
+
+// enqueueEvent is the bus subscriber; it only hands the event over, the
+// webhook lookup runs in fanOut so publishers never wait on the database.
+func (d *WebhookDispatcher) enqueueEvent(ev Event) {
+	if d.ctx == nil || d.ctx.Err() != nil {
+		return
+	}
+	select {
+	case d.events <- ev:
+	default:
+		webhookLog.Warn("event queue full, dropping event", "event", ev.Type)
+	}
+}
+
+func (d *WebhookDispatcher) fanOut(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-d.events:
+			d.dispatch(ctx, ev)
+		}
+	}
+}
+
+// dispatch queues one delivery job per enabled webhook subscribed to ev.
+func (d *WebhookDispatcher) dispatch(ctx context.Context, ev Event) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	hooks, err := d.Store.ListWebhooks(ctx)
+	if err != nil {
+		webhookLog.Error("list webhooks", "err", err)
+		return
+	}
+	for i := range hooks {
+		h := &hooks[i]
+		if !h.Enabled || !h.matches(ev.Type) {
+			continue
+		}
+		d.push(webhookJob{hookID: h.ID, ev: ev, attempt: 1})
+	}
+}
+
+func (d *WebhookDispatcher) push(j webhookJob) {
+	select {
+	case d.queue <- j:
+	default:
+		webhookLog.Warn("delivery queue full, dropping job", "event", j.ev.Type, "webhook", j.hookID)
+	}
+}
+
+func (d *WebhookDispatcher) worker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case j := <-d.queue:
+			h, err := d.Store.GetWebhook(ctx, j.hookID)
+			if err != nil || !h.Enabled {
+				continue // deleted or disabled since queuing
+			}
+			del := d.deliver(ctx, h, j.ev, j.attempt)
+			if del.OK || j.attempt >= d.maxAttempts() {
+				if !del.OK {
+					webhookLog.Warn("delivery gave up", "event", j.ev.Type, "webhook", h.Name, "attempts", j.attempt, "err", del.Error)
+				}
+				continue
+			}
+			next := j
+			next.attempt++
+			time.AfterFunc(d.backoff(j.attempt), func() {
+				if ctx.Err() == nil {
+					d.push(next)
+				}
+			})
+		}
+	}
+}
+
+func (d *WebhookDispatcher) maxAttempts() int {
+	if d.MaxAttempts <= 0 {
+		return 1
+	}
+	return d.MaxAttempts
+}
+
+// exponential: base, 2*base, 4*base ... capped at 10 minutes
+func (d *WebhookDispatcher) backoff(attempt int) time.Duration {
+	base := d.BaseBackoff
+	if base <= 0 {
+		base = 5 * time.Second
+	}
+	b := base << (attempt - 1)
+	if b <= 0 || b > 10*time.Minute {
+		b = 10 * time.Minute
+	}
+	return b
+}
+
+// performs a single delivery attempt and records it in the delivery log.
+func (d *WebhookDispatcher) deliver(ctx context.Context, h *Webhook, ev Event, attempt int) WebhookDelivery {
+	del := WebhookDelivery{
+		WebhookID: h.ID,
+		Timestamp: time.Now().UTC(),
+		Event:     ev.Type,
+		Attempt:   attempt,
+	}
+	start := time.Now()
+	status, err := d.post(ctx, h, ev)
+	del.DurationMs = time.Since(start).Milliseconds()
+	del.Status = status
+	if err != nil {
+		del.Error = err.Error()
+	} else {
+		del.OK = true
+	}
+	if lerr := d.Store.AddWebhookDelivery(context.Background(), del); lerr != nil {
+		webhookLog.Warn("record delivery", "err", lerr)
+	}
+	return del
+}
+
+func (d *WebhookDispatcher) post(ctx context.Context, h *Webhook, ev Event) (int, error) {
+	body, err := renderWebhookBody(h, d.Station, ev)
+	if err != nil {
+		return 0, fmt.Errorf("render: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	ts := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "OnlySats-Webhook/1")
+	req.Header.Set("X-OnlySats-Event", ev.Type)
+	req.Header.Set("X-OnlySats-Timestamp", strconv.FormatInt(ts, 10))
+	if h.Secret != "" {
+		req.Header.Set("X-OnlySats-Signature", "sha256="+SignWebhook(h.Secret, ts, body))
+	}
+
+	client := d.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return resp.StatusCode, fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	return resp.StatusCode, nil
+}
+
+// sends a test event to one webhook synchronously (single attempt, no retry).
+func (d *WebhookDispatcher) SendTest(ctx context.Context, id int64) (WebhookDelivery, error) {
+	h, err := d.Store.GetWebhook(ctx, id)
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+	ev := Event{
+		Type: EventTest,
+		Time: time.Now().UTC(),
+		Data: map[string]any{"title": "Test event", "message": "If you can read this, the webhook works."},
+	}
+	return d.deliver(ctx, h, ev, 1), nil
+}
+
+func (d *WebhookDispatcher) pruneLoop(ctx context.Context) {
+	t := time.NewTicker(6 * time.Hour)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if _, err := d.Store.PruneWebhookDeliveries(ctx, time.Now().Add(-30*24*time.Hour)); err != nil {
+				webhookLog.Warn("prune deliveries", "err", err)
+			}
+		}
+	}
+}
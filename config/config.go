@@ -15,6 +15,13 @@ type AppConfig struct {
 	Paths        PathsConfig        `toml:"paths"`
 	Thumbgen     ThumbgenConfig     `toml:"thumbgen"`
 	StationProxy StationProxyConfig `toml:"stationproxy"`
+	SMTP         SMTPConfig         `toml:"smtp"`
+	Metrics      MetricsConfig      `toml:"metrics"`
+	Logging      LoggingConfig      `toml:"logging"`
+	Derivatives  DerivativesConfig  `toml:"derivatives"`
+	Animations   AnimationsConfig   `toml:"animations"`
+	EmbedMeta    EmbedMetaConfig    `toml:"image_metadata"`
+	Exports      ExportsConfig      `toml:"exports"`
 }
 
 type PassConfig struct {
@@ -26,10 +33,11 @@ type PassConfig struct {
 // App Config Sections
 
 type ServerConfig struct {
-	Port         string `toml:"port"`
-	ReadTimeout  int    `toml:"read_timeout"`
-	WriteTimeout int    `toml:"write_timeout"`
-	LogLevel     string `toml:"log_level"`
+	Port           string   `toml:"port"`
+	ReadTimeout    int      `toml:"read_timeout"`
+	WriteTimeout   int      `toml:"write_timeout"`
+	LogLevel       string   `toml:"log_level"`
+	TrustedProxies []string `toml:"trusted_proxies"` // IPs or CIDRs whose X-Forwarded-For is believed
 }
 
 type DatabaseConfig struct {
@@ -47,10 +55,36 @@ type PathsConfig struct {
 }
 
 type ThumbgenConfig struct {
-	MaxWorkers     int `toml:"max_workers"`
-	BatchSize      int `toml:"batch_size"`
-	ThumbnailWidth int `toml:"thumbnail_width"`
-	Quality        int `toml:"quality"`
+	MaxWorkers     int               `toml:"max_workers"`
+	BatchSize      int               `toml:"batch_size"`
+	ThumbnailWidth int               `toml:"thumbnail_width"`
+	Quality        int               `toml:"quality"`
+	Renditions     []RenditionConfig `toml:"renditions"`
+
+	OnDemand        bool   `toml:"on_demand"`         // generate missing thumbnails when first requested
+	OnDemandWorkers int    `toml:"on_demand_workers"` // concurrent on-demand encodes
+	Backend         string `toml:"backend"`           // auto | vips | go
+	GCIntervalHours int    `toml:"gc_interval_hours"` // thumbnail cache cleanup; 0 disables
+
+	Tiles TilesConfig `toml:"tiles"`
+}
+
+// TilesConfig builds deep-zoom tile pyramids for large images, served at /tiles/{id}/{z}/{x}/{y}
+type TilesConfig struct {
+	Enabled  bool   `toml:"enabled"`
+	MinSize  int    `toml:"min_size"`  // px; images whose longer side is smaller get no pyramid
+	TileSize int    `toml:"tile_size"` // px
+	Format   string `toml:"format"`    // webp | png | jpeg
+	Quality  int    `toml:"quality"`
+	Dir      string `toml:"dir"` // empty uses <data_dir>/tiles
+}
+
+// RenditionConfig is an extra thumbnail size, served at /thumbnails/{name}/...
+type RenditionConfig struct {
+	Name    string `toml:"name"`    // URL segment, e.g. "480"
+	Width   int    `toml:"width"`   // px; never upscaled past the original
+	Format  string `toml:"format"`  // webp | jpeg | avif
+	Quality int    `toml:"quality"` // 0 uses thumbgen.quality
 }
 
 type StationProxyConfig struct {
@@ -61,6 +95,79 @@ type StationProxyConfig struct {
 	FrpsPort      int    `toml:"frps_port"`
 }
 
+type SMTPConfig struct {
+	Enabled     bool   `toml:"enabled"`
+	Host        string `toml:"host"`
+	Port        int    `toml:"port"`
+	Security    string `toml:"security"` // starttls | tls | none
+	Username    string `toml:"username"`
+	Password    string `toml:"password"`
+	From        string `toml:"from"`
+	SkipVerify  bool   `toml:"skip_verify"`
+	BaseURL     string `toml:"base_url"`     // public URL used for links in emails
+	SummaryHour int    `toml:"summary_hour"` // local hour the daily summary goes out
+}
+
+type MetricsConfig struct {
+	Enabled bool   `toml:"enabled"`
+	Token   string `toml:"token"` // optional bearer token required to scrape /metrics
+}
+
+type LoggingConfig struct {
+	Level      string            `toml:"level"`       // debug | info | warn | error
+	Format     string            `toml:"format"`      // text | json
+	File       string            `toml:"file"`        // relative to paths.log_dir; empty disables file output
+	MaxSize    int               `toml:"max_size"`    // megabytes before the file is rotated
+	MaxBackups int               `toml:"max_backups"` // rotated files to keep
+	MaxAge     int               `toml:"max_age"`     // days to keep rotated files
+	Compress   bool              `toml:"compress"`    // gzip rotated files
+	Console    bool              `toml:"console"`     // also write to stderr
+	Components map[string]string `toml:"components"`  // per-component level overrides, e.g. thumbgen = "debug"
+}
+
+// DerivativesConfig controls resized copies of originals served at /images/...?w=
+type DerivativesConfig struct {
+	Enabled  bool   `toml:"enabled"`
+	CacheDir string `toml:"cache_dir"` // empty uses <data_dir>/derivatives
+	MaxSize  int    `toml:"max_size"`  // megabytes; least recently used files are evicted past it
+	Widths   []int  `toml:"widths"`    // allowed ?w= values
+	Quality  int    `toml:"quality"`   // used when ?q= is absent
+	Workers  int    `toml:"workers"`   // concurrent encodes
+}
+
+// AnimationsConfig controls timelapse GIF/WebP rendering at /api/animations
+type AnimationsConfig struct {
+	Enabled   bool   `toml:"enabled"`
+	Dir       string `toml:"dir"`        // empty uses <data_dir>/animations
+	Width     int    `toml:"width"`      // px; used when a request gives none
+	MaxWidth  int    `toml:"max_width"`  // px; larger requests are clamped
+	MaxFrames int    `toml:"max_frames"` // requests matching more are rejected
+	DelayMs   int    `toml:"delay_ms"`   // per frame, when a request gives none
+	Quality   int    `toml:"quality"`    // WebP only
+}
+
+// EmbedMetaConfig controls capture details written into downloaded images
+type EmbedMetaConfig struct {
+	Enabled     bool   `toml:"enabled"`
+	Default     bool   `toml:"default"`     // embed unless ?meta=0; otherwise only with ?meta=1
+	Station     string `toml:"station"`     // empty uses about_meta "name", then stationproxy.station_name
+	License     string `toml:"license"`     // e.g. "CC BY 4.0"
+	Attribution string `toml:"attribution"` // credit line, e.g. "Received by <station>"
+}
+
+// ExportsConfig limits bulk ZIP exports of gallery search results at /api/exports
+type ExportsConfig struct {
+	Enabled      bool   `toml:"enabled"`
+	Dir          string `toml:"dir"`           // empty uses <data_dir>/exports
+	MaxFiles     int    `toml:"max_files"`     // per export
+	MaxSize      int    `toml:"max_size"`      // MB of source files per export
+	SyncMaxSize  int    `toml:"sync_max_size"` // MB; smaller exports may be streamed straight away
+	MaxQueued    int    `toml:"max_queued"`    // jobs waiting or being built, all clients together
+	PerClient    int    `toml:"per_client"`    // unfinished jobs per client IP
+	MaxDownloads int    `toml:"max_downloads"` // archives streamed or downloaded at once
+	KeepHours    int    `toml:"keep_hours"`    // finished archives are deleted after this
+}
+
 // Pass Config Structures
 
 type ImageDirConfig struct {
@@ -87,9 +194,10 @@ type PassesConfig struct {
 func DefaultConfig() (*AppConfig, *PassConfig) {
 	return &AppConfig{
 			Server: ServerConfig{
-				Port:         ":1500",
-				ReadTimeout:  30,
-				WriteTimeout: 30,
+				Port:           ":1500",
+				ReadTimeout:    30,
+				WriteTimeout:   30,
+				TrustedProxies: []string{"127.0.0.1", "::1"},
 			},
 			Database: DatabaseConfig{
 				MaxOpenConns:    1,
@@ -104,10 +212,66 @@ func DefaultConfig() (*AppConfig, *PassConfig) {
 				LogDir:        "logs",
 			},
 			Thumbgen: ThumbgenConfig{
-				MaxWorkers:     4,
-				BatchSize:      1000,
-				ThumbnailWidth: 200,
-				Quality:        75,
+				MaxWorkers:      4,
+				BatchSize:       1000,
+				ThumbnailWidth:  200,
+				Quality:         75,
+				OnDemand:        true,
+				OnDemandWorkers: 2,
+				Backend:         "auto",
+				GCIntervalHours: 24,
+				Tiles: TilesConfig{
+					MinSize:  4096,
+					TileSize: 256,
+					Format:   "webp",
+					Quality:  85,
+				},
+			},
+			SMTP: SMTPConfig{
+				Port:        587,
+				Security:    "starttls",
+				SummaryHour: 7,
+			},
+			Metrics: MetricsConfig{
+				Enabled: false, // exposes hardware, disk and DB sizes; opt in
+			},
+			Logging: LoggingConfig{
+				Level:      "info",
+				Format:     "text",
+				File:       "app.log",
+				MaxSize:    100,
+				MaxBackups: 3,
+				MaxAge:     28,
+				Compress:   true,
+				Console:    true,
+			},
+			Derivatives: DerivativesConfig{
+				Enabled: true,
+				MaxSize: 1024,
+				Widths:  []int{480, 800, 1280, 1920, 2560},
+				Quality: 80,
+				Workers: 2,
+			},
+			Animations: AnimationsConfig{
+				Enabled:   true,
+				Width:     800,
+				MaxWidth:  1280,
+				MaxFrames: 240,
+				DelayMs:   200,
+				Quality:   75,
+			},
+			EmbedMeta: EmbedMetaConfig{
+				Enabled: true,
+			},
+			Exports: ExportsConfig{
+				Enabled:      true,
+				MaxFiles:     5000,
+				MaxSize:      4096,
+				SyncMaxSize:  200,
+				MaxQueued:    20,
+				PerClient:    2,
+				MaxDownloads: 2,
+				KeepHours:    24,
 			},
 		}, &PassConfig{
 			Composites: map[string]string{},
@@ -3,11 +3,13 @@ package handlers
 import (
 	"OnlySats/com"
 	"OnlySats/com/shared"
+	"database/sql"
 	"encoding/json"
-	"io/fs"
+	"errors"
 	"net/http"
 	"path/filepath"
-	"time"
+	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 )
@@ -26,45 +28,24 @@ func ServeDiskStats(liveOutput string) http.HandlerFunc {
 			return
 		}
 
-		total, free, err := diskTotalsForPath(absRoot) // implemented per-OS in files below
-		if err != nil || total == 0 {
+		est, err := shared.EstimateDiskRunway(absRoot)
+		if err != nil || est.Total == 0 {
 			http.Error(w, `{"error":"Unable to retrieve disk stats"}`, http.StatusInternalServerError)
 			return
 		}
 
-		now := time.Now()
-		cutoff := now.Add(-14 * 24 * time.Hour)
-
-		fullSize := dirSize(absRoot, false, time.Time{})
-		recentSize := dirSize(absRoot, true, cutoff)
-
-		allocSize := fullSize + free
-
-		retentionDays := 9999
-		timeToFullDays := 9999
-		if recentSize > 0 {
-			retentionDays = int((float64(allocSize) / float64(recentSize)) * 14.0)
-			timeToFullDays = int((float64(free) / float64(recentSize)) * 14.0)
-			if retentionDays < 0 {
-				retentionDays = 0
-			}
-			if timeToFullDays < 0 {
-				timeToFullDays = 0
-			}
-		}
-
 		resp := map[string]any{
 			"disk": map[string]uint64{
-				"total": total,
-				"free":  free,
+				"total": est.Total,
+				"free":  est.Free,
 			},
 			"live_output": map[string]uint64{
-				"totalSize":  fullSize,
-				"recentSize": recentSize,
+				"totalSize":  est.FullSize,
+				"recentSize": est.RecentSize,
 			},
 			"estimates": map[string]int{
-				"dataRetentionDays":  retentionDays,
-				"timeToDiskFullDays": timeToFullDays,
+				"dataRetentionDays":  est.RetentionDays,
+				"timeToDiskFullDays": est.TimeToFullDays,
 			},
 		}
 
@@ -73,30 +54,11 @@ func ServeDiskStats(liveOutput string) http.HandlerFunc {
 	}
 }
 
-func dirSize(root string, recentOnly bool, cutoff time.Time) uint64 {
-	var total uint64 = 0
-	filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return nil // skip unreadable
-		}
-		if d.IsDir() {
-			return nil
-		}
-		info, ierr := d.Info()
-		if ierr != nil {
-			return nil
-		}
-		if recentOnly && info.ModTime().Before(cutoff) {
-			return nil
-		}
-		total += uint64(info.Size())
-		return nil
-	})
-	return total
-}
-
 type UsersHandler struct {
-	Store *com.LocalDataStore
+	Store  *com.LocalDataStore
+	Mailer *com.Mailer
+	Logins *com.LoginGuard
+	User   func(r *http.Request) string // signed-in admin, recorded on unlocks
 }
 
 type userRow struct {
@@ -128,10 +90,13 @@ type setLevelReq struct {
 type resetPasswordReq struct {
 	Generate    bool    `json:"generate"`
 	NewPassword *string `json:"newPassword,omitempty"`
+	Email       bool    `json:"email"` // also mail the new password to the user
 }
 
 type resetPasswordResp struct {
 	NewPassword string `json:"newPassword"`
+	Emailed     bool   `json:"emailed"`
+	EmailError  string `json:"emailError,omitempty"`
 }
 
 func (h *UsersHandler) List(w http.ResponseWriter, r *http.Request) {
@@ -249,6 +214,145 @@ func (h *UsersHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "failed to reset password", http.StatusInternalServerError)
 		return
 	}
+	resp := resetPasswordResp{NewPassword: pw}
+	if req.Email {
+		if err := h.sendResetEmail(r, id, pw); err != nil {
+			resp.EmailError = err.Error()
+		} else {
+			resp.Emailed = true
+		}
+	}
 	// Return the password once so the admin can deliver it out-of-band.
-	writeJSON(w, http.StatusOK, resetPasswordResp{NewPassword: pw})
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *UsersHandler) sendResetEmail(r *http.Request, id int64, pw string) error {
+	if !h.Mailer.Enabled() {
+		return com.ErrMailDisabled
+	}
+	u, err := h.lookupUser(r, id)
+	if err != nil {
+		return err
+	}
+	return h.Mailer.SendPasswordReset(r.Context(), id, u.Username, pw)
+}
+
+func (h *UsersHandler) lookupUser(r *http.Request, id int64) (*com.UserRow, error) {
+	users, err := h.Store.ListUsers(r.Context())
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		if u.ID == id {
+			return &u, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (h *UsersHandler) unlockedBy(r *http.Request) string {
+	if h.User == nil {
+		return ""
+	}
+	return h.User(r)
+}
+
+// GET /local/api/users/lockouts?limit=50 lists running lockouts and the
+// most recent ones, unlocked or expired included.
+func (h *UsersHandler) Lockouts(w http.ResponseWriter, r *http.Request) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	active, recent, err := h.Logins.Lockouts(r.Context(), limit)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"active": active, "recent": recent})
+}
+
+// POST /local/api/users/{id}/unlock lifts the lockout and backoff of the account.
+func (h *UsersHandler) Unlock(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(mux.Vars(r), "id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	u, err := h.lookupUser(r, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			notFound(w, "user not found")
+			return
+		}
+		serverErr(w, err)
+		return
+	}
+	held, err := h.Logins.Unlock(r.Context(), "user", u.Username, h.unlockedBy(r))
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "wasLocked": held})
+}
+
+// POST /local/api/users/lockouts/unlock {"ip": "..."} lifts the lockout of
+// a client address.
+func (h *UsersHandler) UnlockIP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		IP string `json:"ip"`
+	}
+	if json.NewDecoder(r.Body).Decode(&req) != nil || strings.TrimSpace(req.IP) == "" {
+		http.Error(w, "ip required", http.StatusBadRequest)
+		return
+	}
+	held, err := h.Logins.Unlock(r.Context(), "ip", strings.TrimSpace(req.IP), h.unlockedBy(r))
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "wasLocked": held})
+}
+
+// GET /local/api/users/{id}/notifications
+func (h *UsersHandler) GetNotifications(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(mux.Vars(r), "id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	prefs, err := h.Store.GetNotifyPrefs(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "failed to load preferences", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, prefs)
+}
+
+// PUT /local/api/users/{id}/notifications
+func (h *UsersHandler) SetNotifications(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(mux.Vars(r), "id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var req com.NotifyPrefs
+	if json.NewDecoder(r.Body).Decode(&req) != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if (req.NotifyAlerts || req.NotifySummary) && req.Email == "" {
+		http.Error(w, "an email address is required to enable notifications", http.StatusBadRequest)
+		return
+	}
+	if err := h.Store.SetNotifyPrefs(r.Context(), id, req); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
 }
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"OnlySats/com"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// admin API for alert rules and the alerts they raise.
+type AlertsHandler struct {
+	Store  *com.LocalDataStore
+	Engine *com.AlertEngine
+}
+
+type alertRuleReq struct {
+	Name        string  `json:"name"`
+	Kind        string  `json:"kind"`
+	Target      string  `json:"target"`
+	Threshold   float64 `json:"threshold"`
+	DurationSec int     `json:"duration_sec"`
+	Severity    string  `json:"severity"`
+	Enabled     *bool   `json:"enabled,omitempty"`
+	PostMessage bool    `json:"post_message"`
+}
+
+func (in alertRuleReq) apply(r *com.AlertRule) {
+	r.Name = in.Name
+	r.Kind = in.Kind
+	r.Target = in.Target
+	r.Threshold = in.Threshold
+	r.DurationSec = in.DurationSec
+	r.Severity = in.Severity
+	r.PostMessage = in.PostMessage
+	if in.Enabled != nil {
+		r.Enabled = *in.Enabled
+	}
+}
+
+func (h *AlertsHandler) ListRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := h.Store.ListAlertRules(r.Context())
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rules)
+}
+
+func (h *AlertsHandler) CreateRule(w http.ResponseWriter, r *http.Request) {
+	var in alertRuleReq
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		badRequest(w, "invalid json")
+		return
+	}
+	rule := com.AlertRule{Enabled: true}
+	in.apply(&rule)
+	id, err := h.Store.CreateAlertRule(r.Context(), rule)
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	out, err := h.Store.GetAlertRule(r.Context(), id)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, out)
+}
+
+func (h *AlertsHandler) UpdateRule(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(mux.Vars(r), "id")
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	cur, err := h.Store.GetAlertRule(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			notFound(w, "rule not found")
+			return
+		}
+		serverErr(w, err)
+		return
+	}
+	var in alertRuleReq
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		badRequest(w, "invalid json")
+		return
+	}
+	in.apply(cur)
+	if err := h.Store.UpdateAlertRule(r.Context(), *cur); err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	out, err := h.Store.GetAlertRule(r.Context(), id)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (h *AlertsHandler) DeleteRule(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(mux.Vars(r), "id")
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	if err := h.Store.DeleteAlertRule(r.Context(), id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			notFound(w, "rule not found")
+			return
+		}
+		serverErr(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET /local/api/alerts?state=active|resolved&limit=N
+func (h *AlertsHandler) List(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	state := q.Get("state")
+	if state != "" && state != "active" && state != "resolved" {
+		badRequest(w, "state must be active or resolved")
+		return
+	}
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	alerts, err := h.Store.ListAlerts(r.Context(), state, limit)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	resp := map[string]any{"alerts": alerts}
+	if h.Engine != nil {
+		if t := h.Engine.LastEvaluated(); !t.IsZero() {
+			resp["lastEvaluated"] = t.UTC()
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// POST /local/api/alerts/{id}/ack
+func (h *AlertsHandler) Ack(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(mux.Vars(r), "id")
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	if err := h.Store.AckAlert(r.Context(), id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			notFound(w, "alert not found")
+			return
+		}
+		serverErr(w, err)
+		return
+	}
+	a, err := h.Store.GetAlert(r.Context(), id)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, a)
+}
+
+// POST /local/api/alerts/evaluate runs the rules now instead of waiting for the next tick.
+func (h *AlertsHandler) Evaluate(w http.ResponseWriter, r *http.Request) {
+	if h.Engine == nil {
+		http.Error(w, "alerts not running", http.StatusServiceUnavailable)
+		return
+	}
+	if err := h.Engine.Evaluate(r.Context()); err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
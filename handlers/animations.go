@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"OnlySats/com"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// timelapse animations rendered by com.Animator.
+type AnimationsHandler struct {
+	Store    *com.LocalDataStore
+	Animator *com.Animator
+}
+
+// GET /api/animations?limit=50 lists animations, newest first.
+func (h *AnimationsHandler) List(w http.ResponseWriter, r *http.Request) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	list, err := h.Store.ListAnimations(r.Context(), limit)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"animations": list, "formats": h.Animator.Formats()})
+}
+
+// GET /api/animations/{id} reports status and progress; url is set once done.
+func (h *AnimationsHandler) Get(w http.ResponseWriter, r *http.Request) {
+	a, ok := h.load(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, a)
+}
+
+// GET /api/animations/{id}/file
+func (h *AnimationsHandler) File(w http.ResponseWriter, r *http.Request) {
+	a, ok := h.load(w, r)
+	if !ok {
+		return
+	}
+	if a.Status != "done" {
+		http.Error(w, "animation is "+a.Status, http.StatusConflict)
+		return
+	}
+	f, err := os.Open(h.Animator.Path(a))
+	if err != nil {
+		if os.IsNotExist(err) {
+			notFound(w, "animation file missing")
+			return
+		}
+		serverErr(w, err)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", a.ContentType())
+	// ids are never reused, so a finished file never changes
+	w.Header().Set("Cache-Control", "public, max-age=604800, immutable")
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
+
+// POST /local/api/animations queues a render (202), or returns an earlier
+// animation of the same images and settings (200).
+func (h *AnimationsHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var in com.AnimationRequest
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		badRequest(w, "invalid json")
+		return
+	}
+	a, created, err := h.Animator.Create(r.Context(), in)
+	if err != nil {
+		if errors.Is(err, com.ErrBadAnimation) {
+			badRequest(w, err.Error())
+			return
+		}
+		serverErr(w, err)
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusAccepted
+	}
+	writeJSON(w, status, a)
+}
+
+// DELETE /local/api/animations/{id}
+func (h *AnimationsHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(mux.Vars(r), "id")
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	switch err := h.Animator.Delete(r.Context(), id); {
+	case errors.Is(err, sql.ErrNoRows):
+		notFound(w, "animation not found")
+	case errors.Is(err, com.ErrAnimationBusy):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		serverErr(w, err)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (h *AnimationsHandler) load(w http.ResponseWriter, r *http.Request) (*com.Animation, bool) {
+	id, err := parseID(mux.Vars(r), "id")
+	if err != nil {
+		badRequest(w, err.Error())
+		return nil, false
+	}
+	a, err := h.Store.GetAnimation(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			notFound(w, "animation not found")
+			return nil, false
+		}
+		serverErr(w, err)
+		return nil, false
+	}
+	return a, true
+}
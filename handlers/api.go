@@ -7,15 +7,18 @@ import (
 	"strconv"
 	"strings"
 
+	"OnlySats/com"
 	"OnlySats/com/shared"
 )
 
 type APIHandler struct {
-	DB *shared.Database
+	DB         *shared.Database
+	Renditions []com.Rendition
+	Tiles      bool // tile pyramids enabled; images that have one get a Tiles URL
 }
 
-func NewAPIHandler(db *shared.Database) *APIHandler {
-	return &APIHandler{DB: db}
+func NewAPIHandler(db *shared.Database, rends []com.Rendition) *APIHandler {
+	return &APIHandler{DB: db, Renditions: rends}
 }
 
 type GalleryImage struct {
@@ -32,13 +35,22 @@ type GalleryImage struct {
 	Satellite   string  `json:"satellite"`
 	Name        string  `json:"name"`
 	RawDataPath *string `json:"rawDataPath"`
+
+	// rendition name -> URL, and the same as an <img srcset> value
+	Thumbnails map[string]string `json:"thumbnails,omitempty"`
+	Srcset     string            `json:"srcset,omitempty"`
+
+	// deep-zoom descriptor, set when a tile pyramid exists
+	HasTiles bool   `json:"-"`
+	Tiles    string `json:"tiles,omitempty"`
 }
 
 type ImageResponse struct {
-	Images []GalleryImage `json:"images"`
-	Total  int            `json:"total"`
-	Page   int            `json:"page"`
-	Limit  int            `json:"limit"`
+	Images     []GalleryImage  `json:"images"`
+	Total      int             `json:"total"`
+	Page       int             `json:"page"`
+	Limit      int             `json:"limit"`
+	Renditions []com.Rendition `json:"renditions,omitempty"`
 }
 
 type QueryFilters struct {
@@ -89,17 +101,36 @@ func (h *APIHandler) GetImages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if len(h.Renditions) > 0 {
+		for i := range images {
+			images[i].Thumbnails, images[i].Srcset = com.ThumbURLs(h.Renditions, images[i].Path)
+		}
+	}
+	if h.Tiles {
+		for i := range images {
+			if images[i].HasTiles {
+				images[i].Tiles = fmt.Sprintf("/tiles/%d/info.json", images[i].ID)
+			}
+		}
+	}
+
 	resp := ImageResponse{
-		Images: images,
-		Total:  total,
-		Page:   f.Page,
-		Limit:  f.Limit,
+		Images:     images,
+		Total:      total,
+		Page:       f.Page,
+		Limit:      f.Limit,
+		Renditions: h.Renditions,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(resp)
 }
 
+// GET /api/thumbnails lists the thumbnail renditions this station serves.
+func (h *APIHandler) GetRenditions(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"renditions": h.Renditions})
+}
+
 // Filters & WHERE
 
 func (h *APIHandler) parseQueryFilters(r *http.Request) QueryFilters {
@@ -294,7 +325,7 @@ func (h *APIHandler) queryByImages(whereSQL string, args []any, f QueryFilters)
 		SELECT
 			images.id, images.path, images.composite, images.sensor,
 			images.mapOverlay, images.corrected, images.filled,
-			images.vPixels, images.passId,
+			images.vPixels, images.passId, COALESCE(images.tiles, 0),
 			passes.timestamp, COALESCE(passes.satellite,'Unknown'), passes.name, passes.rawDataPath
 		FROM images
 		JOIN passes ON images.passId = passes.id
@@ -316,7 +347,7 @@ func (h *APIHandler) queryByImages(whereSQL string, args []any, f QueryFilters)
 		if err := rows.Scan(
 			&gi.ID, &gi.Path, &gi.Composite, &gi.Sensor,
 			&gi.MapOverlay, &gi.Corrected, &gi.Filled,
-			&gi.VPixels, &gi.PassID,
+			&gi.VPixels, &gi.PassID, &gi.HasTiles,
 			&gi.Timestamp, &gi.Satellite, &gi.Name, &gi.RawDataPath,
 		); err != nil {
 			return nil, 0, err
@@ -368,7 +399,7 @@ func (h *APIHandler) queryByPasses(whereSQL string, args []any, f QueryFilters)
 			SELECT
 				f.id, f.path, f.composite, f.sensor,
 				f.mapOverlay, f.corrected, f.filled,
-				f.vPixels, f.passId,
+				f.vPixels, f.passId, COALESCE(f.tiles, 0),
 				f.p_timestamp, COALESCE(f.p_satellite,'Unknown'), f.p_name, f.p_rawDataPath
 			FROM filtered f
 			JOIN selected_passes sp ON f.passId = sp.id
@@ -397,7 +428,7 @@ func (h *APIHandler) queryByPasses(whereSQL string, args []any, f QueryFilters)
 			SELECT
 				f.id, f.path, f.composite, f.sensor,
 				f.mapOverlay, f.corrected, f.filled,
-				f.vPixels, f.passId,
+				f.vPixels, f.passId, COALESCE(f.tiles, 0),
 				f.p_timestamp, COALESCE(f.p_satellite,'Unknown'), f.p_name, f.p_rawDataPath
 			FROM filtered f
 			JOIN selected_passes sp ON f.passId = sp.id
@@ -419,7 +450,7 @@ func (h *APIHandler) queryByPasses(whereSQL string, args []any, f QueryFilters)
 		if err := rows.Scan(
 			&gi.ID, &gi.Path, &gi.Composite, &gi.Sensor,
 			&gi.MapOverlay, &gi.Corrected, &gi.Filled,
-			&gi.VPixels, &gi.PassID,
+			&gi.VPixels, &gi.PassID, &gi.HasTiles,
 			&gi.Timestamp, &gi.Satellite, &gi.Name, &gi.RawDataPath,
 		); err != nil {
 			return nil, 0, err
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"OnlySats/com"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// admin API for outgoing email: test sends and the delivery queue.
+type EmailHandler struct {
+	Store  *com.LocalDataStore
+	Mailer *com.Mailer
+}
+
+func (h *EmailHandler) disabled(w http.ResponseWriter) bool {
+	if h.Mailer.Enabled() {
+		return false
+	}
+	http.Error(w, "email is not configured ([smtp] in config.toml)", http.StatusServiceUnavailable)
+	return true
+}
+
+// GET /local/api/email/status
+func (h *EmailHandler) Status(w http.ResponseWriter, r *http.Request) {
+	resp := map[string]any{"enabled": h.Mailer.Enabled()}
+	if h.Mailer.Enabled() {
+		resp["host"] = h.Mailer.Cfg.Host
+		resp["port"] = h.Mailer.Cfg.Port
+		resp["security"] = h.Mailer.Cfg.Security
+		resp["summaryHour"] = h.Mailer.Cfg.SummaryHour
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// POST /local/api/email/test {"to": "..."} sends immediately and reports the SMTP error, if any.
+func (h *EmailHandler) Test(w http.ResponseWriter, r *http.Request) {
+	if h.disabled(w) {
+		return
+	}
+	var in struct {
+		To string `json:"to"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil || in.To == "" {
+		badRequest(w, "to required")
+		return
+	}
+	if err := h.Mailer.SendTest(r.Context(), in.To); err != nil {
+		writeJSON(w, http.StatusBadGateway, map[string]any{"ok": false, "error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// POST /local/api/email/summary queues the daily summary now.
+func (h *EmailHandler) Summary(w http.ResponseWriter, r *http.Request) {
+	if h.disabled(w) {
+		return
+	}
+	n, err := h.Mailer.SendDailySummary(r.Context())
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "recipients": n})
+}
+
+// GET /local/api/email/queue?status=pending|sent|failed&limit=N
+func (h *EmailHandler) Queue(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	status := q.Get("status")
+	switch status {
+	case "", "pending", "sent", "failed":
+	default:
+		badRequest(w, "status must be pending, sent or failed")
+		return
+	}
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	rows, err := h.Store.ListQueuedEmails(r.Context(), status, limit)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rows)
+}
+
+// POST /local/api/email/queue/{id}/retry
+func (h *EmailHandler) Retry(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(mux.Vars(r), "id")
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	if err := h.Store.RetryEmail(r.Context(), id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			notFound(w, "no failed email with that id")
+			return
+		}
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
@@ -0,0 +1,299 @@
+package handlers
+
+import (
+	"OnlySats/com"
+	"OnlySats/com/logging"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var exportsLog = logging.For("exports")
+
+// bulk ZIP exports of the images /api/images would return for the same
+// filters, built by com.Exporter.
+type ExportsHandler struct {
+	API      *APIHandler
+	Exporter *com.Exporter
+	Policy   *com.AccessPolicy // raw recordings also need the "raw" group's level
+}
+
+// GET /api/exports/zip?<same filters as /api/images>&raw=1&manifest=csv|json
+// streams the archive straight away when the selection is under
+// sync_max_size; larger ones must be queued with POST /api/exports.
+func (h *ExportsHandler) Stream(w http.ResponseWriter, r *http.Request) {
+	e, files, total, err := h.plan(r)
+	if err != nil {
+		h.fail(w, err)
+		return
+	}
+	if !h.admitRaw(w, r, e) {
+		return
+	}
+	if total > h.Exporter.SyncMaxBytes {
+		writeJSON(w, http.StatusRequestEntityTooLarge, map[string]any{
+			"ok":    false,
+			"error": fmt.Sprintf("%d MB match; exports over %d MB are built in the background with POST /api/exports", total>>20, h.Exporter.SyncMaxBytes>>20),
+			"files": len(files),
+			"bytes": total,
+		})
+		return
+	}
+	release, ok := h.Exporter.Acquire()
+	if !ok {
+		h.fail(w, fmt.Errorf("%w: all download slots are in use", com.ErrExportBusy))
+		return
+	}
+	defer release()
+
+	// large selections take longer than the server's write timeout
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="onlysats-export.zip"`)
+	if err := h.Exporter.Write(r.Context(), w, files, e.Manifest, nil); err != nil && r.Context().Err() == nil {
+		// headers are gone; the truncated archive tells the client
+		exportsLog.Warn("stream failed", "files", len(files), "err", err)
+	}
+}
+
+// POST /api/exports?<same filters as /api/images>&raw=1&manifest=csv|json
+// queues a background export (202), or returns an earlier one of the same
+// files (200).
+func (h *ExportsHandler) Create(w http.ResponseWriter, r *http.Request) {
+	e, files, total, err := h.plan(r)
+	if err != nil {
+		h.fail(w, err)
+		return
+	}
+	if !h.admitRaw(w, r, e) {
+		return
+	}
+	e, created, err := h.Exporter.Create(r.Context(), e, files, total, clientIP(r))
+	if err != nil {
+		h.fail(w, err)
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusAccepted
+	}
+	writeJSON(w, status, e)
+}
+
+// GET /api/exports/{id} reports status and progress; url is set once done.
+func (h *ExportsHandler) Get(w http.ResponseWriter, r *http.Request) {
+	e, ok := h.load(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, e)
+}
+
+// GET /api/exports/{id}/file
+func (h *ExportsHandler) File(w http.ResponseWriter, r *http.Request) {
+	e, ok := h.load(w, r)
+	if !ok {
+		return
+	}
+	if !h.admitRaw(w, r, e) {
+		return
+	}
+	if e.Status != "done" {
+		http.Error(w, "export is "+e.Status, http.StatusConflict)
+		return
+	}
+	f, err := os.Open(h.Exporter.Path(e))
+	if err != nil {
+		if os.IsNotExist(err) {
+			notFound(w, "export file missing")
+			return
+		}
+		serverErr(w, err)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	release, ok := h.Exporter.Acquire()
+	if !ok {
+		h.fail(w, fmt.Errorf("%w: all download slots are in use", com.ErrExportBusy))
+		return
+	}
+	defer release()
+
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="onlysats-export-%d.zip"`, e.ID))
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
+
+// GET /local/api/exports?limit=50 lists exports, newest first.
+func (h *ExportsHandler) List(w http.ResponseWriter, r *http.Request) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	list, err := h.Exporter.List(r.Context(), limit)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"exports": list})
+}
+
+// DELETE /local/api/exports/{id}
+func (h *ExportsHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(mux.Vars(r), "id")
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	switch err := h.Exporter.Delete(r.Context(), id); {
+	case errors.Is(err, sql.ErrNoRows):
+		notFound(w, "export not found")
+	case errors.Is(err, com.ErrExportBusy):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		serverErr(w, err)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (h *ExportsHandler) load(w http.ResponseWriter, r *http.Request) (*com.Export, bool) {
+	id, err := parseID(mux.Vars(r), "id")
+	if err != nil {
+		badRequest(w, err.Error())
+		return nil, false
+	}
+	e, err := h.Exporter.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			notFound(w, "export not found")
+			return nil, false
+		}
+		serverErr(w, err)
+		return nil, false
+	}
+	return e, true
+}
+
+// admitRaw holds exports that pack raw recordings to the "raw" group, so
+// restricting raw downloads isn't undone by a public "zip" group.
+func (h *ExportsHandler) admitRaw(w http.ResponseWriter, r *http.Request, e *com.Export) bool {
+	return !e.Raw || h.Policy == nil || h.Policy.Admit(w, r, "raw")
+}
+
+func (h *ExportsHandler) fail(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, com.ErrBadExport):
+		badRequest(w, err.Error())
+	case errors.Is(err, com.ErrExportTooLarge):
+		writeJSON(w, http.StatusRequestEntityTooLarge, apiErr{OK: false, Error: err.Error()})
+	case errors.Is(err, com.ErrExportBusy):
+		w.Header().Set("Retry-After", "60")
+		writeJSON(w, http.StatusTooManyRequests, apiErr{OK: false, Error: err.Error()})
+	default:
+		serverErr(w, err)
+	}
+}
+
+// plan reads the options and filters from r and lists the matching files.
+func (h *ExportsHandler) plan(r *http.Request) (*com.Export, []com.ExportFile, int64, error) {
+	q := r.URL.Query()
+	e := &com.Export{Manifest: strings.ToLower(strings.TrimSpace(q.Get("manifest")))}
+	switch e.Manifest {
+	case "":
+		e.Manifest = "csv"
+	case "csv", "json", "none":
+	default:
+		return nil, nil, 0, fmt.Errorf("%w: manifest must be csv, json or none", com.ErrBadExport)
+	}
+	switch strings.ToLower(q.Get("raw")) {
+	case "1", "true", "yes":
+		e.Raw = true
+	}
+
+	// remember the filters only; paging and export options don't select anything
+	kept := url.Values{}
+	for k, v := range q {
+		switch k {
+		case "page", "limit", "limitType", "sortBy", "sortOrder", "raw", "manifest":
+			continue
+		}
+		kept[k] = v
+	}
+	e.Filters = kept.Encode()
+
+	files, err := h.API.exportFiles(r.Context(), h.API.parseQueryFilters(r), e.Raw, h.Exporter.MaxFiles)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	files, total, err := h.Exporter.Plan(files)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return e, files, total, nil
+}
+
+// exportFiles lists the images matching f, oldest pass first, and with raw
+// each pass's raw recording once. More than limit images is an error.
+func (h *APIHandler) exportFiles(ctx context.Context, f QueryFilters, raw bool, limit int) ([]com.ExportFile, error) {
+	whereSQL, args := h.buildWhere(f)
+	rows, err := h.DB.QueryContext(ctx, `
+		SELECT images.path, COALESCE(images.composite, ''), COALESCE(images.sensor, ''),
+			passes.id, COALESCE(passes.name, ''), passes.timestamp, COALESCE(passes.satellite, 'Unknown'),
+			COALESCE(passes.rawDataPath, '')
+		FROM images
+		JOIN passes ON images.passId = passes.id
+	`+" "+whereSQL+`
+		ORDER BY passes.timestamp, images.id
+		LIMIT ?`, append(args, limit+1)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var (
+		out      []com.ExportFile
+		images   int
+		lastPass int64 = -1
+	)
+	for rows.Next() {
+		var (
+			img     com.ExportFile
+			passID  int64
+			rawPath string
+		)
+		img.Kind = "image"
+		if err := rows.Scan(&img.Path, &img.Composite, &img.Sensor, &passID, &img.Pass, &img.Timestamp,
+			&img.Satellite, &rawPath); err != nil {
+			return nil, err
+		}
+		if images++; images > limit {
+			return nil, fmt.Errorf("%w: more than %d images match; narrow the filters", com.ErrExportTooLarge, limit)
+		}
+		img.Path = strings.ReplaceAll(img.Path, `\`, `/`)
+		if raw && passID != lastPass && rawPath != "" && rawPath != "NOT_CONFIGURED" && img.Pass != "" {
+			out = append(out, com.ExportFile{
+				Path:      img.Pass + "/" + strings.ReplaceAll(rawPath, `\`, `/`),
+				Kind:      "raw",
+				Satellite: img.Satellite,
+				Pass:      img.Pass,
+				Timestamp: img.Timestamp,
+			})
+		}
+		lastPass = passID
+		out = append(out, img)
+	}
+	return out, rows.Err()
+}
@@ -15,6 +15,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type GalleryAPI struct {
@@ -22,6 +23,9 @@ type GalleryAPI struct {
 	LiveOutputDir string
 	UserContent   string
 	LocalStore    *com.LocalDataStore
+	Renditions    []com.Rendition
+	Meta          *com.MetadataEmbedder // optional; embeds capture details in ZIP exports
+	Downloads     *com.DownloadLimiter  // optional; limits raw downloads from /api/export
 }
 
 type compEntry struct {
@@ -156,6 +160,9 @@ ORDER BY rp.timestamp DESC, i.id ASC;
 		Filled     int64  `json:"filled"`
 		VPixels    int64  `json:"vPixels"`
 		PassID     int    `json:"passId"`
+
+		Thumbnails map[string]string `json:"thumbnails,omitempty"`
+		Srcset     string            `json:"srcset,omitempty"`
 	}
 
 	type passOut struct {
@@ -198,6 +205,9 @@ ORDER BY rp.timestamp DESC, i.id ASC;
 			VPixels:    nullI64(r.VPixels),
 			PassID:     r.PassID,
 		}
+		if len(api.Renditions) > 0 {
+			img.Thumbnails, img.Srcset = com.ThumbURLs(api.Renditions, rel)
+		}
 		p.Images = append(p.Images, img)
 	}
 
@@ -352,7 +362,9 @@ func (api *GalleryAPI) CompositesList() http.HandlerFunc {
 	}
 }
 
-// streams a single file from LiveOutputDir as a download.
+// streams a single file from LiveOutputDir as a download. Range and
+// If-Range requests resume interrupted downloads; Downloads, when set,
+// limits concurrent downloads per client and paces the bytes.
 // GET /api/export?path=<relative path to file inside live output>
 func (g *GalleryAPI) ExportCADU() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -366,12 +378,18 @@ func (g *GalleryAPI) ExportCADU() http.HandlerFunc {
 			http.Error(w, "invalid path: "+err.Error(), http.StatusBadRequest)
 			return
 		}
-		stat, err := os.Stat(fullPath)
+		f, err := os.Open(fullPath)
 		if err != nil {
 			if os.IsNotExist(err) {
 				http.Error(w, "file not found", http.StatusNotFound)
 				return
 			}
+			http.Error(w, "open error", http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+		stat, err := f.Stat()
+		if err != nil {
 			http.Error(w, "stat error", http.StatusInternalServerError)
 			return
 		}
@@ -380,27 +398,27 @@ func (g *GalleryAPI) ExportCADU() http.HandlerFunc {
 			return
 		}
 
-		// Set headers and stream
-		filename := filepath.Base(fullPath)
-		w.Header().Set("Content-Type", "application/octet-stream")
-		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
-		w.Header().Set("Last-Modified", stat.ModTime().UTC().Format(http.TimeFormat))
-
-		f, err := os.Open(fullPath)
-		if err != nil {
-			http.Error(w, "open error", http.StatusInternalServerError)
-			return
+		out := w
+		if g.Downloads != nil {
+			release, retryAfter, ok := g.Downloads.Acquire(r.Context(), clientIP(r))
+			if !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+				writeJSON(w, http.StatusTooManyRequests, apiErr{OK: false,
+					Error: "too many downloads from your address at once; finish or cancel one and retry"})
+				return
+			}
+			defer release()
+			out = g.Downloads.Writer(r.Context(), w)
 		}
-		defer f.Close()
 
-		// Best-effort Content-Length
-		w.Header().Set("Content-Length", strconv.FormatInt(stat.Size(), 10))
+		// multi-GB files at a capped rate outlive the server's write timeout
+		_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
 
-		if _, err := io.Copy(w, f); err != nil {
-			// Client aborted or write error — don't send another header
-			_ = err
-			return
-		}
+		filename := filepath.Base(fullPath)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+		// ServeContent answers Range/If-Range and sets Accept-Ranges and Last-Modified
+		http.ServeContent(out, r, "", stat.ModTime(), f)
 	}
 }
 
@@ -438,6 +456,16 @@ func (g *GalleryAPI) ZipPath() http.HandlerFunc {
 		}
 		zipName := baseName + ".zip"
 
+		// images.path is relative to the resolved live output root
+		var liveRoot string
+		if g.Meta != nil && g.Meta.Want(r.URL.Query().Get("meta")) {
+			if liveRoot, err = filepath.EvalSymlinks(g.LiveOutputDir); err != nil {
+				http.Error(w, "stat error", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
 		w.Header().Set("Content-Type", "application/zip")
 		w.Header().Set("Content-Disposition", `attachment; filename="`+zipName+`"`)
 
@@ -489,7 +517,18 @@ func (g *GalleryAPI) ZipPath() http.HandlerFunc {
 				return err
 			}
 			defer f.Close()
-			_, err = io.Copy(wr, f)
+			var src io.Reader = f
+			if liveRoot != "" && com.CanEmbed(path) {
+				if imgRel, err := filepath.Rel(liveRoot, path); err == nil {
+					sr, ok, err := g.Meta.Open(r.Context(), f, fh.Size(), filepath.ToSlash(imgRel))
+					if err != nil {
+						embedLog.Warn("metadata lookup failed", "path", imgRel, "err", err)
+					} else if ok {
+						src = sr
+					}
+				}
+			}
+			_, err = io.Copy(wr, src)
 			return err
 		})
 
@@ -1,18 +1,46 @@
 package handlers
 
 import (
+	"OnlySats/com"
+	"OnlySats/com/logging"
+	"errors"
+	"fmt"
 	"log"
+	"math"
 	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 )
 
+var (
+	derivLog = logging.For("derivatives")
+	embedLog = logging.For("imagemeta")
+	wmLog    = logging.For("watermark")
+)
+
+// Derivatives configures resized copies served by ImageServer.
+type Derivatives struct {
+	Cache   *com.DerivativeCache
+	Widths  []int // allowed ?w= values
+	Quality int   // default ?q=
+
+	// optional overlay; watermarked copies are kept in Cache too
+	Watermark *com.Watermarker
+	UserLevel func(r *http.Request) (level int, ok bool) // ok is false for anonymous viewers
+}
+
 // serves original images from liveOutputDir.
 // Request: /images/<images.path from DB>
-func ImageServer(liveOutputDir string) http.HandlerFunc {
+// With d set, ?w=<width>&fmt=<jpeg|webp|png|avif>&q=<1-100> serves a resized,
+// cached copy instead. w must be one of d.Widths (or absent to keep the size).
+// With meta set, JPEG and PNG originals carry capture metadata (?meta=1/0
+// overrides the configured default); the files themselves are left alone.
+func ImageServer(liveOutputDir string, d *Derivatives, meta *com.MetadataEmbedder) http.HandlerFunc {
 	rootAbs, err := filepath.Abs(liveOutputDir)
 	if err != nil {
 		log.Printf("[images] warning: Abs() failed for %q: %v", liveOutputDir, err)
@@ -54,25 +82,55 @@ func ImageServer(liveOutputDir string) http.HandlerFunc {
 			return
 		}
 
+		var wm *com.Watermark
+		if d != nil && d.Watermark != nil {
+			route := com.WatermarkImages
+			if wantsDerivative(r) {
+				route = com.WatermarkDerivatives
+			}
+			var ok bool
+			if wm, ok = d.watermark(w, r, route, filepath.ToSlash(rel)); !ok {
+				return
+			}
+		}
+		if d != nil && wantsDerivative(r) {
+			d.serve(w, r, full, rel, info, wm)
+			return
+		}
+		if wm != nil {
+			d.serveWatermarked(w, r, full, rel, info, wm, meta)
+			return
+		}
+
 		if ct := mime.TypeByExtension(strings.ToLower(filepath.Ext(info.Name()))); ct != "" {
 			w.Header().Set("Content-Type", ct)
 		}
 		setCacheHeaders(w)
+		if meta != nil && meta.Want(r.URL.Query().Get("meta")) {
+			sr, ok, err := meta.Open(r.Context(), f, info.Size(), filepath.ToSlash(rel))
+			if err != nil {
+				embedLog.Warn("metadata lookup failed", "path", rel, "err", err)
+			} else if ok {
+				http.ServeContent(w, r, info.Name(), info.ModTime(), sr)
+				return
+			}
+		}
 		http.ServeContent(w, r, info.Name(), info.ModTime(), f)
 	}
 }
 
-// If thumbRoot != "", mirror under that root, else beside originals in <pass/subdir>/thumbnails/<name>.webp
-func ThumbnailServer(liveOutputDir, thumbRoot string) http.HandlerFunc {
+// If thumbRoot != "", mirror under that root, else beside originals in <pass/subdir>/thumbnails/<name>.webp.
+// /thumbnails/{rendition}/<path> serves a named rendition (see com.ThumbLocation for the layout).
+// With gen set, missing thumbnails are generated on first request.
+func ThumbnailServer(liveOutputDir, thumbRoot string, rends []com.Rendition, gen *com.OnDemandThumbs) http.HandlerFunc {
 	liveAbs, err := filepath.Abs(liveOutputDir)
 	if err != nil {
 		log.Printf("[thumbs] warning: Abs() failed for live_output %q: %v", liveOutputDir, err)
 		liveAbs = liveOutputDir
 	}
 
-	useCentral := strings.TrimSpace(thumbRoot) != ""
 	var centralAbs string
-	if useCentral {
+	if strings.TrimSpace(thumbRoot) != "" {
 		if ca, err := filepath.Abs(thumbRoot); err == nil {
 			centralAbs = ca
 		} else {
@@ -81,6 +139,13 @@ func ThumbnailServer(liveOutputDir, thumbRoot string) http.HandlerFunc {
 		}
 	}
 
+	def := rends[0]
+	for _, r := range rends {
+		if r.Default {
+			def = r
+		}
+	}
+
 	return func(w http.ResponseWriter, r *http.Request) {
 		rel := strings.TrimPrefix(r.URL.Path, "/thumbnails/")
 		if rel == "" {
@@ -88,30 +153,35 @@ func ThumbnailServer(liveOutputDir, thumbRoot string) http.HandlerFunc {
 			return
 		}
 
-		var target string
-		var err error
-
-		if useCentral {
-			// mirror rel under central root, but swap extension to .webp
-			dir := filepath.Dir(rel)
-			name := strings.TrimSuffix(filepath.Base(rel), filepath.Ext(rel)) + ".webp"
-			target, err = safeJoin(centralAbs, filepath.Join(dir, name))
-			if err != nil {
-				http.Error(w, "bad path", http.StatusBadRequest)
-				return
-			}
-		} else {
-			// side-by-side: <live>/<dir>/thumbnails/<name>.webp
-			dir := filepath.Dir(rel)
-			name := strings.TrimSuffix(filepath.Base(rel), filepath.Ext(rel)) + ".webp"
-			target, err = safeJoin(liveAbs, filepath.Join(dir, "thumbnails", name))
-			if err != nil {
-				http.Error(w, "bad path", http.StatusBadRequest)
-				return
+		rend := def
+		if seg, rest, ok := strings.Cut(rel, "/"); ok && rest != "" {
+			if named, found := com.FindRendition(rends, seg); found {
+				rend, rel = named, rest
 			}
 		}
 
+		root, sub := com.ThumbLocation(liveAbs, centralAbs, rend, rel)
+		target, err := safeJoin(root, sub)
+		if err != nil {
+			http.Error(w, "bad path", http.StatusBadRequest)
+			return
+		}
+
 		f, err := os.Open(target)
+		if os.IsNotExist(err) && gen != nil {
+			if gerr := gen.Ensure(r.Context(), rend, rel); gerr != nil {
+				switch {
+				case errors.Is(gerr, com.ErrNoSourceImage):
+					http.NotFound(w, r)
+				case r.Context().Err() != nil:
+					// client went away
+				default:
+					http.Error(w, "thumbnail generation failed", http.StatusInternalServerError)
+				}
+				return
+			}
+			f, err = os.Open(target)
+		}
 		if err != nil {
 			if os.IsNotExist(err) {
 				http.NotFound(w, r)
@@ -134,12 +204,138 @@ func ThumbnailServer(liveOutputDir, thumbRoot string) http.HandlerFunc {
 			return
 		}
 
-		w.Header().Set("Content-Type", "image/webp")
+		w.Header().Set("Content-Type", rend.ContentType())
 		setCacheHeaders(w)
 		http.ServeContent(w, r, info.Name(), info.ModTime(), f)
 	}
 }
 
+func wantsDerivative(r *http.Request) bool {
+	q := r.URL.Query()
+	return q.Has("w") || q.Has("fmt") || q.Has("q")
+}
+
+func (d *Derivatives) spec(r *http.Request) (com.DerivativeSpec, error) {
+	q := r.URL.Query()
+	spec := com.DerivativeSpec{Format: "jpeg", Quality: d.Quality}
+
+	if v := q.Get("w"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || !slices.Contains(d.Widths, n) {
+			allowed := make([]string, len(d.Widths))
+			for i, w := range d.Widths {
+				allowed[i] = strconv.Itoa(w)
+			}
+			return spec, fmt.Errorf("w must be one of %s", strings.Join(allowed, ", "))
+		}
+		spec.Width = n
+	}
+	switch f := strings.ToLower(q.Get("fmt")); f {
+	case "", "jpeg", "jpg":
+	case "webp", "png", "avif":
+		spec.Format = f
+	default:
+		return spec, fmt.Errorf("fmt must be jpeg, webp, png or avif")
+	}
+	if !d.Cache.Backend.Supports(spec.Format) {
+		return spec, fmt.Errorf("%s output is not available on this station", spec.Format)
+	}
+	if v := q.Get("q"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 100 {
+			return spec, fmt.Errorf("q must be 1-100")
+		}
+		// round to 5 so the cache holds a handful of variants, not a hundred
+		spec.Quality = max(5, (n+2)/5*5)
+	}
+	if spec.Format == "png" {
+		spec.Quality = 0 // lossless
+	}
+	return spec, nil
+}
+
+func (d *Derivatives) serve(w http.ResponseWriter, r *http.Request, full, rel string, info os.FileInfo, wm *com.Watermark) {
+	spec, err := d.spec(r)
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	if spec.Width == 0 {
+		// conversion only; width from the original is resolved at encode time
+		spec.Width = math.MaxInt32
+	}
+	spec.Watermark = wm
+	d.serveSpec(w, r, full, rel, info, spec, nil)
+}
+
+// watermark returns the overlay this viewer gets on route, nil for none.
+// ok is false once an error has been written.
+func (d *Derivatives) watermark(w http.ResponseWriter, r *http.Request, route, rel string) (wm *com.Watermark, ok bool) {
+	if !d.Watermark.Active(r.Context(), route) {
+		return nil, true
+	}
+	// signed-in users may be exempt, so shared caches must not mix them up
+	w.Header().Add("Vary", "Cookie")
+	level, signedIn := 0, false
+	if d.UserLevel != nil {
+		level, signedIn = d.UserLevel(r)
+	}
+	wm, err := d.Watermark.For(r.Context(), route, level, signedIn, rel)
+	if err != nil {
+		wmLog.Warn("watermark failed", "path", rel, "err", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+	return wm, true
+}
+
+// serveWatermarked serves the original at full size with wm drawn on, in
+// its own format where the backend can write it.
+func (d *Derivatives) serveWatermarked(w http.ResponseWriter, r *http.Request, full, rel string, info os.FileInfo, wm *com.Watermark, meta *com.MetadataEmbedder) {
+	spec := com.DerivativeSpec{Width: math.MaxInt32, Format: "png", Watermark: wm}
+	switch strings.ToLower(filepath.Ext(rel)) {
+	case ".jpg", ".jpeg":
+		spec.Format, spec.Quality = "jpeg", 92
+	case ".webp":
+		if d.Cache.Backend.Supports("webp") {
+			spec.Format, spec.Quality = "webp", 90
+		}
+	}
+	d.serveSpec(w, r, full, rel, info, spec, meta)
+}
+
+func (d *Derivatives) serveSpec(w http.ResponseWriter, r *http.Request, full, rel string, info os.FileInfo, spec com.DerivativeSpec, meta *com.MetadataEmbedder) {
+	key := com.DerivativeKey(rel, info, spec)
+	etag := `"` + key + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	if match := r.Header.Get("If-None-Match"); match != "" && (match == "*" || strings.Contains(match, etag)) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	f, err := d.Cache.Open(r.Context(), full, key, spec)
+	if err != nil {
+		if r.Context().Err() == nil {
+			derivLog.Warn("derivative failed", "path", rel, "err", err)
+			http.Error(w, "image conversion failed", http.StatusInternalServerError)
+		}
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", spec.ContentType())
+	if meta != nil && meta.Want(r.URL.Query().Get("meta")) {
+		if st, err := f.Stat(); err == nil {
+			if sr, ok, err := meta.Open(r.Context(), f, st.Size(), filepath.ToSlash(rel)); err == nil && ok {
+				http.ServeContent(w, r, "", info.ModTime(), sr)
+				return
+			}
+		}
+	}
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
+
 func setCacheHeaders(w http.ResponseWriter) {
 	w.Header().Set("Cache-Control", "public, max-age=300, immutable")
 	w.Header().Set("Expires", time.Now().Add(7*24*time.Hour).UTC().Format(http.TimeFormat))
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"OnlySats/com"
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// packages a projected image with its geographic bounds, read from the
+// SatDump product metadata beside it.
+// GET /api/export/geo?path=<image inside live output>&format=kmz|world|geojson
+func (g *GalleryAPI) ExportGeo() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("path") == "" {
+			http.Error(w, "missing 'path' query parameter", http.StatusBadRequest)
+			return
+		}
+		format := strings.ToLower(q.Get("format"))
+		switch format {
+		case "":
+			format = "kmz"
+		case "kmz", "world", "geojson":
+		default:
+			http.Error(w, "format must be kmz, world or geojson", http.StatusBadRequest)
+			return
+		}
+
+		fullPath, err := sanitizeAndResolve(g.LiveOutputDir, q.Get("path"))
+		if err != nil {
+			if os.IsNotExist(err) {
+				http.Error(w, "file not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "invalid path: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		root, err := filepath.EvalSymlinks(g.LiveOutputDir)
+		if err != nil {
+			http.Error(w, "stat error", http.StatusInternalServerError)
+			return
+		}
+		rel, err := filepath.Rel(root, fullPath)
+		if err != nil {
+			http.Error(w, "invalid path", http.StatusBadRequest)
+			return
+		}
+		rel = filepath.ToSlash(rel)
+
+		ref, err := com.LoadGeoRef(root, fullPath)
+		switch {
+		case errors.Is(err, com.ErrNoGeoRef):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case errors.Is(err, com.ErrProjection):
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		case os.IsNotExist(err):
+			http.Error(w, "file not found", http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// pass details are optional; files not in the DB still export
+		var (
+			satellite, composite string
+			ts                   int64
+			when                 time.Time
+		)
+		_ = g.DB.QueryRowContext(r.Context(), `
+			SELECT COALESCE(p.satellite, ''), COALESCE(p.timestamp, 0), COALESCE(i.composite, '')
+			FROM images i LEFT JOIN passes p ON p.id = i.passId
+			WHERE i.path = ? LIMIT 1`, rel).Scan(&satellite, &ts, &composite)
+		if ts > 0 {
+			when = time.Unix(ts, 0).UTC()
+		}
+
+		name := filepath.Base(fullPath)
+		base := strings.TrimSuffix(name, filepath.Ext(name))
+		switch format {
+		case "geojson":
+			west, south, east, north := ref.Bounds()
+			props := map[string]any{
+				"name":       name,
+				"image":      "/images/" + rel,
+				"projection": ref.Projection,
+				"width":      ref.Width,
+				"height":     ref.Height,
+				"west":       west,
+				"south":      south,
+				"east":       east,
+				"north":      north,
+			}
+			if satellite != "" {
+				props["satellite"] = satellite
+				props["composite"] = composite
+			}
+			if !when.IsZero() {
+				props["timestamp"] = when.Format(time.RFC3339)
+			}
+			w.Header().Set("Content-Disposition", `attachment; filename="`+base+`.geojson"`)
+			w.Header().Set("Content-Type", "application/geo+json")
+			_ = json.NewEncoder(w).Encode(ref.Footprint(props))
+
+		case "kmz":
+			title := name
+			if satellite != "" {
+				title = satellite + " " + composite
+			}
+			desc := name
+			if !when.IsZero() {
+				desc = fmt.Sprintf("%s, %s", name, when.Format("2006-01-02 15:04 UTC"))
+			}
+			w.Header().Set("Content-Type", "application/vnd.google-earth.kmz")
+			w.Header().Set("Content-Disposition", `attachment; filename="`+base+`.kmz"`)
+			zw := zip.NewWriter(w)
+			// doc.kml must come first for Google Earth
+			if err := zipBytes(zw, "doc.kml", ref.KML(title, desc, "files/"+name, when)); err != nil {
+				return
+			}
+			if err := zipFile(zw, "files/"+name, fullPath); err != nil {
+				return
+			}
+			_ = zw.Close()
+
+		case "world":
+			w.Header().Set("Content-Type", "application/zip")
+			w.Header().Set("Content-Disposition", `attachment; filename="`+base+`_georef.zip"`)
+			zw := zip.NewWriter(w)
+			if err := zipFile(zw, name, fullPath); err != nil {
+				return
+			}
+			if err := zipBytes(zw, base+com.WorldFileExt(filepath.Ext(name)), ref.WorldFile()); err != nil {
+				return
+			}
+			if err := zipBytes(zw, base+".prj", []byte(com.WGS84PRJ)); err != nil {
+				return
+			}
+			_ = zw.Close()
+		}
+	}
+}
+
+func zipBytes(zw *zip.Writer, name string, data []byte) error {
+	wr, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = wr.Write(data)
+	return err
+}
+
+// zipFile stores an already compressed image as is.
+func zipFile(zw *zip.Writer, name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	hdr.Method = zip.Store
+	wr, err := zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(wr, f)
+	return err
+}
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"runtime"
+	"strconv"
 	"time"
 
 	com "OnlySats/com"
@@ -173,3 +174,68 @@ func (h *HardwareHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	}
 }
+
+// serves recorded hardware history for charts.
+type HardwareHistoryHandler struct {
+	Sampler *metrics.HistorySampler
+}
+
+// parseTimeParam accepts unix seconds or RFC3339.
+func parseTimeParam(v string, def time.Time) (time.Time, error) {
+	if v == "" {
+		return def, nil
+	}
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(n, 0), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// GET /local/api/hardware/history?metric=cpu.util&from=&to=&res=
+// Without metric, lists the recorded metric names.
+func (h *HardwareHistoryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	metric := q.Get("metric")
+	if metric == "" {
+		names, err := metrics.HistoryMetrics(r.Context(), h.Sampler.DB)
+		if err != nil {
+			serverErr(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"metrics": names})
+		return
+	}
+
+	now := time.Now()
+	to, err := parseTimeParam(q.Get("to"), now)
+	if err != nil {
+		badRequest(w, "invalid to")
+		return
+	}
+	from, err := parseTimeParam(q.Get("from"), to.Add(-time.Hour))
+	if err != nil {
+		badRequest(w, "invalid from")
+		return
+	}
+	if !from.Before(to) {
+		badRequest(w, "from must be before to")
+		return
+	}
+
+	res := h.Sampler.PickResolution(from, to, now)
+	if v := q.Get("res"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || (n != metrics.ResRaw && n != metrics.ResMinute && n != metrics.ResQuarter) {
+			badRequest(w, "res must be 0, 60 or 900")
+			return
+		}
+		res = n
+	}
+
+	hist, err := metrics.QueryHistory(r.Context(), h.Sampler.DB, metric, res, from, to)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, hist)
+}
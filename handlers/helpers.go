@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"OnlySats/com"
 	"database/sql"
 	"encoding/json"
 	"errors"
@@ -215,3 +216,9 @@ func parseInt64Default(s string, def int64) int64 {
 	}
 	return v
 }
+
+// clientIP is the address the request came from, without the port; behind
+// a trusted proxy it is taken from X-Forwarded-For.
+func clientIP(r *http.Request) string {
+	return com.ClientIP(r)
+}
@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"OnlySats/com/logging"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// admin API for reading station logs without shell access.
+type LogsHandler struct {
+	Dir string // paths.log_dir
+}
+
+type logFileInfo struct {
+	Name       string    `json:"name"`
+	Size       int64     `json:"size"`
+	Modified   time.Time `json:"modified"`
+	Compressed bool      `json:"compressed"`
+	Current    bool      `json:"current"` // the file the app is writing to
+}
+
+// resolve maps a file name from the URL to a path inside Dir.
+func (h *LogsHandler) resolve(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", errors.New("invalid log name")
+	}
+	p := filepath.Join(h.Dir, name)
+	st, err := os.Stat(p)
+	if err != nil {
+		return "", err
+	}
+	if !st.Mode().IsRegular() {
+		return "", fs.ErrNotExist
+	}
+	return p, nil
+}
+
+func parseLogFilter(r *http.Request) (logging.Filter, error) {
+	q := r.URL.Query()
+	var f logging.Filter
+	if v := q.Get("level"); v != "" {
+		lvl, err := logging.ParseLevel(v)
+		if err != nil {
+			return f, err
+		}
+		f.MinLevel = lvl
+	} else {
+		f.MinLevel = slog.LevelDebug
+	}
+	for _, c := range q["component"] {
+		for _, part := range strings.Split(c, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				f.Components = append(f.Components, part)
+			}
+		}
+	}
+	f.Contains = strings.TrimSpace(q.Get("q"))
+	return f, nil
+}
+
+// GET /local/api/logs
+func (h *LogsHandler) List(w http.ResponseWriter, r *http.Request) {
+	entries, err := os.ReadDir(h.Dir)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	current := ""
+	if p := logging.LogFile(); p != "" {
+		current, _ = filepath.Abs(p)
+	}
+	out := make([]logFileInfo, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		abs, _ := filepath.Abs(filepath.Join(h.Dir, e.Name()))
+		out = append(out, logFileInfo{
+			Name:       e.Name(),
+			Size:       info.Size(),
+			Modified:   info.ModTime(),
+			Compressed: strings.HasSuffix(e.Name(), ".gz"),
+			Current:    current != "" && abs == current,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Modified.After(out[j].Modified) })
+	writeJSON(w, http.StatusOK, map[string]any{"dir": h.Dir, "files": out})
+}
+
+// GET /local/api/logs/{name}?level=warn&component=ingest,thumbgen&q=&before=&limit=
+// Returns the newest matching lines; pass the returned "next" as before= for older ones.
+func (h *LogsHandler) Read(w http.ResponseWriter, r *http.Request) {
+	path, err := h.resolve(mux.Vars(r)["name"])
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			notFound(w, "log file not found")
+			return
+		}
+		badRequest(w, err.Error())
+		return
+	}
+	f, err := parseLogFilter(r)
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	q := r.URL.Query()
+	limit := 200
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			badRequest(w, "invalid limit")
+			return
+		}
+		limit = clamp(n, 1, 2000)
+	}
+	before := 0
+	if v := q.Get("before"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			badRequest(w, "invalid before")
+			return
+		}
+		before = n
+	}
+
+	entries, next, err := logging.ReadPage(path, f, before, limit)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"name":    filepath.Base(path),
+		"entries": entries,
+		"next":    next,
+	})
+}
+
+// GET /local/api/logs/tail?level=&component=&backlog=50
+// Server-sent events: one "log" event per new record, after up to backlog
+// recent lines from the current log file.
+func (h *LogsHandler) Tail(w http.ResponseWriter, r *http.Request) {
+	f, err := parseLogFilter(r)
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	backlog := 50
+	if v := r.URL.Query().Get("backlog"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			badRequest(w, "invalid backlog")
+			return
+		}
+		backlog = clamp(n, 0, 1000)
+	}
+
+	rc := http.NewResponseController(w)
+	// the server's write timeout would otherwise end the stream
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	// subscribe before reading the backlog so nothing falls in between
+	lines, cancel := logging.Subscribe(512)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(e logging.Entry) error {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: log\ndata: %s\n\n", b); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	if p := logging.LogFile(); p != "" && backlog > 0 {
+		if recent, _, err := logging.ReadPage(p, f, 0, backlog); err == nil {
+			for _, e := range recent {
+				if send(e) != nil {
+					return
+				}
+			}
+		}
+	}
+	if _, err := fmt.Fprint(w, ": live\n\n"); err != nil || rc.Flush() != nil {
+		return
+	}
+
+	ping := time.NewTicker(15 * time.Second)
+	defer ping.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ping.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil || rc.Flush() != nil {
+				return
+			}
+		case raw := <-lines:
+			e := logging.ParseLine(raw)
+			if !f.Match(e, raw) {
+				continue
+			}
+			if send(e) != nil {
+				return
+			}
+		}
+	}
+}
@@ -121,6 +121,14 @@ func (h *MessagesHandler) Create(w http.ResponseWriter, r *http.Request) {
 		serverErr(w, err)
 		return
 	}
+	com.PublishEvent(com.EventMessagePosted, map[string]any{
+		"id":        id,
+		"title":     title,
+		"message":   body,
+		"type":      typ,
+		"timestamp": when.Unix(),
+		"hasImage":  len(imgBytes) > 0,
+	})
 	writeJSON(w, http.StatusCreated, apiOK[any]{OK: true, Data: map[string]any{
 		"id": id,
 	}})
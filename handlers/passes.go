@@ -18,26 +18,28 @@ func NewTemplatesAdminAPI(prefs *com.LocalDataStore) *TemplatesAdminAPI {
 	return &TemplatesAdminAPI{Prefs: prefs}
 }
 
-func (h *TemplatesAdminAPI) Register(r *mux.Router, requireAuth func(level int, h http.Handler) http.Handler) {
+// Register adds the template admin API under /local/api; com.AccessPolicy
+// holds it to editors.
+func (h *TemplatesAdminAPI) Register(r *mux.Router) {
 	r.UseEncodedPath()
 	// Namespace under /local/api
 	s := r.PathPrefix("/local/api").Subrouter()
-	s.Handle("/pass-types", requireAuth(1, http.HandlerFunc(h.ListPassTypes))).Methods("GET")
-	s.Handle("/pass-types", requireAuth(1, http.HandlerFunc(h.UpsertPassType))).Methods("POST")
-	s.Handle("/pass-types/{code}", requireAuth(1, http.HandlerFunc(h.DeletePassType))).Methods("DELETE")
+	s.Handle("/pass-types", http.HandlerFunc(h.ListPassTypes)).Methods("GET")
+	s.Handle("/pass-types", http.HandlerFunc(h.UpsertPassType)).Methods("POST")
+	s.Handle("/pass-types/{code}", http.HandlerFunc(h.DeletePassType)).Methods("DELETE")
 
-	s.Handle("/folder-includes", requireAuth(1, http.HandlerFunc(h.ListFolderIncludes))).Methods("GET")
-	s.Handle("/folder-includes", requireAuth(1, http.HandlerFunc(h.UpsertFolderInclude))).Methods("POST")
-	s.Handle("/folder-includes/{prefix}", requireAuth(1, http.HandlerFunc(h.DeleteFolderInclude))).Methods("DELETE")
+	s.Handle("/folder-includes", http.HandlerFunc(h.ListFolderIncludes)).Methods("GET")
+	s.Handle("/folder-includes", http.HandlerFunc(h.UpsertFolderInclude)).Methods("POST")
+	s.Handle("/folder-includes/{prefix}", http.HandlerFunc(h.DeleteFolderInclude)).Methods("DELETE")
 
-	s.Handle("/pass-types/{code}/image-dirs", requireAuth(1, http.HandlerFunc(h.ListImageDirRules))).Methods("GET")
-	s.Handle("/pass-types/{code}/image-dirs", requireAuth(1, http.HandlerFunc(h.UpsertImageDirRule))).Methods("POST")
-	s.Handle("/pass-types/{code}/image-dirs/{dir}", requireAuth(1, http.HandlerFunc(h.DeleteImageDirRule))).Methods("DELETE")
+	s.Handle("/pass-types/{code}/image-dirs", http.HandlerFunc(h.ListImageDirRules)).Methods("GET")
+	s.Handle("/pass-types/{code}/image-dirs", http.HandlerFunc(h.UpsertImageDirRule)).Methods("POST")
+	s.Handle("/pass-types/{code}/image-dirs/{dir}", http.HandlerFunc(h.DeleteImageDirRule)).Methods("DELETE")
 
 	//Composites handling
-	s.Handle("/composites", requireAuth(1, http.HandlerFunc(h.ListComposites))).Methods("GET")
-	s.Handle("/composites", requireAuth(1, http.HandlerFunc(h.UpsertComposite))).Methods("POST")
-	s.Handle("/composites/{key}", requireAuth(1, http.HandlerFunc(h.DeleteComposite))).Methods("DELETE")
+	s.Handle("/composites", http.HandlerFunc(h.ListComposites)).Methods("GET")
+	s.Handle("/composites", http.HandlerFunc(h.UpsertComposite)).Methods("POST")
+	s.Handle("/composites/{key}", http.HandlerFunc(h.DeleteComposite)).Methods("DELETE")
 }
 
 type (
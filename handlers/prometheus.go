@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	com "OnlySats/com"
+	"OnlySats/com/metrics"
+	"OnlySats/config"
+)
+
+// PrometheusHandler serves /metrics in the Prometheus text format. Counters
+// kept by the app are written from the registry; hardware and DB sizes are
+// sampled per scrape.
+type PrometheusHandler struct {
+	Cfg      *config.AppConfig
+	Store    *com.LocalDataStore
+	AppStart time.Time
+	Timeout  time.Duration
+}
+
+func (h *PrometheusHandler) authorized(r *http.Request) bool {
+	want := h.Cfg.Metrics.Token
+	if want == "" {
+		return true
+	}
+	got := strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	if got == "" {
+		got = r.URL.Query().Get("token")
+	}
+	return subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
+
+func (h *PrometheusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.Cfg.Metrics.Enabled {
+		http.NotFound(w, r)
+		return
+	}
+	if !h.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metrics.WritePrometheus(w)
+
+	metrics.WriteGauge(w, "onlysats_uptime_seconds", "Seconds since the app started.",
+		metrics.Sample{Value: time.Since(h.AppStart).Seconds()})
+	h.writeDBSizes(w)
+
+	mode := "native"
+	if h.Store != nil {
+		if v, err := h.Store.GetSetting(r.Context(), "hwmonitor"); err == nil && v != "" {
+			mode = v
+		}
+	}
+	if mode == "native" {
+		h.writeHardware(r.Context(), w)
+	}
+}
+
+func (h *PrometheusHandler) writeDBSizes(w http.ResponseWriter) {
+	matches, _ := filepath.Glob(filepath.Join(h.Cfg.Paths.DataDir, "*.db"))
+	var samples []metrics.Sample
+	for _, m := range matches {
+		fi, err := os.Stat(m)
+		if err != nil {
+			continue
+		}
+		size := fi.Size()
+		// WAL pages not yet checkpointed are part of the live size
+		if wal, err := os.Stat(m + "-wal"); err == nil {
+			size += wal.Size()
+		}
+		samples = append(samples, metrics.Sample{
+			Labels: map[string]string{"db": filepath.Base(m)},
+			Value:  float64(size),
+		})
+	}
+	metrics.WriteGauge(w, "onlysats_db_size_bytes", "On-disk size of each SQLite database including its WAL.", samples...)
+}
+
+func (h *PrometheusHandler) writeHardware(ctx context.Context, w http.ResponseWriter) {
+	to := h.Timeout
+	if to <= 0 {
+		to = 3 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, to)
+	defer cancel()
+
+	snap, err := metrics.CollectNative(ctx, h.Cfg.Paths.LiveOutputDir)
+	if err != nil {
+		return
+	}
+	one := func(name, help string, v *float64) {
+		if v != nil {
+			metrics.WriteGauge(w, name, help, metrics.Sample{Value: *v})
+		}
+	}
+
+	one("onlysats_cpu_utilization_percent", "Overall CPU utilization.", snap.CPU.UtilizationPct)
+	one("onlysats_cpu_temperature_celsius", "CPU temperature.", snap.CPU.TemperatureC)
+	one("onlysats_cpu_package_power_watts", "CPU package power.", snap.CPU.PackagePowerW)
+	one("onlysats_system_power_watts", "Whole-system power draw.", snap.SystemPower)
+
+	metrics.WriteGauge(w, "onlysats_memory_total_bytes", "Total physical memory.",
+		metrics.Sample{Value: float64(snap.Memory.Total)})
+	metrics.WriteGauge(w, "onlysats_memory_used_bytes", "Used physical memory.",
+		metrics.Sample{Value: float64(snap.Memory.Used)})
+
+	var total, free, used, temp []metrics.Sample
+	for _, d := range snap.Disks {
+		lbl := map[string]string{"mountpoint": d.Mountpoint, "device": d.Device}
+		if d.IsLiveOutputFS {
+			lbl["live_output"] = "true"
+		}
+		if d.Total != nil {
+			total = append(total, metrics.Sample{Labels: lbl, Value: float64(*d.Total)})
+		}
+		if d.Free != nil {
+			free = append(free, metrics.Sample{Labels: lbl, Value: float64(*d.Free)})
+		}
+		if d.UsedPct != nil {
+			used = append(used, metrics.Sample{Labels: lbl, Value: *d.UsedPct})
+		}
+		if d.TemperatureC != nil {
+			temp = append(temp, metrics.Sample{Labels: lbl, Value: *d.TemperatureC})
+		}
+	}
+	metrics.WriteGauge(w, "onlysats_disk_total_bytes", "Filesystem size.", total...)
+	metrics.WriteGauge(w, "onlysats_disk_free_bytes", "Filesystem free space.", free...)
+	metrics.WriteGauge(w, "onlysats_disk_used_percent", "Filesystem usage.", used...)
+	metrics.WriteGauge(w, "onlysats_disk_temperature_celsius", "Drive temperature.", temp...)
+
+	// cumulative since boot, so these are counters
+	metrics.WriteCounter(w, "onlysats_network_sent_bytes_total", "Bytes sent on all interfaces.",
+		metrics.Sample{Value: float64(snap.Network.BytesSent)})
+	metrics.WriteCounter(w, "onlysats_network_received_bytes_total", "Bytes received on all interfaces.",
+		metrics.Sample{Value: float64(snap.Network.BytesRecv)})
+
+	var gUtil, gTemp, gPower []metrics.Sample
+	for i, g := range snap.GPU {
+		lbl := map[string]string{"gpu": g.Name}
+		if g.Name == "" {
+			lbl["gpu"] = strconv.Itoa(i)
+		}
+		if g.UtilizationPct != nil {
+			gUtil = append(gUtil, metrics.Sample{Labels: lbl, Value: *g.UtilizationPct})
+		}
+		if g.TemperatureC != nil {
+			gTemp = append(gTemp, metrics.Sample{Labels: lbl, Value: *g.TemperatureC})
+		}
+		if g.PowerW != nil {
+			gPower = append(gPower, metrics.Sample{Labels: lbl, Value: *g.PowerW})
+		}
+	}
+	metrics.WriteGauge(w, "onlysats_gpu_utilization_percent", "GPU utilization.", gUtil...)
+	metrics.WriteGauge(w, "onlysats_gpu_temperature_celsius", "GPU temperature.", gTemp...)
+	metrics.WriteGauge(w, "onlysats_gpu_power_watts", "GPU power draw.", gPower...)
+}
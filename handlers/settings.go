@@ -2,16 +2,24 @@ package handlers
 
 import (
 	"OnlySats/com"
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
+	"os"
 	"regexp"
 	"strings"
 	"time"
 )
 
 type SettingsHandler struct {
-	Store *com.LocalDataStore
+	Store     *com.LocalDataStore
+	Watermark *com.Watermarker // nil when image derivatives are disabled
+	Downloads *com.DownloadLimiter
+	Policy    *com.AccessPolicy
+	RateLimit *com.RateLimiter
 }
 
 var cssVarKeyRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
@@ -81,6 +89,18 @@ func (h *SettingsHandler) PostSettings(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "empty payload", http.StatusBadRequest)
 		return
 	}
+	// settings with their own endpoint are validated there; refuse them
+	// before writing anything
+	for k := range payload {
+		if endpoint, ok := com.ReservedSetting(k); ok {
+			if endpoint == "" {
+				badRequest(w, k+" is kept by the server")
+			} else {
+				badRequest(w, k+" can only be changed through "+endpoint)
+			}
+			return
+		}
+	}
 
 	// Short timeout-bound context for DB
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
@@ -146,3 +166,236 @@ func (h *SettingsHandler) GetSettings(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(settings)
 }
+
+// GET /local/api/settings/watermark
+func (h *SettingsHandler) GetWatermark(w http.ResponseWriter, r *http.Request) {
+	if h.Watermark == nil {
+		http.Error(w, "watermarks need [derivatives] enabled", http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"settings": h.Watermark.Settings(r.Context()),
+		"hasLogo":  h.Watermark.HasLogo(),
+	})
+}
+
+// POST /local/api/settings/watermark takes a full or partial settings
+// object; omitted fields keep their current values.
+func (h *SettingsHandler) PostWatermark(w http.ResponseWriter, r *http.Request) {
+	if h.Watermark == nil {
+		http.Error(w, "watermarks need [derivatives] enabled", http.StatusServiceUnavailable)
+		return
+	}
+	s := h.Watermark.Settings(r.Context())
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&s); err != nil {
+		badRequest(w, "invalid JSON body: "+err.Error())
+		return
+	}
+	s, err := h.Watermark.SaveSettings(r.Context(), s)
+	if err != nil {
+		if errors.Is(err, com.ErrBadWatermark) {
+			badRequest(w, err.Error())
+			return
+		}
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"settings": s, "hasLogo": h.Watermark.HasLogo()})
+}
+
+// POST /local/api/settings/watermark/logo (multipart field "image")
+func (h *SettingsHandler) PostWatermarkLogo(w http.ResponseWriter, r *http.Request) {
+	if h.Watermark == nil {
+		http.Error(w, "watermarks need [derivatives] enabled", http.StatusServiceUnavailable)
+		return
+	}
+	const maxFile = int64(2 << 20)
+	r.Body = http.MaxBytesReader(w, r.Body, maxFile+(1<<20))
+	if err := r.ParseMultipartForm(maxFile); err != nil {
+		http.Error(w, "payload too large or invalid multipart", http.StatusRequestEntityTooLarge)
+		return
+	}
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		badRequest(w, "image file required")
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxFile+1))
+	if err != nil {
+		badRequest(w, "read error")
+		return
+	}
+	if int64(len(data)) > maxFile {
+		http.Error(w, "file exceeds 2MB", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err := h.Watermark.SetLogo(data); err != nil {
+		if errors.Is(err, com.ErrBadWatermark) {
+			badRequest(w, err.Error())
+			return
+		}
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// GET /local/api/settings/watermark/logo
+func (h *SettingsHandler) GetWatermarkLogo(w http.ResponseWriter, r *http.Request) {
+	if h.Watermark == nil {
+		http.Error(w, "watermarks need [derivatives] enabled", http.StatusServiceUnavailable)
+		return
+	}
+	data, err := os.ReadFile(h.Watermark.LogoPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			notFound(w, "no logo uploaded")
+			return
+		}
+		serverErr(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write(data)
+}
+
+// DELETE /local/api/settings/watermark/logo
+func (h *SettingsHandler) DeleteWatermarkLogo(w http.ResponseWriter, r *http.Request) {
+	if h.Watermark == nil {
+		http.Error(w, "watermarks need [derivatives] enabled", http.StatusServiceUnavailable)
+		return
+	}
+	if err := h.Watermark.RemoveLogo(); err != nil {
+		serverErr(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET /local/api/settings/downloads returns the raw download limits and
+// the downloads running per client.
+func (h *SettingsHandler) GetDownloads(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"settings": h.Downloads.Settings(r.Context()),
+		"active":   h.Downloads.Active(),
+	})
+}
+
+// POST /local/api/settings/downloads takes a full or partial settings
+// object; omitted fields keep their current values.
+func (h *SettingsHandler) PostDownloads(w http.ResponseWriter, r *http.Request) {
+	s := h.Downloads.Settings(r.Context())
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&s); err != nil {
+		badRequest(w, "invalid JSON body: "+err.Error())
+		return
+	}
+	s, err := h.Downloads.SaveSettings(r.Context(), s)
+	if err != nil {
+		if errors.Is(err, com.ErrBadDownloadSettings) {
+			badRequest(w, err.Error())
+			return
+		}
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"settings": s, "active": h.Downloads.Active()})
+}
+
+type accessGroupOut struct {
+	com.RouteGroup
+	Level com.AccessLevel `json:"level"`
+}
+
+func (h *SettingsHandler) accessGroups(levels map[string]com.AccessLevel) []accessGroupOut {
+	out := make([]accessGroupOut, 0, len(com.RouteGroups))
+	for _, g := range com.RouteGroups {
+		out = append(out, accessGroupOut{RouteGroup: g, Level: levels[g.Key]})
+	}
+	return out
+}
+
+// GET /local/api/settings/access lists the route groups with their paths
+// and the level each needs ("public" or 0-3).
+func (h *SettingsHandler) GetAccessPolicy(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"groups": h.accessGroups(h.Policy.Levels(r.Context()))})
+}
+
+// POST /local/api/settings/access takes {"raw": 3, "gallery": "public"};
+// groups left out keep their level.
+func (h *SettingsHandler) PostAccessPolicy(w http.ResponseWriter, r *http.Request) {
+	var changes map[string]com.AccessLevel
+	if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+		if errors.Is(err, com.ErrBadPolicy) {
+			badRequest(w, err.Error())
+			return
+		}
+		badRequest(w, "invalid JSON body: "+err.Error())
+		return
+	}
+	levels, err := h.Policy.Update(r.Context(), changes)
+	if err != nil {
+		if errors.Is(err, com.ErrBadPolicy) {
+			badRequest(w, err.Error())
+			return
+		}
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"groups": h.accessGroups(levels)})
+}
+
+// GET /local/api/settings/ratelimit returns the limits and the clients
+// refused in the last ten minutes.
+func (h *SettingsHandler) GetRateLimit(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"settings":  h.RateLimit.Settings(r.Context()),
+		"throttled": h.RateLimit.Throttled(),
+	})
+}
+
+// POST /local/api/settings/ratelimit takes a full or partial settings
+// object, e.g. {"classes": {"login": {"perMinute": 5, "burst": 3}}}.
+func (h *SettingsHandler) PostRateLimit(w http.ResponseWriter, r *http.Request) {
+	// classes are decoded over the current values one by one, so a class
+	// sent with only perMinute keeps its burst
+	body := struct {
+		com.RateLimitSettings
+		Classes map[string]json.RawMessage `json:"classes"`
+	}{RateLimitSettings: h.RateLimit.Settings(r.Context())}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&body); err != nil {
+		badRequest(w, "invalid JSON body: "+err.Error())
+		return
+	}
+	s := body.RateLimitSettings
+	if s.Classes == nil {
+		s.Classes = map[string]com.RateClass{}
+	}
+	for name, raw := range body.Classes {
+		c := s.Classes[name]
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&c); err != nil {
+			badRequest(w, "invalid class "+name+": "+err.Error())
+			return
+		}
+		s.Classes[name] = c
+	}
+	s, err := h.RateLimit.SaveSettings(r.Context(), s)
+	if err != nil {
+		if errors.Is(err, com.ErrBadRateLimit) {
+			badRequest(w, err.Error())
+			return
+		}
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"settings": s, "throttled": h.RateLimit.Throttled()})
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"OnlySats/com"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// admin API for signed, expiring links that open one file, folder or pass
+// to someone without an account.
+type ShareLinksHandler struct {
+	Links *com.ShareLinks
+	User  func(r *http.Request) string // signed-in admin, recorded on each link
+}
+
+type shareLinkReq struct {
+	Kind         string  `json:"kind"` // export | zip | image
+	Path         string  `json:"path"`
+	PassID       int64   `json:"passId"`       // zip links: the pass folder instead of path
+	ExpiresHours float64 `json:"expiresHours"` // 0 = 72
+	MaxDownloads int     `json:"maxDownloads"` // 0 = unlimited
+	Note         string  `json:"note"`
+}
+
+// GET /local/api/share-links?limit=100
+func (h *ShareLinksHandler) List(w http.ResponseWriter, r *http.Request) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	links, err := h.Links.List(r.Context(), limit)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"links": links})
+}
+
+// POST /local/api/share-links returns the new link with its signed url,
+// relative to this site.
+func (h *ShareLinksHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var in shareLinkReq
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		badRequest(w, "invalid json")
+		return
+	}
+	l := com.ShareLink{Kind: in.Kind, Path: in.Path, MaxDownloads: in.MaxDownloads, Note: in.Note}
+	if h.User != nil {
+		l.CreatedBy = h.User(r)
+	}
+	ttl := time.Duration(in.ExpiresHours * float64(time.Hour))
+	if in.ExpiresHours < 0 {
+		ttl = -1
+	}
+	link, err := h.Links.Create(r.Context(), l, in.PassID, ttl)
+	if err != nil {
+		if errors.Is(err, com.ErrBadShareLink) {
+			badRequest(w, err.Error())
+			return
+		}
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, link)
+}
+
+// DELETE /local/api/share-links/{id} revokes the link; it stays listed.
+func (h *ShareLinksHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(mux.Vars(r), "id")
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	link, err := h.Links.Revoke(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			notFound(w, "share link not found")
+			return
+		}
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, link)
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"OnlySats/com"
+	"errors"
+	"net/http"
+)
+
+// admin API for thumbnail cache maintenance.
+type ThumbnailsHandler struct {
+	GC      *com.ThumbGC
+	Refresh *com.ThumbRefresher
+	Queue   *com.ThumbQueue
+}
+
+// POST /local/api/thumbnails/gc?dry_run=1&verify=1
+func (h *ThumbnailsHandler) RunGC(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	rep, err := h.GC.Run(r.Context(), queryFlag(q.Get("dry_run")), queryFlag(q.Get("verify")))
+	if errors.Is(err, com.ErrGCRunning) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rep)
+}
+
+// GET /local/api/thumbnails/gc returns the last report (null before any run).
+func (h *ThumbnailsHandler) LastGC(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.GC.Last())
+}
+
+// GET /local/api/thumbnails/refresh reports regeneration after a settings change.
+func (h *ThumbnailsHandler) RefreshStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.Refresh.Status())
+}
+
+// GET /local/api/thumbnails/queue reports queue depth and throughput.
+func (h *ThumbnailsHandler) QueueStatus(w http.ResponseWriter, r *http.Request) {
+	st, err := h.Queue.Status(r.Context())
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+// POST /local/api/thumbnails/queue/kick wakes the queue and retries failed images.
+func (h *ThumbnailsHandler) KickQueue(w http.ResponseWriter, r *http.Request) {
+	h.Queue.Kick()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func queryFlag(v string) bool {
+	switch v {
+	case "1", "true", "yes", "on":
+		return true
+	}
+	return false
+}
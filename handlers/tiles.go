@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"OnlySats/com"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// serves deep-zoom pyramids built by com.Tiler.
+type TilesHandler struct {
+	Tiler *com.Tiler
+}
+
+// GET /tiles/{id}/info.json describes the pyramid (size, levels, URL template).
+func (h *TilesHandler) Info(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(mux.Vars(r), "id")
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	info, err := h.Tiler.Info(id)
+	if err != nil {
+		if os.IsNotExist(err) {
+			notFound(w, "no tiles for this image")
+			return
+		}
+		serverErr(w, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, http.StatusOK, info)
+}
+
+// GET /tiles/{id}/{z}/{x}/{y}
+func (h *TilesHandler) Tile(w http.ResponseWriter, r *http.Request) {
+	v := mux.Vars(r)
+	id, err := parseID(v, "id")
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	z, _ := strconv.Atoi(v["z"])
+	x, _ := strconv.Atoi(v["x"])
+	y, _ := strconv.Atoi(v["y"])
+
+	f, err := os.Open(h.Tiler.TilePath(id, z, x, y))
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+			return
+		}
+		serverErr(w, err)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", h.Tiler.ContentType())
+	// pyramids are rebuilt in place when settings change, so revalidate daily
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
@@ -4,20 +4,17 @@ import (
 	"OnlySats/com"
 	"OnlySats/config"
 	"context"
-	"database/sql"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"path/filepath"
 	"time"
-
-	_ "github.com/mattn/go-sqlite3"
 )
 
 type UpdateHandler struct {
 	Cfg      *config.AppConfig
 	Pass     *config.PassConfig
+	Thumbs   *com.ThumbQueue
 	Cooldown time.Duration
 
 	lastRun  time.Time
@@ -26,6 +23,7 @@ type UpdateHandler struct {
 type RepopulateHandler struct {
 	Cfg      *config.AppConfig
 	Pass     *config.PassConfig
+	Thumbs   *com.ThumbQueue
 	Cooldown time.Duration
 
 	lastRun  time.Time
@@ -249,55 +247,26 @@ func (h *UpdateHandler) runDBUpdate(ctx context.Context) error {
 }
 
 func (h *UpdateHandler) runThumbgen(ctx context.Context) error {
-	dsn := filepath.Join(h.Cfg.Paths.DataDir, "image_metadata.db") + "?_busy_timeout=5000&_journal_mode=WAL&_cache_size=10000"
-	db, err := sql.Open("sqlite3", dsn)
-	if err != nil {
-		return fmt.Errorf("open db: %w", err)
-	}
-	defer db.Close()
+	return flushThumbs(ctx, h.Thumbs)
+}
 
-	if err := db.PingContext(ctx); err != nil {
-		return fmt.Errorf("ping db: %w", err)
+// flushThumbs waits for the thumbnail queue to catch up with the rows the
+// DB update just added. On timeout the queue keeps working in the background.
+func flushThumbs(ctx context.Context, q *com.ThumbQueue) error {
+	if q == nil {
+		return errors.New("thumbnail queue not configured")
 	}
-
-	type result struct{ err error }
-	ch := make(chan result, 1)
-	go func() {
-		err := com.RunThumbGen(h.Cfg, db)
-		ch <- result{err}
-	}()
-	select {
-	case <-ctx.Done():
-		return errors.New("thumbgen timed out or canceled")
-	case res := <-ch:
-		return res.err
+	if err := q.Flush(ctx); err != nil {
+		if ctx.Err() != nil {
+			return errors.New("thumbgen timed out or canceled (still running in background)")
+		}
+		return err
 	}
+	return nil
 }
 
 func (h *RepopulateHandler) runThumbgen(ctx context.Context) error {
-	dsn := filepath.Join(h.Cfg.Paths.DataDir, "image_metadata.db") + "?_busy_timeout=5000&_journal_mode=WAL&_cache_size=10000"
-	db, err := sql.Open("sqlite3", dsn)
-	if err != nil {
-		return fmt.Errorf("open db: %w", err)
-	}
-	defer db.Close()
-
-	if err := db.PingContext(ctx); err != nil {
-		return fmt.Errorf("ping db: %w", err)
-	}
-
-	type result struct{ err error }
-	ch := make(chan result, 1)
-	go func() {
-		err := com.RunThumbGen(h.Cfg, db)
-		ch <- result{err}
-	}()
-	select {
-	case <-ctx.Done():
-		return errors.New("thumbgen timed out or canceled")
-	case res := <-ch:
-		return res.err
-	}
+	return flushThumbs(ctx, h.Thumbs)
 }
 
 func (h *RepopulateHandler) runDBRepopulate(ctx context.Context) error {
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"OnlySats/com"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// admin API for outbound webhooks and their delivery log.
+type WebhooksHandler struct {
+	Store      *com.LocalDataStore
+	Dispatcher *com.WebhookDispatcher
+}
+
+type webhookReq struct {
+	Name     string   `json:"name"`
+	URL      string   `json:"url"`
+	Kind     string   `json:"kind"`
+	Events   []string `json:"events"`
+	Template string   `json:"template"`
+	Secret   *string  `json:"secret,omitempty"`
+	Enabled  *bool    `json:"enabled,omitempty"`
+}
+
+// secrets are write-only; the list only says whether one is set.
+type webhookOut struct {
+	com.Webhook
+	HasSecret bool `json:"has_secret"`
+}
+
+func toWebhookOut(h com.Webhook) webhookOut {
+	out := webhookOut{Webhook: h, HasSecret: h.Secret != ""}
+	out.Secret = ""
+	return out
+}
+
+func (h *WebhooksHandler) List(w http.ResponseWriter, r *http.Request) {
+	hooks, err := h.Store.ListWebhooks(r.Context())
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	out := make([]webhookOut, 0, len(hooks))
+	for _, wh := range hooks {
+		out = append(out, toWebhookOut(wh))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (h *WebhooksHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var in webhookReq
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		badRequest(w, "invalid json")
+		return
+	}
+	wh := com.Webhook{
+		Name:     in.Name,
+		URL:      in.URL,
+		Kind:     in.Kind,
+		Events:   in.Events,
+		Template: in.Template,
+		Enabled:  true,
+	}
+	if in.Secret != nil {
+		wh.Secret = *in.Secret
+	}
+	if in.Enabled != nil {
+		wh.Enabled = *in.Enabled
+	}
+	id, err := h.Store.CreateWebhook(r.Context(), wh)
+	if err != nil {
+		if errors.Is(err, com.ErrBadWebhook) {
+			badRequest(w, err.Error())
+			return
+		}
+		serverErr(w, err)
+		return
+	}
+	wh.ID = id
+	writeJSON(w, http.StatusCreated, toWebhookOut(wh))
+}
+
+func (h *WebhooksHandler) Update(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(mux.Vars(r), "id")
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	cur, err := h.Store.GetWebhook(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			notFound(w, "webhook not found")
+			return
+		}
+		serverErr(w, err)
+		return
+	}
+	var in webhookReq
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		badRequest(w, "invalid json")
+		return
+	}
+	cur.Name = in.Name
+	cur.URL = in.URL
+	cur.Kind = in.Kind
+	cur.Events = in.Events
+	cur.Template = in.Template
+	if in.Secret != nil {
+		cur.Secret = *in.Secret
+	}
+	if in.Enabled != nil {
+		cur.Enabled = *in.Enabled
+	}
+	if err := h.Store.UpdateWebhook(r.Context(), *cur); err != nil {
+		switch {
+		case errors.Is(err, com.ErrBadWebhook):
+			badRequest(w, err.Error())
+		case errors.Is(err, sql.ErrNoRows):
+			notFound(w, "webhook not found")
+		default:
+			serverErr(w, err)
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, toWebhookOut(*cur))
+}
+
+func (h *WebhooksHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(mux.Vars(r), "id")
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	if err := h.Store.DeleteWebhook(r.Context(), id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			notFound(w, "webhook not found")
+			return
+		}
+		serverErr(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *WebhooksHandler) Deliveries(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(mux.Vars(r), "id")
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	rows, err := h.Store.ListWebhookDeliveries(r.Context(), id, limit)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rows)
+}
+
+// POST /local/api/webhooks/{id}/test
+func (h *WebhooksHandler) Test(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(mux.Vars(r), "id")
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	if h.Dispatcher == nil {
+		http.Error(w, "webhooks not running", http.StatusServiceUnavailable)
+		return
+	}
+	del, err := h.Dispatcher.SendTest(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			notFound(w, "webhook not found")
+			return
+		}
+		serverErr(w, err)
+		return
+	}
+	status := http.StatusOK
+	if !del.OK {
+		status = http.StatusBadGateway
+	}
+	writeJSON(w, status, del)
+}
@@ -10,6 +10,9 @@ import (
 	"html/template"
 	"io/fs"
 	"log"
+	"log/slog"
+	"math"
+	"net"
 	"net/http"
 	"net/url"
 	"path/filepath"
@@ -23,6 +26,8 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 
 	com "OnlySats/com"
+	"OnlySats/com/logging"
+	"OnlySats/com/metrics"
 	"OnlySats/com/shared"
 	"OnlySats/config"
 	"OnlySats/handlers"
@@ -40,7 +45,30 @@ type Application struct {
 	localStore   *com.LocalDataStore
 	sessionStore *sessions.CookieStore
 	tempAdmin    *com.EphemeralAdmin
+	webhooks     *com.WebhookDispatcher
+	alerts       *com.AlertEngine
+	mailer       *com.Mailer
+	hwHistory    *metrics.HistorySampler
+	thumbGC      *com.ThumbGC
+	thumbRefresh *com.ThumbRefresher
+	thumbQueue   *com.ThumbQueue
+	renditions   []com.Rendition
+	tiles        *com.Tiler            // nil unless thumbgen.tiles is enabled
+	animator     *com.Animator         // nil unless [animations] is enabled
+	embedMeta    *com.MetadataEmbedder // nil unless [image_metadata] is enabled
+	watermark    *com.Watermarker      // nil unless [derivatives] is enabled
+	exporter     *com.Exporter         // nil unless [exports] is enabled
+	downloads    *com.DownloadLimiter
+	shareLinks   *com.ShareLinks
+	policy       *com.AccessPolicy
+	rateLimit    *com.RateLimiter
+	loginGuard   *com.LoginGuard
+	proxies      []*net.IPNet // [server] trusted_proxies
 	startTime    time.Time
+
+	// lifetime of background services
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 // NewApplication creates and initializes a new Application instance
@@ -48,14 +76,18 @@ func NewApplication() (*Application, error) {
 	app := &Application{
 		startTime: time.Now(),
 	}
+	app.ctx, app.cancel = context.WithCancel(context.Background())
 
 	if err := app.loadConfig(); err != nil {
 		return nil, fmt.Errorf("failed to load config: %w", err)
 	}
+	app.renditions = com.Renditions(app.config)
+	app.tiles = com.NewTiler(app.config, com.ThumbBackend(app.config))
 
 	if err := app.initializeStores(); err != nil {
 		return nil, fmt.Errorf("failed to initialize stores: %w", err)
 	}
+	app.embedMeta = com.NewMetadataEmbedder(app.config, app.localStore, app.db.DB)
 
 	return app, nil
 }
@@ -64,6 +96,10 @@ func NewApplication() (*Application, error) {
 func (app *Application) Close() error {
 	var errs []error
 
+	if app.cancel != nil {
+		app.cancel()
+	}
+
 	if app.localStore != nil {
 		if err := app.localStore.Close(); err != nil {
 			errs = append(errs, fmt.Errorf("local store close: %w", err))
@@ -76,6 +112,10 @@ func (app *Application) Close() error {
 		}
 	}
 
+	if err := logging.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("log close: %w", err))
+	}
+
 	if len(errs) > 0 {
 		return fmt.Errorf("multiple close errors: %v", errs)
 	}
@@ -86,7 +126,10 @@ func (app *Application) Close() error {
 func (app *Application) loadConfig() error {
 	var err error
 	app.config, app.passConfig, err = config.LoadConfig("config.toml")
-	return err
+	if err != nil {
+		return err
+	}
+	return logging.Setup(app.config)
 }
 
 func (app *Application) initializeStores() error {
@@ -96,6 +139,7 @@ func (app *Application) initializeStores() error {
 	if err != nil {
 		return fmt.Errorf("local data init: %w", err)
 	}
+	app.downloads = com.NewDownloadLimiter(app.localStore)
 
 	// Init sqlite3 for image meta and settings
 	dbCfg, err := shared.NewConfigFromAppConfig(app.config)
@@ -124,19 +168,73 @@ func (app *Application) initializeStores() error {
 
 	secure := true
 	app.sessionStore = com.NewCookieStore(keys, secure, 60*60*48)
+	app.shareLinks = com.NewShareLinks(app.localStore, app.db.DB, app.config.Paths.LiveOutputDir, keys.Auth)
+	app.policy = com.NewAccessPolicy(app.localStore, app.sessionStore)
+	app.rateLimit = com.NewRateLimiter(app.localStore, func(r *http.Request) (int, bool) {
+		_, level, err := com.RequireAuthQuick(app.sessionStore, r, math.MaxInt)
+		return level, err == nil
+	})
+	app.proxies, err = com.ParseTrustedProxies(app.config.Server.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("server config: %w", err)
+	}
+	app.loginGuard, err = com.NewLoginGuard(context.Background(), app.localStore)
+	if err != nil {
+		return fmt.Errorf("login guard init: %w", err)
+	}
 
 	return nil
 }
 
+// starts long-running services that other startup tasks may publish to.
+func (app *Application) startBackgroundServices() {
+	app.webhooks = com.NewWebhookDispatcher(app.localStore, app.config.StationProxy.StationId)
+	app.webhooks.Start(app.ctx)
+
+	app.alerts = com.NewAlertEngine(app.localStore, app.config, app.db.DB)
+	app.alerts.Start(app.ctx)
+
+	app.mailer = com.NewMailer(app.config, app.localStore, app.db.DB)
+	app.mailer.Start(app.ctx)
+
+	app.hwHistory = metrics.NewHistorySampler(app.anal, app.config.Paths.LiveOutputDir)
+	app.hwHistory.Enabled = func(ctx context.Context) bool {
+		v, _ := app.localStore.GetSetting(ctx, "hwmonitor")
+		return v != "off"
+	}
+	app.hwHistory.Start(app.ctx)
+
+	app.thumbGC = com.NewThumbGC(app.db.DB, app.config.Paths.LiveOutputDir, app.config.Paths.ThumbnailDir,
+		app.renditions, com.ThumbBackend(app.config), app.tiles, time.Duration(app.config.Thumbgen.GCIntervalHours)*time.Hour)
+	app.thumbGC.Start(app.ctx)
+
+	app.thumbRefresh = com.NewThumbRefresher(app.db.DB, app.config.Paths.LiveOutputDir, app.config.Paths.ThumbnailDir,
+		app.renditions, com.ThumbBackend(app.config), app.tiles)
+
+	app.thumbQueue = com.NewThumbQueue(app.db.DB, app.config.Paths.LiveOutputDir, app.config.Paths.ThumbnailDir,
+		app.renditions, com.ThumbBackend(app.config), app.tiles, app.config.Thumbgen.MaxWorkers, app.config.Thumbgen.BatchSize)
+
+	app.animator = com.NewAnimator(app.config, app.localStore, app.db.DB, com.ThumbBackend(app.config))
+	if app.animator != nil {
+		app.animator.Start(app.ctx)
+	}
+
+	app.exporter = com.NewExporter(app.config, app.localStore)
+	if app.exporter != nil {
+		app.exporter.Start(app.ctx)
+	}
+}
+
 func (app *Application) runStartupTasks() error {
 	// Run database update
-	if err := com.RunDBUpdate(app.config, app.passConfig, false); err != nil {
-		return fmt.Errorf("database update: %w", err)
-	}
+	err := com.RunDBUpdate(app.config, app.passConfig, false)
 
-	// Generate thumbnails
-	if err := com.RunThumbGen(app.config, app.db.DB); err != nil {
-		return fmt.Errorf("thumbnail generation: %w", err)
+	// Generate thumbnails in the background: new images through the queue,
+	// ones made with older [thumbgen] settings through the refresher
+	app.thumbQueue.Start(app.ctx)
+	app.thumbRefresh.Start(app.ctx)
+	if err != nil {
+		return fmt.Errorf("database update: %w", err)
 	}
 	log.Println("Data initialized")
 	return nil
@@ -157,7 +255,13 @@ func (app *Application) startStationProxy() {
 
 func (app *Application) createRouter() *mux.Router {
 	r := mux.NewRouter()
+	r.Use(com.ResolveClientIP(app.proxies))
 	r.Use(com.SecurityHeaders)
+	r.Use(com.HTTPMetrics)
+	r.Use(com.AccessLog)
+	r.Use(app.rateLimit.Middleware)
+	r.Use(app.shareLinks.Middleware) // signed ?share= links, ahead of the policy they bypass
+	r.Use(app.policy.Middleware)     // levels of the route groups in com.RouteGroups
 
 	// route handlers
 	app.setupStaticRoutes(r)
@@ -196,12 +300,16 @@ func (app *Application) setupGalleryRoutes(r *mux.Router) {
 		log.Fatal("Failed to create HTML filesystem:", err)
 	}
 
-	apiHandler := handlers.NewAPIHandler(app.db)
+	apiHandler := handlers.NewAPIHandler(app.db, app.renditions)
+	apiHandler.Tiles = app.tiles != nil
 	gapi := &handlers.GalleryAPI{
 		DB:            app.db.DB,
 		LiveOutputDir: app.config.Paths.LiveOutputDir,
 		UserContent:   filepath.Join("public", "userContent"),
 		LocalStore:    app.localStore,
+		Renditions:    app.renditions,
+		Meta:          app.embedMeta,
+		Downloads:     app.downloads,
 	}
 
 	galleryHandler, _, err := handlers.GalleryHandler(htmlFS, gapi)
@@ -211,19 +319,78 @@ func (app *Application) setupGalleryRoutes(r *mux.Router) {
 
 	// API endpoints
 	r.HandleFunc("/api/images", apiHandler.GetImages).Methods("GET")
+	r.HandleFunc("/api/thumbnails", apiHandler.GetRenditions).Methods("GET")
 	r.HandleFunc("/api/satellites", gapi.Satellites()).Methods("GET")
 	r.HandleFunc("/api/bands", gapi.Bands()).Methods("GET")
 	r.HandleFunc("/api/composites", gapi.CompositesList()).Methods("GET")
 	r.HandleFunc("/api/export", gapi.ExportCADU()).Methods("GET")
+	r.HandleFunc("/api/export/geo", gapi.ExportGeo()).Methods("GET")
 	r.HandleFunc("/api/zip", gapi.ZipPath()).Methods("GET")
 
 	// Gallery page
 	r.HandleFunc("/gallery", galleryHandler).Methods("GET")
+
+	// Timelapse animations
+	if app.animator != nil {
+		anims := &handlers.AnimationsHandler{Store: app.localStore, Animator: app.animator}
+		r.HandleFunc("/api/animations", anims.List).Methods("GET")
+		r.HandleFunc("/api/animations/{id:[0-9]+}", anims.Get).Methods("GET")
+		r.HandleFunc("/api/animations/{id:[0-9]+}/file", anims.File).Methods("GET")
+		r.Handle("/local/api/animations", http.HandlerFunc(anims.Create)).Methods("POST")
+		r.Handle("/local/api/animations/{id:[0-9]+}", http.HandlerFunc(anims.Delete)).Methods("DELETE")
+	}
+	if app.exporter != nil {
+		exports := &handlers.ExportsHandler{API: apiHandler, Exporter: app.exporter, Policy: app.policy}
+		r.HandleFunc("/api/exports/zip", exports.Stream).Methods("GET")
+		r.HandleFunc("/api/exports", exports.Create).Methods("POST")
+		r.HandleFunc("/api/exports/{id:[0-9]+}", exports.Get).Methods("GET")
+		r.HandleFunc("/api/exports/{id:[0-9]+}/file", exports.File).Methods("GET")
+		r.Handle("/local/api/exports", http.HandlerFunc(exports.List)).Methods("GET")
+		r.Handle("/local/api/exports/{id:[0-9]+}", http.HandlerFunc(exports.Delete)).Methods("DELETE")
+	}
 }
 
 func (app *Application) setupImageRoutes(r *mux.Router) {
-	r.PathPrefix("/images/").Handler(handlers.ImageServer(app.config.Paths.LiveOutputDir))
-	r.PathPrefix("/thumbnails/").Handler(handlers.ThumbnailServer(app.config.Paths.LiveOutputDir, app.config.Paths.ThumbnailDir))
+	var deriv *handlers.Derivatives
+	if dc := app.config.Derivatives; dc.Enabled && len(dc.Widths) > 0 {
+		dir := dc.CacheDir
+		if strings.TrimSpace(dir) == "" {
+			dir = filepath.Join(app.config.Paths.DataDir, "derivatives")
+		}
+		cache, err := com.NewDerivativeCache(dir, int64(dc.MaxSize)<<20, com.ThumbBackend(app.config), dc.Workers)
+		if err != nil {
+			logging.For("derivatives").Warn("derivatives disabled", "err", err)
+		} else {
+			quality := dc.Quality
+			if quality <= 0 || quality > 100 {
+				quality = 80
+			}
+			app.watermark = com.NewWatermarker(app.config, app.localStore, app.db.DB)
+			deriv = &handlers.Derivatives{
+				Cache:     cache,
+				Widths:    dc.Widths,
+				Quality:   quality,
+				Watermark: app.watermark,
+				UserLevel: func(r *http.Request) (int, bool) {
+					_, level, err := com.RequireAuthQuick(app.sessionStore, r, math.MaxInt)
+					return level, err == nil
+				},
+			}
+		}
+	}
+	r.PathPrefix("/images/").Handler(handlers.ImageServer(app.config.Paths.LiveOutputDir, deriv, app.embedMeta))
+	var gen *com.OnDemandThumbs
+	if app.config.Thumbgen.OnDemand {
+		gen = com.NewOnDemandThumbs(app.db.DB, app.config.Paths.LiveOutputDir, app.config.Paths.ThumbnailDir,
+			app.renditions, com.ThumbBackend(app.config), app.config.Thumbgen.OnDemandWorkers)
+	}
+	r.PathPrefix("/thumbnails/").Handler(handlers.ThumbnailServer(app.config.Paths.LiveOutputDir, app.config.Paths.ThumbnailDir, app.renditions, gen))
+
+	if app.tiles != nil {
+		tiles := &handlers.TilesHandler{Tiler: app.tiles}
+		r.HandleFunc("/tiles/{id:[0-9]+}/info.json", tiles.Info).Methods("GET")
+		r.HandleFunc("/tiles/{id:[0-9]+}/{z:[0-9]+}/{x:[0-9]+}/{y:[0-9]+}", tiles.Tile).Methods("GET")
+	}
 }
 
 func (app *Application) setupSatdumpRoutes(r *mux.Router) {
@@ -333,7 +500,7 @@ func (app *Application) setupSatdumpRoutes(r *mux.Router) {
 	}
 
 	// Index: use cookie or first instance
-	r.Handle("/local/satdump", app.requireAuth(3, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	r.Handle("/local/satdump", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		name, ok := getActive(r)
 		if !ok {
 			if first, ok2 := firstInstance(r.Context()); ok2 {
@@ -354,26 +521,26 @@ func (app *Application) setupSatdumpRoutes(r *mux.Router) {
 			}
 		}
 		http.Redirect(w, r, "/local/satdump/"+url.PathEscape(name), http.StatusFound) // 302
-	}))).Methods("GET")
+	})).Methods("GET")
 
-	r.Handle("/local/satdump/live", app.requireAuth(3, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	r.Handle("/local/satdump/live", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if _, ip, port, ok := resolveFromCookieOrFirst(w, r); ok {
 			handlers.SatdumpLive(ip, port).ServeHTTP(w, r)
 			return
 		}
 		http.Error(w, "No SatDump instances configured", http.StatusNotFound)
-	}))).Methods("GET")
+	})).Methods("GET")
 
-	r.Handle("/local/satdump/html", app.requireAuth(3, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	r.Handle("/local/satdump/html", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if _, ip, port, ok := resolveFromCookieOrFirst(w, r); ok {
 			handlers.SatdumpHTML(ip, port).ServeHTTP(w, r)
 			return
 		}
 		http.Error(w, "No SatDump instances configured", http.StatusNotFound)
-	}))).Methods("GET")
+	})).Methods("GET")
 
 	// match name
-	r.Handle("/local/satdump/{name:[^/.]+}", app.requireAuth(3, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	r.Handle("/local/satdump/{name:[^/.]+}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		name := mux.Vars(r)["name"]
 		if u, err := url.PathUnescape(name); err == nil {
 			name = u
@@ -412,10 +579,10 @@ func (app *Application) setupSatdumpRoutes(r *mux.Router) {
 			http.Error(w, "Template rendering failed", http.StatusInternalServerError)
 			return
 		}
-	}))).Methods("GET")
+	})).Methods("GET")
 
 	// asset proxy
-	r.PathPrefix("/local/satdump/").Handler(app.requireAuth(3, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	r.PathPrefix("/local/satdump/").Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if _, ip, port, ok := resolveFromCookieOrFirst(w, r); ok {
 			r2 := r.Clone(r.Context())
 			r2.URL.Path = strings.TrimPrefix(r.URL.Path, "/local/satdump")
@@ -426,7 +593,7 @@ func (app *Application) setupSatdumpRoutes(r *mux.Router) {
 			return
 		}
 		http.Error(w, "No SatDump instances configured", http.StatusNotFound)
-	})))
+	}))
 
 	ah := &handlers.SatdumpHandler{Store: app.localStore, AnalDB: app.anal}
 	r.Handle("/api/satdump/names", http.HandlerFunc(ah.Names)).Methods("GET")
@@ -445,33 +612,52 @@ func (app *Application) setupUpdateRoutes(r *mux.Router) {
 	upd := &handlers.UpdateHandler{
 		Cfg:      app.config,
 		Pass:     app.passConfig,
+		Thumbs:   app.thumbQueue,
 		Cooldown: cd,
 	}
 	rpl := &handlers.RepopulateHandler{
 		Cfg:      app.config,
 		Pass:     app.passConfig,
+		Thumbs:   app.thumbQueue,
 		Cooldown: time.Minute,
 	}
 
 	r.Handle("/api/update", upd).Methods("POST")
-	r.Handle("/api/repopulate", app.requireAuth(3, rpl)).Methods("POST")
+	r.Handle("/api/repopulate", rpl).Methods("POST")
 }
 
 func (app *Application) setupMiscRoutes(r *mux.Router) {
 	// Settings handler
-	settings := &handlers.SettingsHandler{Store: app.localStore}
-	r.Handle("/api/config/theme", app.requireAuth(1, http.HandlerFunc(settings.PostTheme))).Methods("POST")
-	r.Handle("/local/api/settings", app.requireAuth(1, http.HandlerFunc(settings.PostSettings))).Methods("POST")
-	r.Handle("/local/api/settings", app.requireAuth(1, http.HandlerFunc(settings.GetSettings))).Methods("GET")
+	settings := &handlers.SettingsHandler{
+		Store:     app.localStore,
+		Watermark: app.watermark,
+		Downloads: app.downloads,
+		Policy:    app.policy,
+		RateLimit: app.rateLimit,
+	}
+	r.Handle("/api/config/theme", http.HandlerFunc(settings.PostTheme)).Methods("POST")
+	r.Handle("/local/api/settings", http.HandlerFunc(settings.PostSettings)).Methods("POST")
+	r.Handle("/local/api/settings", http.HandlerFunc(settings.GetSettings)).Methods("GET")
+	r.Handle("/local/api/settings/watermark", http.HandlerFunc(settings.GetWatermark)).Methods("GET")
+	r.Handle("/local/api/settings/watermark", http.HandlerFunc(settings.PostWatermark)).Methods("POST")
+	r.Handle("/local/api/settings/watermark/logo", http.HandlerFunc(settings.GetWatermarkLogo)).Methods("GET")
+	r.Handle("/local/api/settings/watermark/logo", http.HandlerFunc(settings.PostWatermarkLogo)).Methods("POST")
+	r.Handle("/local/api/settings/watermark/logo", http.HandlerFunc(settings.DeleteWatermarkLogo)).Methods("DELETE")
+	r.Handle("/local/api/settings/downloads", http.HandlerFunc(settings.GetDownloads)).Methods("GET")
+	r.Handle("/local/api/settings/downloads", http.HandlerFunc(settings.PostDownloads)).Methods("POST")
+	r.Handle("/local/api/settings/access", http.HandlerFunc(settings.GetAccessPolicy)).Methods("GET")
+	r.Handle("/local/api/settings/access", http.HandlerFunc(settings.PostAccessPolicy)).Methods("POST")
+	r.Handle("/local/api/settings/ratelimit", http.HandlerFunc(settings.GetRateLimit)).Methods("GET")
+	r.Handle("/local/api/settings/ratelimit", http.HandlerFunc(settings.PostRateLimit)).Methods("POST")
 
 	htmlFS, err := fs.Sub(embeddedFiles, "public/html")
 	if err != nil {
 		log.Fatal("Failed to create HTML filesystem:", err)
 	}
 
-	r.Handle("/local/configure-passes", app.requireAuth(1, app.serveEmbeddedHTML("template_editor.html", htmlFS))).Methods("GET")
+	r.Handle("/local/configure-passes", app.serveEmbeddedHTML("template_editor.html", htmlFS)).Methods("GET")
 	tapi := handlers.NewTemplatesAdminAPI(app.localStore) // make sure StationPreferences is opened at startup
-	tapi.Register(r, app.requireAuth)
+	tapi.Register(r)
 
 	// Hardware monitor handler
 	hw := &handlers.HardwareHandler{
@@ -479,18 +665,32 @@ func (app *Application) setupMiscRoutes(r *mux.Router) {
 		Store:   app.localStore,
 		Timeout: 3 * time.Second,
 	}
-	r.Handle("/local/api/hardware", app.requireAuth(3, hw)).Methods("GET")
+	r.Handle("/local/api/hardware", hw).Methods("GET")
+	hwHist := &handlers.HardwareHistoryHandler{Sampler: app.hwHistory}
+	r.Handle("/local/api/hardware/history", hwHist).Methods("GET")
 	info := handlers.NewInfoHandler(app.startTime)
 	r.Handle("/local/api/info", info).Methods("GET")
 
+	// Prometheus scrape endpoint (off by default, optional bearer token, see [metrics])
+	if app.config.Metrics.Enabled && app.config.Metrics.Token == "" {
+		logging.For("metrics").Warn("/metrics is enabled without a token and readable by anyone who can reach this server")
+	}
+	prom := &handlers.PrometheusHandler{
+		Cfg:      app.config,
+		Store:    app.localStore,
+		AppStart: app.startTime,
+		Timeout:  3 * time.Second,
+	}
+	r.Handle("/metrics", prom).Methods("GET")
+
 	// CSS and admin routes
 	r.Handle("/colors.css", &handlers.ColorsCSSHandler{Store: app.localStore})
-	r.Handle("/local/stats", app.requireAuth(3, app.serveEmbeddedHTML("stats.html", htmlFS))).Methods("GET")
-	r.Handle("/local/admin", app.requireAuth(1, app.serveEmbeddedHTML("admin-center.html", htmlFS))).Methods("GET")
-	r.Handle("/local/api/disk-stats", app.requireAuth(3, http.HandlerFunc(handlers.ServeDiskStats(app.config.Paths.LiveOutputDir)))).Methods("GET")
+	r.Handle("/local/stats", app.serveEmbeddedHTML("stats.html", htmlFS)).Methods("GET")
+	r.Handle("/local/admin", app.serveEmbeddedHTML("admin-center.html", htmlFS)).Methods("GET")
+	r.Handle("/local/api/disk-stats", http.HandlerFunc(handlers.ServeDiskStats(app.config.Paths.LiveOutputDir))).Methods("GET")
 
 	// API endpoints
-	r.Handle("/api/stats", app.requireAuth(3, http.HandlerFunc(app.handleStats))).Methods("GET")
+	r.Handle("/api/stats", http.HandlerFunc(app.handleStats)).Methods("GET")
 
 	// About page configuration & read APIs
 	about := &handlers.AboutHandler{Store: app.localStore}
@@ -502,46 +702,104 @@ func (app *Application) setupMiscRoutes(r *mux.Router) {
 	r.Handle("/api/about/meta", http.HandlerFunc(about.GetMeta)).Methods("GET")
 
 	// Admin about endpoints
-	r.Handle("/local/configure-about", app.requireAuth(1, app.serveEmbeddedHTML("about_editor.html", htmlFS))).Methods("GET")
-	r.Handle("/local/api/about/body", app.requireAuth(1, http.HandlerFunc(about.PutBody))).Methods("PUT")
-	r.Handle("/local/api/about/body", app.requireAuth(1, http.HandlerFunc(about.DeleteBody))).Methods("DELETE")
+	r.Handle("/local/configure-about", app.serveEmbeddedHTML("about_editor.html", htmlFS)).Methods("GET")
+	r.Handle("/local/api/about/body", http.HandlerFunc(about.PutBody)).Methods("PUT")
+	r.Handle("/local/api/about/body", http.HandlerFunc(about.DeleteBody)).Methods("DELETE")
 	r.Handle("/api/about/images/{id:[0-9]+}/raw", http.HandlerFunc(about.RawImage)).Methods("GET")
-	r.Handle("/local/api/about/images/upload", app.requireAuth(1, http.HandlerFunc(about.UploadImage))).Methods("POST")
-	r.Handle("/local/api/about/images/{id:[0-9]+}", app.requireAuth(1, http.HandlerFunc(about.UpdateImage))).Methods("PUT")
-	r.Handle("/local/api/about/images/{id:[0-9]+}", app.requireAuth(1, http.HandlerFunc(about.DeleteImage))).Methods("DELETE")
-	r.Handle("/local/api/about/meta/{key}", app.requireAuth(1, http.HandlerFunc(about.PutMeta))).Methods("PUT")
-	r.Handle("/local/api/about/meta/{key}", app.requireAuth(1, http.HandlerFunc(about.DeleteMeta))).Methods("DELETE")
+	r.Handle("/local/api/about/images/upload", http.HandlerFunc(about.UploadImage)).Methods("POST")
+	r.Handle("/local/api/about/images/{id:[0-9]+}", http.HandlerFunc(about.UpdateImage)).Methods("PUT")
+	r.Handle("/local/api/about/images/{id:[0-9]+}", http.HandlerFunc(about.DeleteImage)).Methods("DELETE")
+	r.Handle("/local/api/about/meta/{key}", http.HandlerFunc(about.PutMeta)).Methods("PUT")
+	r.Handle("/local/api/about/meta/{key}", http.HandlerFunc(about.DeleteMeta)).Methods("DELETE")
+
+	// Share links
+	shares := &handlers.ShareLinksHandler{Links: app.shareLinks, User: func(r *http.Request) string {
+		user, _, _ := com.RequireAuthQuick(app.sessionStore, r, 0)
+		return user
+	}}
+	r.Handle("/local/api/share-links", http.HandlerFunc(shares.List)).Methods("GET")
+	r.Handle("/local/api/share-links", http.HandlerFunc(shares.Create)).Methods("POST")
+	r.Handle("/local/api/share-links/{id:[0-9]+}", http.HandlerFunc(shares.Revoke)).Methods("DELETE")
 
 	// Users
-	users := &handlers.UsersHandler{Store: app.localStore}
-
-	r.Handle("/local/api/users", app.requireAuth(0, http.HandlerFunc(users.List))).Methods("GET")
-	r.Handle("/local/api/users", app.requireAuth(0, http.HandlerFunc(users.Create))).Methods("POST")
-	r.Handle("/local/api/users/{id:[0-9]+}", app.requireAuth(0, http.HandlerFunc(users.Delete))).Methods("DELETE")
-	r.Handle("/local/api/users/{id:[0-9]+}/username", app.requireAuth(0, http.HandlerFunc(users.SetUsername))).Methods("PUT")
-	r.Handle("/local/api/users/{id:[0-9]+}/level", app.requireAuth(0, http.HandlerFunc(users.SetLevel))).Methods("PUT")
-	r.Handle("/local/api/users/{id:[0-9]+}/reset-password", app.requireAuth(0, http.HandlerFunc(users.ResetPassword))).Methods("POST")
+	users := &handlers.UsersHandler{Store: app.localStore, Mailer: app.mailer, Logins: app.loginGuard, User: func(r *http.Request) string {
+		user, _, _ := com.RequireAuthQuick(app.sessionStore, r, 0)
+		return user
+	}}
+
+	r.Handle("/local/api/users", http.HandlerFunc(users.List)).Methods("GET")
+	r.Handle("/local/api/users", http.HandlerFunc(users.Create)).Methods("POST")
+	r.Handle("/local/api/users/{id:[0-9]+}", http.HandlerFunc(users.Delete)).Methods("DELETE")
+	r.Handle("/local/api/users/{id:[0-9]+}/username", http.HandlerFunc(users.SetUsername)).Methods("PUT")
+	r.Handle("/local/api/users/{id:[0-9]+}/level", http.HandlerFunc(users.SetLevel)).Methods("PUT")
+	r.Handle("/local/api/users/lockouts", http.HandlerFunc(users.Lockouts)).Methods("GET")
+	r.Handle("/local/api/users/lockouts/unlock", http.HandlerFunc(users.UnlockIP)).Methods("POST")
+	r.Handle("/local/api/users/{id:[0-9]+}/unlock", http.HandlerFunc(users.Unlock)).Methods("POST")
+	r.Handle("/local/api/users/{id:[0-9]+}/reset-password", http.HandlerFunc(users.ResetPassword)).Methods("POST")
+	r.Handle("/local/api/users/{id:[0-9]+}/notifications", http.HandlerFunc(users.GetNotifications)).Methods("GET")
+	r.Handle("/local/api/users/{id:[0-9]+}/notifications", http.HandlerFunc(users.SetNotifications)).Methods("PUT")
+
+	// Outgoing email
+	email := &handlers.EmailHandler{Store: app.localStore, Mailer: app.mailer}
+	r.Handle("/local/api/email/status", http.HandlerFunc(email.Status)).Methods("GET")
+	r.Handle("/local/api/email/test", http.HandlerFunc(email.Test)).Methods("POST")
+	r.Handle("/local/api/email/summary", http.HandlerFunc(email.Summary)).Methods("POST")
+	r.Handle("/local/api/email/queue", http.HandlerFunc(email.Queue)).Methods("GET")
+	r.Handle("/local/api/email/queue/{id:[0-9]+}/retry", http.HandlerFunc(email.Retry)).Methods("POST")
 
 	// Satdump config
 	satdump := &handlers.SatdumpHandler{Store: app.localStore}
 
-	r.Handle("/local/api/satdump", app.requireAuth(0, http.HandlerFunc(satdump.List))).Methods("GET")
-	r.Handle("/local/api/satdump", app.requireAuth(0, http.HandlerFunc(satdump.Create))).Methods("POST")
-	r.Handle("/local/api/satdump/{name}", app.requireAuth(0, http.HandlerFunc(satdump.Get))).Methods("GET")
-	r.Handle("/local/api/satdump/{name}", app.requireAuth(0, http.HandlerFunc(satdump.Update))).Methods("PUT")
-	r.Handle("/local/api/satdump/{name}", app.requireAuth(0, http.HandlerFunc(satdump.Delete))).Methods("DELETE")
+	r.Handle("/local/api/satdump", http.HandlerFunc(satdump.List)).Methods("GET")
+	r.Handle("/local/api/satdump", http.HandlerFunc(satdump.Create)).Methods("POST")
+	r.Handle("/local/api/satdump/{name}", http.HandlerFunc(satdump.Get)).Methods("GET")
+	r.Handle("/local/api/satdump/{name}", http.HandlerFunc(satdump.Update)).Methods("PUT")
+	r.Handle("/local/api/satdump/{name}", http.HandlerFunc(satdump.Delete)).Methods("DELETE")
+
+	// Webhooks
+	hooks := &handlers.WebhooksHandler{Store: app.localStore, Dispatcher: app.webhooks}
+	r.Handle("/local/api/webhooks", http.HandlerFunc(hooks.List)).Methods("GET")
+	r.Handle("/local/api/webhooks", http.HandlerFunc(hooks.Create)).Methods("POST")
+	r.Handle("/local/api/webhooks/{id:[0-9]+}", http.HandlerFunc(hooks.Update)).Methods("PUT")
+	r.Handle("/local/api/webhooks/{id:[0-9]+}", http.HandlerFunc(hooks.Delete)).Methods("DELETE")
+	r.Handle("/local/api/webhooks/{id:[0-9]+}/deliveries", http.HandlerFunc(hooks.Deliveries)).Methods("GET")
+	r.Handle("/local/api/webhooks/{id:[0-9]+}/test", http.HandlerFunc(hooks.Test)).Methods("POST")
+
+	// Alert rules + alerts
+	alerts := &handlers.AlertsHandler{Store: app.localStore, Engine: app.alerts}
+	r.Handle("/local/api/alerts", http.HandlerFunc(alerts.List)).Methods("GET")
+	r.Handle("/local/api/alerts/evaluate", http.HandlerFunc(alerts.Evaluate)).Methods("POST")
+	r.Handle("/local/api/alerts/{id:[0-9]+}/ack", http.HandlerFunc(alerts.Ack)).Methods("POST")
+	r.Handle("/local/api/alerts/rules", http.HandlerFunc(alerts.ListRules)).Methods("GET")
+	r.Handle("/local/api/alerts/rules", http.HandlerFunc(alerts.CreateRule)).Methods("POST")
+	r.Handle("/local/api/alerts/rules/{id:[0-9]+}", http.HandlerFunc(alerts.UpdateRule)).Methods("PUT")
+	r.Handle("/local/api/alerts/rules/{id:[0-9]+}", http.HandlerFunc(alerts.DeleteRule)).Methods("DELETE")
+
+	// Logs
+	logs := &handlers.LogsHandler{Dir: app.config.Paths.LogDir}
+	r.Handle("/local/api/logs", http.HandlerFunc(logs.List)).Methods("GET")
+	r.Handle("/local/api/logs/tail", http.HandlerFunc(logs.Tail)).Methods("GET")
+	r.Handle("/local/api/logs/{name}", http.HandlerFunc(logs.Read)).Methods("GET")
+
+	// Thumbnail cache
+	thumbs := &handlers.ThumbnailsHandler{GC: app.thumbGC, Refresh: app.thumbRefresh, Queue: app.thumbQueue}
+	r.Handle("/local/api/thumbnails/gc", http.HandlerFunc(thumbs.LastGC)).Methods("GET")
+	r.Handle("/local/api/thumbnails/gc", http.HandlerFunc(thumbs.RunGC)).Methods("POST")
+	r.Handle("/local/api/thumbnails/queue", http.HandlerFunc(thumbs.QueueStatus)).Methods("GET")
+	r.Handle("/local/api/thumbnails/queue/kick", http.HandlerFunc(thumbs.KickQueue)).Methods("POST")
+	r.Handle("/local/api/thumbnails/refresh", http.HandlerFunc(thumbs.RefreshStatus)).Methods("GET")
 
 	// Message Posting/Getting
-	r.Handle("/local/messages-admin", app.requireAuth(1, app.serveEmbeddedHTML("messages.html", htmlFS))).Methods("GET")
+	r.Handle("/local/messages-admin", app.serveEmbeddedHTML("messages.html", htmlFS)).Methods("GET")
 
 	msgs := &handlers.MessagesHandler{Store: app.localStore}
 	r.Handle("/api/messages", http.HandlerFunc(msgs.List)).Methods("GET")
 	r.Handle("/api/messages/latest", http.HandlerFunc(msgs.Latest)).Methods("GET")
 	r.Handle("/api/messages/{id:[0-9]+}", http.HandlerFunc(msgs.Get)).Methods("GET")
 	r.Handle("/api/messages/{id:[0-9]+}/image", http.HandlerFunc(msgs.RawImage)).Methods("GET")
-	r.Handle("/local/api/messages", app.requireAuth(1, http.HandlerFunc(msgs.Create))).Methods("POST")
-	r.Handle("/local/api/messages/{id:[0-9]+}", app.requireAuth(1, http.HandlerFunc(msgs.Update))).Methods("PUT")
-	r.Handle("/local/api/messages/{id:[0-9]+}", app.requireAuth(1, http.HandlerFunc(msgs.Delete))).Methods("DELETE")
+	r.Handle("/local/api/messages", http.HandlerFunc(msgs.Create)).Methods("POST")
+	r.Handle("/local/api/messages/{id:[0-9]+}", http.HandlerFunc(msgs.Update)).Methods("PUT")
+	r.Handle("/local/api/messages/{id:[0-9]+}", http.HandlerFunc(msgs.Delete)).Methods("DELETE")
 	r.Handle("/messages/{id:[0-9]+}", app.serveEmbeddedHTML("message_viewer.html", htmlFS)).Methods("GET")
 }
 
@@ -575,51 +833,6 @@ func (app *Application) loginPage(htmlFS fs.FS) http.HandlerFunc {
 	}
 }
 
-// Authentication middleware
-func (app *Application) requireAuth(minLevel int, next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		session, err := app.sessionStore.Get(r, "session")
-		if err != nil {
-			log.Printf("Session error: %v", err)
-			http.Error(w, "Session error", http.StatusInternalServerError)
-			return
-		}
-
-		authenticated, ok := session.Values["authenticated"].(bool)
-		if !ok || !authenticated {
-			http.Redirect(w, r, "/login", http.StatusSeeOther)
-			return
-		}
-
-		level, ok := session.Values["level"].(int)
-		if !ok || level > minLevel {
-			http.Error(w, "Access denied", http.StatusForbidden)
-			return
-		}
-
-		const idleSeconds = 30 * 60 // 30 minutes idle timeout
-
-		last, _ := session.Values["lastActive"].(int64)
-		now := time.Now().Unix()
-		if last == 0 {
-			session.Values["lastActive"] = now
-			_ = session.Save(r, w) // best-effort
-		} else if now-last > idleSeconds {
-			// idle expired -> kill and redirect to login
-			session.Options.MaxAge = -1
-			_ = session.Save(r, w)
-			http.Redirect(w, r, "/login", http.StatusSeeOther)
-			return
-		} else {
-			// refresh activity timestamp
-			session.Values["lastActive"] = now
-			_ = session.Save(r, w) // best-effort; ignore error to avoid breaking request
-		}
-
-		next.ServeHTTP(w, r)
-	})
-}
-
 // Auth handlers
 func (app *Application) handleLogin(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
@@ -629,10 +842,19 @@ func (app *Application) handleLogin(w http.ResponseWriter, r *http.Request) {
 
 	username := r.FormValue("username")
 	password := r.FormValue("password")
+	ip := com.ClientIP(r)
+
+	// refuse without checking the password while a backoff or lockout runs
+	if wait, err := app.loginGuard.Check(username, ip); err != nil {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
 
 	// DB auth first
 	user, level, ok, err := app.localStore.AuthenticateUser(r.Context(), username, password)
 	if err != nil {
+		app.loginGuard.Release(username, ip)
 		http.Error(w, "Auth error", http.StatusInternalServerError)
 		return
 	}
@@ -647,9 +869,11 @@ func (app *Application) handleLogin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if !ok {
+		app.loginGuard.Fail(r.Context(), username, ip)
 		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
 		return
 	}
+	app.loginGuard.Success(username, ip)
 
 	// Write session (regenerate + set values)
 	if err := com.CookieLogin(app.sessionStore, w, r, user, level); err != nil {
@@ -734,6 +958,7 @@ func main() {
 	}
 
 	log.Println("Server starting, please wait...")
+	app.startBackgroundServices()
 	if err := app.runStartupTasks(); err != nil {
 		log.Printf("Startup warning: %v", err)
 	}
@@ -755,6 +980,7 @@ func main() {
 		WriteTimeout:      time.Duration(app.config.Server.WriteTimeout) * time.Second,
 		ReadHeaderTimeout: 10 * time.Second,
 		IdleTimeout:       60 * time.Second,
+		ErrorLog:          slog.NewLogLogger(logging.For("http").Handler(), slog.LevelWarn),
 	}
 	log.Printf("Server running at http://localhost%s", app.config.Server.Port)
 	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
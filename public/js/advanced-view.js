@@ -449,6 +449,7 @@ function createImageCard(img) {
   wrapper.className = 'image-card';
   const imagePath = 'images/' + String(img.path || '').replace(/\\/g, '/');
   const tPath = getThumbnailPath(img.path);
+  const srcset = img.srcset ? ` srcset="${img.srcset}" sizes="200px"` : '';
 
   const dateStr = img.timestamp
     ? new Date(img.timestamp * 1000).toLocaleString(undefined, { dateStyle: 'medium', timeStyle: 'short' })
@@ -456,7 +457,7 @@ function createImageCard(img) {
 
   wrapper.innerHTML = `
     <a href="${imagePath}" target="_blank">
-      <img loading="lazy" src="${tPath}" alt="Image">
+      <img loading="lazy" src="${tPath}"${srcset} alt="Image">
     </a>
     <div class="meta" onclick="openLightbox('${imagePath}')">
       <div><strong>Date:</strong> ${dateStr}</div>
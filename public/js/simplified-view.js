@@ -24,10 +24,11 @@ function createImageCard(img, pass) {
   wrapper.className = 'image-card';
   const imagePath = "images/" + img.path.replace(/\\/g, '/');
   const tPath = getThumbnailPath(img.path);
+  const srcset = img.srcset ? ` srcset="${img.srcset}" sizes="200px"` : '';
 
   wrapper.innerHTML = `
     <a href="${imagePath}" target="_blank">
-      <img loading="lazy" src="${tPath}" alt="Image">
+      <img loading="lazy" src="${tPath}"${srcset} alt="Image">
     </a>
     <div class="meta" onclick="openLightbox('${imagePath}')">
       <div><strong>Date:</strong> ${pass.timestamp ? new Date(pass.timestamp * 1000).toLocaleString(undefined, { dateStyle: 'medium', timeStyle: 'short' }) : 'Unknown'}</div>